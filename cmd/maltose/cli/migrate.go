@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/graingo/maltose/cmd/maltose/utils"
+	"github.com/graingo/maltose/database/mdb"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/frame/mins"
+	"github.com/graingo/maltose/os/mcfg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateDirFlag    string
+	migrateConfigFlag string
+	migrateDBFlag     string
+	migrateTableFlag  string
+	migrateToFlag     int64
+	migrateStepsFlag  int
+)
+
+// migrationNameSeparator matches the characters replaced by underscores in migration names.
+var migrationNameSeparator = regexp.MustCompile(`[^a-z0-9]+`)
+
+// migrateCmd represents the migrate command which is a parent for the migration commands.
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage database schema migrations.",
+	Long: `Creates, applies and reverts the SQL migrations of a directory, named
+<version>_<name>.up.sql and <version>_<name>.down.sql. The database is read
+from the "database" node of the project configuration, like mdb. Go migrations
+are run by the application with mdb.Migrator.`,
+}
+
+// migrateCreateCmd creates the files of a new migration.
+var migrateCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create the up and down files of a new migration.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		name := strings.Trim(migrationNameSeparator.ReplaceAllString(strings.ToLower(args[0]), "_"), "_")
+		if name == "" {
+			return merror.Newf("invalid migration name: %s", args[0])
+		}
+		if err := os.MkdirAll(migrateDirFlag, 0755); err != nil {
+			return merror.Wrap(err, "failed to create migration directory")
+		}
+		version := time.Now().UTC().Format("20060102150405")
+		for _, direction := range []string{"up", "down"} {
+			path := filepath.Join(migrateDirFlag, fmt.Sprintf("%s_%s.%s.sql", version, name, direction))
+			content := fmt.Sprintf("-- %s migration of %s\n", direction, name)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				return merror.Wrapf(err, "failed to write migration file %s", path)
+			}
+			utils.PrintInfo("📄 Created {{.Path}}", utils.TplData{"Path": path})
+		}
+		return nil
+	},
+}
+
+// migrateUpCmd applies the pending migrations.
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply the pending migrations.",
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		migrator, err := newMigrator()
+		if err != nil {
+			return err
+		}
+		var applied []*mdb.Migration
+		if migrateToFlag > 0 {
+			applied, err = migrator.UpTo(cmd.Context(), migrateToFlag)
+		} else {
+			applied, err = migrator.Up(cmd.Context())
+		}
+		for _, mg := range applied {
+			utils.PrintInfo("⬆️  Applied {{.Migration}}", utils.TplData{"Migration": mg})
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			utils.PrintSuccess("✅ Database is up to date.", nil)
+			return nil
+		}
+		utils.PrintSuccess("✅ Successfully applied {{.Count}} migrations.", utils.TplData{"Count": len(applied)})
+		return nil
+	},
+}
+
+// migrateDownCmd reverts the last applied migrations.
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert the last applied migrations.",
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		migrator, err := newMigrator()
+		if err != nil {
+			return err
+		}
+		reverted, err := migrator.Down(cmd.Context(), migrateStepsFlag)
+		for _, mg := range reverted {
+			utils.PrintInfo("⬇️  Reverted {{.Migration}}", utils.TplData{"Migration": mg})
+		}
+		if err != nil {
+			return err
+		}
+		utils.PrintSuccess("✅ Successfully reverted {{.Count}} migrations.", utils.TplData{"Count": len(reverted)})
+		return nil
+	},
+}
+
+// migrateRedoCmd reverts and applies again the last applied migration.
+var migrateRedoCmd = &cobra.Command{
+	Use:   "redo",
+	Short: "Revert and apply again the last applied migration.",
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		migrator, err := newMigrator()
+		if err != nil {
+			return err
+		}
+		redone, err := migrator.Redo(cmd.Context())
+		if err != nil {
+			return err
+		}
+		if redone == nil {
+			utils.PrintNotice("No migration is applied.", nil)
+			return nil
+		}
+		utils.PrintSuccess("✅ Successfully redone {{.Migration}}.", utils.TplData{"Migration": redone})
+		return nil
+	},
+}
+
+// migrateStatusCmd prints the state of the migrations.
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the migrations.",
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		migrator, err := newMigrator()
+		if err != nil {
+			return err
+		}
+		statuses, err := migrator.Status(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, status := range statuses {
+			state, appliedAt := "pending", "-"
+			if status.Applied {
+				state, appliedAt = "applied", status.AppliedAt.Local().Format(time.DateTime)
+			}
+			switch {
+			case status.Missing:
+				state += " (missing)"
+			case status.Modified:
+				state += " (modified)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", strconv.FormatInt(status.Version, 10), status.Name, state, appliedAt)
+		}
+		return w.Flush()
+	},
+}
+
+// migrateUnlockCmd releases the migration lock left by a crashed process.
+var migrateUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Release the migration lock left by a crashed migration.",
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		migrator, err := newMigrator()
+		if err != nil {
+			return err
+		}
+		if err := migrator.ForceUnlock(cmd.Context()); err != nil {
+			return err
+		}
+		utils.PrintSuccess("✅ Successfully released the migration lock.", nil)
+		return nil
+	},
+}
+
+// newMigrator connects to the configured database and loads the migrations of the directory.
+func newMigrator() (*mdb.Migrator, error) {
+	adapter, err := mcfg.NewAdapterFile()
+	if err != nil {
+		return nil, err
+	}
+	if migrateConfigFlag != "" {
+		if err := adapter.SetFile(migrateConfigFlag); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := mins.NewScope(mcfg.NewWithAdapter(adapter)).TryDB(migrateDBFlag)
+	if err != nil {
+		return nil, merror.Wrap(err, "failed to connect to the database")
+	}
+	migrator := mdb.NewMigrator(db, mdb.MigrateConfig{Table: migrateTableFlag})
+	if err := migrator.LoadFS(os.DirFS(migrateDirFlag), "."); err != nil {
+		return nil, err
+	}
+	return migrator, nil
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateCreateCmd, migrateUpCmd, migrateDownCmd, migrateRedoCmd, migrateStatusCmd, migrateUnlockCmd)
+
+	migrateCmd.PersistentFlags().StringVarP(&migrateDirFlag, "dir", "d", "migrations", "Directory of the migration files")
+	migrateCmd.PersistentFlags().StringVarP(&migrateConfigFlag, "config", "c", "", "Configuration file (default is the config file of the project)")
+	migrateCmd.PersistentFlags().StringVarP(&migrateDBFlag, "name", "n", mdb.DefaultName, "Name of the database instance in the configuration")
+	migrateCmd.PersistentFlags().StringVar(&migrateTableFlag, "table", mdb.DefaultMigrationTable, "Migration history table")
+	migrateUpCmd.Flags().Int64Var(&migrateToFlag, "to", 0, "Apply the pending migrations up to this version")
+	migrateDownCmd.Flags().IntVar(&migrateStepsFlag, "steps", 1, "Number of migrations to revert")
+}
@@ -2,6 +2,8 @@ package otlpmetric
 
 import (
 	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 )
 
 // Protocol defines the protocol for the exporter.
@@ -32,6 +34,9 @@ type options struct {
 	endpoint       string        // Endpoint address.
 	urlPath        string        // URL path (for HTTP protocol only).
 
+	// Additional readers sharing the same provider, e.g. a Prometheus exporter.
+	readers []sdkmetric.Reader
+
 	// Resource attributes.
 	resourceAttributes map[string]string // Custom resource attributes.
 }
@@ -112,3 +117,13 @@ func WithResourceAttribute(key, value string) Option {
 		o.resourceAttributes[key] = value
 	}
 }
+
+// WithReader adds a reader to the provider created by Init, allowing OTLP push
+// to run side-by-side with pull exporters such as Prometheus.
+func WithReader(reader sdkmetric.Reader) Option {
+	return func(o *options) {
+		if reader != nil {
+			o.readers = append(o.readers, reader)
+		}
+	}
+}
@@ -55,7 +55,7 @@ func Init(endpoint string, opts ...Option) (func(context.Context) error, error)
 	)
 
 	// Use the new mmetric.NewProvider to create and wrap the OTel provider.
-	provider := mmetric.NewProvider(
+	providerOpts := []sdkmetric.Option{
 		// Resource configuration: Set resource information associated with metrics.
 		// Resources usually include:
 		// - Service name
@@ -71,7 +71,11 @@ func Init(endpoint string, opts ...Option) (func(context.Context) error, error)
 		// - Process asynchronously, without blocking the application.
 		// See: https://pkg.go.dev/go.opentelemetry.io/otel/sdk/metric#WithReader
 		sdkmetric.WithReader(reader),
-	)
+	}
+	for _, extra := range o.readers {
+		providerOpts = append(providerOpts, sdkmetric.WithReader(extra))
+	}
+	provider := mmetric.NewProvider(providerOpts...)
 
 	// Set the created provider as the global provider for the mmetric package.
 	mmetric.SetProvider(provider)
@@ -0,0 +1,101 @@
+package prometheus
+
+import (
+	promclient "github.com/prometheus/client_golang/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// Option is a function type for configuration options.
+type Option func(*options)
+
+// options is the configuration options struct.
+type options struct {
+	// Service information.
+	serviceName    string // Service name.
+	serviceVersion string // Service version.
+	environment    string // Environment name.
+
+	// Exposition configuration.
+	namespace string               // Prefix added to every metric name.
+	registry  *promclient.Registry // Registry the collector is registered with.
+	exemplars bool                 // Whether exemplars carrying trace ids are recorded.
+
+	// Additional readers sharing the same provider, e.g. an OTLP periodic reader.
+	readers []sdkmetric.Reader
+
+	// Resource attributes.
+	resourceAttributes map[string]string // Custom resource attributes.
+}
+
+// defaultOptions returns the default options.
+func defaultOptions() options {
+	return options{
+		serviceName:        "maltose-service",
+		serviceVersion:     "1.0.0",
+		environment:        "production",
+		exemplars:          true,
+		resourceAttributes: map[string]string{},
+	}
+}
+
+// WithServiceName sets the service name.
+func WithServiceName(name string) Option {
+	return func(o *options) {
+		o.serviceName = name
+	}
+}
+
+// WithServiceVersion sets the service version.
+func WithServiceVersion(version string) Option {
+	return func(o *options) {
+		o.serviceVersion = version
+	}
+}
+
+// WithEnvironment sets the environment.
+func WithEnvironment(env string) Option {
+	return func(o *options) {
+		o.environment = env
+	}
+}
+
+// WithNamespace sets a prefix added to every exposed metric name.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithRegistry sets the Prometheus registry used by the exporter.
+// A dedicated registry is created by default so that only mmetric instruments are exposed.
+func WithRegistry(registry *promclient.Registry) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// WithExemplars sets whether measurements recorded inside a sampled span carry
+// exemplars with the trace and span ids. Exemplars are only rendered when the
+// scraper negotiates the OpenMetrics format.
+func WithExemplars(enabled bool) Option {
+	return func(o *options) {
+		o.exemplars = enabled
+	}
+}
+
+// WithReader adds a reader to the provider created by Init, allowing the
+// Prometheus endpoint to run side-by-side with push exporters such as OTLP.
+func WithReader(reader sdkmetric.Reader) Option {
+	return func(o *options) {
+		if reader != nil {
+			o.readers = append(o.readers, reader)
+		}
+	}
+}
+
+// WithResourceAttribute adds a resource attribute.
+func WithResourceAttribute(key, value string) Option {
+	return func(o *options) {
+		o.resourceAttributes[key] = value
+	}
+}
@@ -0,0 +1,199 @@
+// Package prometheus exposes mmetric instruments on a Prometheus pull endpoint.
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/mhttp"
+	"github.com/graingo/maltose/net/mipv4"
+	"github.com/graingo/maltose/os/mmetric"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/exemplar"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// DefaultPath is the default scrape path.
+const DefaultPath = "/metrics"
+
+// Exporter is a Prometheus pull exporter.
+// It is an sdkmetric.Reader, so it can be registered on any MeterProvider,
+// including one that also pushes to an OTLP collector.
+type Exporter struct {
+	*otelprom.Exporter
+	handler http.Handler
+	options options
+}
+
+// Router is implemented by *mhttp.Server and *mhttp.RouterGroup.
+type Router interface {
+	GET(path string, handler mhttp.HandlerFunc, middlewares ...mhttp.MiddlewareFunc) *mhttp.RouterGroup
+}
+
+// New creates a Prometheus exporter without installing a provider.
+// Register it on a provider with sdkmetric.WithReader, or use Init.
+func New(opts ...Option) (*Exporter, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.registry == nil {
+		o.registry = promclient.NewRegistry()
+	}
+
+	exporterOpts := []otelprom.Option{otelprom.WithRegisterer(o.registry)}
+	if o.namespace != "" {
+		exporterOpts = append(exporterOpts, otelprom.WithNamespace(o.namespace))
+	}
+	exporter, err := otelprom.New(exporterOpts...)
+	if err != nil {
+		return nil, merror.Wrap(err, "failed to create prometheus exporter")
+	}
+
+	return &Exporter{
+		Exporter: exporter,
+		handler: promhttp.HandlerFor(o.registry, promhttp.HandlerOpts{
+			// OpenMetrics is required for exemplars to be rendered.
+			EnableOpenMetrics: true,
+		}),
+		options: o,
+	}, nil
+}
+
+// Init creates a Prometheus exporter, registers it together with any readers
+// added by WithReader on a new provider, and sets that provider as the global
+// mmetric provider. The returned function shuts the provider down.
+func Init(opts ...Option) (*Exporter, func(context.Context) error, error) {
+	exporter, err := New(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := createResource(exporter.options)
+	if err != nil {
+		return nil, nil, merror.Wrap(err, "failed to create resource")
+	}
+
+	filter := exemplar.AlwaysOffFilter
+	if exporter.options.exemplars {
+		filter = exemplar.TraceBasedFilter
+	}
+	providerOpts := []sdkmetric.Option{
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(exporter),
+		sdkmetric.WithExemplarFilter(filter),
+	}
+	for _, reader := range exporter.options.readers {
+		providerOpts = append(providerOpts, sdkmetric.WithReader(reader))
+	}
+	provider := mmetric.NewProvider(providerOpts...)
+
+	// Set the created provider as the global provider for the mmetric package.
+	mmetric.SetProvider(provider)
+
+	return exporter, provider.Shutdown, nil
+}
+
+// Handler returns the HTTP handler serving the scrape endpoint.
+func (e *Exporter) Handler() http.Handler {
+	return e.handler
+}
+
+// Register mounts the scrape endpoint on an mhttp server or router group.
+// The path defaults to DefaultPath.
+func (e *Exporter) Register(router Router, path ...string) {
+	p := DefaultPath
+	if len(path) > 0 && path[0] != "" {
+		p = path[0]
+	}
+	router.GET(p, func(r *mhttp.Request) {
+		e.handler.ServeHTTP(r.Writer, r.Request)
+	})
+}
+
+// Serve runs a separate admin listener on address that only serves the scrape
+// endpoint. It blocks until ctx is canceled or the listener fails.
+func (e *Exporter) Serve(ctx context.Context, address string, path ...string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return merror.Wrapf(err, "failed to listen on %s", address)
+	}
+	return e.ServeListener(ctx, listener, path...)
+}
+
+// ServeListener is like Serve but uses an existing listener.
+func (e *Exporter) ServeListener(ctx context.Context, listener net.Listener, path ...string) error {
+	p := DefaultPath
+	if len(path) > 0 && path[0] != "" {
+		p = path[0]
+	}
+	mux := http.NewServeMux()
+	mux.Handle(p, e.handler)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errChan:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// createResource creates a resource.
+func createResource(opts options) (*resource.Resource, error) {
+	var (
+		intranetIPArray, err = mipv4.GetIntranetIPArray()
+		hostIP               = "NoHostIpFound"
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(intranetIPArray) == 0 {
+		if intranetIPArray, err = mipv4.GetIPArray(); err != nil {
+			return nil, err
+		}
+	}
+	if len(intranetIPArray) > 0 {
+		hostIP = intranetIPArray[0]
+	}
+
+	attributes := []attribute.KeyValue{
+		semconv.ServiceNameKey.String(opts.serviceName),
+		semconv.ServiceVersionKey.String(opts.serviceVersion),
+		semconv.DeploymentEnvironmentKey.String(opts.environment),
+		semconv.HostNameKey.String(hostIP),
+	}
+	for key, value := range opts.resourceAttributes {
+		attributes = append(attributes, attribute.String(key, value))
+	}
+
+	return resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithProcess(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(attributes...),
+	)
+}
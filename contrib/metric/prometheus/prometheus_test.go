@@ -0,0 +1,110 @@
+package prometheus
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mhttp"
+	"github.com/graingo/maltose/os/mmetric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/exemplar"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var (
+	_ Router = (*mhttp.Server)(nil)
+	_ Router = (*mhttp.RouterGroup)(nil)
+)
+
+func scrape(t *testing.T, handler http.Handler, accept string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, DefaultPath, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
+
+func TestExporter(t *testing.T) {
+	exporter, err := New(WithNamespace("app"))
+	require.NoError(t, err)
+	provider := mmetric.NewProvider(
+		sdkmetric.WithReader(exporter),
+		sdkmetric.WithExemplarFilter(exemplar.TraceBasedFilter),
+	)
+	defer provider.Shutdown(context.Background())
+	mmetric.SetProvider(provider)
+
+	t.Run("buckets", func(t *testing.T) {
+		histogram := mmetric.NewMustHistogram("request_duration",
+			mmetric.NewMetricOption().WithUnit("s").WithBuckets([]float64{0.1, 0.5, 2}))
+		histogram.Record(0.3)
+
+		body := scrape(t, exporter.Handler(), "")
+		assert.Contains(t, body, `app_request_duration_seconds_bucket{`)
+		assert.Contains(t, body, `le="0.5"} 1`)
+		assert.Contains(t, body, `le="2"} 1`)
+		assert.NotContains(t, body, `le="0.25"`)
+	})
+
+	t.Run("exemplars", func(t *testing.T) {
+		tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+		defer tracerProvider.Shutdown(context.Background())
+		ctx, span := tracerProvider.Tracer("test").Start(context.Background(), "op")
+		defer span.End()
+
+		counter := mmetric.NewMustCounter("jobs_total", mmetric.NewMetricOption())
+		counter.Inc(ctx)
+
+		body := scrape(t, exporter.Handler(), "application/openmetrics-text; version=1.0.0")
+		traceID := span.SpanContext().TraceID().String()
+		assert.Contains(t, body, `trace_id="`+traceID+`"`)
+
+		body = scrape(t, exporter.Handler(), "")
+		assert.NotContains(t, body, "trace_id")
+	})
+}
+
+func TestExporterServeListener(t *testing.T) {
+	exporter, err := New()
+	require.NoError(t, err)
+	provider := mmetric.NewProvider(sdkmetric.WithReader(exporter))
+	defer provider.Shutdown(context.Background())
+	counter, err := provider.Meter("test").Int64Counter("admin_hits")
+	require.NoError(t, err)
+	counter.Add(context.Background(), 3)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- exporter.ServeListener(ctx, listener, "/admin/metrics")
+	}()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/admin/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(string(body), "admin_hits_total"))
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener did not shut down")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/graingo/maltose/contrib/trace/otlptrace"
 	"github.com/graingo/maltose/errors/merror"
 	"github.com/graingo/maltose/os/mcfg"
+	"github.com/graingo/maltose/os/mmetric"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
@@ -49,18 +50,29 @@ type TraceConfig struct {
 
 // MetricConfig defines OTLP metric exporter settings.
 type MetricConfig struct {
-	Enabled        bool          `mconv:"enabled"`
-	Endpoint       string        `mconv:"endpoint"`
-	Protocol       string        `mconv:"protocol"`
-	Timeout        time.Duration `mconv:"timeout"`
-	URLPath        string        `mconv:"url_path"`
-	ExportInterval time.Duration `mconv:"export_interval"`
+	Enabled        bool            `mconv:"enabled"`
+	Endpoint       string          `mconv:"endpoint"`
+	Protocol       string          `mconv:"protocol"`
+	Timeout        time.Duration   `mconv:"timeout"`
+	URLPath        string          `mconv:"url_path"`
+	ExportInterval time.Duration   `mconv:"export_interval"`
+	Collector      CollectorConfig `mconv:"collector"`
+}
+
+// CollectorConfig defines the Go runtime, process and connection pool metrics collector.
+// Enabling it without selecting a group reports all groups.
+type CollectorConfig struct {
+	Enabled bool `mconv:"enabled"`
+	Runtime bool `mconv:"runtime"`
+	Process bool `mconv:"process"`
+	Pools   bool `mconv:"pools"`
 }
 
 // Provider owns initialized telemetry providers and shuts them down together.
 type Provider struct {
 	traceShutdown   func(context.Context)
 	metricShutdown  func(context.Context) error
+	collector       *mmetric.Collector
 	shutdownTimeout time.Duration
 	shutdownOnce    sync.Once
 	shutdownErr     error
@@ -116,6 +128,19 @@ func New(ctx context.Context, config Config) (*Provider, error) {
 			return nil, err
 		}
 		provider.metricShutdown = shutdown
+
+		if config.Metric.Collector.Enabled {
+			collector, err := mmetric.StartCollector(mmetric.CollectorConfig{
+				Runtime: config.Metric.Collector.Runtime,
+				Process: config.Metric.Collector.Process,
+				Pools:   config.Metric.Collector.Pools,
+			})
+			if err != nil {
+				_ = provider.Shutdown(ctx)
+				return nil, merror.Wrap(err, "failed to start runtime metrics collector")
+			}
+			provider.collector = collector
+		}
 	}
 	return provider, nil
 }
@@ -126,6 +151,7 @@ func (p *Provider) Shutdown(ctx context.Context) error {
 		return nil
 	}
 	p.shutdownOnce.Do(func() {
+		p.shutdownErr = p.collector.Stop()
 		if p.metricShutdown != nil {
 			p.shutdownErr = errors.Join(p.shutdownErr, p.metricShutdown(ctx))
 		}
@@ -181,6 +207,9 @@ func normalizeConfig(config Config) Config {
 	if config.Metric.ExportInterval <= 0 {
 		config.Metric.ExportInterval = defaultExportInterval
 	}
+	if collector := &config.Metric.Collector; collector.Enabled && !collector.Runtime && !collector.Process && !collector.Pools {
+		collector.Runtime, collector.Process, collector.Pools = true, true, true
+	}
 	return config
 }
 
@@ -117,3 +117,15 @@ func TestProviderShutdownIsIdempotent(t *testing.T) {
 	assert.Equal(t, 1, traceCalls)
 	assert.Equal(t, 1, metricCalls)
 }
+
+func TestNormalizeConfigEnablesAllCollectorGroups(t *testing.T) {
+	config := normalizeConfig(Config{
+		Metric: MetricConfig{Collector: CollectorConfig{Enabled: true}},
+	})
+	assert.Equal(t, CollectorConfig{Enabled: true, Runtime: true, Process: true, Pools: true}, config.Metric.Collector)
+
+	config = normalizeConfig(Config{
+		Metric: MetricConfig{Collector: CollectorConfig{Enabled: true, Pools: true}},
+	})
+	assert.Equal(t, CollectorConfig{Enabled: true, Pools: true}, config.Metric.Collector)
+}
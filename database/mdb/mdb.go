@@ -11,7 +11,9 @@ import (
 
 type DB struct {
 	*gorm.DB
-	config *Config
+	config   *Config
+	metrics  *poolMetrics
+	replicas *replicaRouter
 }
 
 func New(config ...*Config) (*DB, error) {
@@ -45,7 +47,8 @@ func New(config ...*Config) (*DB, error) {
 		return nil, merror.Wrap(err, "failed to configure database connection pool")
 	}
 
-	if err := configureReplicas(db, cfg); err != nil {
+	replicas, err := configureReplicas(db, cfg)
+	if err != nil {
 		if cfg.Logger != nil {
 			cfg.Logger.Errorf(ctx, err, "failed to configure database replicas")
 		}
@@ -55,12 +58,13 @@ func New(config ...*Config) (*DB, error) {
 
 	for _, plugin := range cfg.Plugins {
 		if err := db.Use(plugin); err != nil {
+			replicas.close()
 			closeGormDB(db)
 			return nil, merror.Wrap(err, "failed to load database plugin")
 		}
 	}
 
-	return &DB{DB: db, config: cfg}, nil
+	return &DB{DB: db, config: cfg, metrics: &poolMetrics{}, replicas: replicas}, nil
 }
 
 func closeGormDB(db *gorm.DB) {
@@ -77,6 +81,8 @@ func (db *DB) Close() error {
 	if db == nil || db.DB == nil {
 		return nil
 	}
+	db.unregisterPoolMetrics()
+	db.replicas.close()
 	sqlDB, err := db.DB.DB()
 	if err != nil {
 		return err
@@ -84,25 +90,33 @@ func (db *DB) Close() error {
 	return sqlDB.Close()
 }
 
-// WithContext returns a new DB with the given context.
+// WithContext returns a new DB with the given context. It joins the transaction
+// of the database carried by ctx, see TransactContext.
 func (db *DB) WithContext(ctx context.Context) *DB {
+	gormDB := db.DB
+	if state := db.txState(ctx); state != nil && !isTransaction(gormDB.Statement.ConnPool) {
+		gormDB = state.tx.DB
+	}
 	return &DB{
-		DB:     db.DB.WithContext(ctx),
-		config: db.config,
+		DB:       gormDB.WithContext(ctx),
+		config:   db.config,
+		metrics:  db.metrics,
+		replicas: db.replicas,
 	}
 }
 
-// Transact starts a transaction with the given context.
+// Transact starts a transaction with the given context, or a savepoint if the
+// context carries a transaction of the database.
 func (db *DB) Transact(ctx context.Context, fn func(tx *DB) error) error {
 	return db.TransactWithOptions(ctx, nil, fn)
 }
 
-// TransactWithOptions starts a transaction with the given context and options.
+// TransactWithOptions starts a transaction with the given context and options,
+// or a savepoint if the context carries a transaction of the database.
 func (db *DB) TransactWithOptions(ctx context.Context, opts *sql.TxOptions, fn func(tx *DB) error) error {
-	config := db.config
-	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		return fn(&DB{DB: tx, config: config})
-	}, opts)
+	return db.transact(ctx, TxOptions{SQL: opts}, func(_ context.Context, tx *DB) error {
+		return fn(tx)
+	})
 }
 
 // Ping checks if the database is reachable.
@@ -41,21 +41,34 @@ type Config struct {
 	Logger *mlog.Logger
 	// Replicas is the replicas list.
 	Replicas []Config
+	// ReplicaPolicy selects the replica of each read: "random", "round_robin",
+	// "weighted" or "least_connections". Default is "random".
+	ReplicaPolicy string `mconv:"replica_policy"`
+	// Weight is the weight of a replica under the weighted and least_connections policies. Default is 1.
+	Weight int `mconv:"weight"`
+	// HealthCheckInterval is how often the replicas are pinged. Unhealthy replicas
+	// are excluded from reads until a ping succeeds. Default is 10s, negative disables it.
+	HealthCheckInterval time.Duration `mconv:"health_check_interval"`
+	// StickyDuration is how long the reads of a WithReadYourWrites context go to
+	// the primary after a write. Zero keeps them on the primary for the rest of the context.
+	StickyDuration time.Duration `mconv:"sticky_duration"`
 	// Plugins is the plugins list.
 	Plugins []gorm.Plugin
 }
 
 func defaultConfig() *Config {
 	return &Config{
-		Type:              "mysql",
-		Port:              "3306",
-		MaxIdleTime:       10 * time.Second,
-		MaxIdleConnection: 10,
-		MaxOpenConnection: 100,
-		MaxLifetime:       0,
-		Logger:            mlog.New(),
-		SlowThreshold:     300 * time.Millisecond,
-		Replicas:          []Config{},
+		Type:                "mysql",
+		Port:                "3306",
+		MaxIdleTime:         10 * time.Second,
+		MaxIdleConnection:   10,
+		MaxOpenConnection:   100,
+		MaxLifetime:         0,
+		Logger:              mlog.New(),
+		SlowThreshold:       300 * time.Millisecond,
+		Replicas:            []Config{},
+		ReplicaPolicy:       ReplicaPolicyRandom,
+		HealthCheckInterval: 10 * time.Second,
 		Plugins: []gorm.Plugin{
 			otelgorm.NewPlugin(),
 		},
@@ -121,6 +134,18 @@ func mergeConfig(config *Config) *Config {
 	if config.Replicas != nil {
 		merged.Replicas = append([]Config(nil), config.Replicas...)
 	}
+	if config.ReplicaPolicy != "" {
+		merged.ReplicaPolicy = config.ReplicaPolicy
+	}
+	if config.Weight != 0 {
+		merged.Weight = config.Weight
+	}
+	if config.HealthCheckInterval != 0 {
+		merged.HealthCheckInterval = config.HealthCheckInterval
+	}
+	if config.StickyDuration != 0 {
+		merged.StickyDuration = config.StickyDuration
+	}
 	if config.Plugins != nil {
 		merged.Plugins = append([]gorm.Plugin(nil), config.Plugins...)
 	}
@@ -33,6 +33,7 @@ func Instance(name ...string) *DB {
 				intlog.Errorf(context.TODO(), `new db instance failed: "%s": %v`, key, err)
 				return nil
 			}
+			r.RegisterPoolMetrics(key)
 			return r
 		}
 		return nil
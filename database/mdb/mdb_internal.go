@@ -124,38 +124,73 @@ func configureConnectionPool(db *gorm.DB, cfg *Config) error {
 	return nil
 }
 
-// configureReplicas sets up database replicas.
-func configureReplicas(db *gorm.DB, cfg *Config) error {
+// configureReplicas opens the database replicas and routes reads to them.
+func configureReplicas(db *gorm.DB, cfg *Config) (*replicaRouter, error) {
 	if len(cfg.Replicas) == 0 {
-		return nil
+		return nil, nil
 	}
 
+	router, err := newReplicaRouter(cfg)
+	if err != nil {
+		return nil, err
+	}
 	replicas := make([]gorm.Dialector, len(cfg.Replicas))
 	for i, replicaCfg := range cfg.Replicas {
 		replicaCfg = mergeReplicaConfig(cfg, replicaCfg)
 		driver, err := createDriver(&replicaCfg)
 		if err != nil {
-			return merror.Wrapf(err, "invalid database replica configuration at index %d", i)
+			router.close()
+			return nil, merror.Wrapf(err, "invalid database replica configuration at index %d", i)
+		}
+		replicaDB, err := gorm.Open(driver, createGormConfig(cfg))
+		if err != nil {
+			router.close()
+			return nil, merror.Wrapf(err, "failed to open database replica at index %d", i)
+		}
+		if err = configureConnectionPool(replicaDB, &replicaCfg); err != nil {
+			closeGormDB(replicaDB)
+			router.close()
+			return nil, merror.Wrapf(err, "failed to configure connection pool of database replica at index %d", i)
 		}
-		replicas[i] = driver
+		sqlDB, _ := replicaDB.DB()
+		router.add(replicaName(&replicaCfg, i), replicaCfg.Weight, sqlDB)
+		replicas[i] = connDialector(replicaCfg.Type, sqlDB)
 	}
 
 	resolver := dbresolver.Register(dbresolver.Config{
 		Replicas:          replicas,
-		Policy:            dbresolver.RandomPolicy{}, // use random policy
+		Policy:            router,
 		TraceResolverMode: true,
 	})
 	if err := db.Use(resolver); err != nil {
 		if cfg.Logger != nil {
 			cfg.Logger.With(mlog.String(maltose.COMPONENT, "mdb")).Errorf(context.Background(), err, "Failed to configure db resolver")
 		}
-		return merror.Wrap(err, "failed to configure db resolver")
+		router.close()
+		return nil, merror.Wrap(err, "failed to configure db resolver")
 	}
+	if err := router.registerCallbacks(db); err != nil {
+		router.close()
+		return nil, merror.Wrap(err, "failed to register replica routing callbacks")
+	}
+	router.start()
 
-	return nil
+	return router, nil
+}
+
+// connDialector returns a dialector of type using an opened connection pool.
+func connDialector(typ string, conn gorm.ConnPool) gorm.Dialector {
+	switch typ {
+	case "mysql":
+		return gormMySQL.New(gormMySQL.Config{Conn: conn})
+	case "postgres":
+		return postgres.New(postgres.Config{Conn: conn})
+	default:
+		return &sqlite.Dialector{Conn: conn}
+	}
 }
 
-// mergeReplicaConfig inherits connection fields omitted by a replica.
+// mergeReplicaConfig inherits connection and pool fields omitted by a replica.
 func mergeReplicaConfig(primary *Config, replica Config) Config {
 	if replica.Type == "" {
 		replica.Type = primary.Type
@@ -172,5 +207,20 @@ func mergeReplicaConfig(primary *Config, replica Config) Config {
 	if replica.DBName == "" {
 		replica.DBName = primary.DBName
 	}
+	if replica.MaxIdleTime == 0 {
+		replica.MaxIdleTime = primary.MaxIdleTime
+	}
+	if replica.MaxIdleConnection == 0 {
+		replica.MaxIdleConnection = primary.MaxIdleConnection
+	}
+	if replica.MaxOpenConnection == 0 {
+		replica.MaxOpenConnection = primary.MaxOpenConnection
+	}
+	if replica.MaxLifetime == 0 {
+		replica.MaxLifetime = primary.MaxLifetime
+	}
+	if replica.Weight <= 0 {
+		replica.Weight = 1
+	}
 	return replica
 }
@@ -0,0 +1,99 @@
+package mdb
+
+import (
+	"sync"
+
+	"github.com/graingo/maltose/os/mmetric"
+)
+
+// instrumentName is the instrumentation name of the mdb metrics.
+const instrumentName = "github.com/graingo/maltose/database/mdb"
+
+// metricAttrReplica is the replica attribute of the replica metrics, "primary"
+// for reads sent to the primary.
+const metricAttrReplica = "db.replica"
+
+// replicaMetricManager holds the replica routing metrics.
+type replicaMetricManager struct {
+	ReadTotal mmetric.Counter
+	Healthy   mmetric.Gauge
+}
+
+// replicaMetrics is the global replica metric manager.
+var replicaMetrics = newReplicaMetricManager()
+
+func newReplicaMetricManager() *replicaMetricManager {
+	meter := mmetric.GetProvider().Meter(mmetric.MeterOption{
+		Instrument:        instrumentName,
+		InstrumentVersion: "v1.0.0",
+	})
+	return &replicaMetricManager{
+		ReadTotal: meter.MustCounter(
+			"db.client.replica.read.total",
+			mmetric.MetricOption{
+				Help: "reads routed to each replica or to the primary",
+				Unit: "",
+			},
+		),
+		Healthy: meter.MustGauge(
+			"db.client.replica.healthy",
+			mmetric.MetricOption{
+				Help: "replica health, 1 healthy, 0 excluded from reads",
+				Unit: "",
+			},
+		),
+	}
+}
+
+// poolMetrics holds the pool metrics registration shared by all copies of a DB.
+type poolMetrics struct {
+	mu         sync.Mutex
+	unregister func()
+}
+
+// RegisterPoolMetrics reports the sql.DBStats of the primary connection pool
+// under the given instance name when a mmetric.Collector with pool metrics is
+// running. Instances created by Instance and by the framework are registered
+// automatically. Registering again replaces the previous name, and Close
+// removes the registration.
+func (db *DB) RegisterPoolMetrics(name string) {
+	if db == nil || db.DB == nil || db.metrics == nil {
+		return
+	}
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return
+	}
+	unregister := mmetric.RegisterPool(db.Dialector.Name(), name, func() mmetric.PoolStats {
+		stats := sqlDB.Stats()
+		return mmetric.PoolStats{
+			MaxOpen:      int64(stats.MaxOpenConnections),
+			Idle:         int64(stats.Idle),
+			InUse:        int64(stats.InUse),
+			WaitCount:    stats.WaitCount,
+			WaitDuration: stats.WaitDuration,
+			Closed:       stats.MaxIdleClosed + stats.MaxIdleTimeClosed + stats.MaxLifetimeClosed,
+		}
+	})
+
+	db.metrics.mu.Lock()
+	previous := db.metrics.unregister
+	db.metrics.unregister = unregister
+	db.metrics.mu.Unlock()
+	if previous != nil {
+		previous()
+	}
+}
+
+func (db *DB) unregisterPoolMetrics() {
+	if db.metrics == nil {
+		return
+	}
+	db.metrics.mu.Lock()
+	unregister := db.metrics.unregister
+	db.metrics.unregister = nil
+	db.metrics.mu.Unlock()
+	if unregister != nil {
+		unregister()
+	}
+}
@@ -0,0 +1,646 @@
+package mdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graingo/maltose"
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/os/mlog"
+)
+
+const (
+	// DefaultMigrationTable is the default name of the migration history table.
+	DefaultMigrationTable = "schema_migrations"
+
+	// statementBegin and statementEnd enclose a statement of a SQL migration
+	// containing semicolons at line ends, e.g. a trigger or a function body.
+	statementBegin = "-- mdb:statement-begin"
+	statementEnd   = "-- mdb:statement-end"
+
+	// lockRetryInterval is how often a held migration lock is tried again.
+	lockRetryInterval = 250 * time.Millisecond
+)
+
+// migrationFilePattern matches the SQL migration files, e.g. "20240101120000_create_user.up.sql".
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change. SQL migrations are usually loaded
+// from files with Migrator.LoadFS, Go migrations are registered with
+// Migrator.Register. Each migration runs in a transaction with the update of
+// the history table.
+type Migration struct {
+	// Version orders the migrations, e.g. a timestamp like 20240101120000.
+	Version int64
+	// Name describes the migration.
+	Name string
+	// UpSQL and DownSQL are the statements of a SQL migration, separated by
+	// semicolons at line ends.
+	UpSQL   string
+	DownSQL string
+	// Up and Down are the functions of a Go migration. They take precedence
+	// over UpSQL and DownSQL. Their context carries the transaction, see TransactContext.
+	Up   func(ctx context.Context, tx *DB) error
+	Down func(ctx context.Context, tx *DB) error
+}
+
+// String returns the version and name of the migration, e.g. "20240101120000_create_user".
+func (mg *Migration) String() string {
+	return fmt.Sprintf("%d_%s", mg.Version, mg.Name)
+}
+
+// reversible reports whether the migration can be reverted.
+func (mg *Migration) reversible() bool {
+	return mg.Down != nil || strings.TrimSpace(mg.DownSQL) != ""
+}
+
+// checksum returns the checksum of the up statements of a SQL migration, empty for Go migrations.
+func (mg *Migration) checksum() string {
+	if mg.Up != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(mg.UpSQL))
+	return hex.EncodeToString(sum[:])
+}
+
+// run runs the up or down part of the migration in tx.
+func (mg *Migration) run(ctx context.Context, tx *DB, up bool) error {
+	fn, query := mg.Up, mg.UpSQL
+	if !up {
+		fn, query = mg.Down, mg.DownSQL
+	}
+	if fn != nil {
+		return fn(ctx, tx)
+	}
+	for _, statement := range splitStatements(query) {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrationStatus is the state of a migration.
+type MigrationStatus struct {
+	Version int64
+	Name    string
+	// Applied reports whether the migration was applied, at AppliedAt.
+	Applied   bool
+	AppliedAt time.Time
+	// Modified reports whether the up statements of an applied SQL migration
+	// changed since it was applied.
+	Modified bool
+	// Missing reports whether an applied migration is not registered anymore.
+	Missing bool
+}
+
+// MigrateConfig defines how a Migrator records and locks the migrations.
+type MigrateConfig struct {
+	// Table is the migration history table. Default is DefaultMigrationTable.
+	Table string
+	// LockTimeout is how long to wait for the migrations run by another process.
+	// Default is 1 minute.
+	LockTimeout time.Duration
+	// StaleLockAge is the age after which the lock table row of a process that
+	// crashed while migrating is broken. The lock holder refreshes the row while
+	// migrating. It only applies to databases without advisory locks. Default is 5 minutes.
+	StaleLockAge time.Duration
+	// Logger logs the applied and reverted migrations. Default is the logger of the database.
+	Logger *mlog.Logger
+}
+
+func normalizeMigrateConfig(db *DB, config MigrateConfig) MigrateConfig {
+	if config.Table == "" {
+		config.Table = DefaultMigrationTable
+	}
+	if config.LockTimeout <= 0 {
+		config.LockTimeout = time.Minute
+	}
+	if config.StaleLockAge <= 0 {
+		config.StaleLockAge = 5 * time.Minute
+	}
+	if config.Logger == nil && db.config != nil {
+		// The logger of the database already has the component field.
+		config.Logger = db.config.Logger
+	}
+	if config.Logger == nil {
+		config.Logger = mlog.New().With(mlog.String(maltose.COMPONENT, "mdb"))
+	}
+	return config
+}
+
+// Migrator applies and reverts the migrations of a database. Runs are serialized
+// across processes with an advisory lock on MySQL and PostgreSQL, and a lock
+// table on other databases, so several instances can migrate at startup.
+type Migrator struct {
+	db         *DB
+	config     MigrateConfig
+	migrations map[int64]*Migration
+}
+
+// migrationRecord is a row of the migration history table.
+type migrationRecord struct {
+	Version   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	Checksum  string `gorm:"size:64"`
+	AppliedAt time.Time
+}
+
+// migrationLockRecord is the row of the lock table held while migrations run.
+type migrationLockRecord struct {
+	ID       int    `gorm:"primaryKey;autoIncrement:false"`
+	Owner    string `gorm:"size:255"`
+	LockedAt time.Time
+}
+
+// NewMigrator creates a migrator of db.
+func NewMigrator(db *DB, config ...MigrateConfig) *Migrator {
+	var c MigrateConfig
+	if len(config) > 0 {
+		c = config[0]
+	}
+	return &Migrator{
+		db:         db,
+		config:     normalizeMigrateConfig(db, c),
+		migrations: make(map[int64]*Migration),
+	}
+}
+
+// Register registers migrations, e.g. Go migrations.
+func (m *Migrator) Register(migrations ...*Migration) error {
+	for _, mg := range migrations {
+		if mg.Version <= 0 {
+			return merror.NewCodef(mcode.CodeInvalidParameter, "migration %s must have a positive version", mg)
+		}
+		if mg.Up == nil && strings.TrimSpace(mg.UpSQL) == "" {
+			return merror.NewCodef(mcode.CodeInvalidParameter, "migration %s has no up migration", mg)
+		}
+		if existing, ok := m.migrations[mg.Version]; ok {
+			return merror.NewCodef(mcode.CodeInvalidParameter, "migrations %s and %s have the same version", existing, mg)
+		}
+		m.migrations[mg.Version] = mg
+	}
+	return nil
+}
+
+// LoadFS registers the SQL migrations of directory dir of fsys, e.g. an
+// embed.FS or os.DirFS. Their files are named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, the down file being optional.
+func (m *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return merror.Wrapf(err, "failed to read migration directory %s", dir)
+	}
+
+	loaded := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return merror.NewCodef(mcode.CodeInvalidParameter,
+				"invalid migration file name %s, expected <version>_<name>.up.sql or <version>_<name>.down.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return merror.WrapCodef(err, mcode.CodeInvalidParameter, "invalid version of migration file %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return merror.Wrapf(err, "failed to read migration file %s", entry.Name())
+		}
+
+		mg, ok := loaded[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: match[2]}
+			loaded[version] = mg
+		} else if mg.Name != match[2] {
+			return merror.NewCodef(mcode.CodeInvalidParameter, "migration files of version %d have different names", version)
+		}
+		if match[3] == "up" {
+			mg.UpSQL = string(content)
+		} else {
+			mg.DownSQL = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(loaded))
+	for _, mg := range loaded {
+		migrations = append(migrations, mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return m.Register(migrations...)
+}
+
+// Status returns the state of the registered and applied migrations by version.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	ctx = UsePrimary(ctx)
+	records, err := m.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mg := range m.sorted() {
+		status := MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if record, ok := records[mg.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+			status.Modified = modified(record, mg)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range records {
+		if _, ok := m.migrations[record.Version]; !ok {
+			statuses = append(statuses, MigrationStatus{
+				Version:   record.Version,
+				Name:      record.Name,
+				Applied:   true,
+				AppliedAt: record.AppliedAt,
+				Missing:   true,
+			})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applies the pending migrations in version order and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	return m.UpTo(ctx, math.MaxInt64)
+}
+
+// UpTo applies the pending migrations up to version included and returns them.
+// It fails without applying any migration when an applied SQL migration was modified.
+func (m *Migrator) UpTo(ctx context.Context, version int64) ([]*Migration, error) {
+	var applied []*Migration
+	err := m.withLock(ctx, func(ctx context.Context, records map[int64]migrationRecord) error {
+		for _, record := range records {
+			if mg, ok := m.migrations[record.Version]; ok && modified(record, mg) {
+				return merror.NewCodef(mcode.CodeInvalidOperation, "migration %s was modified after it was applied", mg)
+			}
+		}
+		for _, mg := range m.sorted() {
+			if _, ok := records[mg.Version]; ok || mg.Version > version {
+				continue
+			}
+			if err := m.apply(ctx, mg, true); err != nil {
+				return err
+			}
+			applied = append(applied, mg)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations and returns them. It fails
+// without reverting any migration when one of them cannot be reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	if steps < 1 {
+		return nil, merror.NewCodef(mcode.CodeInvalidParameter, "invalid number of migrations to revert: %d", steps)
+	}
+	var reverted []*Migration
+	err := m.withLock(ctx, func(ctx context.Context, records map[int64]migrationRecord) error {
+		migrations, err := m.lastApplied(records, steps)
+		if err != nil {
+			return err
+		}
+		for _, mg := range migrations {
+			if err := m.apply(ctx, mg, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, mg)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Redo reverts and applies again the last applied migration, e.g. after
+// editing it in development. It returns nil if no migration is applied.
+func (m *Migrator) Redo(ctx context.Context) (*Migration, error) {
+	var redone *Migration
+	err := m.withLock(ctx, func(ctx context.Context, records map[int64]migrationRecord) error {
+		migrations, err := m.lastApplied(records, 1)
+		if err != nil || len(migrations) == 0 {
+			return err
+		}
+		if err = m.apply(ctx, migrations[0], false); err != nil {
+			return err
+		}
+		if err = m.apply(ctx, migrations[0], true); err != nil {
+			return err
+		}
+		redone = migrations[0]
+		return nil
+	})
+	return redone, err
+}
+
+// sorted returns the registered migrations by version.
+func (m *Migrator) sorted() []*Migration {
+	migrations := make([]*Migration, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		migrations = append(migrations, mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations
+}
+
+// lastApplied returns the last count applied migrations, latest first, checking they can be reverted.
+func (m *Migrator) lastApplied(records map[int64]migrationRecord, count int) ([]*Migration, error) {
+	versions := make([]int64, 0, len(records))
+	for version := range records {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+	migrations := make([]*Migration, 0, count)
+	for _, version := range versions[:min(count, len(versions))] {
+		mg, ok := m.migrations[version]
+		if !ok {
+			return nil, merror.NewCodef(mcode.CodeNotFound, "applied migration %d_%s is not registered", version, records[version].Name)
+		}
+		if !mg.reversible() {
+			return nil, merror.NewCodef(mcode.CodeNotSupported, "migration %s has no down migration", mg)
+		}
+		migrations = append(migrations, mg)
+	}
+	return migrations, nil
+}
+
+// withLock runs fn with the applied migrations while holding the migration lock.
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context, records map[int64]migrationRecord) error) error {
+	ctx = UsePrimary(ctx)
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = m.db.WithContext(ctx).Table(m.config.Table).AutoMigrate(&migrationRecord{}); err != nil {
+		return merror.Wrapf(err, "failed to create migration table %s", m.config.Table)
+	}
+	records, err := m.records(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(ctx, records)
+}
+
+// records returns the applied migrations by version.
+func (m *Migrator) records(ctx context.Context) (map[int64]migrationRecord, error) {
+	records := make(map[int64]migrationRecord)
+	if !m.db.WithContext(ctx).Migrator().HasTable(m.config.Table) {
+		return records, nil
+	}
+	var rows []migrationRecord
+	if err := m.db.WithContext(ctx).Table(m.config.Table).Find(&rows).Error; err != nil {
+		return nil, merror.Wrapf(err, "failed to read migration table %s", m.config.Table)
+	}
+	for _, row := range rows {
+		records[row.Version] = row
+	}
+	return records, nil
+}
+
+// apply applies or reverts a migration and updates the history table in a transaction.
+func (m *Migrator) apply(ctx context.Context, mg *Migration, up bool) error {
+	start := time.Now()
+	err := m.db.transact(ctx, TxOptions{}, func(ctx context.Context, tx *DB) error {
+		if err := mg.run(ctx, tx, up); err != nil {
+			return err
+		}
+		if up {
+			return tx.Table(m.config.Table).Create(&migrationRecord{
+				Version:   mg.Version,
+				Name:      mg.Name,
+				Checksum:  mg.checksum(),
+				AppliedAt: time.Now(),
+			}).Error
+		}
+		return tx.Table(m.config.Table).Where("version = ?", mg.Version).Delete(&migrationRecord{}).Error
+	})
+	if err != nil {
+		if up {
+			return merror.WrapCodef(err, mcode.CodeDbOperationError, "failed to apply migration %s", mg)
+		}
+		return merror.WrapCodef(err, mcode.CodeDbOperationError, "failed to revert migration %s", mg)
+	}
+	if up {
+		m.config.Logger.Infof(ctx, "applied migration %s in %s", mg, time.Since(start))
+	} else {
+		m.config.Logger.Infof(ctx, "reverted migration %s in %s", mg, time.Since(start))
+	}
+	return nil
+}
+
+// lock acquires the migration lock and returns its release function.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	switch m.db.Dialector.Name() {
+	case "mysql":
+		return m.sessionLock(ctx,
+			"SELECT GET_LOCK(CONCAT(DATABASE(), '.', ?), 0)",
+			"SELECT RELEASE_LOCK(CONCAT(DATABASE(), '.', ?))", m.config.Table)
+	case "postgres":
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(m.config.Table))
+		return m.sessionLock(ctx,
+			"SELECT pg_try_advisory_lock($1)",
+			"SELECT pg_advisory_unlock($1)", int64(hash.Sum64()))
+	default:
+		return m.tableLock(ctx)
+	}
+}
+
+// sessionLock acquires an advisory lock held by a dedicated connection.
+func (m *Migrator) sessionLock(ctx context.Context, acquire, release string, key any) (func(), error) {
+	sqlDB, err := m.db.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, merror.Wrap(err, "failed to get a connection for the migration lock")
+	}
+	err = m.waitLock(ctx, func() (bool, error) {
+		var acquired sql.NullBool
+		if err := conn.QueryRowContext(ctx, acquire, key).Scan(&acquired); err != nil {
+			return false, merror.Wrap(err, "failed to acquire the migration lock")
+		}
+		return acquired.Bool, nil
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), release, key); err != nil {
+			m.config.Logger.Warnf(ctx, "failed to release the migration lock: %v", err)
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+// tableLock acquires the lock as the single row of a lock table, for databases
+// without advisory locks. The row is refreshed while the lock is held, and broken
+// by other processes once older than the stale lock age.
+func (m *Migrator) tableLock(ctx context.Context) (func(), error) {
+	table := m.lockTable()
+	if err := m.db.WithContext(ctx).Table(table).AutoMigrate(&migrationLockRecord{}); err != nil {
+		return nil, merror.Wrapf(err, "failed to create migration lock table %s", table)
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d", host, os.Getpid())
+	err := m.waitLock(ctx, func() (bool, error) {
+		err := m.db.WithContext(ctx).Table(table).Create(&migrationLockRecord{ID: 1, Owner: owner, LockedAt: time.Now()}).Error
+		if err == nil {
+			return true, nil
+		}
+		var held []migrationLockRecord
+		if findErr := m.db.WithContext(ctx).Table(table).Limit(1).Find(&held).Error; findErr != nil || len(held) == 0 {
+			return false, merror.Wrap(err, "failed to acquire the migration lock")
+		}
+		if age := time.Since(held[0].LockedAt); age > m.config.StaleLockAge {
+			m.config.Logger.Warnf(ctx, "breaking the migration lock held by %s, not refreshed for %s", held[0].Owner, age)
+			err = m.db.WithContext(ctx).Table(table).Where("id = ? AND owner = ?", 1, held[0].Owner).Delete(&migrationLockRecord{}).Error
+			if err != nil {
+				return false, merror.Wrap(err, "failed to break the stale migration lock")
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(m.config.StaleLockAge / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := m.db.WithContext(context.WithoutCancel(ctx)).Table(table).
+					Where("id = ? AND owner = ?", 1, owner).Update("locked_at", time.Now()).Error
+				if err != nil {
+					m.config.Logger.Warnf(ctx, "failed to refresh the migration lock: %v", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		err := m.db.WithContext(context.WithoutCancel(ctx)).Table(table).
+			Where("id = ? AND owner = ?", 1, owner).Delete(&migrationLockRecord{}).Error
+		if err != nil {
+			m.config.Logger.Warnf(ctx, "failed to release the migration lock, run ForceUnlock: %v", err)
+		}
+	}, nil
+}
+
+// ForceUnlock releases the lock table row left by a process that crashed while
+// migrating, without waiting for it to become stale. Advisory locks are released
+// by the database when their connection closes and need no unlocking.
+func (m *Migrator) ForceUnlock(ctx context.Context) error {
+	ctx = UsePrimary(ctx)
+	table := m.lockTable()
+	if !m.db.WithContext(ctx).Migrator().HasTable(table) {
+		return nil
+	}
+	if err := m.db.WithContext(ctx).Table(table).Where("id = ?", 1).Delete(&migrationLockRecord{}).Error; err != nil {
+		return merror.Wrapf(err, "failed to delete the migration lock of table %s", table)
+	}
+	return nil
+}
+
+// lockTable returns the name of the lock table.
+func (m *Migrator) lockTable() string {
+	return m.config.Table + "_lock"
+}
+
+// waitLock tries to acquire the migration lock until it succeeds or the lock timeout expires.
+func (m *Migrator) waitLock(ctx context.Context, try func() (bool, error)) error {
+	deadline := time.Now().Add(m.config.LockTimeout)
+	for {
+		acquired, err := try()
+		if err != nil || acquired {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return merror.NewCodef(mcode.CodeOperationFailed,
+				"timed out after %s waiting for the migration lock held by another process", m.config.LockTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// modified reports whether the up statements of an applied SQL migration changed.
+func modified(record migrationRecord, mg *Migration) bool {
+	checksum := mg.checksum()
+	return record.Checksum != "" && checksum != "" && record.Checksum != checksum
+}
+
+// splitStatements splits SQL into its statements, which end with a semicolon at
+// a line end unless enclosed by statementBegin and statementEnd.
+func splitStatements(query string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+		enclosed   bool
+	)
+	flush := func() {
+		statement := strings.TrimSpace(current.String())
+		current.Reset()
+		for _, line := range strings.Split(statement, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+				statements = append(statements, statement)
+				return
+			}
+		}
+	}
+	for _, line := range strings.Split(query, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == statementBegin:
+			flush()
+			enclosed = true
+			continue
+		case trimmed == statementEnd:
+			flush()
+			enclosed = false
+			continue
+		}
+		current.WriteString(line)
+		current.WriteByte('\n')
+		if !enclosed && !strings.HasPrefix(trimmed, "--") && strings.HasSuffix(trimmed, ";") {
+			flush()
+		}
+	}
+	flush()
+	return statements
+}
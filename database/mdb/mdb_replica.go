@@ -0,0 +1,348 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/graingo/maltose"
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/os/mlog"
+	"github.com/graingo/maltose/os/mmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+// Policies selecting the replica of each read.
+const (
+	// ReplicaPolicyRandom picks a replica at random.
+	ReplicaPolicyRandom = "random"
+	// ReplicaPolicyRoundRobin picks the replicas in turn.
+	ReplicaPolicyRoundRobin = "round_robin"
+	// ReplicaPolicyWeighted picks the replicas at random in proportion to their weight.
+	ReplicaPolicyWeighted = "weighted"
+	// ReplicaPolicyLeastConnections picks the replica with the fewest connections
+	// in use relative to its weight.
+	ReplicaPolicyLeastConnections = "least_connections"
+)
+
+// resolverCallback is the name of the dbresolver callbacks.
+const resolverCallback = "gorm:db_resolver"
+
+// primaryRoute is the value of the replica metric attribute for reads sent to the primary.
+const primaryRoute = "primary"
+
+type (
+	usePrimaryKey     struct{}
+	readYourWritesKey struct{}
+)
+
+// UsePrimary returns a context whose reads are sent to the primary instead of
+// the replicas, e.g. for a read that must not lag behind.
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, usePrimaryKey{}, true)
+}
+
+// WithReadYourWrites returns a context whose reads are sent to the primary once
+// a write was made with it, so they see the write despite the replication lag.
+// Derive it once per unit of work, e.g. per HTTP request. See Config.StickyDuration.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(readYourWritesKey{}).(*lastWrite); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, readYourWritesKey{}, &lastWrite{})
+}
+
+// lastWrite is the time of the last write of a WithReadYourWrites context.
+type lastWrite struct {
+	unixNano atomic.Int64
+}
+
+// replicaRouter selects the replica of each read among the healthy ones. It is
+// the dbresolver policy of a DB.
+type replicaRouter struct {
+	policy   string
+	sticky   time.Duration
+	interval time.Duration
+	logger   *mlog.Logger
+
+	replicas []*replica
+	byPool   map[gorm.ConnPool]*replica
+	next     atomic.Uint64
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+// replica is a replica connection pool and its health.
+type replica struct {
+	name       string
+	weight     int
+	db         *sql.DB
+	healthy    atomic.Bool
+	attributes []attribute.KeyValue
+}
+
+func newReplicaRouter(cfg *Config) (*replicaRouter, error) {
+	switch cfg.ReplicaPolicy {
+	case "":
+		cfg.ReplicaPolicy = ReplicaPolicyRandom
+	case ReplicaPolicyRandom, ReplicaPolicyRoundRobin, ReplicaPolicyWeighted, ReplicaPolicyLeastConnections:
+	default:
+		return nil, merror.NewCodef(mcode.CodeInvalidConfiguration, "unsupported replica policy %q", cfg.ReplicaPolicy)
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = mlog.New()
+	}
+	return &replicaRouter{
+		policy:   cfg.ReplicaPolicy,
+		sticky:   cfg.StickyDuration,
+		interval: cfg.HealthCheckInterval,
+		logger:   logger.With(mlog.String(maltose.COMPONENT, "mdb")),
+		byPool:   make(map[gorm.ConnPool]*replica),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// replicaName returns the name of a replica in logs and metrics.
+func replicaName(cfg *Config, index int) string {
+	if cfg.Host != "" {
+		return net.JoinHostPort(cfg.Host, cfg.Port)
+	}
+	return "replica-" + strconv.Itoa(index)
+}
+
+// add adds a replica, healthy until a ping fails.
+func (rt *replicaRouter) add(name string, weight int, db *sql.DB) {
+	r := &replica{
+		name:       name,
+		weight:     weight,
+		db:         db,
+		attributes: []attribute.KeyValue{attribute.String(metricAttrReplica, name)},
+	}
+	r.healthy.Store(true)
+	replicaMetrics.Healthy.Record(1, mmetric.WithAttributes(r.attributes...))
+	rt.replicas = append(rt.replicas, r)
+	rt.byPool[db] = r
+}
+
+// Resolve implements dbresolver.Policy.
+func (rt *replicaRouter) Resolve(pools []gorm.ConnPool) gorm.ConnPool {
+	candidates := make([]*replica, 0, len(pools))
+	for _, pool := range pools {
+		if r := rt.byPool[pool]; r != nil && r.healthy.Load() {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		// All replicas became unhealthy after the read was routed.
+		return pools[rand.Intn(len(pools))]
+	}
+	r := rt.pick(candidates)
+	replicaMetrics.ReadTotal.Inc(context.Background(), mmetric.WithAttributes(r.attributes...))
+	return r.db
+}
+
+// pick selects a replica among candidates with the policy.
+func (rt *replicaRouter) pick(candidates []*replica) *replica {
+	switch rt.policy {
+	case ReplicaPolicyRoundRobin:
+		return candidates[(rt.next.Add(1)-1)%uint64(len(candidates))]
+	case ReplicaPolicyWeighted:
+		total := 0
+		for _, r := range candidates {
+			total += r.weight
+		}
+		n := rand.Intn(total)
+		for _, r := range candidates {
+			if n < r.weight {
+				return r
+			}
+			n -= r.weight
+		}
+	case ReplicaPolicyLeastConnections:
+		best, bestLoad := candidates[0], -1.0
+		for _, r := range candidates {
+			load := float64(r.db.Stats().InUse) / float64(r.weight)
+			if bestLoad < 0 || load < bestLoad {
+				best, bestLoad = r, load
+			}
+		}
+		return best
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// registerCallbacks keeps reads on the primary when needed by skipping the
+// dbresolver callbacks, and records the writes of WithReadYourWrites contexts.
+func (rt *replicaRouter) registerCallbacks(db *gorm.DB) error {
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Query().Before("*").Replace(resolverCallback, rt.route(callback.Query().Get(resolverCallback))),
+		callback.Row().Before("*").Replace(resolverCallback, rt.route(callback.Row().Get(resolverCallback))),
+		callback.Raw().Before("*").Replace(resolverCallback, rt.route(callback.Raw().Get(resolverCallback))),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	record := func(db *gorm.DB) {
+		recordWrite(db.Statement.Context)
+	}
+	recordRaw := func(db *gorm.DB) {
+		if !isReadSQL(db.Statement.SQL.String()) {
+			recordWrite(db.Statement.Context)
+		}
+	}
+	for _, err := range []error{
+		callback.Create().After("*").Register("mdb:read_your_writes", record),
+		callback.Update().After("*").Register("mdb:read_your_writes", record),
+		callback.Delete().After("*").Register("mdb:read_your_writes", record),
+		callback.Raw().After("*").Register("mdb:read_your_writes", recordRaw),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// route wraps the dbresolver callback resolve, keeping the statement on the
+// connection pool of the primary when its read goes to the primary.
+func (rt *replicaRouter) route(resolve func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if !isTransaction(db.Statement.ConnPool) && rt.readsPrimary(db.Statement) {
+			return
+		}
+		resolve(db)
+	}
+}
+
+// readsPrimary reports whether stmt is a read that goes to the primary.
+func (rt *replicaRouter) readsPrimary(stmt *gorm.Statement) bool {
+	if query := stmt.SQL.String(); query != "" && !isReadSQL(query) {
+		return false
+	}
+	ctx := stmt.Context
+	primary := !rt.anyHealthy()
+	if ctx != nil && !primary {
+		if v, _ := ctx.Value(usePrimaryKey{}).(bool); v {
+			primary = true
+		} else if last, ok := ctx.Value(readYourWritesKey{}).(*lastWrite); ok {
+			if at := last.unixNano.Load(); at != 0 {
+				primary = rt.sticky <= 0 || time.Since(time.Unix(0, at)) < rt.sticky
+			}
+		}
+	}
+	if primary {
+		replicaMetrics.ReadTotal.Inc(context.Background(),
+			mmetric.WithAttributes(attribute.String(metricAttrReplica, primaryRoute)))
+	}
+	return primary
+}
+
+// anyHealthy reports whether a replica is healthy.
+func (rt *replicaRouter) anyHealthy() bool {
+	for _, r := range rt.replicas {
+		if r.healthy.Load() {
+			return true
+		}
+	}
+	return false
+}
+
+// start pings the replicas every interval.
+func (rt *replicaRouter) start() {
+	if rt.interval <= 0 {
+		return
+	}
+	rt.wg.Add(1)
+	go func() {
+		defer rt.wg.Done()
+		ticker := time.NewTicker(rt.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-rt.stop:
+				return
+			case <-ticker.C:
+				rt.probe()
+			}
+		}
+	}()
+}
+
+// probe pings the replicas and updates their health.
+func (rt *replicaRouter) probe() {
+	timeout := min(rt.interval, 5*time.Second)
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	for _, r := range rt.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := r.db.PingContext(ctx)
+		cancel()
+
+		healthy := err == nil
+		if r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				rt.logger.Infof(ctx, "database replica %s is healthy again", r.name)
+			} else {
+				rt.logger.Warnf(ctx, "database replica %s is unhealthy and excluded from reads: %v", r.name, err)
+			}
+		}
+		value := 0.0
+		if healthy {
+			value = 1
+		}
+		mmetric.RecordGauge(ctx, replicaMetrics.Healthy, value, mmetric.WithAttributes(r.attributes...))
+	}
+}
+
+// close stops the health checks and closes the replica connection pools.
+func (rt *replicaRouter) close() {
+	if rt == nil {
+		return
+	}
+	rt.once.Do(func() {
+		close(rt.stop)
+		rt.wg.Wait()
+		for _, r := range rt.replicas {
+			_ = r.db.Close()
+		}
+	})
+}
+
+// recordWrite records a write made with a WithReadYourWrites context.
+func recordWrite(ctx context.Context) {
+	if ctx == nil {
+		return
+	}
+	if last, ok := ctx.Value(readYourWritesKey{}).(*lastWrite); ok {
+		last.unixNano.Store(time.Now().UnixNano())
+	}
+}
+
+// isReadSQL reports whether a raw statement only reads.
+func isReadSQL(query string) bool {
+	query = strings.TrimSpace(query)
+	if len(query) < 6 || !strings.EqualFold(query[:6], "select") {
+		return false
+	}
+	return !strings.HasSuffix(strings.ToLower(query), "for update")
+}
+
+// isTransaction reports whether pool is a transaction, whose statements all go to the primary.
+func isTransaction(pool gorm.ConnPool) bool {
+	_, ok := pool.(gorm.TxCommitter)
+	return ok
+}
@@ -0,0 +1,143 @@
+package mdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// node is a row naming the database it was written to.
+type node struct {
+	Name string
+}
+
+func (node) TableName() string {
+	return "node"
+}
+
+// seedDatabase creates an in-memory database holding a node named name.
+func seedDatabase(t *testing.T, dsn, name string) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(dsn))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&node{}))
+	require.NoError(t, db.Create(&node{Name: name}).Error)
+	// The shared in-memory database lives while a connection is open.
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+}
+
+func setupReplicatedDB(t *testing.T, policy string) *DB {
+	t.Helper()
+	prefix := strings.ReplaceAll(t.Name(), "/", "_")
+	dsn := func(name string) string {
+		return fmt.Sprintf("file:%s_%s?mode=memory&cache=shared", prefix, name)
+	}
+	seedDatabase(t, dsn("primary"), "primary")
+	seedDatabase(t, dsn("replica0"), "replica-0")
+	seedDatabase(t, dsn("replica1"), "replica-1")
+
+	db, err := New(&Config{
+		Type:                "sqlite",
+		DSN:                 dsn("primary"),
+		Replicas:            []Config{{DSN: dsn("replica0")}, {DSN: dsn("replica1")}},
+		ReplicaPolicy:       policy,
+		HealthCheckInterval: -1,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// readNode returns the name of the database a read with ctx was routed to.
+func readNode(t *testing.T, db *DB, ctx context.Context) string {
+	t.Helper()
+	var n node
+	require.NoError(t, db.WithContext(ctx).Order("rowid").First(&n).Error)
+	return n.Name
+}
+
+func TestReplicaRouting(t *testing.T) {
+	t.Run("round_robin", func(t *testing.T) {
+		db := setupReplicatedDB(t, ReplicaPolicyRoundRobin)
+		ctx := context.Background()
+		assert.Equal(t, []string{"replica-0", "replica-1", "replica-0"},
+			[]string{readNode(t, db, ctx), readNode(t, db, ctx), readNode(t, db, ctx)})
+
+		var name string
+		require.NoError(t, db.WithContext(ctx).Raw("SELECT name FROM node").Scan(&name).Error)
+		assert.Equal(t, "replica-1", name)
+	})
+
+	t.Run("use_primary", func(t *testing.T) {
+		db := setupReplicatedDB(t, ReplicaPolicyRandom)
+		assert.Equal(t, "primary", readNode(t, db, UsePrimary(context.Background())))
+		assert.NotEqual(t, "primary", readNode(t, db, context.Background()))
+	})
+
+	t.Run("read_your_writes", func(t *testing.T) {
+		db := setupReplicatedDB(t, ReplicaPolicyRandom)
+		ctx := WithReadYourWrites(context.Background())
+		assert.NotEqual(t, "primary", readNode(t, db, ctx))
+
+		require.NoError(t, db.WithContext(ctx).Create(&node{Name: "written"}).Error)
+		assert.Equal(t, "primary", readNode(t, db, ctx))
+		assert.Equal(t, "primary", readNode(t, db, WithReadYourWrites(ctx)))
+		assert.NotEqual(t, "primary", readNode(t, db, context.Background()))
+
+		raw := WithReadYourWrites(context.Background())
+		require.NoError(t, db.WithContext(raw).Exec("UPDATE node SET name = name").Error)
+		assert.Equal(t, "primary", readNode(t, db, raw))
+	})
+
+	t.Run("excludes_unhealthy_replicas", func(t *testing.T) {
+		db := setupReplicatedDB(t, ReplicaPolicyRoundRobin)
+		require.NoError(t, db.replicas.replicas[0].db.Close())
+		db.replicas.probe()
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, "replica-1", readNode(t, db, context.Background()))
+		}
+
+		require.NoError(t, db.replicas.replicas[1].db.Close())
+		db.replicas.probe()
+		assert.Equal(t, "primary", readNode(t, db, context.Background()))
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		db := setupReplicatedDB(t, ReplicaPolicyWeighted)
+		db.replicas.replicas[1].weight = 1000
+		counts := map[string]int{}
+		for i := 0; i < 50; i++ {
+			counts[readNode(t, db, context.Background())]++
+		}
+		assert.Greater(t, counts["replica-1"], 45)
+	})
+
+	t.Run("least_connections", func(t *testing.T) {
+		db := setupReplicatedDB(t, ReplicaPolicyLeastConnections)
+		tx := db.replicas.replicas[0].db
+		conn, err := tx.Conn(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.Equal(t, "replica-1", readNode(t, db, context.Background()))
+	})
+
+	t.Run("invalid_policy", func(t *testing.T) {
+		_, err := New(&Config{
+			Type:     "sqlite",
+			DSN:      "file:invalid_policy?mode=memory&cache=shared",
+			Replicas: []Config{{DSN: "file:invalid_policy_replica?mode=memory&cache=shared"}},
+
+			ReplicaPolicy: "fastest",
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unsupported replica policy")
+	})
+}
@@ -0,0 +1,151 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Propagation defines how a transaction relates to the transaction of the same
+// database ongoing in its context.
+type Propagation int
+
+const (
+	// PropagationNested runs in a savepoint of the ongoing transaction, rolled
+	// back alone on failure, or in a new transaction if none is ongoing.
+	PropagationNested Propagation = iota
+	// PropagationRequired joins the ongoing transaction, or starts a new one if
+	// none is ongoing. A failure is returned to the ongoing transaction, which
+	// decides whether to roll back.
+	PropagationRequired
+	// PropagationRequiresNew always runs in a new transaction, committed
+	// independently of the ongoing one. It uses a second connection of the pool.
+	PropagationRequiresNew
+)
+
+// TxOptions defines how TransactContextWithOptions runs a transaction.
+type TxOptions struct {
+	// Propagation is the relation to the ongoing transaction. Default is PropagationNested.
+	Propagation Propagation
+	// SQL are the options of new transactions, e.g. the isolation level.
+	SQL *sql.TxOptions
+}
+
+// txKey is the context key of the transaction of a database, identified by
+// its configuration, which all the handles derived from the database share.
+type txKey struct {
+	config *Config
+}
+
+// txState is a transaction or a savepoint carried by a context.
+type txState struct {
+	root   *gorm.DB // database the transaction was started on
+	tx     *DB
+	parent *txState // transaction of a savepoint
+
+	mu    sync.Mutex
+	hooks []func(ctx context.Context)
+}
+
+// addHooks adds after-commit hooks.
+func (s *txState) addHooks(hooks ...func(ctx context.Context)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hooks...)
+}
+
+// takeHooks removes and returns the after-commit hooks.
+func (s *txState) takeHooks() []func(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks := s.hooks
+	s.hooks = nil
+	return hooks
+}
+
+// TransactContext runs fn in a transaction carried by the context passed to fn:
+// WithContext, and so the DAOs and m.DBContext, join it with this context.
+// Nested calls run in savepoints. The transaction is committed if fn returns
+// nil, and rolled back otherwise.
+func (db *DB) TransactContext(ctx context.Context, fn func(ctx context.Context) error) error {
+	return db.TransactContextWithOptions(ctx, TxOptions{}, fn)
+}
+
+// TransactContextWithOptions runs fn like TransactContext with the given options.
+func (db *DB) TransactContextWithOptions(ctx context.Context, opts TxOptions, fn func(ctx context.Context) error) error {
+	return db.transact(ctx, opts, func(ctx context.Context, _ *DB) error {
+		return fn(ctx)
+	})
+}
+
+// AfterCommit registers fn to run once the transaction of ctx is committed,
+// e.g. to invalidate a cache or publish an event. It is discarded if the
+// transaction, or the savepoint of ctx, is rolled back. Without transaction,
+// fn runs immediately.
+func (db *DB) AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	state := db.txState(ctx)
+	if state == nil {
+		fn(ctx)
+		return
+	}
+	state.addHooks(fn)
+}
+
+// txState returns the transaction of the database carried by ctx or the statement context of db.
+func (db *DB) txState(ctx context.Context) *txState {
+	if db == nil || db.DB == nil || db.config == nil {
+		return nil
+	}
+	key := txKey{config: db.config}
+	if ctx != nil {
+		if state, ok := ctx.Value(key).(*txState); ok {
+			return state
+		}
+	}
+	if db.DB.Statement != nil && db.DB.Statement.Context != nil {
+		if state, ok := db.DB.Statement.Context.Value(key).(*txState); ok {
+			return state
+		}
+	}
+	return nil
+}
+
+// transact runs fn in a transaction with the propagation of opts.
+func (db *DB) transact(ctx context.Context, opts TxOptions, fn func(ctx context.Context, tx *DB) error) error {
+	parent := db.txState(ctx)
+	if parent != nil && opts.Propagation == PropagationRequired {
+		ctx = context.WithValue(ctx, txKey{config: db.config}, parent)
+		return fn(ctx, parent.tx.WithContext(ctx))
+	}
+
+	state := &txState{root: db.DB}
+	begin := db.DB
+	switch {
+	case parent != nil && opts.Propagation == PropagationNested:
+		// gorm runs the transactions of a transaction in savepoints.
+		state.root, state.parent, begin = parent.root, parent, parent.tx.DB
+	case parent != nil:
+		state.root, begin = parent.root, parent.root
+	}
+
+	err := begin.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		state.tx = &DB{DB: tx, config: db.config, metrics: db.metrics, replicas: db.replicas}
+		txCtx := context.WithValue(ctx, txKey{config: db.config}, state)
+		return fn(txCtx, state.tx.WithContext(txCtx))
+	}, opts.SQL)
+	if err != nil {
+		return err
+	}
+
+	if state.parent != nil {
+		// The savepoint commits with its transaction.
+		state.parent.addHooks(state.takeHooks()...)
+		return nil
+	}
+	for _, hook := range state.takeHooks() {
+		hook(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package mdb_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/graingo/maltose/database/mdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// migrationFS is a migration directory with a multi-statement migration and a trigger.
+func migrationFS() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/1_create_account.up.sql": {Data: []byte(`
+-- accounts and their audit log
+CREATE TABLE account (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+CREATE TABLE audit (account_id INTEGER);
+`)},
+		"migrations/1_create_account.down.sql": {Data: []byte("DROP TABLE audit;\nDROP TABLE account;\n")},
+		"migrations/2_audit_account.up.sql": {Data: []byte(`
+-- mdb:statement-begin
+CREATE TRIGGER account_audit AFTER INSERT ON account
+BEGIN
+	INSERT INTO audit (account_id) VALUES (NEW.id);
+END;
+-- mdb:statement-end
+`)},
+		"migrations/2_audit_account.down.sql": {Data: []byte("DROP TRIGGER account_audit;")},
+		"migrations/README.md":                {Data: []byte("ignored")},
+	}
+}
+
+func setupMigrationDB(t *testing.T) *mdb.DB {
+	t.Helper()
+	db, err := mdb.New(&mdb.Config{
+		Type: "sqlite",
+		DSN:  filepath.Join(t.TempDir(), "migrate.db") + "?_busy_timeout=5000",
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("up_status_down_redo", func(t *testing.T) {
+		db := setupMigrationDB(t)
+		migrator := mdb.NewMigrator(db)
+		require.NoError(t, migrator.LoadFS(migrationFS(), "migrations"))
+		require.NoError(t, migrator.Register(&mdb.Migration{
+			Version: 3,
+			Name:    "seed_account",
+			Up: func(ctx context.Context, tx *mdb.DB) error {
+				return tx.Exec("INSERT INTO account (name) VALUES (?)", "admin").Error
+			},
+		}))
+
+		applied, err := migrator.UpTo(ctx, 2)
+		require.NoError(t, err)
+		require.Len(t, applied, 2)
+		assert.Equal(t, "1_create_account", applied[0].String())
+
+		applied, err = migrator.Up(ctx)
+		require.NoError(t, err)
+		require.Len(t, applied, 1)
+		var audits int64
+		require.NoError(t, db.Table("audit").Count(&audits).Error)
+		assert.EqualValues(t, 1, audits)
+
+		statuses, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, 3)
+		for _, status := range statuses {
+			assert.True(t, status.Applied)
+			assert.False(t, status.AppliedAt.IsZero())
+		}
+
+		// The Go migration has no down migration.
+		_, err = migrator.Down(ctx, 1)
+		assert.ErrorContains(t, err, "3_seed_account has no down migration")
+
+		redone, err := mdb.NewMigrator(db).Redo(ctx)
+		assert.Nil(t, redone)
+		assert.ErrorContains(t, err, "3_seed_account is not registered")
+
+		migrator = mdb.NewMigrator(db)
+		require.NoError(t, migrator.LoadFS(migrationFS(), "migrations"))
+		statuses, err = migrator.Status(ctx)
+		require.NoError(t, err)
+		assert.True(t, statuses[2].Missing)
+
+		require.NoError(t, db.Exec("DELETE FROM schema_migrations WHERE version = 3").Error)
+		redone, err = migrator.Redo(ctx)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, redone.Version)
+
+		reverted, err := migrator.Down(ctx, 5)
+		require.NoError(t, err)
+		require.Len(t, reverted, 2)
+		assert.False(t, db.Migrator().HasTable("account"))
+		statuses, err = migrator.Status(ctx)
+		require.NoError(t, err)
+		assert.False(t, statuses[0].Applied)
+	})
+
+	t.Run("detects_modified_migrations", func(t *testing.T) {
+		db := setupMigrationDB(t)
+		migrator := mdb.NewMigrator(db)
+		require.NoError(t, migrator.LoadFS(migrationFS(), "migrations"))
+		_, err := migrator.UpTo(ctx, 1)
+		require.NoError(t, err)
+
+		fsys := migrationFS()
+		fsys["migrations/1_create_account.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE account (id INTEGER);")}
+		migrator = mdb.NewMigrator(db)
+		require.NoError(t, migrator.LoadFS(fsys, "migrations"))
+		statuses, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		assert.True(t, statuses[0].Modified)
+
+		applied, err := migrator.Up(ctx)
+		assert.Empty(t, applied)
+		assert.ErrorContains(t, err, "1_create_account was modified after it was applied")
+	})
+
+	t.Run("failed_migration_is_rolled_back", func(t *testing.T) {
+		db := setupMigrationDB(t)
+		migrator := mdb.NewMigrator(db)
+		require.NoError(t, migrator.Register(&mdb.Migration{
+			Version: 1,
+			Name:    "broken",
+			UpSQL:   "CREATE TABLE broken (id INTEGER);\nINSERT INTO missing VALUES (1);",
+		}))
+		_, err := migrator.Up(ctx)
+		assert.ErrorContains(t, err, "failed to apply migration 1_broken")
+		assert.False(t, db.Migrator().HasTable("broken"))
+		statuses, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		assert.False(t, statuses[0].Applied)
+	})
+
+	t.Run("context_carries_the_transaction", func(t *testing.T) {
+		db := setupMigrationDB(t)
+		require.NoError(t, db.Exec("CREATE TABLE seen (name TEXT)").Error)
+		migrator := mdb.NewMigrator(db)
+		require.NoError(t, migrator.Register(&mdb.Migration{
+			Version: 1,
+			Name:    "context",
+			Up: func(ctx context.Context, _ *mdb.DB) error {
+				if err := db.WithContext(ctx).Exec("INSERT INTO seen (name) VALUES (?)", "up").Error; err != nil {
+					return err
+				}
+				return errors.New("failed")
+			},
+		}))
+		_, err := migrator.Up(ctx)
+		assert.ErrorContains(t, err, "failed to apply migration 1_context")
+		var seen int64
+		require.NoError(t, db.Table("seen").Count(&seen).Error)
+		assert.Zero(t, seen)
+	})
+
+	t.Run("serializes_concurrent_runs", func(t *testing.T) {
+		db := setupMigrationDB(t)
+		started, release := make(chan struct{}), make(chan struct{})
+		first := mdb.NewMigrator(db)
+		require.NoError(t, first.Register(&mdb.Migration{
+			Version: 1,
+			Name:    "slow",
+			Up: func(ctx context.Context, tx *mdb.DB) error {
+				close(started)
+				<-release
+				return nil
+			},
+		}))
+		done := make(chan error, 1)
+		go func() {
+			_, err := first.Up(ctx)
+			done <- err
+		}()
+		<-started
+
+		second := mdb.NewMigrator(db, mdb.MigrateConfig{LockTimeout: 300 * time.Millisecond})
+		_, err := second.Up(ctx)
+		assert.ErrorContains(t, err, "waiting for the migration lock")
+
+		close(release)
+		require.NoError(t, <-done)
+		_, err = second.Up(ctx)
+		assert.NoError(t, err)
+	})
+
+	t.Run("breaks_stale_locks", func(t *testing.T) {
+		db := setupMigrationDB(t)
+		_, err := mdb.NewMigrator(db).Up(ctx)
+		require.NoError(t, err)
+		crash := func(lockedAt time.Time) {
+			require.NoError(t, db.Exec("INSERT INTO schema_migrations_lock (id, owner, locked_at) VALUES (1, ?, ?)",
+				"crashed:1", lockedAt).Error)
+		}
+
+		crash(time.Now())
+		migrator := mdb.NewMigrator(db, mdb.MigrateConfig{LockTimeout: 300 * time.Millisecond})
+		_, err = migrator.Up(ctx)
+		assert.ErrorContains(t, err, "waiting for the migration lock")
+		require.NoError(t, migrator.ForceUnlock(ctx))
+		_, err = migrator.Up(ctx)
+		require.NoError(t, err)
+
+		crash(time.Now().Add(-time.Hour))
+		_, err = mdb.NewMigrator(db, mdb.MigrateConfig{LockTimeout: time.Second, StaleLockAge: time.Minute}).Up(ctx)
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid_migrations", func(t *testing.T) {
+		migrator := mdb.NewMigrator(setupMigrationDB(t))
+		err := migrator.LoadFS(fstest.MapFS{"create_account.sql": {Data: []byte("SELECT 1;")}}, ".")
+		assert.ErrorContains(t, err, "invalid migration file name")
+
+		err = migrator.LoadFS(fstest.MapFS{"1_account.down.sql": {Data: []byte("SELECT 1;")}}, ".")
+		assert.ErrorContains(t, err, "has no up migration")
+
+		require.NoError(t, migrator.Register(&mdb.Migration{Version: 1, Name: "a", UpSQL: "SELECT 1;"}))
+		err = migrator.Register(&mdb.Migration{Version: 1, Name: "b", UpSQL: "SELECT 1;"})
+		assert.ErrorContains(t, err, "have the same version")
+	})
+}
@@ -11,8 +11,11 @@ import (
 
 	"github.com/graingo/maltose/database/mdb"
 	"github.com/graingo/maltose/os/mlog"
+	"github.com/graingo/maltose/os/mmetric"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	"gorm.io/gorm"
 )
 
@@ -300,3 +303,40 @@ func TestMDBInstanceIsInvalidatedWhenConfigChanges(t *testing.T) {
 	assert.NotSame(t, first, second)
 	mdb.RemoveConfig(name)
 }
+
+func TestMDBInstanceReportsPoolMetrics(t *testing.T) {
+	const name = "pool-metrics-test"
+	reader := sdkmetric.NewManualReader()
+	provider := mmetric.NewProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+	collector, err := mmetric.StartCollector(mmetric.CollectorConfig{Pools: true, Provider: provider})
+	require.NoError(t, err)
+	defer collector.Stop()
+
+	poolNames := func() []string {
+		var (
+			data  metricdata.ResourceMetrics
+			names []string
+		)
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		for _, sm := range data.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name != "db.client.connection.max" {
+					continue
+				}
+				for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints {
+					pool, _ := point.Attributes.Value(mmetric.AttrDBClientConnectionPool)
+					names = append(names, pool.AsString())
+				}
+			}
+		}
+		return names
+	}
+
+	mdb.SetConfig(name, &mdb.Config{Type: "sqlite", DSN: "file:pool_metrics?mode=memory&cache=shared", MaxOpenConnection: 4})
+	require.NotNil(t, mdb.Instance(name))
+	assert.Contains(t, poolNames(), name)
+
+	mdb.RemoveConfig(name)
+	assert.NotContains(t, poolNames(), name, "closed instance should stop reporting")
+}
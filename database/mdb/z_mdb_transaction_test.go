@@ -0,0 +1,169 @@
+package mdb_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/graingo/maltose/database/mdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// order is a row written in the transaction tests.
+type order struct {
+	ID   uint `gorm:"primaryKey"`
+	Name string
+}
+
+func setupTransactionDB(t *testing.T) *mdb.DB {
+	t.Helper()
+	db, err := mdb.New(&mdb.Config{
+		Type: "sqlite",
+		DSN:  filepath.Join(t.TempDir(), "tx.db") + "?_journal_mode=WAL&_busy_timeout=5000",
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	require.NoError(t, db.AutoMigrate(&order{}))
+	return db
+}
+
+// createOrder is a DAO function getting the database from the context.
+func createOrder(ctx context.Context, db *mdb.DB, name string) error {
+	return db.WithContext(ctx).Create(&order{Name: name}).Error
+}
+
+func orderNames(t *testing.T, db *mdb.DB) []string {
+	t.Helper()
+	var names []string
+	require.NoError(t, db.Model(&order{}).Order("id").Pluck("name", &names).Error)
+	return names
+}
+
+func TestTransactContext(t *testing.T) {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+
+	t.Run("joins_transaction_of_context", func(t *testing.T) {
+		db := setupTransactionDB(t)
+		err := db.TransactContext(ctx, func(ctx context.Context) error {
+			require.NoError(t, createOrder(ctx, db, "rolled-back"))
+			return errFailed
+		})
+		require.ErrorIs(t, err, errFailed)
+		assert.Empty(t, orderNames(t, db))
+
+		require.NoError(t, db.TransactContext(ctx, func(ctx context.Context) error {
+			return createOrder(ctx, db, "committed")
+		}))
+		assert.Equal(t, []string{"committed"}, orderNames(t, db))
+	})
+
+	t.Run("nested_savepoints_and_hooks", func(t *testing.T) {
+		db := setupTransactionDB(t)
+		var hooks []string
+		err := db.TransactContext(ctx, func(ctx context.Context) error {
+			require.NoError(t, createOrder(ctx, db, "outer"))
+			db.AfterCommit(ctx, func(ctx context.Context) { hooks = append(hooks, "outer") })
+
+			err := db.TransactContext(ctx, func(ctx context.Context) error {
+				require.NoError(t, createOrder(ctx, db, "failed"))
+				db.AfterCommit(ctx, func(ctx context.Context) { hooks = append(hooks, "failed") })
+				return errFailed
+			})
+			require.ErrorIs(t, err, errFailed)
+
+			require.NoError(t, db.Transact(ctx, func(tx *mdb.DB) error {
+				db.AfterCommit(tx.Statement.Context, func(ctx context.Context) { hooks = append(hooks, "inner") })
+				return tx.Create(&order{Name: "inner"}).Error
+			}))
+			assert.Empty(t, hooks)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"outer", "inner"}, orderNames(t, db))
+		assert.Equal(t, []string{"outer", "inner"}, hooks)
+	})
+
+	t.Run("required_joins_without_savepoint", func(t *testing.T) {
+		db := setupTransactionDB(t)
+		err := db.TransactContext(ctx, func(ctx context.Context) error {
+			err := db.TransactContextWithOptions(ctx, mdb.TxOptions{Propagation: mdb.PropagationRequired}, func(ctx context.Context) error {
+				require.NoError(t, createOrder(ctx, db, "joined"))
+				return errFailed
+			})
+			require.ErrorIs(t, err, errFailed)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"joined"}, orderNames(t, db))
+	})
+
+	t.Run("requires_new_commits_independently", func(t *testing.T) {
+		db := setupTransactionDB(t)
+		var hooked bool
+		err := db.TransactContext(ctx, func(ctx context.Context) error {
+			err := db.TransactContextWithOptions(ctx, mdb.TxOptions{Propagation: mdb.PropagationRequiresNew}, func(ctx context.Context) error {
+				db.AfterCommit(ctx, func(ctx context.Context) { hooked = true })
+				return createOrder(ctx, db, "audit")
+			})
+			require.NoError(t, err)
+			assert.True(t, hooked)
+			require.NoError(t, createOrder(ctx, db, "rolled-back"))
+			return errFailed
+		})
+		require.ErrorIs(t, err, errFailed)
+		assert.Equal(t, []string{"audit"}, orderNames(t, db))
+	})
+
+	t.Run("derived_handles_share_the_transaction", func(t *testing.T) {
+		db := setupTransactionDB(t)
+		var hooks []string
+		err := db.TransactContext(ctx, func(ctx context.Context) error {
+			// The handle of m.DBContext(ctx).
+			handle := db.WithContext(ctx)
+			require.NoError(t, handle.Create(&order{Name: "rolled-back"}).Error)
+			handle.AfterCommit(ctx, func(ctx context.Context) { hooks = append(hooks, "handle") })
+
+			err := handle.TransactContextWithOptions(ctx, mdb.TxOptions{Propagation: mdb.PropagationRequired}, func(ctx context.Context) error {
+				return createOrder(ctx, db.WithContext(ctx), "joined")
+			})
+			require.NoError(t, err)
+			return errFailed
+		})
+		require.ErrorIs(t, err, errFailed)
+		assert.Empty(t, orderNames(t, db))
+		assert.Empty(t, hooks, "hooks of a rolled back transaction are discarded")
+
+		err = db.Transact(ctx, func(tx *mdb.DB) error {
+			require.NoError(t, tx.Create(&order{Name: "outer"}).Error)
+			tx.AfterCommit(ctx, func(ctx context.Context) { hooks = append(hooks, "tx") })
+			err := tx.Transact(ctx, func(nested *mdb.DB) error {
+				require.NoError(t, nested.Create(&order{Name: "savepoint"}).Error)
+				nested.AfterCommit(ctx, func(ctx context.Context) { hooks = append(hooks, "savepoint") })
+				return errFailed
+			})
+			require.ErrorIs(t, err, errFailed)
+			assert.Empty(t, hooks)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"outer"}, orderNames(t, db))
+		assert.Equal(t, []string{"tx"}, hooks)
+	})
+
+	t.Run("after_commit", func(t *testing.T) {
+		db := setupTransactionDB(t)
+		var calls int
+		db.AfterCommit(ctx, func(ctx context.Context) { calls++ })
+		assert.Equal(t, 1, calls)
+
+		err := db.TransactContext(ctx, func(ctx context.Context) error {
+			db.AfterCommit(ctx, func(ctx context.Context) { calls++ })
+			return errFailed
+		})
+		require.ErrorIs(t, err, errFailed)
+		assert.Equal(t, 1, calls)
+	})
+}
@@ -13,9 +13,10 @@ import (
 
 // Redis is the main struct for redis operations.
 type Redis struct {
-	client redis.UniversalClient
-	config *Config
-	mu     sync.RWMutex
+	client         redis.UniversalClient
+	config         *Config
+	mu             sync.RWMutex
+	unregisterPool func()
 }
 
 type Hook redis.Hook
@@ -92,6 +93,7 @@ func (r *Redis) Ping(ctx context.Context) error {
 
 // Close closes the client, releasing any open resources.
 func (r *Redis) Close() error {
+	r.unregisterPoolMetrics()
 	return r.client.Close()
 }
 
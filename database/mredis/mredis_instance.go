@@ -33,6 +33,7 @@ func Instance(name ...string) *Redis {
 				intlog.Errorf(context.TODO(), `new redis instance failed: "%s": %v`, key, err)
 				return nil
 			}
+			r.RegisterPoolMetrics(key)
 			return r
 		}
 		return nil
@@ -0,0 +1,49 @@
+package mredis
+
+import (
+	"time"
+
+	"github.com/graingo/maltose/os/mmetric"
+)
+
+// RegisterPoolMetrics reports the client pool statistics under the given
+// instance name when a mmetric.Collector with pool metrics is running.
+// Instances created by Instance and by the framework are registered
+// automatically. Registering again replaces the previous name, and Close
+// removes the registration.
+func (r *Redis) RegisterPoolMetrics(name string) {
+	if r == nil || r.client == nil {
+		return
+	}
+	client, poolSize := r.client, int64(r.config.PoolSize)
+	unregister := mmetric.RegisterPool("redis", name, func() mmetric.PoolStats {
+		stats := client.PoolStats()
+		return mmetric.PoolStats{
+			MaxOpen:      poolSize,
+			Idle:         int64(stats.IdleConns),
+			InUse:        int64(stats.TotalConns) - int64(stats.IdleConns),
+			WaitCount:    int64(stats.WaitCount),
+			WaitDuration: time.Duration(stats.WaitDurationNs),
+			Timeouts:     int64(stats.Timeouts),
+			Closed:       int64(stats.StaleConns),
+		}
+	})
+
+	r.mu.Lock()
+	previous := r.unregisterPool
+	r.unregisterPool = unregister
+	r.mu.Unlock()
+	if previous != nil {
+		previous()
+	}
+}
+
+func (r *Redis) unregisterPoolMetrics() {
+	r.mu.Lock()
+	unregister := r.unregisterPool
+	r.unregisterPool = nil
+	r.mu.Unlock()
+	if unregister != nil {
+		unregister()
+	}
+}
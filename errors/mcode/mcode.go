@@ -34,6 +34,45 @@ var (
 	CodeBusinessValidationFailed = localCode{5000, "Business Validation Failed", nil}
 )
 
+// predefinedCodes are the predefined error codes by number.
+var predefinedCodes = map[int]Code{}
+
+func init() {
+	for _, code := range []Code{
+		CodeOK,
+		CodeUnknown,
+		CodeInvalidRequest,
+		CodeInvalidParameter,
+		CodeMissingParameter,
+		CodeValidationFailed,
+		CodeNotFound,
+		CodeNotAuthorized,
+		CodeForbidden,
+		CodeInternalError,
+		CodeDbOperationError,
+		CodeInternalPanic,
+		CodeServerBusy,
+		CodeRateLimitExceeded,
+		CodeInvalidOperation,
+		CodeInvalidConfiguration,
+		CodeMissingConfiguration,
+		CodeNotImplemented,
+		CodeNotSupported,
+		CodeOperationFailed,
+		CodeSecurityReason,
+		CodeBusinessValidationFailed,
+	} {
+		predefinedCodes[code.Code()] = code
+	}
+}
+
+// Lookup returns the predefined error code numbered code, e.g. to restore the
+// code of an error received from another service.
+func Lookup(code int) (Code, bool) {
+	c, ok := predefinedCodes[code]
+	return c, ok
+}
+
 // New creates a new error code.
 func New(code int, message string, detail any) Code {
 	return localCode{
@@ -47,3 +47,14 @@ func TestLocalCode_String(t *testing.T) {
 	c3 := mcode.New(500, "", nil)
 	assert.Equal(t, "500", fmt.Sprintf("%s", c3))
 }
+
+func TestLookup(t *testing.T) {
+	code, ok := mcode.Lookup(1004)
+	assert.True(t, ok)
+	assert.Equal(t, mcode.CodeNotFound, code)
+
+	_, ok = mcode.Lookup(-1)
+	assert.False(t, ok)
+	_, ok = mcode.Lookup(99)
+	assert.False(t, ok)
+}
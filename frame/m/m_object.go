@@ -6,6 +6,7 @@ import (
 	"github.com/graingo/maltose/database/mdb"
 	"github.com/graingo/maltose/database/mredis"
 	"github.com/graingo/maltose/frame/mins"
+	"github.com/graingo/maltose/net/mclient"
 	"github.com/graingo/maltose/net/mhttp"
 	"github.com/graingo/maltose/os/mcfg"
 	"github.com/graingo/maltose/os/mlog"
@@ -47,6 +48,7 @@ func TryDB(name ...string) (*mdb.DB, error) {
 }
 
 // DBContext returns the instance of the database with the specified name and context.
+// It joins the transaction of the database carried by ctx, see mdb.DB.TransactContext.
 func DBContext(ctx context.Context, name ...string) *mdb.DB {
 	return mins.DB(name...).WithContext(ctx)
 }
@@ -65,3 +67,13 @@ func Redis(name ...string) *mredis.Redis {
 func TryRedis(name ...string) (*mredis.Redis, error) {
 	return mins.TryRedis(name...)
 }
+
+// Client returns the instance of the HTTP client with the specified name.
+func Client(name ...string) *mclient.Client {
+	return mins.Client(name...)
+}
+
+// TryClient returns an HTTP client instance or an initialization error.
+func TryClient(name ...string) (*mclient.Client, error) {
+	return mins.TryClient(name...)
+}
@@ -11,6 +11,7 @@ const (
 	frameCoreNameRedis  = "maltose.redis"
 	frameCoreNameServer = "maltose.server"
 	frameCoreNameDB     = "maltose.db"
+	frameCoreNameClient = "maltose.client"
 )
 
 // Scope owns framework instances for one application or test boundary.
@@ -21,6 +22,7 @@ type Scope struct {
 	redisInstances      *minstance.Container
 	serverInstances     *minstance.Container
 	loggerInstances     *minstance.Container
+	clientInstances     *minstance.Container
 	useGlobalComponents bool
 }
 
@@ -47,6 +49,7 @@ func newScope(config *mcfg.Config, useGlobalComponents bool) *Scope {
 		redisInstances:      minstance.New(),
 		serverInstances:     minstance.New(),
 		loggerInstances:     minstance.New(),
+		clientInstances:     minstance.New(),
 		useGlobalComponents: useGlobalComponents,
 	}
 }
@@ -0,0 +1,93 @@
+package mins
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/graingo/maltose/os/mlog"
+)
+
+const (
+	configNodeNameClient = "client" // config node name for http client
+)
+
+// Client returns an HTTP client instance from the default scope.
+func Client(name ...string) *mclient.Client {
+	return defaultScope.Client(name...)
+}
+
+// Client returns an HTTP client instance owned by the scope.
+// Its requests are logged to the client logger configuration, or to the scope logger.
+func (s *Scope) Client(name ...string) *mclient.Client {
+	var (
+		ctx          = context.Background()
+		instanceName = mclient.DefaultName
+	)
+	if len(name) > 0 && name[0] != "" {
+		instanceName = name[0]
+	}
+	instanceKey := fmt.Sprintf("%s.%s", frameCoreNameClient, instanceName)
+
+	// Create each named instance at most once within the scope.
+	instance := s.clientInstances.GetOrSetFunc(instanceKey, func() any {
+		client := mclient.New()
+
+		// Clients work without configuration, only apply it when available.
+		var clientConfigMap map[string]any
+		if s.Config().Available(ctx) {
+			configMap, err := s.Config().Data(ctx)
+			if err != nil {
+				panic(merror.NewCodef(mcode.CodeMissingConfiguration, `retrieve config data map failed: %v`, err))
+			}
+
+			if clientConfigNode, ok := configMap[configNodeNameClient]; ok {
+				globalConfigMap := mustConfigMap(clientConfigNode, configNodeNameClient)
+				// try to get specific instance config
+				if instanceConfig, ok := globalConfigMap[instanceName]; ok {
+					clientConfigMap = mustConfigMap(instanceConfig, fmt.Sprintf("%s.%s", configNodeNameClient, instanceName))
+				} else if defaultConfig, ok := globalConfigMap["default"]; ok {
+					// try to get default instance config
+					clientConfigMap = mustConfigMap(defaultConfig, configNodeNameClient+".default")
+				} else if len(globalConfigMap) > 0 {
+					// use flat structure config
+					clientConfigMap = globalConfigMap
+				}
+			}
+		}
+
+		if len(clientConfigMap) > 0 {
+			if err := client.SetConfigWithMap(clientConfigMap); err != nil {
+				panic(merror.NewCodef(mcode.CodeInvalidConfiguration, `set client config failed for instance "%s": %v`, instanceName, err))
+			}
+		}
+
+		// Prefer the client logger configuration, then the scope logger.
+		logger := s.Log()
+		if loggerConfig, ok := clientConfigMap[configNodeNameLogger]; ok {
+			logger = mlog.New()
+			loggerConfigMap := mustConfigMap(loggerConfig, fmt.Sprintf("%s.%s.%s", configNodeNameClient, instanceName, configNodeNameLogger))
+			if err := logger.SetConfigWithMap(loggerConfigMap); err != nil {
+				panic(merror.NewCodef(mcode.CodeInvalidConfiguration, `set client logger config failed for instance "%s": %v`, instanceName, err))
+			}
+		}
+		client.Use(mclient.MiddlewareLog(logger))
+		return client
+	})
+
+	return instance.(*mclient.Client)
+}
+
+// TryClient returns an HTTP client instance or an initialization error.
+// Unlike Client, it does not panic when the configuration is invalid.
+func TryClient(name ...string) (client *mclient.Client, err error) {
+	return defaultScope.TryClient(name...)
+}
+
+// TryClient returns a scoped HTTP client instance or an initialization error.
+func (s *Scope) TryClient(name ...string) (client *mclient.Client, err error) {
+	defer recoverAsError(&err)
+	return s.Client(name...), nil
+}
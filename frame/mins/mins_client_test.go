@@ -0,0 +1,67 @@
+package mins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopeBuildsConfiguredClients(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("X-Service") + " " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	scope := NewScope(newConfigFromYAML(t, `
+client:
+  default:
+    timeout: 5s
+  payment:
+    base_url: `+server.URL+`/api
+    timeout: 2s
+    header:
+      X-Service: payment
+    retry:
+      count: 1
+      base_interval: 1ms
+    rate_limit:
+      requests_per_second: 100
+      burst: 10
+    logger:
+      service_name: payment-client
+`))
+
+	payment := scope.Client("payment")
+	assert.Same(t, payment, scope.Client("payment"))
+	assert.NotSame(t, payment, scope.Client())
+	assert.Equal(t, 2*time.Second, payment.GetClient().Timeout)
+	assert.Equal(t, 5*time.Second, scope.Client("other").GetClient().Timeout)
+
+	resp, err := payment.R().Get("/orders")
+	require.NoError(t, err)
+	defer resp.Close()
+	assert.Equal(t, "payment /api/orders", resp.ReadAllString())
+	assert.Equal(t, int32(2), hits.Load())
+}
+
+func TestScopeClientWithoutConfiguration(t *testing.T) {
+	scope := NewScope(newConfigFromYAML(t, "logger: {}"))
+	client, err := scope.TryClient()
+	require.NoError(t, err)
+	assert.NotNil(t, client)
+
+	scope = NewScope(newConfigFromYAML(t, "client:\n  default:\n    proxy: \"://invalid\""))
+	client, err = scope.TryClient()
+	assert.Nil(t, client)
+	assert.Error(t, err)
+}
@@ -97,6 +97,7 @@ func (s *Scope) DB(name ...string) *mdb.DB {
 		if err != nil {
 			panic(err)
 		}
+		db.RegisterPoolMetrics(instanceName)
 		return db
 	})
 
@@ -0,0 +1,43 @@
+package mins
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopeDBContextJoinsTransaction(t *testing.T) {
+	scope := NewScope(newConfigFromYAML(t, `
+database:
+  type: sqlite
+  dsn: "file:mins-transaction?mode=memory&cache=shared"
+`))
+	database := scope.DB()
+	t.Cleanup(func() { require.NoError(t, database.Close()) })
+
+	type record struct {
+		ID   uint
+		Name string
+	}
+	ctx := context.Background()
+	require.NoError(t, database.WithContext(ctx).AutoMigrate(&record{}))
+
+	errFailed := errors.New("failed")
+	var hooks []string
+	err := database.TransactContext(ctx, func(ctx context.Context) error {
+		handle, err := scope.TryDBContext(ctx)
+		require.NoError(t, err)
+		require.NoError(t, handle.Create(&record{Name: "joined"}).Error)
+		handle.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "joined") })
+		return errFailed
+	})
+	require.ErrorIs(t, err, errFailed)
+
+	var count int64
+	require.NoError(t, database.WithContext(ctx).Model(&record{}).Count(&count).Error)
+	assert.Zero(t, count)
+	assert.Empty(t, hooks)
+}
@@ -104,6 +104,7 @@ func (s *Scope) Redis(name ...string) *mredis.Redis {
 		if err != nil {
 			panic(err)
 		}
+		redisClient.RegisterPoolMetrics(instanceName)
 		return redisClient
 	})
 
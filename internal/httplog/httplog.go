@@ -0,0 +1,182 @@
+// Package httplog provides the sampling, body capture and redaction helpers
+// shared by the mhttp and mclient log middlewares.
+package httplog
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Sampler decides whether a completed request should be logged.
+// Failed and slow requests are always logged; successful requests are
+// logged with the rate configured for their route.
+type Sampler struct {
+	rate          float64
+	routeRates    map[string]float64
+	slowThreshold time.Duration
+}
+
+// NewSampler creates a sampler. A rate outside (0, 1) is treated as 1,
+// while per-route rates are clamped into [0, 1] so that a route can be muted.
+func NewSampler(rate float64, routeRates map[string]float64, slowThreshold time.Duration) *Sampler {
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	routes := make(map[string]float64, len(routeRates))
+	for route, routeRate := range routeRates {
+		routes[route] = clampRate(routeRate)
+	}
+	return &Sampler{
+		rate:          rate,
+		routeRates:    routes,
+		slowThreshold: slowThreshold,
+	}
+}
+
+// Rate returns the sampling rate applied to successful requests of route.
+func (s *Sampler) Rate(route string) float64 {
+	if s == nil {
+		return 1
+	}
+	if rate, ok := s.routeRates[route]; ok {
+		return rate
+	}
+	return s.rate
+}
+
+// Sample reports whether the request should be logged.
+func (s *Sampler) Sample(route string, failed bool, latency time.Duration) bool {
+	if s == nil || failed {
+		return true
+	}
+	if s.slowThreshold > 0 && latency >= s.slowThreshold {
+		return true
+	}
+	rate := s.Rate(route)
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return rand.Float64() < rate
+}
+
+func clampRate(rate float64) float64 {
+	if rate < 0 {
+		return 0
+	}
+	if rate > 1 {
+		return 1
+	}
+	return rate
+}
+
+// BodyLimits resolves the body capture limit for a content type.
+// A limit of zero disables capture and a negative limit captures everything.
+type BodyLimits struct {
+	defaultLimit int
+	limits       map[string]int
+}
+
+// NewBodyLimits creates body limits from a default and per media type overrides.
+// Media type keys may use wildcards such as "text/*".
+func NewBodyLimits(defaultLimit int, contentTypes map[string]int) BodyLimits {
+	limits := make(map[string]int, len(contentTypes))
+	for contentType, limit := range contentTypes {
+		limits[strings.ToLower(strings.TrimSpace(contentType))] = limit
+	}
+	return BodyLimits{defaultLimit: defaultLimit, limits: limits}
+}
+
+// Enabled reports whether any content type has body capture enabled.
+func (b BodyLimits) Enabled() bool {
+	if b.defaultLimit != 0 {
+		return true
+	}
+	for _, limit := range b.limits {
+		if limit != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Limit returns the capture limit for contentType.
+func (b BodyLimits) Limit(contentType string) int {
+	if len(b.limits) == 0 {
+		return b.defaultLimit
+	}
+	mediaType := MediaType(contentType)
+	if mediaType == "" {
+		return b.defaultLimit
+	}
+	if limit, ok := b.limits[mediaType]; ok {
+		return limit
+	}
+	if i := strings.IndexByte(mediaType, '/'); i > 0 {
+		if limit, ok := b.limits[mediaType[:i]+"/*"]; ok {
+			return limit
+		}
+	}
+	if limit, ok := b.limits["*/*"]; ok {
+		return limit
+	}
+	return b.defaultLimit
+}
+
+// MediaType returns the lower-cased media type of a Content-Type header value.
+func MediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = strings.Cut(contentType, ";")
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+type replayReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ReadBody reads up to limit+1 bytes of body for logging and returns a reader
+// that replays the captured bytes followed by the unread remainder.
+func ReadBody(body io.ReadCloser, limit int) ([]byte, io.ReadCloser) {
+	if limit < 0 {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, body
+		}
+		_ = body.Close()
+		return data, io.NopCloser(bytes.NewReader(data))
+	}
+	data, err := io.ReadAll(io.LimitReader(body, int64(limit+1)))
+	if err != nil {
+		return nil, body
+	}
+	return data, &replayReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(data), body),
+		Closer: body,
+	}
+}
+
+// BodyString safely converts a byte slice to a string for logging, with a size limit.
+func BodyString(body []byte, limit int) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if limit < 0 { // no limit
+		return string(body)
+	}
+	if len(body) > limit {
+		return string(body[:limit]) + "..."
+	}
+	return string(body)
+}
@@ -0,0 +1,63 @@
+package httplog
+
+import "time"
+
+// SamplingConfig defines log sampling.
+// Failed requests and slow requests are always logged.
+type SamplingConfig struct {
+	// Rate is the fraction of successful requests that are logged.
+	// A value <= 0 or > 1 logs every request.
+	Rate float64
+	// RouteRates overrides Rate per route: the route pattern such as "/users/:id"
+	// of server requests, or the URL path of client requests. A rate of 0 mutes
+	// successful requests.
+	RouteRates map[string]float64
+	// SlowThreshold forces requests taking at least this long to be logged. Zero disables it.
+	SlowThreshold time.Duration
+}
+
+// BodyConfig defines request and response body capture.
+type BodyConfig struct {
+	// MaxSize is the capture limit in bytes. Zero disables body logging and a negative value is unlimited.
+	MaxSize int
+	// ContentTypes overrides MaxSize per media type, e.g. "application/json" or "text/*".
+	ContentTypes map[string]int
+}
+
+// RedactionConfig defines which values are masked in logs.
+type RedactionConfig struct {
+	// Headers lists header names whose values are masked.
+	Headers []string
+	// Query lists query parameter and form field names whose values are masked.
+	Query []string
+	// JSONPaths lists JSON body fields to mask. A name without dots, such as "password",
+	// matches at any depth; dotted paths such as "user.token" are anchored at the root
+	// and "*" matches any key or array index.
+	JSONPaths []string
+	// Mask replaces redacted values. It defaults to "***".
+	Mask string
+}
+
+// DefaultRedactionConfig returns redaction rules for commonly sensitive values.
+func DefaultRedactionConfig() RedactionConfig {
+	return RedactionConfig{
+		Headers:   []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"},
+		Query:     []string{"token", "access_token", "refresh_token", "password", "secret"},
+		JSONPaths: []string{"password", "token", "access_token", "refresh_token", "secret"},
+	}
+}
+
+// Sampler creates the sampler of the configuration.
+func (c SamplingConfig) Sampler() *Sampler {
+	return NewSampler(c.Rate, c.RouteRates, c.SlowThreshold)
+}
+
+// Limits returns the body limits of the configuration.
+func (c BodyConfig) Limits() BodyLimits {
+	return NewBodyLimits(c.MaxSize, c.ContentTypes)
+}
+
+// Redactor creates the redactor of the configuration.
+func (c RedactionConfig) Redactor() *Redactor {
+	return NewRedactor(c.Headers, c.Query, c.JSONPaths, c.Mask)
+}
@@ -0,0 +1,262 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultMask replaces redacted values when no mask is configured.
+const DefaultMask = "***"
+
+// Redactor masks sensitive headers, query parameters and JSON body fields.
+type Redactor struct {
+	mask      string
+	headers   map[string]struct{}
+	query     map[string]struct{}
+	anywhere  map[string]struct{} // JSON keys redacted at any depth
+	anchored  [][]string          // JSON paths anchored at the document root
+	textMatch *regexp.Regexp      // fallback for truncated or invalid JSON
+}
+
+// NewRedactor creates a redactor.
+// Header and query names are matched case-insensitively. A JSON path without
+// dots, such as "password", matches the key at any depth; a dotted path such as
+// "user.credentials.token" is anchored at the root and "*" matches any key or
+// array index.
+func NewRedactor(headers, query, jsonPaths []string, mask string) *Redactor {
+	if mask == "" {
+		mask = DefaultMask
+	}
+	r := &Redactor{
+		mask:     mask,
+		headers:  make(map[string]struct{}, len(headers)),
+		query:    make(map[string]struct{}, len(query)),
+		anywhere: make(map[string]struct{}),
+	}
+	for _, name := range headers {
+		r.headers[strings.ToLower(strings.TrimSpace(name))] = struct{}{}
+	}
+	for _, name := range query {
+		r.query[strings.ToLower(strings.TrimSpace(name))] = struct{}{}
+	}
+
+	leafNames := make([]string, 0, len(jsonPaths))
+	for _, path := range jsonPaths {
+		path = strings.TrimPrefix(strings.TrimSpace(path), "$.")
+		if path == "" {
+			continue
+		}
+		segments := strings.Split(strings.ToLower(path), ".")
+		if len(segments) == 1 {
+			r.anywhere[segments[0]] = struct{}{}
+		} else {
+			r.anchored = append(r.anchored, segments)
+		}
+		if leaf := segments[len(segments)-1]; leaf != "*" {
+			leafNames = append(leafNames, regexp.QuoteMeta(leaf))
+		}
+	}
+	if len(leafNames) > 0 {
+		r.textMatch = regexp.MustCompile(
+			`(?i)"(` + strings.Join(leafNames, "|") + `)"(\s*:\s*)(?:"(?:[^"\\]|\\.)*"?|[^,}\]\s]*)`,
+		)
+	}
+	return r
+}
+
+// Mask returns the replacement used for redacted values.
+func (r *Redactor) Mask() string {
+	return r.mask
+}
+
+// HeaderRedacted reports whether the header name is redacted.
+func (r *Redactor) HeaderRedacted(name string) bool {
+	_, ok := r.headers[strings.ToLower(name)]
+	return ok
+}
+
+// QueryRedacted reports whether the query parameter name is redacted.
+func (r *Redactor) QueryRedacted(name string) bool {
+	_, ok := r.query[strings.ToLower(name)]
+	return ok
+}
+
+// Header flattens header into a map with redacted values masked.
+func (r *Redactor) Header(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	fields := make(map[string]string, len(header))
+	for name, values := range header {
+		if r.HeaderRedacted(name) {
+			fields[name] = r.mask
+			continue
+		}
+		fields[name] = strings.Join(values, ", ")
+	}
+	return fields
+}
+
+// Query encodes values with redacted parameters masked.
+func (r *Redactor) Query(values url.Values) string {
+	if len(values) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	for _, key := range keys {
+		redacted := r.QueryRedacted(key)
+		for _, value := range values[key] {
+			if buf.Len() > 0 {
+				buf.WriteByte('&')
+			}
+			buf.WriteString(url.QueryEscape(key))
+			buf.WriteByte('=')
+			if redacted {
+				buf.WriteString(r.mask)
+			} else {
+				buf.WriteString(url.QueryEscape(value))
+			}
+		}
+	}
+	return buf.String()
+}
+
+// RequestURI returns the request URI of u with redacted query parameters masked.
+func (r *Redactor) RequestURI(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery == "" {
+		return path
+	}
+	return path + "?" + r.Query(u.Query())
+}
+
+// Body redacts a captured body according to its content type.
+// JSON bodies have configured paths masked and form bodies have
+// redacted query parameter names masked.
+func (r *Redactor) Body(contentType string, body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	mediaType := MediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if len(r.query) == 0 {
+			return body
+		}
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return body
+		}
+		return []byte(r.Query(values))
+	case isJSON(mediaType, body):
+		return r.JSON(body)
+	default:
+		return body
+	}
+}
+
+// JSON masks configured paths in a JSON document. Documents that cannot be
+// decoded, for example because the capture was truncated, fall back to
+// masking matching keys textually.
+func (r *Redactor) JSON(body []byte) []byte {
+	if len(r.anywhere) == 0 && len(r.anchored) == 0 {
+		return body
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil || decoder.More() {
+		return r.redactText(body)
+	}
+	redacted, err := json.Marshal(r.walk(document, nil))
+	if err != nil {
+		return r.redactText(body)
+	}
+	return redacted
+}
+
+func (r *Redactor) walk(value any, path []string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			childPath := append(path[:len(path):len(path)], strings.ToLower(key))
+			if r.matches(childPath) {
+				v[key] = r.mask
+				continue
+			}
+			v[key] = r.walk(child, childPath)
+		}
+		return v
+	case []any:
+		for i, child := range v {
+			childPath := append(path[:len(path):len(path)], strconv.Itoa(i))
+			if r.matches(childPath) {
+				v[i] = r.mask
+				continue
+			}
+			v[i] = r.walk(child, childPath)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+func (r *Redactor) matches(path []string) bool {
+	if _, ok := r.anywhere[path[len(path)-1]]; ok {
+		return true
+	}
+	for _, pattern := range r.anchored {
+		if len(pattern) != len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range pattern {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) redactText(body []byte) []byte {
+	if r.textMatch == nil {
+		return body
+	}
+	mask := strings.ReplaceAll(r.mask, "$", "$$")
+	return r.textMatch.ReplaceAll(body, []byte(`"$1"$2"`+mask+`"`))
+}
+
+func isJSON(mediaType string, body []byte) bool {
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		return true
+	}
+	if mediaType != "" && mediaType != "text/plain" {
+		return false
+	}
+	trimmed := bytes.TrimSpace(body)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+}
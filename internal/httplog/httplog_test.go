@@ -0,0 +1,74 @@
+package httplog
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler(t *testing.T) {
+	sampler := NewSampler(0, map[string]float64{"/health": 0, "/noisy": 2}, time.Second)
+
+	assert.Equal(t, 1.0, sampler.Rate("/users"))
+	assert.Equal(t, 0.0, sampler.Rate("/health"))
+	assert.Equal(t, 1.0, sampler.Rate("/noisy"))
+	assert.True(t, sampler.Sample("/users", false, 0))
+	assert.False(t, sampler.Sample("/health", false, 0))
+	assert.True(t, sampler.Sample("/health", true, 0), "failures are always sampled")
+	assert.True(t, sampler.Sample("/health", false, 2*time.Second), "slow requests are always sampled")
+}
+
+func TestBodyLimits(t *testing.T) {
+	limits := NewBodyLimits(16, map[string]int{"application/json": -1, "text/*": 0, "IMAGE/PNG": 4})
+
+	assert.True(t, limits.Enabled())
+	assert.Equal(t, -1, limits.Limit("application/json; charset=utf-8"))
+	assert.Equal(t, 0, limits.Limit("text/html"))
+	assert.Equal(t, 4, limits.Limit("image/png"))
+	assert.Equal(t, 16, limits.Limit("application/octet-stream"))
+	assert.Equal(t, 16, limits.Limit(""))
+	assert.False(t, NewBodyLimits(0, nil).Enabled())
+}
+
+func TestRedactor(t *testing.T) {
+	redactor := NewRedactor(
+		[]string{"Authorization"},
+		[]string{"token"},
+		[]string{"password", "items.*.secret", "$.meta.key"},
+		"",
+	)
+
+	t.Run("header_and_query", func(t *testing.T) {
+		header := http.Header{"Authorization": {"Bearer x"}, "Accept": {"a", "b"}}
+		assert.Equal(t, map[string]string{"Authorization": DefaultMask, "Accept": "a, b"}, redactor.Header(header))
+
+		u, _ := url.Parse("/path?b=2&TOKEN=abc&a=1")
+		assert.Equal(t, "/path?TOKEN=***&a=1&b=2", redactor.RequestURI(u))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		body := []byte(`{"user":{"password":"p"},"items":[{"secret":"s","id":1}],"meta":{"key":"k"},"key":"visible"}`)
+		assert.JSONEq(t,
+			`{"user":{"password":"***"},"items":[{"secret":"***","id":1}],"meta":{"key":"***"},"key":"visible"}`,
+			string(redactor.Body("application/json", body)),
+		)
+	})
+
+	t.Run("truncated_json", func(t *testing.T) {
+		body := []byte(`{"password": "hunter2", "secret": "s", "name":"al`)
+		assert.Equal(t, `{"password": "***", "secret": "***", "name":"al`, string(redactor.Body("application/json", body)))
+	})
+
+	t.Run("form", func(t *testing.T) {
+		body := []byte("token=abc&name=bob")
+		assert.Equal(t, "name=bob&token=***", string(redactor.Body("application/x-www-form-urlencoded", body)))
+	})
+
+	t.Run("other_content", func(t *testing.T) {
+		body := []byte(`password=plain`)
+		assert.Equal(t, body, redactor.Body("application/octet-stream", body))
+	})
+}
@@ -1,12 +1,17 @@
 package mclient
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
 	"time"
 
 	"github.com/graingo/maltose"
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/os/mlog"
 )
 
 // Client is an HTTP client with enhanced features.
@@ -14,6 +19,12 @@ type Client struct {
 	client      *http.Client     // HTTP client for the request.
 	config      ClientConfig     // Default configuration for the client.
 	middlewares []MiddlewareFunc // Middleware functions.
+	balancer    *LoadBalancer    // Load balancer of relative URLs, nil to use BaseURL.
+	hedger      *Hedger          // Hedger of idempotent requests, nil to disable hedging.
+	errDecoder  ErrorDecoder     // Decoder of the error responses of the typed helpers.
+	envelope    bool             // Whether the typed helpers unwrap the Maltose response envelope.
+	retryBudget *RetryBudget     // Budget of the retries, nil for no limit.
+	rateLimitAt int              // Position in middlewares of the rate limiter of the config, plus one.
 }
 
 // New creates and returns a new HTTP client object.
@@ -49,29 +60,9 @@ func New() *Client {
 
 // NewWithConfig creates and returns a client with given config.
 // Note that the internal middlewares (recovery, trace, metric) are still applied.
+// Settings that cannot be applied are logged, see SetConfig.
 func NewWithConfig(config ClientConfig) *Client {
-	c := New()
-
-	// Preserve default User-Agent if not provided in the custom config.
-	if config.Header == nil {
-		config.Header = make(http.Header)
-	} else {
-		config.Header = config.Header.Clone()
-	}
-	if config.Header.Get("User-Agent") == "" {
-		config.Header.Set("User-Agent", c.config.Header.Get("User-Agent"))
-	}
-	c.config = config
-
-	// Apply configuration to http.Client
-	if config.Timeout > 0 {
-		c.client.Timeout = config.Timeout
-	}
-	if config.Transport != nil {
-		c.client.Transport = config.Transport
-	}
-
-	return c
+	return New().SetConfig(config)
 }
 
 // Use adds middleware handlers to the client.
@@ -89,6 +80,12 @@ func (c *Client) Clone() *Client {
 		newClient.config.Header = c.config.Header.Clone()
 	}
 	newClient.middlewares = append([]MiddlewareFunc(nil), c.middlewares...)
+	newClient.balancer = c.balancer
+	newClient.hedger = c.hedger
+	newClient.errDecoder = c.errDecoder
+	newClient.envelope = c.envelope
+	newClient.retryBudget = c.retryBudget
+	newClient.rateLimitAt = c.rateLimitAt
 	return newClient
 }
 
@@ -126,10 +123,41 @@ func (c *Client) SetTransport(transport http.RoundTripper) *Client {
 }
 
 // SetConfig sets the client configuration.
+// Settings that cannot be applied, e.g. an invalid TLS certificate or proxy,
+// are logged to mlog.DefaultLogger() and ignored, use SetConfigWithMap to get
+// the error instead.
 func (c *Client) SetConfig(config ClientConfig) *Client {
-	if config.Header != nil {
+	if err := c.setConfig(config); err != nil {
+		mlog.DefaultLogger().Errorw(context.Background(), err, "failed to apply the client config",
+			mlog.String(maltose.COMPONENT, "mclient"))
+	}
+	return c
+}
+
+// setConfig applies config to the client.
+func (c *Client) setConfig(config ClientConfig) error {
+	var balancer *LoadBalancer
+	if len(config.Endpoints) > 0 {
+		var err error
+		balancer, err = newLoadBalancer(LoadBalancerConfig{
+			Resolver: NewStaticResolver(config.Endpoints...),
+			Policy:   config.LoadBalance,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Preserve default User-Agent if not provided in the custom config.
+	userAgent := c.config.Header.Get("User-Agent")
+	if config.Header == nil {
+		config.Header = make(http.Header)
+	} else {
 		config.Header = config.Header.Clone()
 	}
+	if config.Header.Get("User-Agent") == "" && userAgent != "" {
+		config.Header.Set("User-Agent", userAgent)
+	}
 	c.config = config
 
 	// Apply configuration to HTTP client
@@ -139,19 +167,76 @@ func (c *Client) SetConfig(config ClientConfig) *Client {
 	if config.Transport != nil {
 		c.client.Transport = config.Transport
 	}
+	if balancer != nil {
+		c.SetLoadBalancer(balancer)
+	}
+	if config.RetryBudget.Ratio > 0 {
+		c.WithRetryBudget(config.RetryBudget)
+	}
+	c.setConfigRateLimit(config.RateLimit)
+	return c.setTransportConfig(config)
+}
 
-	return c
+// setConfigRateLimit replaces the rate limiter of the previous config, so that
+// applying configs does not stack limiters.
+func (c *Client) setConfigRateLimit(config RateLimitConfig) {
+	var limiter MiddlewareFunc
+	if config.RequestsPerSecond > 0 {
+		limiter = MiddlewareRateLimit(config)
+	}
+	switch {
+	case c.rateLimitAt > 0 && limiter != nil:
+		c.middlewares[c.rateLimitAt-1] = limiter
+	case c.rateLimitAt > 0:
+		c.middlewares = slices.Delete(c.middlewares, c.rateLimitAt-1, c.rateLimitAt)
+		c.rateLimitAt = 0
+	case limiter != nil:
+		c.Use(limiter)
+		c.rateLimitAt = len(c.middlewares)
+	}
+}
+
+// setTransportConfig applies the TLS and proxy settings of config to the transport.
+func (c *Client) setTransportConfig(config ClientConfig) error {
+	if config.TLS == (TLSConfig{}) && config.Proxy == "" {
+		return nil
+	}
+	transport, ok := c.client.Transport.(*http.Transport)
+	if !ok {
+		return merror.NewCode(mcode.CodeInvalidConfiguration, "cannot set TLS and proxy for custom Transport of the client")
+	}
+	transport = transport.Clone()
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return merror.WrapCodef(err, mcode.CodeInvalidConfiguration, "invalid client proxy %q", config.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if config.TLS != (TLSConfig{}) {
+		tlsConfig, err := config.TLS.tlsConfig(transport.TLSClientConfig)
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	c.client.Transport = transport
+	return nil
 }
 
 // NewRequest creates and returns a new request object.
 func (c *Client) NewRequest() *Request {
-	return &Request{
+	req := &Request{
 		client:      c,
 		middlewares: make([]MiddlewareFunc, 0),
 		queryParams: make(url.Values),
 		formParams:  make(url.Values),
 		response:    &Response{},
 	}
+	if c.config.Retry.Count > 0 {
+		req.SetRetry(c.config.Retry)
+	}
+	return req
 }
 
 // R returns a new request object bound to this client for chain calls.
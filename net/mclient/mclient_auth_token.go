@@ -0,0 +1,269 @@
+package mclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+)
+
+// Token is an access token issued by an authorization server.
+type Token struct {
+	// AccessToken is sent in the Authorization header of the requests.
+	AccessToken string `json:"access_token"`
+	// TokenType is the scheme of the Authorization header. Default is Bearer.
+	TokenType string `json:"token_type,omitempty"`
+	// RefreshToken is used to obtain a new access token, when issued.
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// Expiry is when the access token expires. Zero means it does not expire.
+	Expiry time.Time `json:"expiry,omitempty"`
+}
+
+// Valid reports whether the token is set and not expired.
+func (t *Token) Valid() bool {
+	return t.validAt(time.Now(), 0)
+}
+
+// validAt reports whether the token is set and still valid leeway after now.
+func (t *Token) validAt(now time.Time, leeway time.Duration) bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || now.Add(leeway).Before(t.Expiry))
+}
+
+// authorization returns the value of the Authorization header of the token.
+func (t *Token) authorization() string {
+	tokenType := t.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		// Servers often return "bearer", but some only accept the canonical case.
+		tokenType = "Bearer"
+	}
+	return tokenType + " " + t.AccessToken
+}
+
+// TokenSource provides the tokens of authenticated requests.
+type TokenSource interface {
+	// Token returns a token, fetching a new one when needed.
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc is an adapter to use a function as a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+// Token calls f(ctx).
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// StaticTokenSource returns a source always returning the bearer token accessToken.
+func StaticTokenSource(accessToken string) TokenSource {
+	token := &Token{AccessToken: accessToken}
+	return TokenSourceFunc(func(context.Context) (*Token, error) {
+		return token, nil
+	})
+}
+
+// ClientCredentialsConfig is the configuration of the OAuth2 client credentials flow.
+type ClientCredentialsConfig struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string
+	// ClientID is the identifier of the application.
+	ClientID string
+	// ClientSecret is the secret of the application.
+	ClientSecret string
+	// Scopes are the requested scopes.
+	Scopes []string
+	// EndpointParams are additional parameters of the token requests, e.g. audience.
+	EndpointParams url.Values
+	// AuthInParams sends the client credentials as form parameters instead of
+	// with HTTP basic authentication.
+	AuthInParams bool
+	// Client sends the token requests. Default is a new client.
+	Client *Client
+}
+
+// clientCredentialsSource is a TokenSource of the client credentials flow.
+type clientCredentialsSource struct {
+	config ClientCredentialsConfig
+}
+
+// NewClientCredentialsSource returns a source fetching a new token with the
+// client credentials flow on every call. Wrap it with NewCachedTokenSource, or
+// use it through MiddlewareAuth which does, to reuse tokens until they expire.
+func NewClientCredentialsSource(config ClientCredentialsConfig) TokenSource {
+	if config.Client == nil {
+		config.Client = New()
+	}
+	return &clientCredentialsSource{config: config}
+}
+
+// Token implements TokenSource.
+func (s *clientCredentialsSource) Token(ctx context.Context) (*Token, error) {
+	params := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.config.Scopes) > 0 {
+		params.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	for key, values := range s.config.EndpointParams {
+		params[key] = append([]string(nil), values...)
+	}
+	return fetchToken(ctx, s.config.Client, s.config.TokenURL, s.config.ClientID, s.config.ClientSecret, s.config.AuthInParams, params)
+}
+
+// cacheKey implements tokenCacheKeyer.
+func (s *clientCredentialsSource) cacheKey() string {
+	return tokenCacheKey("client_credentials", s.config.TokenURL, s.config.ClientID, s.config.Scopes, s.config.EndpointParams.Encode())
+}
+
+// RefreshTokenConfig is the configuration of the OAuth2 refresh token flow.
+type RefreshTokenConfig struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string
+	// ClientID is the identifier of the application.
+	ClientID string
+	// ClientSecret is the secret of the application, empty for public clients.
+	ClientSecret string
+	// RefreshToken is the initial refresh token.
+	RefreshToken string
+	// Scopes are the requested scopes, empty to keep the scopes of the grant.
+	Scopes []string
+	// AuthInParams sends the client credentials as form parameters instead of
+	// with HTTP basic authentication.
+	AuthInParams bool
+	// Client sends the token requests. Default is a new client.
+	Client *Client
+}
+
+// refreshTokenSource is a TokenSource of the refresh token flow.
+type refreshTokenSource struct {
+	config       RefreshTokenConfig
+	mu           sync.Mutex
+	refreshToken string
+}
+
+// NewRefreshTokenSource returns a source fetching a new token with the refresh
+// token flow on every call. Refresh tokens rotated by the server replace the
+// previous one.
+func NewRefreshTokenSource(config RefreshTokenConfig) TokenSource {
+	if config.Client == nil {
+		config.Client = New()
+	}
+	return &refreshTokenSource{config: config, refreshToken: config.RefreshToken}
+}
+
+// Token implements TokenSource.
+func (s *refreshTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refreshToken == "" {
+		return nil, merror.NewCode(mcode.CodeInvalidConfiguration, "refresh token is required")
+	}
+	params := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.refreshToken},
+	}
+	if len(s.config.Scopes) > 0 {
+		params.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	token, err := fetchToken(ctx, s.config.Client, s.config.TokenURL, s.config.ClientID, s.config.ClientSecret, s.config.AuthInParams, params)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = s.refreshToken
+	}
+	s.refreshToken = token.RefreshToken
+	return token, nil
+}
+
+// cacheKey implements tokenCacheKeyer. The initial refresh token tells the
+// grants of different users apart.
+func (s *refreshTokenSource) cacheKey() string {
+	return tokenCacheKey("refresh_token", s.config.TokenURL, s.config.ClientID, s.config.Scopes, s.config.RefreshToken)
+}
+
+// tokenCacheKeyer is implemented by the token sources deriving their cache key
+// from their configuration.
+type tokenCacheKeyer interface {
+	cacheKey() string
+}
+
+// tokenCacheKey returns the cache key of the tokens of a grant, hashed so that
+// no credential appears in the cache.
+func tokenCacheKey(grant, tokenURL, clientID string, scopes []string, extra string) string {
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+	hash := sha256.New()
+	for _, part := range []string{grant, tokenURL, clientID, strings.Join(scopes, " "), extra} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return "mclient:token:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// tokenResponse is the response of a token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// fetchToken requests a token from the token endpoint tokenURL.
+func fetchToken(ctx context.Context, client *Client, tokenURL, clientID, clientSecret string, authInParams bool, params url.Values) (*Token, error) {
+	req := client.R().SetContext(ctx)
+	if authInParams {
+		params.Set("client_id", clientID)
+		if clientSecret != "" {
+			params.Set("client_secret", clientSecret)
+		}
+	} else {
+		req.Request.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+	for key, values := range params {
+		for _, value := range values {
+			req.formParams.Add(key, value)
+		}
+	}
+	req.SetHeader("Accept", "application/json")
+
+	requested := time.Now()
+	resp, err := req.Post(tokenURL)
+	if err != nil {
+		return nil, merror.Wrapf(err, "token request to %s failed", tokenURL)
+	}
+	defer resp.Close()
+
+	var body tokenResponse
+	if err := json.Unmarshal(resp.ReadAll(), &body); err != nil && resp.IsSuccess() {
+		return nil, merror.Wrapf(err, "failed to decode token response of %s", tokenURL)
+	}
+	if !resp.IsSuccess() || body.Error != "" {
+		message := body.Error
+		if body.ErrorDescription != "" {
+			message += ": " + body.ErrorDescription
+		}
+		return nil, merror.NewCodef(mcode.CodeNotAuthorized, "token request to %s failed with status %d %s", tokenURL, resp.StatusCode, message)
+	}
+	if body.AccessToken == "" {
+		return nil, merror.NewCodef(mcode.CodeNotAuthorized, "token response of %s has no access token", tokenURL)
+	}
+
+	token := &Token{
+		AccessToken:  body.AccessToken,
+		TokenType:    body.TokenType,
+		RefreshToken: body.RefreshToken,
+	}
+	if body.ExpiresIn > 0 {
+		// Count from the request so the token is never assumed to live longer than it does.
+		token.Expiry = requested.Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,375 @@
+package mclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/graingo/maltose"
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/os/mlog"
+)
+
+// BalancePolicy selects the endpoint of each request.
+type BalancePolicy string
+
+const (
+	// BalanceRoundRobin sends requests to the endpoints in turn.
+	BalanceRoundRobin BalancePolicy = "round_robin"
+	// BalanceWeighted sends requests to the endpoints in proportion to their weight.
+	BalanceWeighted BalancePolicy = "weighted"
+	// BalanceLeastRequests sends requests to the endpoint with the fewest
+	// in-flight requests relative to its weight.
+	BalanceLeastRequests BalancePolicy = "least_requests"
+)
+
+// LoadBalancerConfig is the configuration of a client-side load balancer.
+type LoadBalancerConfig struct {
+	// Resolver discovers the endpoints, e.g. NewStaticResolver, NewFileResolver
+	// or NewDNSResolver.
+	Resolver EndpointResolver
+	// Policy is the balancing policy. Default is BalanceRoundRobin.
+	Policy BalancePolicy
+	// RefreshInterval is how often the endpoints are resolved again. Default is 30s.
+	// When a refresh fails, the previous endpoints are kept.
+	RefreshInterval time.Duration
+	// EjectFailures is the number of consecutive failures that eject an endpoint. Default is 5.
+	EjectFailures int
+	// EjectDuration is how long an endpoint is ejected for the first time. It grows
+	// with each further ejection until the endpoint succeeds again. Default is 30s.
+	EjectDuration time.Duration
+	// MaxEjectDuration caps the ejection duration. Default is 5m.
+	MaxEjectDuration time.Duration
+	// MaxEjectPercent is the largest share of endpoints ejected at the same time,
+	// between 0 and 1. Default is 0.5.
+	MaxEjectPercent float64
+	// IsFailure classifies the outcome of a request. By default errors other than
+	// context cancellation and 5xx responses are failures.
+	IsFailure func(*Response, error) bool
+	// Logger logs ejections and failed refreshes. Default is mlog.DefaultLogger().
+	Logger *mlog.Logger
+}
+
+// DefaultLoadBalancerConfig returns a default load balancer configuration.
+func DefaultLoadBalancerConfig() LoadBalancerConfig {
+	return LoadBalancerConfig{
+		Policy:           BalanceRoundRobin,
+		RefreshInterval:  30 * time.Second,
+		EjectFailures:    5,
+		EjectDuration:    30 * time.Second,
+		MaxEjectDuration: 5 * time.Minute,
+		MaxEjectPercent:  0.5,
+	}
+}
+
+func normalizeLoadBalancerConfig(config LoadBalancerConfig) LoadBalancerConfig {
+	defaults := DefaultLoadBalancerConfig()
+	if config.Policy == "" {
+		config.Policy = defaults.Policy
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaults.RefreshInterval
+	}
+	if config.EjectFailures <= 0 {
+		config.EjectFailures = defaults.EjectFailures
+	}
+	if config.EjectDuration <= 0 {
+		config.EjectDuration = defaults.EjectDuration
+	}
+	if config.MaxEjectDuration < config.EjectDuration {
+		config.MaxEjectDuration = max(defaults.MaxEjectDuration, config.EjectDuration)
+	}
+	if config.MaxEjectPercent <= 0 {
+		config.MaxEjectPercent = defaults.MaxEjectPercent
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = defaultIsFailure
+	}
+	if config.Logger == nil {
+		config.Logger = mlog.DefaultLogger()
+	}
+	return config
+}
+
+// LoadBalancer spreads the requests of a client over the endpoints of a service.
+type LoadBalancer struct {
+	config    LoadBalancerConfig
+	refreshMu sync.Mutex
+
+	mu         sync.Mutex
+	endpoints  []*endpointState
+	resolvedAt time.Time
+	next       int
+}
+
+// endpointState is an endpoint with its balancing and health state.
+type endpointState struct {
+	Endpoint
+	inFlight      int
+	currentWeight int // smooth weighted round-robin state
+	failures      int // consecutive failures
+	ejections     int // consecutive ejections
+	ejectedUntil  time.Time
+}
+
+// NewLoadBalancer creates a load balancer. The endpoints are resolved on first use.
+// It panics if the configuration is invalid.
+func NewLoadBalancer(config LoadBalancerConfig) *LoadBalancer {
+	balancer, err := newLoadBalancer(config)
+	if err != nil {
+		panic(err)
+	}
+	return balancer
+}
+
+// newLoadBalancer creates a load balancer, or returns an error if the configuration is invalid.
+func newLoadBalancer(config LoadBalancerConfig) (*LoadBalancer, error) {
+	config = normalizeLoadBalancerConfig(config)
+	switch config.Policy {
+	case BalanceRoundRobin, BalanceWeighted, BalanceLeastRequests:
+	default:
+		return nil, merror.NewCodef(mcode.CodeInvalidConfiguration, "unsupported balance policy %q", config.Policy)
+	}
+	if config.Resolver == nil {
+		return nil, merror.NewCode(mcode.CodeInvalidConfiguration, "load balancer resolver is required")
+	}
+	return &LoadBalancer{config: config}, nil
+}
+
+// SetLoadBalancer makes the client send requests with a relative URL to the
+// endpoints of balancer instead of the BaseURL. Retries go to a different
+// endpoint when one is available. Clones of the client share the balancer.
+func (c *Client) SetLoadBalancer(balancer *LoadBalancer) *Client {
+	c.balancer = balancer
+	return c
+}
+
+// SetEndpoints balances the requests of the client over a static list of
+// endpoints in the format of ParseEndpoint with the given policy. It panics if
+// the policy is unsupported.
+func (c *Client) SetEndpoints(policy BalancePolicy, endpoints ...string) *Client {
+	return c.SetLoadBalancer(NewLoadBalancer(LoadBalancerConfig{
+		Resolver: NewStaticResolver(endpoints...),
+		Policy:   policy,
+	}))
+}
+
+// Endpoints returns the last resolved endpoints that are not ejected.
+func (b *LoadBalancer) Endpoints() []Endpoint {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	endpoints := make([]Endpoint, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if !e.ejectedUntil.After(now) {
+			endpoints = append(endpoints, e.Endpoint)
+		}
+	}
+	return endpoints
+}
+
+// pick selects the endpoint of a request, avoiding the endpoints in tried
+// when possible, and counts it as in flight until done is called.
+func (b *LoadBalancer) pick(ctx context.Context, tried map[string]struct{}) (*endpointState, error) {
+	if err := b.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	candidates := b.candidates(now, tried)
+	if len(candidates) == 0 {
+		// Every healthy endpoint was tried already, try one of them again.
+		candidates = b.candidates(now, nil)
+	}
+	if len(candidates) == 0 {
+		// Every endpoint is ejected: better to try one than to fail.
+		candidates = b.endpoints
+	}
+
+	var picked *endpointState
+	switch b.config.Policy {
+	case BalanceWeighted:
+		total := 0
+		for _, e := range candidates {
+			e.currentWeight += e.Weight
+			total += e.Weight
+			if picked == nil || e.currentWeight > picked.currentWeight {
+				picked = e
+			}
+		}
+		picked.currentWeight -= total
+
+	case BalanceLeastRequests:
+		// Start at a rotating offset so ties are spread evenly.
+		offset := b.next % len(candidates)
+		b.next++
+		for i := range candidates {
+			e := candidates[(offset+i)%len(candidates)]
+			if picked == nil || e.inFlight*picked.Weight < picked.inFlight*e.Weight {
+				picked = e
+			}
+		}
+
+	default:
+		picked = candidates[b.next%len(candidates)]
+		b.next++
+	}
+	picked.inFlight++
+	return picked, nil
+}
+
+// endpointSet is the set of endpoints tried by the attempts of a request. It
+// is shared by the concurrent attempts of a hedged request.
+type endpointSet struct {
+	mu   sync.Mutex
+	urls map[string]struct{}
+}
+
+// snapshot returns a copy of the set.
+func (s *endpointSet) snapshot() map[string]struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urls := make(map[string]struct{}, len(s.urls))
+	for url := range s.urls {
+		urls[url] = struct{}{}
+	}
+	return urls
+}
+
+func (s *endpointSet) add(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.urls == nil {
+		s.urls = make(map[string]struct{})
+	}
+	s.urls[url] = struct{}{}
+}
+
+// candidates returns the endpoints that are neither ejected nor tried.
+func (b *LoadBalancer) candidates(now time.Time, tried map[string]struct{}) []*endpointState {
+	candidates := make([]*endpointState, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if _, ok := tried[e.URL]; ok || e.ejectedUntil.After(now) {
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+	return candidates
+}
+
+// done records the outcome of a request sent to e.
+func (b *LoadBalancer) done(ctx context.Context, e *endpointState, failure bool) {
+	now := time.Now()
+	b.mu.Lock()
+	e.inFlight--
+	if !failure {
+		e.failures = 0
+		if !e.ejectedUntil.After(now) {
+			e.ejections = 0
+		}
+		b.mu.Unlock()
+		return
+	}
+
+	e.failures++
+	if e.failures < b.config.EjectFailures || e.ejectedUntil.After(now) {
+		b.mu.Unlock()
+		return
+	}
+	ejected := 0
+	for _, other := range b.endpoints {
+		if other.ejectedUntil.After(now) {
+			ejected++
+		}
+	}
+	if float64(ejected+1) > b.config.MaxEjectPercent*float64(len(b.endpoints)) {
+		b.mu.Unlock()
+		return
+	}
+	e.failures = 0
+	e.ejections++
+	duration := min(b.config.EjectDuration*time.Duration(e.ejections), b.config.MaxEjectDuration)
+	e.ejectedUntil = now.Add(duration)
+	b.mu.Unlock()
+
+	b.config.Logger.Warnw(ctx, "endpoint ejected after consecutive failures",
+		mlog.String(maltose.COMPONENT, "mclient"),
+		mlog.String("endpoint", e.URL),
+		mlog.String("duration", duration.String()),
+	)
+}
+
+// refresh resolves the endpoints when they are stale. Requests keep using the
+// previous endpoints while a refresh is in progress.
+func (b *LoadBalancer) refresh(ctx context.Context) error {
+	b.mu.Lock()
+	resolved := !b.resolvedAt.IsZero()
+	stale := !resolved || time.Since(b.resolvedAt) >= b.config.RefreshInterval
+	b.mu.Unlock()
+	if !stale {
+		return nil
+	}
+	if resolved {
+		if !b.refreshMu.TryLock() {
+			return nil
+		}
+	} else {
+		b.refreshMu.Lock()
+	}
+	defer b.refreshMu.Unlock()
+
+	b.mu.Lock()
+	stale = b.resolvedAt.IsZero() || time.Since(b.resolvedAt) >= b.config.RefreshInterval
+	b.mu.Unlock()
+	if !stale {
+		return nil
+	}
+
+	endpoints, err := b.config.Resolver.Resolve(ctx)
+	if err == nil && len(endpoints) == 0 {
+		err = merror.NewCode(mcode.CodeOperationFailed, "resolver returned no endpoints")
+	}
+	if err != nil {
+		if !resolved {
+			return merror.WrapCode(err, mcode.CodeOperationFailed, "failed to resolve endpoints")
+		}
+		b.config.Logger.Warnw(ctx, "failed to refresh endpoints, keeping the previous ones",
+			mlog.String(maltose.COMPONENT, "mclient"),
+			mlog.Err(err),
+		)
+		b.mu.Lock()
+		b.resolvedAt = time.Now()
+		b.mu.Unlock()
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Keep the state of endpoints that are still present.
+	previous := make(map[string]*endpointState, len(b.endpoints))
+	for _, e := range b.endpoints {
+		previous[e.URL] = e
+	}
+	states := make([]*endpointState, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if endpoint.Weight <= 0 {
+			endpoint.Weight = 1
+		}
+		state, ok := previous[endpoint.URL]
+		if !ok {
+			state = &endpointState{}
+		} else if state == nil {
+			continue // listed twice
+		}
+		previous[endpoint.URL] = nil
+		state.Endpoint = endpoint
+		states = append(states, state)
+	}
+	b.endpoints = states
+	b.resolvedAt = time.Now()
+	return nil
+}
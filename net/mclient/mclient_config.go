@@ -2,24 +2,106 @@ package mclient
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"net/http"
 	"net/http/cookiejar"
+	"os"
 	"time"
 
+	"github.com/graingo/maltose/errors/mcode"
 	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/mconv"
+)
+
+const (
+	// DefaultName is the default name of the client instances declared in configuration.
+	DefaultName = "default"
 )
 
 // ClientConfig is the configuration for Client.
 type ClientConfig struct {
 	// BaseURL specifies the base URL for all requests.
 	BaseURL string `mconv:"base_url"`
+	// Endpoints are the base URLs of the replicas of the service, in the format
+	// of ParseEndpoint. When set, requests with a relative URL are balanced over
+	// them instead of being sent to BaseURL.
+	Endpoints []string `mconv:"endpoints"`
+	// LoadBalance is the policy used to balance over Endpoints. Default is round_robin.
+	LoadBalance BalancePolicy `mconv:"load_balance"`
 	// Timeout specifies a time limit for requests made by this client.
 	Timeout time.Duration `mconv:"timeout"`
 	// Transport specifies the mechanism by which individual HTTP requests are made.
 	Transport http.RoundTripper
 	// Header specifies the default header for requests.
-	Header http.Header
+	Header http.Header `mconv:"header"`
+	// Retry is the default retry configuration of the requests. Zero Count disables retries.
+	Retry RetryConfig `mconv:"retry"`
+	// RetryBudget limits the retries of the client when Ratio is set.
+	RetryBudget RetryBudgetConfig `mconv:"retry_budget"`
+	// RateLimit limits the rate of the requests when RequestsPerSecond is set.
+	RateLimit RateLimitConfig `mconv:"rate_limit"`
+	// TLS configures the TLS connections of the default transport.
+	TLS TLSConfig `mconv:"tls"`
+	// Proxy is the URL of the proxy of the default transport. By default the
+	// proxy is taken from the environment.
+	Proxy string `mconv:"proxy"`
+}
+
+// TLSConfig is the TLS configuration of a client declared in configuration.
+type TLSConfig struct {
+	// CertFile and KeyFile are the client certificate presented to the server.
+	CertFile string `mconv:"cert_file"`
+	KeyFile  string `mconv:"key_file"`
+	// CAFile is the certificate authority verifying the server instead of the system pool.
+	CAFile string `mconv:"ca_file"`
+	// ServerName overrides the name the server certificate is verified against.
+	ServerName string `mconv:"server_name"`
+	// InsecureSkipVerify disables the verification of the server certificate.
+	InsecureSkipVerify bool `mconv:"insecure_skip_verify"`
+}
+
+// ConfigFromMap creates a client config from a configuration map.
+// The header node maps header names to a value or a list of values.
+func ConfigFromMap(configMap map[string]any) (ClientConfig, error) {
+	var (
+		config ClientConfig
+		fields = make(map[string]any, len(configMap))
+	)
+	for k, v := range configMap {
+		fields[k] = v
+	}
+	if node, ok := fields["header"]; ok {
+		delete(fields, "header")
+		headers, ok := node.(map[string]any)
+		if !ok {
+			return config, merror.NewCodef(mcode.CodeInvalidConfiguration, "client header must be an object, got %T", node)
+		}
+		config.Header = make(http.Header, len(headers))
+		for key, value := range headers {
+			if values, ok := value.([]any); ok {
+				for _, v := range values {
+					config.Header.Add(key, mconv.ToString(v))
+				}
+				continue
+			}
+			config.Header.Set(key, mconv.ToString(value))
+		}
+	}
+	if err := mconv.ToStructE(fields, &config); err != nil {
+		return config, merror.WrapCode(err, mcode.CodeInvalidConfiguration, "convert client config failed")
+	}
+	return config, nil
+}
+
+// SetConfigWithMap sets the client configuration from a configuration map,
+// see ConfigFromMap.
+func (c *Client) SetConfigWithMap(configMap map[string]any) error {
+	config, err := ConfigFromMap(configMap)
+	if err != nil {
+		return err
+	}
+	return c.setConfig(config)
 }
 
 // SetBrowserMode enables browser mode of the client.
@@ -155,3 +237,36 @@ func (c *Client) SetBasicAuth(username, password string) *Client {
 	auth := username + ":" + password
 	return c.SetHeader("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
 }
+
+// tlsConfig returns base with the settings of c applied.
+func (c TLSConfig) tlsConfig(base *tls.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if base != nil {
+		tlsConfig = base.Clone()
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, merror.WrapCodef(err, mcode.CodeInvalidConfiguration, "failed to load certificate from %s and key from %s", c.CertFile, c.KeyFile)
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, merror.WrapCodef(err, mcode.CodeInvalidConfiguration, "failed to read CA file %s", c.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, merror.NewCodef(mcode.CodeInvalidConfiguration, "no certificate found in CA file %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.ServerName != "" {
+		tlsConfig.ServerName = c.ServerName
+	}
+	if c.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
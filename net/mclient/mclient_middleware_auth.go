@@ -0,0 +1,263 @@
+package mclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/internal/intlog"
+	"github.com/graingo/maltose/os/mcache"
+	"github.com/graingo/maltose/util/msync"
+)
+
+// TokenCacheConfig represents options for caching the tokens of a TokenSource.
+type TokenCacheConfig struct {
+	// ExpiryLeeway is how long before its expiry a token is replaced. Default is 30s.
+	ExpiryLeeway time.Duration
+	// Adapter shares the tokens between the instances of a service, e.g. a
+	// Redis adapter. By default tokens are only kept in memory.
+	Adapter mcache.Adapter
+	// Key is the cache key of the token in Adapter. By default it is derived
+	// from the token URL, client ID and scopes of the client credentials and
+	// refresh token sources. It is required with Adapter for other sources.
+	Key string
+}
+
+// DefaultTokenCacheConfig returns a default token cache configuration.
+func DefaultTokenCacheConfig() TokenCacheConfig {
+	return TokenCacheConfig{
+		ExpiryLeeway: 30 * time.Second,
+	}
+}
+
+func normalizeTokenCacheConfig(source TokenSource, config TokenCacheConfig) TokenCacheConfig {
+	defaults := DefaultTokenCacheConfig()
+	if config.ExpiryLeeway <= 0 {
+		config.ExpiryLeeway = defaults.ExpiryLeeway
+	}
+	if config.Key == "" {
+		keyer, ok := source.(tokenCacheKeyer)
+		switch {
+		case ok:
+			config.Key = keyer.cacheKey()
+		case config.Adapter != nil:
+			panic(merror.NewCode(mcode.CodeInvalidConfiguration, "token cache key is required to share the tokens of the source"))
+		default:
+			config.Key = "mclient:token"
+		}
+	}
+	return config
+}
+
+// CachedTokenSource reuses the tokens of a source until shortly before they
+// expire. Concurrent callers share a single fetch of a new token.
+type CachedTokenSource struct {
+	source TokenSource
+	config TokenCacheConfig
+	flight *msync.SingleFlight
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewCachedTokenSource creates a token source caching the tokens of source.
+// It panics if config has an Adapter but no Key that can be used for source.
+func NewCachedTokenSource(source TokenSource, config TokenCacheConfig) *CachedTokenSource {
+	if source == nil {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "token source is required"))
+	}
+	return &CachedTokenSource{
+		source: source,
+		config: normalizeTokenCacheConfig(source, config),
+		flight: msync.NewSingleFlight(),
+	}
+}
+
+// Token returns the cached token, fetching a new one when it is about to expire.
+func (s *CachedTokenSource) Token(ctx context.Context) (*Token, error) {
+	if token := s.cached(ctx); token != nil {
+		return token, nil
+	}
+	value, err := s.flight.Do(s.config.Key, func() (any, error) {
+		// Another caller may have fetched the token while this one waited.
+		if token := s.cached(ctx); token != nil {
+			return token, nil
+		}
+		token, err := s.source.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !token.Valid() {
+			return nil, merror.NewCode(mcode.CodeNotAuthorized, "token source returned an invalid token")
+		}
+		s.store(ctx, token)
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Token), nil
+}
+
+// Invalidate drops the cached token if it is still token, so the next call
+// fetches a new one. Tokens already replaced by another caller are kept.
+func (s *CachedTokenSource) Invalidate(ctx context.Context, token *Token) {
+	s.mu.Lock()
+	if s.token != nil && (token == nil || s.token.AccessToken == token.AccessToken) {
+		s.token = nil
+	}
+	s.mu.Unlock()
+
+	if s.config.Adapter == nil {
+		return
+	}
+	if token != nil {
+		if shared := s.load(ctx); shared == nil || shared.AccessToken != token.AccessToken {
+			return
+		}
+	}
+	if _, err := s.config.Adapter.Remove(ctx, s.config.Key); err != nil {
+		intlog.Errorf(ctx, "failed to remove cached token %s: %v", s.config.Key, err)
+	}
+}
+
+// cached returns the cached token when it is valid past the expiry leeway.
+func (s *CachedTokenSource) cached(ctx context.Context) *Token {
+	now := time.Now()
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+	if token.validAt(now, s.config.ExpiryLeeway) {
+		return token
+	}
+	if s.config.Adapter == nil {
+		return nil
+	}
+	if token = s.load(ctx); !token.validAt(now, s.config.ExpiryLeeway) {
+		return nil
+	}
+	s.mu.Lock()
+	s.token = token
+	s.mu.Unlock()
+	return token
+}
+
+func (s *CachedTokenSource) load(ctx context.Context) *Token {
+	value, err := s.config.Adapter.Get(ctx, s.config.Key)
+	if err != nil {
+		intlog.Errorf(ctx, "failed to load cached token %s: %v", s.config.Key, err)
+		return nil
+	}
+	if value.IsNil() {
+		return nil
+	}
+	var token Token
+	if err := json.Unmarshal([]byte(value.String()), &token); err != nil {
+		intlog.Errorf(ctx, "failed to decode cached token %s: %v", s.config.Key, err)
+		return nil
+	}
+	return &token
+}
+
+func (s *CachedTokenSource) store(ctx context.Context, token *Token) {
+	s.mu.Lock()
+	s.token = token
+	s.mu.Unlock()
+
+	if s.config.Adapter == nil {
+		return
+	}
+	var ttl time.Duration // tokens without expiry are kept until invalidated
+	if !token.Expiry.IsZero() {
+		if ttl = time.Until(token.Expiry) - s.config.ExpiryLeeway; ttl <= 0 {
+			return
+		}
+	}
+	// Refresh tokens stay with their source, they are not needed to use the token.
+	shared := *token
+	shared.RefreshToken = ""
+	data, err := json.Marshal(&shared)
+	if err != nil {
+		intlog.Errorf(ctx, "failed to encode token %s for caching: %v", s.config.Key, err)
+		return
+	}
+	if err := s.config.Adapter.Set(ctx, s.config.Key, string(data), ttl); err != nil {
+		intlog.Errorf(ctx, "failed to cache token %s: %v", s.config.Key, err)
+	}
+}
+
+// AuthConfig represents options for the auth middleware.
+type AuthConfig struct {
+	// Source provides the tokens, e.g. NewClientCredentialsSource or NewRefreshTokenSource.
+	// Sources other than a CachedTokenSource are cached with Cache.
+	Source TokenSource
+	// Cache configures the token cache of Source.
+	Cache TokenCacheConfig
+	// Skip determines if a request is sent without a token.
+	Skip func(*Request) bool
+}
+
+// WithAuth authenticates all requests from this client.
+func (c *Client) WithAuth(config AuthConfig) *Client {
+	c.Use(MiddlewareAuth(config))
+	return c
+}
+
+// MiddlewareAuth returns a middleware setting the Authorization header of the
+// requests from a token source. When the server answers 401 Unauthorized, the
+// token is dropped and the request is sent once more with a new token, provided
+// its body can be sent again.
+func MiddlewareAuth(config AuthConfig) MiddlewareFunc {
+	if config.Source == nil {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "auth token source is required"))
+	}
+	source, ok := config.Source.(*CachedTokenSource)
+	if !ok {
+		source = NewCachedTokenSource(config.Source, config.Cache)
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (*Response, error) {
+			if config.Skip != nil && config.Skip(req) {
+				return next(req)
+			}
+			ctx := req.Context()
+			token, err := source.Token(ctx)
+			if err != nil {
+				return nil, merror.WrapCode(err, mcode.CodeNotAuthorized, "failed to get auth token")
+			}
+			req.Request.Header.Set("Authorization", token.authorization())
+
+			// Keep what is needed to send the request again before next consumes it.
+			retry := req.Request.Clone(ctx)
+			resp, err := next(req)
+			if err != nil || resp == nil || resp.Response == nil || resp.StatusCode != http.StatusUnauthorized {
+				return resp, err
+			}
+			if retry.Body != nil && retry.Body != http.NoBody {
+				if retry.GetBody == nil {
+					return resp, nil
+				}
+				body, bodyErr := retry.GetBody()
+				if bodyErr != nil {
+					return resp, nil
+				}
+				retry.Body = body
+			}
+
+			source.Invalidate(ctx, token)
+			token, err = source.Token(ctx)
+			if err != nil {
+				return resp, nil
+			}
+			_ = resp.Close()
+			retry.Header.Set("Authorization", token.authorization())
+			req.Request = retry
+			return next(req)
+		}
+	}
+}
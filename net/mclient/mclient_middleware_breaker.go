@@ -0,0 +1,434 @@
+package mclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/graingo/maltose"
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/os/mlog"
+	"github.com/graingo/maltose/os/mmetric"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through and records their outcome.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests until the open timeout elapses.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// ErrCircuitOpen is matched by errors.Is for requests rejected by a circuit breaker.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is the error of a request rejected by a circuit breaker.
+// It is returned wrapped in an merror with code mcode.CodeServerBusy.
+type CircuitOpenError struct {
+	// Name is the name of the circuit breaker.
+	Name string
+	// Key is the circuit the request belongs to, see CircuitBreakerConfig.KeyFunc.
+	Key string
+	// State is the state that rejected the request, open or half-open.
+	State CircuitState
+	// RetryAfter is the time left until the circuit admits probe requests.
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %s is %s for %q, retry after %s", e.Name, e.State, e.Key, e.RetryAfter)
+}
+
+// Is reports whether target is ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreakerConfig represents options for the circuit breaker middleware.
+type CircuitBreakerConfig struct {
+	// Name identifies the breaker in logs, metrics and errors. Default is "default".
+	Name string
+	// KeyFunc splits requests into independent circuits, e.g. CircuitKeyHost or
+	// CircuitKeyRoute. By default all requests of the client share one circuit.
+	KeyFunc func(*Request) string
+	// Window is the length of the rolling window outcomes are counted over. Default is 60s.
+	Window time.Duration
+	// Buckets is the number of buckets the window is divided into. Default is 10.
+	Buckets int
+	// MinRequests is the number of requests in the window before the thresholds apply. Default is 20.
+	MinRequests int
+	// FailureRateThreshold opens the circuit when the failure rate in the window
+	// reaches it, between 0 and 1. Default is 0.5.
+	FailureRateThreshold float64
+	// SlowCallDuration is the duration from which a request counts as slow.
+	// Zero disables slow call detection.
+	SlowCallDuration time.Duration
+	// SlowCallRateThreshold opens the circuit when the slow call rate in the
+	// window reaches it, between 0 and 1. Default is 1.
+	SlowCallRateThreshold float64
+	// OpenTimeout is how long the circuit stays open before admitting probes. Default is 30s.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probes admitted in the half-open state.
+	// The circuit closes once they all succeed, and opens again on the first failure. Default is 1.
+	HalfOpenRequests int
+	// IsFailure classifies the outcome of a request. By default errors other than
+	// context cancellation and 5xx responses are failures.
+	IsFailure func(*Response, error) bool
+	// OnStateChange is called after a circuit changes state.
+	OnStateChange func(name, key string, from, to CircuitState)
+	// Logger logs state changes. Default is mlog.DefaultLogger().
+	Logger *mlog.Logger
+}
+
+// DefaultCircuitBreakerConfig returns a default circuit breaker configuration.
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		Name:                  "default",
+		Window:                60 * time.Second,
+		Buckets:               10,
+		MinRequests:           20,
+		FailureRateThreshold:  0.5,
+		SlowCallRateThreshold: 1,
+		OpenTimeout:           30 * time.Second,
+		HalfOpenRequests:      1,
+	}
+}
+
+func normalizeCircuitBreakerConfig(config CircuitBreakerConfig) CircuitBreakerConfig {
+	defaults := DefaultCircuitBreakerConfig()
+	if config.Name == "" {
+		config.Name = defaults.Name
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.Buckets <= 0 {
+		config.Buckets = defaults.Buckets
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaults.MinRequests
+	}
+	if config.FailureRateThreshold <= 0 {
+		config.FailureRateThreshold = defaults.FailureRateThreshold
+	}
+	if config.SlowCallRateThreshold <= 0 {
+		config.SlowCallRateThreshold = defaults.SlowCallRateThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaults.OpenTimeout
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = defaults.HalfOpenRequests
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = defaultIsFailure
+	}
+	if config.Logger == nil {
+		config.Logger = mlog.DefaultLogger()
+	}
+	return config
+}
+
+func defaultIsFailure(resp *Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp != nil && resp.Response != nil && resp.StatusCode >= 500
+}
+
+// CircuitKeyHost keys circuits by the request host.
+func CircuitKeyHost(r *Request) string {
+	if r.Request == nil || r.Request.URL == nil {
+		return ""
+	}
+	return r.Request.URL.Host
+}
+
+// CircuitKeyRoute keys circuits by the request method, host and path.
+func CircuitKeyRoute(r *Request) string {
+	if r.Request == nil || r.Request.URL == nil {
+		return ""
+	}
+	return r.Request.Method + " " + r.Request.URL.Host + r.Request.URL.Path
+}
+
+// CircuitBreaker tracks the circuits of a client.
+type CircuitBreaker struct {
+	config   CircuitBreakerConfig
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewCircuitBreaker creates a circuit breaker. Use its Middleware on a client
+// or request, and State to inspect it.
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		config:   normalizeCircuitBreakerConfig(config),
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// MiddlewareCircuitBreaker returns a middleware that fails fast with a
+// CircuitOpenError while the downstream is failing.
+func MiddlewareCircuitBreaker(config CircuitBreakerConfig) MiddlewareFunc {
+	return NewCircuitBreaker(config).Middleware()
+}
+
+// WithCircuitBreaker applies a circuit breaker to all requests from this client.
+func (c *Client) WithCircuitBreaker(config CircuitBreakerConfig) *Client {
+	c.Use(MiddlewareCircuitBreaker(config))
+	return c
+}
+
+// State returns the state of the circuit of key, or of the shared circuit
+// when no KeyFunc is configured.
+func (b *CircuitBreaker) State(key ...string) CircuitState {
+	k := ""
+	if len(key) > 0 {
+		k = key[0]
+	}
+	b.mu.Lock()
+	c, ok := b.circuits[k]
+	b.mu.Unlock()
+	if !ok {
+		return CircuitClosed
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Middleware returns the middleware of the circuit breaker.
+func (b *CircuitBreaker) Middleware() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (*Response, error) {
+			key := ""
+			if b.config.KeyFunc != nil {
+				key = b.config.KeyFunc(req)
+			}
+			c := b.circuit(key)
+			ctx := req.Context()
+
+			generation, err := c.allow(ctx, time.Now())
+			if err != nil {
+				metricManager.HTTPClientCircuitRejectedTotal.Inc(ctx, mmetric.WithAttributes(c.attributes...))
+				return nil, merror.WrapCode(err, mcode.CodeServerBusy)
+			}
+
+			start := time.Now()
+			defer func() {
+				// A panicking request must not keep its half-open probe slot.
+				if exception := recover(); exception != nil {
+					c.record(ctx, generation, true, false, time.Now())
+					panic(exception)
+				}
+			}()
+			resp, err := next(req)
+			failure := b.config.IsFailure(resp, err)
+			slow := b.config.SlowCallDuration > 0 && time.Since(start) >= b.config.SlowCallDuration
+			c.record(ctx, generation, failure, slow, time.Now())
+			return resp, err
+		}
+	}
+}
+
+// circuit returns the circuit of key, creating it on first use.
+func (b *CircuitBreaker) circuit(key string) *circuit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		c = newCircuit(b, key)
+		b.circuits[key] = c
+		metricManager.HTTPClientCircuitState.Record(float64(CircuitClosed), mmetric.WithAttributes(c.attributes...))
+	}
+	return c
+}
+
+// circuitBucket counts the outcomes of one slice of the rolling window.
+type circuitBucket struct {
+	total    int
+	failures int
+	slow     int
+}
+
+type circuit struct {
+	breaker    *CircuitBreaker
+	key        string
+	attributes []attribute.KeyValue
+
+	mu                sync.Mutex
+	state             CircuitState
+	generation        uint64 // changes with the state, so late outcomes are ignored
+	openedAt          time.Time
+	halfOpenInFlight  int
+	halfOpenSucceeded int
+	buckets           []circuitBucket
+	bucketIndex       int
+	bucketStart       time.Time
+}
+
+func newCircuit(b *CircuitBreaker, key string) *circuit {
+	return &circuit{
+		breaker: b,
+		key:     key,
+		attributes: []attribute.KeyValue{
+			attribute.String(metricAttrCircuitName, b.config.Name),
+			attribute.String(metricAttrCircuitKey, key),
+		},
+		buckets:     make([]circuitBucket, b.config.Buckets),
+		bucketStart: time.Now(),
+	}
+}
+
+// allow admits a request and returns the generation its outcome belongs to.
+func (c *circuit) allow(ctx context.Context, now time.Time) (uint64, error) {
+	config := c.breaker.config
+	c.mu.Lock()
+	var transition func()
+	defer func() {
+		c.mu.Unlock()
+		if transition != nil {
+			transition()
+		}
+	}()
+
+	switch c.state {
+	case CircuitOpen:
+		if elapsed := now.Sub(c.openedAt); elapsed < config.OpenTimeout {
+			return 0, &CircuitOpenError{Name: config.Name, Key: c.key, State: CircuitOpen, RetryAfter: config.OpenTimeout - elapsed}
+		}
+		transition = c.setState(ctx, CircuitHalfOpen, now)
+		fallthrough
+	case CircuitHalfOpen:
+		if c.halfOpenInFlight >= config.HalfOpenRequests {
+			return 0, &CircuitOpenError{Name: config.Name, Key: c.key, State: CircuitHalfOpen}
+		}
+		c.halfOpenInFlight++
+	}
+	return c.generation, nil
+}
+
+// record counts the outcome of a request admitted in generation.
+func (c *circuit) record(ctx context.Context, generation uint64, failure, slow bool, now time.Time) {
+	config := c.breaker.config
+	c.mu.Lock()
+	var transition func()
+	defer func() {
+		c.mu.Unlock()
+		if transition != nil {
+			transition()
+		}
+	}()
+
+	if generation != c.generation {
+		return
+	}
+	switch c.state {
+	case CircuitHalfOpen:
+		c.halfOpenInFlight--
+		if failure || slow {
+			transition = c.setState(ctx, CircuitOpen, now)
+			return
+		}
+		if c.halfOpenSucceeded++; c.halfOpenSucceeded >= config.HalfOpenRequests {
+			transition = c.setState(ctx, CircuitClosed, now)
+		}
+
+	case CircuitClosed:
+		c.advance(now)
+		bucket := &c.buckets[c.bucketIndex]
+		bucket.total++
+		if failure {
+			bucket.failures++
+		}
+		if slow {
+			bucket.slow++
+		}
+
+		var total, failures, slowCalls int
+		for _, b := range c.buckets {
+			total += b.total
+			failures += b.failures
+			slowCalls += b.slow
+		}
+		if total < config.MinRequests {
+			return
+		}
+		if float64(failures)/float64(total) >= config.FailureRateThreshold ||
+			(config.SlowCallDuration > 0 && float64(slowCalls)/float64(total) >= config.SlowCallRateThreshold) {
+			transition = c.setState(ctx, CircuitOpen, now)
+		}
+	}
+}
+
+// advance rotates the buckets that fell out of the rolling window.
+func (c *circuit) advance(now time.Time) {
+	width := c.breaker.config.Window / time.Duration(len(c.buckets))
+	for steps := 0; now.Sub(c.bucketStart) >= width; steps++ {
+		if steps >= len(c.buckets) {
+			// The whole window expired.
+			clear(c.buckets)
+			c.bucketStart = now
+			return
+		}
+		c.bucketIndex = (c.bucketIndex + 1) % len(c.buckets)
+		c.buckets[c.bucketIndex] = circuitBucket{}
+		c.bucketStart = c.bucketStart.Add(width)
+	}
+}
+
+// setState changes the state with c.mu held and returns the notification to
+// run once it is released.
+func (c *circuit) setState(ctx context.Context, to CircuitState, now time.Time) func() {
+	from := c.state
+	c.state = to
+	c.generation++
+	c.halfOpenInFlight = 0
+	c.halfOpenSucceeded = 0
+	switch to {
+	case CircuitOpen:
+		c.openedAt = now
+	case CircuitClosed:
+		clear(c.buckets)
+		c.bucketStart = now
+	}
+
+	config := c.breaker.config
+	return func() {
+		mmetric.RecordGauge(ctx, metricManager.HTTPClientCircuitState, float64(to), mmetric.WithAttributes(c.attributes...))
+		config.Logger.Warnw(ctx, "circuit breaker state changed",
+			mlog.String(maltose.COMPONENT, "mclient"),
+			mlog.String("circuit", config.Name),
+			mlog.String("key", c.key),
+			mlog.String("from", from.String()),
+			mlog.String("to", to.String()),
+		)
+		if config.OnStateChange != nil {
+			config.OnStateChange(config.Name, c.key, from, to)
+		}
+	}
+}
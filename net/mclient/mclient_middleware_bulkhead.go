@@ -0,0 +1,267 @@
+package mclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/os/mmetric"
+	"github.com/graingo/maltose/util/msync"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrBulkheadFull is matched by errors.Is for requests rejected by a bulkhead.
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// BulkheadRejectedError is the error of a request rejected by a bulkhead.
+// It is returned wrapped in an merror with code mcode.CodeServerBusy.
+type BulkheadRejectedError struct {
+	// Name is the name of the bulkhead.
+	Name string
+	// Key is the partition the request belongs to, see BulkheadConfig.KeyFunc.
+	Key string
+	// Waited is how long the request waited in the queue, zero when the queue was full.
+	Waited time.Duration
+}
+
+// Error implements the error interface.
+func (e *BulkheadRejectedError) Error() string {
+	if e.Waited > 0 {
+		return fmt.Sprintf("bulkhead %s is full for %q, no slot freed within %s", e.Name, e.Key, e.Waited)
+	}
+	return fmt.Sprintf("bulkhead %s is full for %q, queue is full", e.Name, e.Key)
+}
+
+// Is reports whether target is ErrBulkheadFull.
+func (e *BulkheadRejectedError) Is(target error) bool {
+	return target == ErrBulkheadFull
+}
+
+// BulkheadConfig represents options for the bulkhead middleware.
+type BulkheadConfig struct {
+	// Name identifies the bulkhead in metrics and errors. Default is "default".
+	Name string
+	// KeyFunc splits requests into independent partitions, e.g. CircuitKeyHost
+	// to limit each host separately. By default all requests of the client share one partition.
+	KeyFunc func(*Request) string
+	// MaxConcurrent is the number of requests in flight per partition. Default is 100.
+	MaxConcurrent int
+	// MaxQueue is the number of requests waiting for a slot per partition.
+	// Zero rejects requests at once when all slots are in use.
+	MaxQueue int
+	// QueueTimeout is how long a request waits for a slot. Zero waits until
+	// the request context is done.
+	QueueTimeout time.Duration
+}
+
+// DefaultBulkheadConfig returns a default bulkhead configuration.
+func DefaultBulkheadConfig() BulkheadConfig {
+	return BulkheadConfig{
+		Name:          "default",
+		MaxConcurrent: 100,
+	}
+}
+
+func normalizeBulkheadConfig(config BulkheadConfig) BulkheadConfig {
+	defaults := DefaultBulkheadConfig()
+	if config.Name == "" {
+		config.Name = defaults.Name
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = defaults.MaxConcurrent
+	}
+	if config.MaxQueue < 0 {
+		config.MaxQueue = 0
+	}
+	return config
+}
+
+// Bulkhead caps the requests in flight of a client, so a slow dependency
+// cannot tie up all its goroutines and connections.
+type Bulkhead struct {
+	config     BulkheadConfig
+	mu         sync.Mutex
+	partitions map[string]*bulkheadPartition
+}
+
+// bulkheadPartition is the slots and queue of a partition.
+type bulkheadPartition struct {
+	limit      *msync.Limit
+	queued     atomic.Int64
+	attributes []attribute.KeyValue
+}
+
+// NewBulkhead creates a bulkhead. Use its Middleware on a client or request,
+// and InFlight and Queued to inspect it.
+func NewBulkhead(config BulkheadConfig) *Bulkhead {
+	return &Bulkhead{
+		config:     normalizeBulkheadConfig(config),
+		partitions: make(map[string]*bulkheadPartition),
+	}
+}
+
+// MiddlewareBulkhead returns a middleware that rejects requests with a
+// BulkheadRejectedError when too many are in flight.
+func MiddlewareBulkhead(config BulkheadConfig) MiddlewareFunc {
+	return NewBulkhead(config).Middleware()
+}
+
+// WithBulkhead applies a bulkhead to all requests from this client.
+func (c *Client) WithBulkhead(config BulkheadConfig) *Client {
+	c.Use(MiddlewareBulkhead(config))
+	return c
+}
+
+// InFlight returns the number of requests in flight of the partition key, or
+// of the shared partition when no KeyFunc is configured.
+func (b *Bulkhead) InFlight(key ...string) int {
+	if p := b.lookup(key); p != nil {
+		return p.limit.InUse()
+	}
+	return 0
+}
+
+// Queued returns the number of requests waiting in the partition key, or in
+// the shared partition when no KeyFunc is configured.
+func (b *Bulkhead) Queued(key ...string) int {
+	if p := b.lookup(key); p != nil {
+		return int(p.queued.Load())
+	}
+	return 0
+}
+
+// Middleware returns the middleware of the bulkhead. A slot is held until the
+// response body is read to the end or closed.
+func (b *Bulkhead) Middleware() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (*Response, error) {
+			key := ""
+			if b.config.KeyFunc != nil {
+				key = b.config.KeyFunc(req)
+			}
+			p := b.partition(key)
+			ctx := req.Context()
+
+			if err := b.acquire(ctx, p, key); err != nil {
+				return nil, err
+			}
+			metricManager.HTTPClientBulkheadInFlight.Inc(ctx, mmetric.WithAttributes(p.attributes...))
+			var once sync.Once
+			release := func() {
+				once.Do(func() {
+					_ = p.limit.Return()
+					metricManager.HTTPClientBulkheadInFlight.Dec(ctx, mmetric.WithAttributes(p.attributes...))
+				})
+			}
+			defer func() {
+				if exception := recover(); exception != nil {
+					release()
+					panic(exception)
+				}
+			}()
+
+			resp, err := next(req)
+			if err != nil || resp == nil || resp.Response == nil || resp.Body == nil {
+				release()
+				return resp, err
+			}
+			resp.Body = &releaseReadCloser{ReadCloser: resp.Body, release: release}
+			return resp, nil
+		}
+	}
+}
+
+// acquire takes a slot of p, waiting in the queue when allowed.
+func (b *Bulkhead) acquire(ctx context.Context, p *bulkheadPartition, key string) error {
+	if p.limit.TryBorrow() {
+		return nil
+	}
+	if p.queued.Add(1) > int64(b.config.MaxQueue) {
+		p.queued.Add(-1)
+		metricManager.HTTPClientBulkheadRejectedTotal.Inc(ctx, mmetric.WithAttributes(p.attributes...))
+		return merror.WrapCode(&BulkheadRejectedError{Name: b.config.Name, Key: key}, mcode.CodeServerBusy)
+	}
+	metricManager.HTTPClientBulkheadQueued.Inc(ctx, mmetric.WithAttributes(p.attributes...))
+	defer func() {
+		p.queued.Add(-1)
+		metricManager.HTTPClientBulkheadQueued.Dec(ctx, mmetric.WithAttributes(p.attributes...))
+	}()
+
+	waitCtx := ctx
+	if b.config.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, b.config.QueueTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	if err := p.limit.BorrowContext(waitCtx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		metricManager.HTTPClientBulkheadRejectedTotal.Inc(ctx, mmetric.WithAttributes(p.attributes...))
+		return merror.WrapCode(&BulkheadRejectedError{Name: b.config.Name, Key: key, Waited: time.Since(start)}, mcode.CodeServerBusy)
+	}
+	return nil
+}
+
+// partition returns the partition of key, creating it on first use.
+func (b *Bulkhead) partition(key string) *bulkheadPartition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.partitions[key]
+	if !ok {
+		p = &bulkheadPartition{
+			limit: msync.NewLimit(b.config.MaxConcurrent),
+			attributes: []attribute.KeyValue{
+				attribute.String(metricAttrBulkheadName, b.config.Name),
+				attribute.String(metricAttrBulkheadKey, key),
+			},
+		}
+		b.partitions[key] = p
+	}
+	return p
+}
+
+// lookup returns the partition of the optional key, nil if it was never used.
+func (b *Bulkhead) lookup(key []string) *bulkheadPartition {
+	k := ""
+	if len(key) > 0 {
+		k = key[0]
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.partitions[k]
+}
+
+// releaseReadCloser calls release once the body is read to the end, fails or is closed.
+type releaseReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+// Read reads the body, releasing at its end.
+func (r *releaseReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.release()
+	}
+	return n, err
+}
+
+// Close closes the body and releases.
+func (r *releaseReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
+
+// Unwrap returns the wrapped body, e.g. the connection of an upgraded response.
+func (r *releaseReadCloser) Unwrap() io.ReadCloser {
+	return r.ReadCloser
+}
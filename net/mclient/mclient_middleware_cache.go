@@ -0,0 +1,455 @@
+package mclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/internal/intlog"
+	"github.com/graingo/maltose/os/mcache"
+)
+
+const (
+	// CacheStatusHeader is the response header reporting how MiddlewareCache served a response.
+	CacheStatusHeader = "X-Cache-Status"
+
+	// CacheHit is a fresh response served from the cache.
+	CacheHit = "HIT"
+	// CacheMiss is a response fetched from the server.
+	CacheMiss = "MISS"
+	// CacheRevalidated is a cached response the server confirmed with 304 Not Modified.
+	CacheRevalidated = "REVALIDATED"
+	// CacheStale is a stale cached response served because the server failed.
+	CacheStale = "STALE"
+	// CacheBypass is a response fetched from the server because the request bypassed the cache.
+	CacheBypass = "BYPASS"
+)
+
+// CacheConfig represents options for the response cache middleware.
+type CacheConfig struct {
+	// Adapter stores the cached responses, e.g. mcache.NewAdapterMemory() or a Redis adapter.
+	Adapter mcache.Adapter
+	// KeyPrefix is prepended to the cache keys. Default is "mclient:cache:".
+	KeyPrefix string
+	// StaleIfError is how long past its freshness a response may be served when
+	// the server fails, unless the response forbids it. The stale-if-error
+	// directive of a response takes precedence. Zero disables it.
+	StaleIfError time.Duration
+	// RevalidateTTL is how long responses with an ETag or Last-Modified header
+	// are kept for revalidation after they became stale. Default is 24h.
+	RevalidateTTL time.Duration
+	// MaxBodySize is the largest body cached, larger responses are passed through. Default is 1MB.
+	MaxBodySize int64
+}
+
+// DefaultCacheConfig returns a default cache configuration without an adapter.
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		KeyPrefix:     "mclient:cache:",
+		RevalidateTTL: 24 * time.Hour,
+		MaxBodySize:   1 << 20,
+	}
+}
+
+func normalizeCacheConfig(config CacheConfig) CacheConfig {
+	defaults := DefaultCacheConfig()
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = defaults.KeyPrefix
+	}
+	if config.RevalidateTTL <= 0 {
+		config.RevalidateTTL = defaults.RevalidateTTL
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = defaults.MaxBodySize
+	}
+	return config
+}
+
+// BypassCache makes the request skip cached responses. A cacheable response
+// still replaces the cached one.
+func (r *Request) BypassCache() *Request {
+	r.cacheBypass = true
+	return r
+}
+
+// WithCache caches the GET and HEAD responses of this client.
+func (c *Client) WithCache(config CacheConfig) *Client {
+	c.Use(MiddlewareCache(config))
+	return c
+}
+
+// MiddlewareCache returns a middleware acting as an HTTP cache for GET and HEAD
+// requests. It honors Cache-Control, Expires and Vary, revalidates stale
+// responses with If-None-Match and If-Modified-Since, and can serve stale
+// responses when the server fails. Since the adapter may be shared, responses
+// to requests with an Authorization or Cookie header are only stored when they
+// are public, s-maxage or must-revalidate. Successful unsafe requests
+// invalidate the cached responses of their URL. The header CacheStatusHeader
+// of the returned response reports how it was served.
+func MiddlewareCache(config CacheConfig) MiddlewareFunc {
+	config = normalizeCacheConfig(config)
+	if config.Adapter == nil {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "cache adapter is required"))
+	}
+	cache := &responseCache{config: config}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (*Response, error) {
+			switch req.Request.Method {
+			case http.MethodGet, http.MethodHead:
+				if req.stream {
+					// Streams are neither served from nor stored in the cache.
+					return next(req)
+				}
+				return cache.handle(req, next)
+			case http.MethodOptions, http.MethodTrace:
+				return next(req)
+			default:
+				resp, err := next(req)
+				if err == nil && resp != nil && resp.Response != nil && resp.StatusCode < 400 {
+					cache.invalidate(req.Context(), req)
+				}
+				return resp, err
+			}
+		}
+	}
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	StatusCode int               `json:"status_code"`
+	Header     http.Header       `json:"header"`
+	Body       []byte            `json:"body"`
+	Vary       map[string]string `json:"vary,omitempty"` // request header values the response varies on
+	StoredAt   time.Time         `json:"stored_at"`      // when the response was received or revalidated
+}
+
+type responseCache struct {
+	config CacheConfig
+}
+
+func (c *responseCache) key(method string, req *Request) string {
+	return c.config.KeyPrefix + method + " " + req.Request.URL.String()
+}
+
+func (c *responseCache) handle(req *Request, next HandlerFunc) (*Response, error) {
+	var (
+		ctx     = req.Context()
+		key     = c.key(req.Request.Method, req)
+		reqCC   = parseCacheControl(req.Request.Header)
+		noStore = reqCC.has("no-store")
+		bypass  = req.cacheBypass || noStore
+		entry   *cacheEntry
+		// Conditional requests of the caller are passed through.
+		conditional = req.Request.Header.Get("If-None-Match") != "" || req.Request.Header.Get("If-Modified-Since") != ""
+		respCC      cacheControl
+		staleness   time.Duration
+	)
+	if !bypass && !conditional {
+		entry = c.load(ctx, key, req.Request.Header)
+	}
+	now := time.Now()
+	if entry != nil {
+		respCC = parseCacheControl(entry.Header)
+		staleness = entry.age(now) - entry.freshness(respCC)
+		mustRevalidate := reqCC.has("no-cache") || respCC.has("no-cache")
+		if maxAge, ok := reqCC.seconds("max-age"); ok && entry.age(now) > maxAge {
+			mustRevalidate = true
+		}
+		if staleness < 0 && !mustRevalidate {
+			return entry.response(req, now, CacheHit), nil
+		}
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Request.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Request.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := next(req)
+	if entry != nil {
+		req.Request.Header.Del("If-None-Match")
+		req.Request.Header.Del("If-Modified-Since")
+	}
+
+	if err != nil || resp == nil || resp.Response == nil || resp.StatusCode >= 500 {
+		if entry != nil && c.canServeStale(respCC, staleness) {
+			if resp != nil {
+				resp.Close()
+			}
+			intlog.Printf(ctx, "serving stale response for %s after error: %v", req.Request.URL, err)
+			return entry.response(req, now, CacheStale), nil
+		}
+		return resp, err
+	}
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Close()
+		// Headers of the 304 response update the cached ones.
+		for name, values := range resp.Header {
+			if name != "Content-Length" {
+				entry.Header[name] = values
+			}
+		}
+		entry.StoredAt = time.Now()
+		c.store(ctx, key, entry)
+		return entry.response(req, entry.StoredAt, CacheRevalidated), nil
+	}
+
+	status := CacheMiss
+	if bypass {
+		status = CacheBypass
+	}
+	if noStore || conditional || !c.cacheable(req, resp) {
+		resp.Header.Set(CacheStatusHeader, status)
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.config.MaxBodySize+1))
+	if err != nil {
+		resp.Close()
+		return nil, merror.Wrap(err, "failed to read response body for caching")
+	}
+	if int64(len(body)) > c.config.MaxBodySize {
+		// Too large to cache, hand the body back unread.
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		resp.Header.Set(CacheStatusHeader, status)
+		return resp, nil
+	}
+	resp.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	entry = &cacheEntry{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+		StoredAt:   time.Now(),
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if entry.Vary == nil {
+			entry.Vary = make(map[string]string)
+		}
+		entry.Vary[name] = req.Request.Header.Get(name)
+	}
+	c.store(ctx, key, entry)
+	resp.Header.Set(CacheStatusHeader, status)
+	return resp, nil
+}
+
+// cacheable reports whether resp may be stored.
+func (c *responseCache) cacheable(req *Request, resp *Response) bool {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+	default:
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	// The cache may be shared between users, so private responses are not stored.
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	// Likewise responses to credentialed requests are only stored when the
+	// server allows it explicitly, see RFC 9111 section 3.5.
+	if credentialed(req.Request.Header) && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	entry := cacheEntry{Header: resp.Header}
+	return entry.freshness(cc) > 0 || entry.hasValidator()
+}
+
+// credentialed reports whether the request header carries credentials.
+func credentialed(header http.Header) bool {
+	return header.Get("Authorization") != "" || header.Get("Cookie") != ""
+}
+
+// canServeStale reports whether a response stale for staleness may be served on error.
+func (c *responseCache) canServeStale(cc cacheControl, staleness time.Duration) bool {
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") {
+		return false
+	}
+	limit := c.config.StaleIfError
+	if seconds, ok := cc.seconds("stale-if-error"); ok {
+		limit = seconds
+	}
+	return staleness <= limit
+}
+
+func (c *responseCache) load(ctx context.Context, key string, header http.Header) *cacheEntry {
+	value, err := c.config.Adapter.Get(ctx, key)
+	if err != nil {
+		intlog.Errorf(ctx, "failed to load cached response %s: %v", key, err)
+		return nil
+	}
+	if value.IsNil() {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal([]byte(value.String()), &entry); err != nil {
+		intlog.Errorf(ctx, "failed to decode cached response %s: %v", key, err)
+		return nil
+	}
+	for name, value := range entry.Vary {
+		if header.Get(name) != value {
+			return nil
+		}
+	}
+	return &entry
+}
+
+func (c *responseCache) store(ctx context.Context, key string, entry *cacheEntry) {
+	cc := parseCacheControl(entry.Header)
+	ttl := entry.freshness(cc)
+	if !cc.has("must-revalidate") && !cc.has("proxy-revalidate") {
+		staleIfError := c.config.StaleIfError
+		if seconds, ok := cc.seconds("stale-if-error"); ok {
+			staleIfError = seconds
+		}
+		ttl += staleIfError
+	}
+	if entry.hasValidator() {
+		ttl = max(ttl, entry.freshness(cc)+c.config.RevalidateTTL)
+	}
+	if ttl <= 0 {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		intlog.Errorf(ctx, "failed to encode response %s for caching: %v", key, err)
+		return
+	}
+	if err := c.config.Adapter.Set(ctx, key, string(data), ttl); err != nil {
+		intlog.Errorf(ctx, "failed to cache response %s: %v", key, err)
+	}
+}
+
+func (c *responseCache) invalidate(ctx context.Context, req *Request) {
+	if _, err := c.config.Adapter.Remove(ctx, c.key(http.MethodGet, req), c.key(http.MethodHead, req)); err != nil {
+		intlog.Errorf(ctx, "failed to invalidate cached responses of %s: %v", req.Request.URL, err)
+	}
+}
+
+// response builds the response served from the entry.
+func (e *cacheEntry) response(req *Request, now time.Time, status string) *Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(e.age(now).Seconds())))
+	header.Set(CacheStatusHeader, status)
+	return &Response{
+		Response: &http.Response{
+			Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+			StatusCode:    e.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(e.Body)),
+			ContentLength: int64(len(e.Body)),
+			Request:       req.Request,
+		},
+		result:      req.result,
+		errorResult: req.errorResult,
+	}
+}
+
+// age returns the current age of the response.
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	var initial time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		initial = max(0, e.StoredAt.Sub(date))
+	}
+	if seconds, err := strconv.Atoi(e.Header.Get("Age")); err == nil {
+		initial = max(initial, time.Duration(seconds)*time.Second)
+	}
+	return initial + now.Sub(e.StoredAt)
+}
+
+// freshness returns the freshness lifetime of the response.
+func (e *cacheEntry) freshness(cc cacheControl) time.Duration {
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0 // invalid dates mean already expired
+		}
+		date, err := http.ParseTime(e.Header.Get("Date"))
+		if err != nil {
+			date = e.StoredAt
+		}
+		return max(0, expiresAt.Sub(date))
+	}
+	return 0
+}
+
+func (e *cacheEntry) hasValidator() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// cacheControl holds the directives of Cache-Control headers.
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	if len(cc) == 0 && strings.Contains(header.Get("Pragma"), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the delta-seconds argument of a directive.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// varyHeaders returns the canonical names of the Vary header.
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
@@ -10,14 +10,41 @@ import (
 
 // localMetricManager is the local metric manager.
 type localMetricManager struct {
-	HTTPClientRequestTotal         mmetric.Counter
-	HTTPClientRequestDuration      mmetric.Histogram
-	HTTPClientRequestDurationTotal mmetric.Counter
-	HTTPClientRequestBodySize      mmetric.Counter
-	HTTPClientResponseBodySize     mmetric.Counter
-	HTTPpClientErrorTotal          mmetric.Counter
+	HTTPClientRequestTotal              mmetric.Counter
+	HTTPClientRequestDuration           mmetric.Histogram
+	HTTPClientRequestDurationTotal      mmetric.Counter
+	HTTPClientRequestBodySize           mmetric.Counter
+	HTTPClientResponseBodySize          mmetric.Counter
+	HTTPpClientErrorTotal               mmetric.Counter
+	HTTPClientCircuitState              mmetric.Gauge
+	HTTPClientCircuitRejectedTotal      mmetric.Counter
+	HTTPClientHedgeTotal                mmetric.Counter
+	HTTPClientHedgeWonTotal             mmetric.Counter
+	HTTPClientBulkheadInFlight          mmetric.UpDownCounter
+	HTTPClientBulkheadQueued            mmetric.UpDownCounter
+	HTTPClientBulkheadRejectedTotal     mmetric.Counter
+	HTTPClientRetryBudgetExhaustedTotal mmetric.Counter
+	HTTPClientMirrorTotal               mmetric.Counter
 }
 
+// Attributes of the circuit breaker metrics.
+const (
+	metricAttrCircuitName = "circuit_breaker.name"
+	metricAttrCircuitKey  = "circuit_breaker.key"
+)
+
+// Attributes of the bulkhead metrics.
+const (
+	metricAttrBulkheadName = "bulkhead.name"
+	metricAttrBulkheadKey  = "bulkhead.key"
+)
+
+// Attributes of the mirror metrics.
+const (
+	metricAttrMirrorBaseURL = "mirror.base_url"
+	metricAttrMirrorOutcome = "mirror.outcome"
+)
+
 // global metric manager
 var metricManager = newMetricManager()
 
@@ -70,6 +97,69 @@ func newMetricManager() *localMetricManager {
 				Unit: "",
 			},
 		),
+		HTTPClientCircuitState: meter.MustGauge(
+			"http.client.circuit_breaker.state",
+			mmetric.MetricOption{
+				Help: "circuit breaker state, 0 closed, 1 open, 2 half-open",
+				Unit: "",
+			},
+		),
+		HTTPClientCircuitRejectedTotal: meter.MustCounter(
+			"http.client.circuit_breaker.rejected.total",
+			mmetric.MetricOption{
+				Help: "requests rejected by the circuit breaker",
+				Unit: "",
+			},
+		),
+		HTTPClientHedgeTotal: meter.MustCounter(
+			"http.client.hedge.total",
+			mmetric.MetricOption{
+				Help: "hedged requests sent in addition to the original ones",
+				Unit: "",
+			},
+		),
+		HTTPClientHedgeWonTotal: meter.MustCounter(
+			"http.client.hedge.won.total",
+			mmetric.MetricOption{
+				Help: "hedged requests answering before the original ones",
+				Unit: "",
+			},
+		),
+		HTTPClientBulkheadInFlight: meter.MustUpDownCounter(
+			"http.client.bulkhead.in_flight",
+			mmetric.MetricOption{
+				Help: "requests holding a bulkhead slot",
+				Unit: "",
+			},
+		),
+		HTTPClientBulkheadQueued: meter.MustUpDownCounter(
+			"http.client.bulkhead.queued",
+			mmetric.MetricOption{
+				Help: "requests waiting for a bulkhead slot",
+				Unit: "",
+			},
+		),
+		HTTPClientBulkheadRejectedTotal: meter.MustCounter(
+			"http.client.bulkhead.rejected.total",
+			mmetric.MetricOption{
+				Help: "requests rejected by the bulkhead",
+				Unit: "",
+			},
+		),
+		HTTPClientRetryBudgetExhaustedTotal: meter.MustCounter(
+			"http.client.retry.budget_exhausted.total",
+			mmetric.MetricOption{
+				Help: "retries given up because the retry budget was exhausted",
+				Unit: "",
+			},
+		),
+		HTTPClientMirrorTotal: meter.MustCounter(
+			"http.client.mirror.total",
+			mmetric.MetricOption{
+				Help: "mirrored requests by outcome",
+				Unit: "",
+			},
+		),
 	}
 }
 
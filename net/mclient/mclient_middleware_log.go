@@ -1,13 +1,12 @@
 package mclient
 
 import (
-	"bytes"
-	"io"
 	"net/http"
 	"sort"
 	"time"
 
 	"github.com/graingo/maltose"
+	"github.com/graingo/maltose/internal/httplog"
 	"github.com/graingo/maltose/os/mlog"
 )
 
@@ -18,38 +17,71 @@ var LogMaxBodySize = 0
 // 1. Before the request is sent ("started").
 // 2. After the request is completed ("finished" or "error").
 // This allows for better observability, especially for hanging requests.
+// It uses DefaultLogConfig; see MiddlewareLogWithConfig for sampling and redaction.
 func MiddlewareLog(logger *mlog.Logger) MiddlewareFunc {
+	return MiddlewareLogWithConfig(logger, DefaultLogConfig())
+}
+
+// MiddlewareLogWithConfig creates a logging middleware with the given configuration.
+// Failed and slow requests are always logged, successful requests are sampled,
+// and sensitive headers, query parameters and body fields are masked.
+func MiddlewareLogWithConfig(logger *mlog.Logger, config LogConfig) MiddlewareFunc {
 	if logger == nil {
 		return func(next HandlerFunc) HandlerFunc {
 			return next
 		}
 	}
-	bodyLimit := LogMaxBodySize
+	var (
+		redactor = config.Redaction.Redactor()
+		sampler  = config.Sampling.Sampler()
+		limits   = config.Body.Limits()
+	)
 
 	return func(next HandlerFunc) HandlerFunc {
 		return func(req *Request) (*Response, error) {
 			ctx := req.Context()
 			l := logger.With(mlog.String(maltose.COMPONENT, "mclient"))
+			route := ""
+			if req.Request.URL != nil {
+				route = req.Request.URL.Path
+			}
 
 			// --- Step 1: Log request start ---
 
-			var reqBodyBytes []byte
-			if bodyLimit != 0 && req.Body != nil {
-				reqBodyBytes, req.Body = readBodyForLog(req.Body, bodyLimit)
+			var (
+				reqBodyBytes   []byte
+				reqContentType = req.Request.Header.Get("Content-Type")
+				reqBodyLimit   = limits.Limit(reqContentType)
+			)
+			// Streamed multipart uploads are not captured.
+			if reqBodyLimit != 0 && req.Body != nil && len(req.files) == 0 {
+				reqBodyBytes, req.Body = httplog.ReadBody(req.Body, reqBodyLimit)
 			}
 
 			requestFields := mlog.Fields{
 				mlog.String("method", req.Request.Method),
 				mlog.String("url", urlWithoutQuery(req.Request)),
 			}
-			if queryKeys := requestQueryKeys(req.Request); len(queryKeys) > 0 {
+			if config.Query {
+				if req.Request.URL != nil && req.Request.URL.RawQuery != "" {
+					requestFields = append(requestFields, mlog.String("query", redactor.Query(req.Request.URL.Query())))
+				}
+			} else if queryKeys := requestQueryKeys(req.Request); len(queryKeys) > 0 {
 				requestFields = append(requestFields, mlog.Any("query_keys", queryKeys))
 			}
+			if config.Headers {
+				requestFields = append(requestFields, mlog.Any("request_headers", redactor.Header(req.Request.Header)))
+			}
 			if len(reqBodyBytes) > 0 {
-				requestFields = append(requestFields, mlog.String("request_body", getBodyString(reqBodyBytes, bodyLimit)))
+				body := redactor.Body(reqContentType, reqBodyBytes)
+				requestFields = append(requestFields, mlog.String("request_body", httplog.BodyString(body, reqBodyLimit)))
 			}
 
-			l.Infow(ctx, "http client request started", requestFields...)
+			// Sampling is decided on completion, so the start entry is only written
+			// for routes whose successful requests are always logged.
+			if sampler.Rate(route) >= 1 {
+				l.Infow(ctx, "http client request started", requestFields...)
+			}
 
 			// --- Step 2: Execute request and log completion ---
 
@@ -67,14 +99,20 @@ func MiddlewareLog(logger *mlog.Logger) MiddlewareFunc {
 				l.Errorw(ctx, err, "http client request error", finalFields...)
 				return resp, err
 			}
+			if !sampler.Sample(route, resp.StatusCode >= 400, duration) {
+				return resp, nil
+			}
 
 			// If we got a response, add its details to the log
 			finalFields = append(finalFields, mlog.Int("status", resp.StatusCode))
-			if bodyLimit != 0 && resp.Body != nil {
-				bodyBytes, restoredBody := readBodyForLog(resp.Body, bodyLimit)
+			resContentType := resp.Header.Get("Content-Type")
+			// Streamed responses are left unread, capturing them would block until the stream ends.
+			if resBodyLimit := limits.Limit(resContentType); resBodyLimit != 0 && resp.Body != nil && !req.stream {
+				bodyBytes, restoredBody := httplog.ReadBody(resp.Body, resBodyLimit)
 				resp.Body = restoredBody
 				if len(bodyBytes) > 0 {
-					finalFields = append(finalFields, mlog.String("response_body", getBodyString(bodyBytes, bodyLimit)))
+					body := redactor.Body(resContentType, bodyBytes)
+					finalFields = append(finalFields, mlog.String("response_body", httplog.BodyString(body, resBodyLimit)))
 				}
 			}
 
@@ -111,41 +149,3 @@ func requestQueryKeys(request *http.Request) []string {
 	sort.Strings(keys)
 	return keys
 }
-
-type replayReadCloser struct {
-	io.Reader
-	io.Closer
-}
-
-func readBodyForLog(body io.ReadCloser, limit int) ([]byte, io.ReadCloser) {
-	if limit < 0 {
-		data, err := io.ReadAll(body)
-		if err != nil {
-			return nil, body
-		}
-		_ = body.Close()
-		return data, io.NopCloser(bytes.NewReader(data))
-	}
-	data, err := io.ReadAll(io.LimitReader(body, int64(limit+1)))
-	if err != nil {
-		return nil, body
-	}
-	return data, &replayReadCloser{
-		Reader: io.MultiReader(bytes.NewReader(data), body),
-		Closer: body,
-	}
-}
-
-// getBodyString safely converts a byte slice to a string for logging, with a size limit.
-func getBodyString(body []byte, limit int) string {
-	if len(body) == 0 {
-		return ""
-	}
-	if limit < 0 { // no limit
-		return string(body)
-	}
-	if len(body) > limit {
-		return string(body[:limit]) + "..."
-	}
-	return string(body)
-}
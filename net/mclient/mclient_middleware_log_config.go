@@ -0,0 +1,42 @@
+package mclient
+
+import "github.com/graingo/maltose/internal/httplog"
+
+// LogConfig defines the configuration for MiddlewareLogWithConfig.
+type LogConfig struct {
+	// Query logs the full query string with redaction applied instead of only the query keys.
+	Query bool
+	// Headers logs the request headers with redaction applied.
+	Headers bool
+	// Sampling controls which completed requests are logged.
+	Sampling LogSamplingConfig
+	// Body controls request and response body capture.
+	Body LogBodyConfig
+	// Redaction controls masking of sensitive values.
+	Redaction LogRedactionConfig
+}
+
+// LogSamplingConfig defines outbound request log sampling. Its RouteRates are
+// keyed by request URL path. Requests that fail (transport errors or status
+// >= 400) and slow requests are always logged.
+type LogSamplingConfig = httplog.SamplingConfig
+
+// LogBodyConfig defines request and response body capture.
+type LogBodyConfig = httplog.BodyConfig
+
+// LogRedactionConfig defines which values are masked in request logs.
+type LogRedactionConfig = httplog.RedactionConfig
+
+// DefaultLogRedactionConfig returns redaction rules for commonly sensitive values.
+func DefaultLogRedactionConfig() LogRedactionConfig {
+	return httplog.DefaultRedactionConfig()
+}
+
+// DefaultLogConfig returns the configuration used by MiddlewareLog.
+// Body capture follows LogMaxBodySize.
+func DefaultLogConfig() LogConfig {
+	return LogConfig{
+		Body:      LogBodyConfig{MaxSize: LogMaxBodySize},
+		Redaction: DefaultLogRedactionConfig(),
+	}
+}
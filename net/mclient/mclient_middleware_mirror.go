@@ -0,0 +1,283 @@
+package mclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/graingo/maltose"
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/internal/intlog"
+	"github.com/graingo/maltose/os/mlog"
+	"github.com/graingo/maltose/os/mmetric"
+	"github.com/graingo/maltose/util/msync"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Outcomes of mirrored requests, reported by the mirror metric.
+const (
+	mirrorOutcomeSent    = "sent"
+	mirrorOutcomeMatch   = "match"
+	mirrorOutcomeDiff    = "diff"
+	mirrorOutcomeError   = "error"
+	mirrorOutcomeDropped = "dropped"
+)
+
+// mirroredKey marks the context of shadow requests, so they are never mirrored again.
+type mirroredKey struct{}
+
+// MirrorConfig represents options for the traffic mirroring middleware.
+type MirrorConfig struct {
+	// BaseURL is the base URL of the shadow backend the requests are mirrored to,
+	// e.g. "http://orders-v2:8080". The path and query of the requests are kept.
+	BaseURL string
+	// SampleRate is the fraction of the matching requests mirrored, between 0 and 1. Default is 1.
+	SampleRate float64
+	// Match selects the requests mirrored. By default all requests are.
+	Match func(*Request) bool
+	// MaxConcurrent is the number of shadow requests in flight. Requests are not
+	// mirrored while it is reached. Default is 10.
+	MaxConcurrent int
+	// Timeout is the timeout of the shadow requests. Default is 5s.
+	Timeout time.Duration
+	// Client sends the shadow requests. Default is a new client.
+	Client *Client
+	// Compare compares the shadow responses with the primary ones. The body of
+	// a primary response is compared once the caller read it to the end.
+	Compare bool
+	// MaxCompareBodySize is the largest body compared. Default is 1MB.
+	MaxCompareBodySize int64
+	// OnDiff is called when a shadow response differs from the primary one.
+	// By default the difference is logged.
+	OnDiff func(ctx context.Context, diff *MirrorDiff)
+	// Logger logs the differences. Default is mlog.DefaultLogger().
+	Logger *mlog.Logger
+}
+
+// MirrorDiff is the difference between a primary and a shadow response.
+type MirrorDiff struct {
+	// Method is the method of the request.
+	Method string
+	// URL is the URL of the shadow request.
+	URL string
+	// PrimaryStatus is the status of the primary response.
+	PrimaryStatus int
+	// ShadowStatus is the status of the shadow response.
+	ShadowStatus int
+	// PrimaryBody is the body of the primary response, nil when it was not compared.
+	PrimaryBody []byte
+	// ShadowBody is the body of the shadow response, nil when it was not compared.
+	ShadowBody []byte
+}
+
+// DefaultMirrorConfig returns a default mirror configuration without a base URL.
+func DefaultMirrorConfig() MirrorConfig {
+	return MirrorConfig{
+		SampleRate:         1,
+		MaxConcurrent:      10,
+		Timeout:            5 * time.Second,
+		MaxCompareBodySize: 1 << 20,
+	}
+}
+
+func normalizeMirrorConfig(config MirrorConfig) MirrorConfig {
+	defaults := DefaultMirrorConfig()
+	if config.BaseURL == "" {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "mirror base URL is required"))
+	}
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		config.SampleRate = defaults.SampleRate
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = defaults.MaxConcurrent
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.Client == nil {
+		config.Client = New()
+	}
+	if config.MaxCompareBodySize <= 0 {
+		config.MaxCompareBodySize = defaults.MaxCompareBodySize
+	}
+	if config.Logger == nil {
+		config.Logger = mlog.DefaultLogger()
+	}
+	return config
+}
+
+// WithMirror mirrors the requests of this client to a shadow backend.
+func (c *Client) WithMirror(config MirrorConfig) *Client {
+	c.Use(MiddlewareMirror(config))
+	return c
+}
+
+// MiddlewareMirror returns a middleware sending a copy of the requests to a
+// shadow backend in the background. Shadow responses are discarded and never
+// affect the callers. Only the first attempt of a request is mirrored.
+func MiddlewareMirror(config MirrorConfig) MiddlewareFunc {
+	config = normalizeMirrorConfig(config)
+	limit := msync.NewLimit(config.MaxConcurrent)
+	attributes := []attribute.KeyValue{attribute.String(metricAttrMirrorBaseURL, config.BaseURL)}
+	count := func(ctx context.Context, outcome string) {
+		metricManager.HTTPClientMirrorTotal.Inc(ctx, mmetric.WithAttributes(
+			append(attributes, attribute.String(metricAttrMirrorOutcome, outcome))...,
+		))
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (*Response, error) {
+			ctx := req.Context()
+			if len(req.attempts) > 0 || ctx.Value(mirroredKey{}) != nil || req.Request == nil || req.Request.URL == nil ||
+				(config.Match != nil && !config.Match(req)) || rand.Float64() >= config.SampleRate {
+				return next(req)
+			}
+			if !limit.TryBorrow() {
+				count(ctx, mirrorOutcomeDropped)
+				return next(req)
+			}
+			body, err := bufferRequestBody(req.Request)
+			if err != nil {
+				_ = limit.Return()
+				return nil, err
+			}
+			shadow := config.Client.R().
+				SetContext(context.WithValue(context.WithoutCancel(ctx), mirroredKey{}, true)).
+				Method(req.Request.Method)
+			shadow.Request.Header = req.Request.Header.Clone()
+			if body != nil {
+				shadow.SetBody(body)
+			}
+			shadowURL := joinURL(config.BaseURL, req.Request.URL.RequestURI())
+
+			resp, err := next(req)
+			var capture *mirrorCapture
+			if config.Compare && err == nil && resp != nil && resp.Response != nil && resp.Body != nil {
+				capture = newMirrorCapture(resp.Body, config.MaxCompareBodySize)
+				resp.Body = capture
+			}
+			go func() {
+				defer func() { _ = limit.Return() }()
+				outcome := mirror(shadow, shadowURL, config, resp, capture)
+				count(ctx, outcome)
+			}()
+			return resp, err
+		}
+	}
+}
+
+// mirror sends the shadow request and compares its response with primary.
+func mirror(shadow *Request, shadowURL string, config MirrorConfig, primary *Response, capture *mirrorCapture) string {
+	ctx, cancel := context.WithTimeout(shadow.Context(), config.Timeout)
+	defer cancel()
+	shadow.SetContext(ctx)
+	resp, err := shadow.Send(shadowURL)
+	if err != nil {
+		intlog.Errorf(ctx, "mirrored request to %s failed: %+v", shadowURL, err)
+		return mirrorOutcomeError
+	}
+	defer resp.Close()
+	if !config.Compare {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return mirrorOutcomeSent
+	}
+	if primary == nil || primary.Response == nil {
+		return mirrorOutcomeError
+	}
+
+	diff := &MirrorDiff{
+		Method:        shadow.Request.Method,
+		URL:           shadowURL,
+		PrimaryStatus: primary.StatusCode,
+		ShadowStatus:  resp.StatusCode,
+	}
+	if primaryBody, ok := capture.wait(ctx); ok {
+		shadowBody, readErr := io.ReadAll(io.LimitReader(resp.Body, config.MaxCompareBodySize+1))
+		if readErr == nil && int64(len(shadowBody)) <= config.MaxCompareBodySize {
+			diff.PrimaryBody, diff.ShadowBody = primaryBody, shadowBody
+		}
+	}
+	if diff.PrimaryStatus == diff.ShadowStatus &&
+		(diff.PrimaryBody == nil || bytes.Equal(diff.PrimaryBody, diff.ShadowBody)) {
+		return mirrorOutcomeMatch
+	}
+
+	if config.OnDiff != nil {
+		config.OnDiff(ctx, diff)
+	} else {
+		config.Logger.Warnw(ctx, "mirrored response differs",
+			mlog.String(maltose.COMPONENT, "mclient"),
+			mlog.String("method", diff.Method),
+			mlog.String("url", diff.URL),
+			mlog.Int("primary_status", diff.PrimaryStatus),
+			mlog.Int("shadow_status", diff.ShadowStatus),
+			mlog.String("primary_body", string(diff.PrimaryBody)),
+			mlog.String("shadow_body", string(diff.ShadowBody)),
+		)
+	}
+	return mirrorOutcomeDiff
+}
+
+// mirrorCapture keeps a copy of the primary response body as the caller reads it.
+type mirrorCapture struct {
+	io.ReadCloser
+	limit int64
+
+	buf      bytes.Buffer
+	complete bool // whether the body was read to the end within limit
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newMirrorCapture(body io.ReadCloser, limit int64) *mirrorCapture {
+	return &mirrorCapture{ReadCloser: body, limit: limit, done: make(chan struct{})}
+}
+
+// Read reads the body, keeping a copy of what was read.
+func (c *mirrorCapture) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 && int64(c.buf.Len()+n) <= c.limit {
+		c.buf.Write(p[:n])
+	} else if n > 0 {
+		c.finish(false)
+	}
+	if err != nil {
+		c.finish(err == io.EOF)
+	}
+	return n, err
+}
+
+// Close closes the body.
+func (c *mirrorCapture) Close() error {
+	err := c.ReadCloser.Close()
+	c.finish(false)
+	return err
+}
+
+func (c *mirrorCapture) finish(complete bool) {
+	c.once.Do(func() {
+		c.complete = complete
+		close(c.done)
+	})
+}
+
+// wait returns the primary body once the caller is done with it. It returns
+// false when the body was not read to the end or is too large.
+func (c *mirrorCapture) wait(ctx context.Context) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	select {
+	case <-c.done:
+		if !c.complete {
+			return nil, false
+		}
+		return c.buf.Bytes(), true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
@@ -114,9 +114,9 @@ func (l *TokenBucketLimiter) reserveToken() (time.Duration, bool) {
 // RateLimitConfig represents options for rate limiting middleware.
 type RateLimitConfig struct {
 	// RequestsPerSecond is the number of requests allowed per second
-	RequestsPerSecond float64
+	RequestsPerSecond float64 `mconv:"requests_per_second"`
 	// Burst is the maximum number of requests allowed to happen at once
-	Burst int
+	Burst int `mconv:"burst"`
 	// Skip determines if rate limiting should be skipped for a request
 	Skip func(*Request) bool
 	// ErrorHandler handles rate limit errors
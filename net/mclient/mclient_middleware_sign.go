@@ -0,0 +1,63 @@
+package mclient
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/msign"
+)
+
+// WithSigner signs all requests from this client with signer.
+func (c *Client) WithSigner(signer msign.Signer) *Client {
+	c.Use(MiddlewareSign(signer))
+	return c
+}
+
+// MiddlewareSign returns a middleware signing the requests with signer, e.g.
+// msign.NewHMACSigner or msign.NewSigV4Signer. Every attempt is signed again,
+// so retried requests carry a fresh timestamp and nonce. The body is buffered
+// to be signed and can be sent again through GetBody.
+//
+// Register it after the middlewares changing the request, so the signature
+// covers their headers.
+func MiddlewareSign(signer msign.Signer) MiddlewareFunc {
+	if signer == nil {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "request signer is required"))
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (*Response, error) {
+			body, err := bufferRequestBody(req.Request)
+			if err != nil {
+				return nil, err
+			}
+			if err = signer.Sign(req.Request, body, time.Now()); err != nil {
+				return nil, merror.Wrap(err, "failed to sign request")
+			}
+			return next(req)
+		}
+	}
+}
+
+// bufferRequestBody reads the body of r and replaces it with a replayable copy.
+func bufferRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, merror.Wrap(err, "failed to read request body for signing")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	if r.GetBody == nil {
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,371 @@
+package mclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"gopkg.in/yaml.v3"
+)
+
+// RecordMode is the mode of a Recorder.
+type RecordMode string
+
+const (
+	// RecordModeReplay answers the requests from the cassette only. Requests
+	// without a recorded interaction fail.
+	RecordModeReplay RecordMode = "replay"
+	// RecordModeRecord sends the requests and records the interactions,
+	// replacing the previous content of the cassette.
+	RecordModeRecord RecordMode = "record"
+	// RecordModePassthrough sends the requests without using the cassette.
+	RecordModePassthrough RecordMode = "passthrough"
+)
+
+// redactedValue replaces the redacted header and query values.
+const redactedValue = "[REDACTED]"
+
+// RecorderConfig represents options for a Recorder.
+type RecorderConfig struct {
+	// Path is the cassette file. It is JSON when its extension is ".json", YAML otherwise.
+	Path string
+	// Mode is the mode of the recorder. Default is RecordModeReplay.
+	Mode RecordMode
+	// Transport sends the requests in the record and passthrough modes. Default is http.DefaultTransport.
+	Transport http.RoundTripper
+	// MatchHeaders are the request headers that must be equal for a recorded
+	// interaction to match, in addition to the method, the URL and the body.
+	MatchHeaders []string
+	// IgnoreBody matches requests regardless of their body.
+	IgnoreBody bool
+	// Matcher replaces the matching of requests with recorded ones.
+	Matcher func(r *http.Request, body []byte, recorded *RecordedRequest) bool
+	// RedactHeaders are the headers whose values are not recorded, in addition
+	// to Authorization, Proxy-Authorization, Cookie and Set-Cookie.
+	RedactHeaders []string
+	// RedactQuery are the query parameters whose values are not recorded, e.g. "access_token".
+	RedactQuery []string
+	// Redact edits the interactions before they are saved, e.g. to remove
+	// secrets from the bodies. Use IgnoreBody or a Matcher when it changes the
+	// request bodies, as the requests are matched against the saved ones.
+	Redact func(*Interaction)
+}
+
+// Cassette is the content of a cassette file.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions" yaml:"interactions"`
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request" yaml:"request"`
+	Response RecordedResponse `json:"response" yaml:"response"`
+}
+
+// RecordedRequest is a recorded request.
+type RecordedRequest struct {
+	Method       string      `json:"method" yaml:"method"`
+	URL          string      `json:"url" yaml:"url"`
+	Header       http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	RecordedBody `yaml:",inline"`
+}
+
+// RecordedResponse is a recorded response.
+type RecordedResponse struct {
+	StatusCode   int         `json:"status_code" yaml:"status_code"`
+	Header       http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	RecordedBody `yaml:",inline"`
+}
+
+// RecordedBody is a recorded body. Bodies that are not valid UTF-8 are
+// recorded in base64.
+type RecordedBody struct {
+	Body     string `json:"body,omitempty" yaml:"body,omitempty"`
+	Encoding string `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+}
+
+// Bytes returns the content of the body.
+func (b RecordedBody) Bytes() ([]byte, error) {
+	if b.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(b.Body)
+	}
+	return []byte(b.Body), nil
+}
+
+func newRecordedBody(data []byte) RecordedBody {
+	if utf8.Valid(data) {
+		return RecordedBody{Body: string(data)}
+	}
+	return RecordedBody{Body: base64.StdEncoding.EncodeToString(data), Encoding: "base64"}
+}
+
+// Recorder is a transport recording the interactions of a client to a
+// cassette file and replaying them offline, e.g. for deterministic tests.
+// Set it with Client.SetTransport.
+//
+// When several recorded interactions match a request, they are replayed in
+// the recorded order, the last one being repeated once all were used.
+type Recorder struct {
+	config RecorderConfig
+
+	mu       sync.Mutex
+	cassette *Cassette
+	replayed map[*Interaction]bool
+}
+
+// NewRecorder creates a recorder. The cassette is loaded in the replay mode.
+func NewRecorder(config RecorderConfig) (*Recorder, error) {
+	if config.Path == "" {
+		return nil, merror.NewCode(mcode.CodeInvalidConfiguration, "recorder cassette path is required")
+	}
+	if config.Mode == "" {
+		config.Mode = RecordModeReplay
+	}
+	if config.Transport == nil {
+		config.Transport = http.DefaultTransport
+	}
+	config.RedactHeaders = append(
+		[]string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+		config.RedactHeaders...,
+	)
+
+	recorder := &Recorder{
+		config:   config,
+		cassette: &Cassette{},
+		replayed: make(map[*Interaction]bool),
+	}
+	switch config.Mode {
+	case RecordModeReplay:
+		if err := recorder.load(); err != nil {
+			return nil, err
+		}
+	case RecordModeRecord, RecordModePassthrough:
+	default:
+		return nil, merror.NewCodef(mcode.CodeInvalidConfiguration, "unsupported record mode %q", config.Mode)
+	}
+	return recorder, nil
+}
+
+// Cassette returns the interactions recorded or loaded by the recorder.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{Interactions: slices.Clone(r.cassette.Interactions)}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.config.Mode == RecordModePassthrough {
+		return r.config.Transport.RoundTrip(req)
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, merror.Wrap(err, "failed to read request body for recording")
+		}
+	}
+	if r.config.Mode == RecordModeReplay {
+		return r.replay(req, body)
+	}
+	return r.record(req, body)
+}
+
+// replay answers req with the first matching interaction not replayed yet.
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.mu.Lock()
+	var found *Interaction
+	for _, interaction := range r.cassette.Interactions {
+		if !r.match(req, body, &interaction.Request) {
+			continue
+		}
+		found = interaction
+		if !r.replayed[interaction] {
+			break
+		}
+	}
+	if found != nil {
+		r.replayed[found] = true
+	}
+	r.mu.Unlock()
+
+	if found == nil {
+		return nil, merror.NewCodef(mcode.CodeNotFound,
+			"no interaction recorded in %s matches %s %s", r.config.Path, req.Method, r.redactURL(req.URL))
+	}
+	respBody, err := found.Response.Bytes()
+	if err != nil {
+		return nil, merror.Wrapf(err, "invalid response body recorded in %s", r.config.Path)
+	}
+	header := found.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(found.Response.StatusCode) + " " + http.StatusText(found.Response.StatusCode),
+		StatusCode:    found.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// record sends req and saves the interaction to the cassette.
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	outgoing := req.Clone(req.Context())
+	if req.Body != nil {
+		outgoing.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := r.config.Transport.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, merror.Wrap(err, "failed to read response body for recording")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	resp.ContentLength = int64(len(respBody))
+	resp.Request = req
+
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method:       req.Method,
+			URL:          r.redactURL(req.URL),
+			Header:       r.redactHeader(req.Header),
+			RecordedBody: newRecordedBody(body),
+		},
+		Response: RecordedResponse{
+			StatusCode:   resp.StatusCode,
+			Header:       r.redactHeader(resp.Header),
+			RecordedBody: newRecordedBody(respBody),
+		},
+	}
+	if r.config.Redact != nil {
+		r.config.Redact(interaction)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	if err = r.save(); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// match reports whether req matches the recorded request.
+func (r *Recorder) match(req *http.Request, body []byte, recorded *RecordedRequest) bool {
+	if r.config.Matcher != nil {
+		return r.config.Matcher(req, body, recorded)
+	}
+	if req.Method != recorded.Method || r.redactURL(req.URL) != recorded.URL {
+		return false
+	}
+	header := r.redactHeader(req.Header)
+	for _, name := range r.config.MatchHeaders {
+		if !slices.Equal(header.Values(name), recorded.Header.Values(name)) {
+			return false
+		}
+	}
+	if r.config.IgnoreBody {
+		return true
+	}
+	recordedBody, err := recorded.Bytes()
+	return err == nil && bytes.Equal(body, recordedBody)
+}
+
+// redactHeader returns a copy of header with the redacted values replaced.
+func (r *Recorder) redactHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	header = header.Clone()
+	for _, name := range r.config.RedactHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			header.Set(name, redactedValue)
+		}
+	}
+	return header
+}
+
+// redactURL returns u with the redacted query values replaced.
+func (r *Recorder) redactURL(u *url.URL) string {
+	if len(r.config.RedactQuery) == 0 || u.RawQuery == "" {
+		return u.String()
+	}
+	query := u.Query()
+	for _, name := range r.config.RedactQuery {
+		if query.Has(name) {
+			query.Set(name, redactedValue)
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// isJSON reports whether the cassette file is JSON.
+func (r *Recorder) isJSON() bool {
+	return strings.EqualFold(filepath.Ext(r.config.Path), ".json")
+}
+
+// load reads the cassette file.
+func (r *Recorder) load() error {
+	data, err := os.ReadFile(r.config.Path)
+	if err != nil {
+		return merror.Wrapf(err, "failed to read cassette %s", r.config.Path)
+	}
+	if r.isJSON() {
+		err = json.Unmarshal(data, r.cassette)
+	} else {
+		err = yaml.Unmarshal(data, r.cassette)
+	}
+	if err != nil {
+		return merror.Wrapf(err, "failed to decode cassette %s", r.config.Path)
+	}
+	return nil
+}
+
+// save writes the cassette file.
+func (r *Recorder) save() error {
+	var (
+		data []byte
+		err  error
+	)
+	if r.isJSON() {
+		data, err = json.MarshalIndent(r.cassette, "", "  ")
+	} else {
+		data, err = yaml.Marshal(r.cassette)
+	}
+	if err != nil {
+		return merror.Wrapf(err, "failed to encode cassette %s", r.config.Path)
+	}
+	if err = os.MkdirAll(filepath.Dir(r.config.Path), 0o755); err != nil {
+		return merror.Wrapf(err, "failed to create directory of cassette %s", r.config.Path)
+	}
+	if err = os.WriteFile(r.config.Path, data, 0o644); err != nil {
+		return merror.Wrapf(err, "failed to write cassette %s", r.config.Path)
+	}
+	return nil
+}
@@ -21,6 +21,17 @@ type Request struct {
 	retryConfig    RetryConfig                      // Retry configuration.
 	result         any                              // Result object for successful response.
 	errorResult    any                              // Error result object for error response.
+	triedEndpoints *endpointSet                     // Endpoints tried by the attempts of this request.
+	cacheBypass    bool                             // Whether to skip cached responses.
+	files          []*multipartFile                 // Files of a multipart/form-data body.
+	boundary       string                           // Boundary of the multipart/form-data body.
+	stream         bool                             // Whether the response body is consumed as a stream.
+	progress       DownloadProgress                 // Progress callback of downloads.
+	hedger         *Hedger                          // Hedger of this request, overriding the client one.
+	hedgerSet      bool                             // Whether hedger was set on this request.
+	errDecoder     ErrorDecoder                     // Decoder of error responses, overriding the client one.
+	envelope       *bool                            // Whether to unwrap the response envelope, overriding the client setting.
+	attempts       []Attempt                        // Records of the attempts of the last send.
 }
 
 // GetResponse returns the response object of this request.
@@ -0,0 +1,286 @@
+package mclient
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+)
+
+// DownloadProgress reports the progress of a download: the bytes written so
+// far, including resumed ones, and the total size, or -1 if it is unknown.
+type DownloadProgress func(written, total int64)
+
+// SetDownloadProgress sets the progress callback of Download and DownloadTo.
+func (r *Request) SetDownloadProgress(progress DownloadProgress) *Request {
+	r.progress = progress
+	return r
+}
+
+// Download streams the body of a GET request to the file at path without
+// buffering it in memory. The body is written to path+".part" and renamed to
+// path once complete. An existing ".part" file is resumed with a Range request,
+// and a transfer interrupted mid-body is resumed the same way while retries
+// are left, see SetRetry. Resumes are conditional on the ETag or Last-Modified
+// of the first response, kept in path+".part.validator", so a changed file is
+// downloaded again from the start. Note the client timeout bounds the whole transfer.
+func (r *Request) Download(url, path string) (*Response, error) {
+	partPath := path + ".part"
+	validatorPath := partPath + ".validator"
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, merror.Wrapf(err, "failed to open download file %s", partPath)
+	}
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = file.Close()
+		return nil, merror.Wrapf(err, "failed to seek download file %s", partPath)
+	}
+	validator, err := os.ReadFile(validatorPath)
+	if err != nil && !os.IsNotExist(err) {
+		_ = file.Close()
+		return nil, merror.Wrapf(err, "failed to read download validator %s", validatorPath)
+	}
+
+	resp, err := r.download(url, &downloadTarget{
+		w:         file,
+		offset:    offset,
+		validator: string(validator),
+		reset: func() error {
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+			_, err := file.Seek(0, io.SeekStart)
+			return err
+		},
+		keep: func(validator string) error {
+			if validator == "" {
+				if err := os.Remove(validatorPath); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				return nil
+			}
+			return os.WriteFile(validatorPath, []byte(validator), 0o644)
+		},
+	})
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = merror.Wrapf(closeErr, "failed to close download file %s", partPath)
+	}
+	if err != nil {
+		return resp, err
+	}
+	if err = os.Rename(partPath, path); err != nil {
+		return resp, merror.Wrapf(err, "failed to move download file to %s", path)
+	}
+	_ = os.Remove(validatorPath)
+	return resp, nil
+}
+
+// DownloadTo streams the body of a GET request to w without buffering it in
+// memory. A transfer interrupted mid-body is resumed with a Range request
+// while retries are left, see SetRetry, if the response has a validator.
+func (r *Request) DownloadTo(url string, w io.Writer) (*Response, error) {
+	return r.download(url, &downloadTarget{w: w})
+}
+
+// downloadTarget is where a download is written.
+type downloadTarget struct {
+	w io.Writer
+	// offset is the number of bytes already written.
+	offset int64
+	// validator is the ETag or Last-Modified of the body being written.
+	validator string
+	// reset discards what was written, it is nil if w cannot be reset.
+	reset func() error
+	// keep records a new validator, it is nil if the validator is not persisted.
+	keep func(validator string) error
+}
+
+// download writes the body to target from its offset on. Resumes send the
+// validator as If-Range, so the server sends the whole body if it changed.
+// The returned response has its body closed.
+func (r *Request) download(url string, target *downloadTarget) (*Response, error) {
+	r.stream = true
+	ctx := r.Method(http.MethodGet).Context()
+	offset := target.offset
+	for resumes := 0; ; {
+		if offset > 0 && target.validator == "" {
+			// Without a validator a changed body cannot be detected.
+			if err := r.resetDownload(target.reset); err != nil {
+				return nil, err
+			}
+			offset = 0
+		}
+		if offset > 0 {
+			r.SetHeader("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+			r.SetHeader("If-Range", target.validator)
+		} else if r.Request != nil {
+			r.Request.Header.Del("Range")
+			r.Request.Header.Del("If-Range")
+		}
+		resp, err := r.Get(url)
+		if err != nil {
+			return nil, err
+		}
+
+		total := int64(-1)
+		switch {
+		case resp.StatusCode == http.StatusPartialContent && offset > 0:
+			start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+			if !ok || start != offset {
+				resp.Close()
+				return resp, merror.NewCodef(mcode.CodeOperationFailed,
+					"unexpected Content-Range %q resuming download at %d", resp.Header.Get("Content-Range"), offset)
+			}
+			total = size
+
+		case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+			resp.Close()
+			if _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && size == offset {
+				// The download was already complete.
+				r.reportProgress(offset, size)
+				return resp, nil
+			}
+			if err = r.resetDownload(target.reset); err != nil {
+				return resp, err
+			}
+			offset = 0
+			continue
+
+		case resp.IsSuccess():
+			if offset > 0 {
+				// The body changed or the server ignored the Range header,
+				// so the whole body is sent.
+				if err = r.resetDownload(target.reset); err != nil {
+					resp.Close()
+					return resp, err
+				}
+				offset = 0
+			}
+			target.validator = downloadValidator(resp.Header)
+			if target.keep != nil {
+				if err = target.keep(target.validator); err != nil {
+					resp.Close()
+					return resp, merror.Wrap(err, "failed to keep download validator")
+				}
+			}
+			total = resp.ContentLength
+
+		default:
+			resp.Close()
+			return resp, merror.NewCodef(mcode.CodeOperationFailed, "download of %s failed with status %s", url, resp.Status)
+		}
+
+		written, readErr, writeErr := r.copyDownload(target.w, resp.Body, offset, total)
+		resp.Close()
+		offset += written
+		if writeErr != nil {
+			return resp, merror.Wrap(writeErr, "failed to write download")
+		}
+		if readErr == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil || resumes >= r.retryCount || (target.validator == "" && target.reset == nil) {
+			return resp, merror.Wrapf(readErr, "download of %s interrupted after %d bytes", url, offset)
+		}
+		resumes++
+		if delay := r.calculateRetryDelay(resumes); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return resp, ctx.Err()
+			}
+		}
+	}
+}
+
+// downloadValidator returns the strong ETag of header, or its Last-Modified
+// if there is none. Weak ETags cannot be used in If-Range.
+func downloadValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+func (r *Request) resetDownload(reset func() error) error {
+	if reset == nil {
+		return merror.NewCode(mcode.CodeOperationFailed, "server cannot resume the download and the writer cannot be reset")
+	}
+	if err := reset(); err != nil {
+		return merror.Wrap(err, "failed to reset download")
+	}
+	return nil
+}
+
+// copyDownload copies body to w, reporting progress, and separates read errors,
+// which can be resumed, from write errors, which cannot.
+func (r *Request) copyDownload(w io.Writer, body io.Reader, offset, total int64) (written int64, readErr, writeErr error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			m, err := w.Write(buf[:n])
+			written += int64(m)
+			if err == nil && m < n {
+				err = io.ErrShortWrite
+			}
+			if err != nil {
+				return written, nil, err
+			}
+			r.reportProgress(offset+written, total)
+		}
+		if err == io.EOF {
+			if total >= 0 && offset+written < total {
+				return written, io.ErrUnexpectedEOF, nil
+			}
+			return written, nil, nil
+		}
+		if err != nil {
+			return written, err, nil
+		}
+	}
+}
+
+func (r *Request) reportProgress(written, total int64) {
+	if r.progress != nil {
+		r.progress(written, total)
+	}
+}
+
+// parseContentRange parses "bytes start-end/size" and "bytes */size". The
+// size is -1 if it is unknown.
+func parseContentRange(value string) (start, size int64, ok bool) {
+	value, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rng, sizeStr, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, false
+	}
+	size = -1
+	if sizeStr != "*" {
+		var err error
+		if size, err = strconv.ParseInt(sizeStr, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if rng == "*" {
+		return 0, size, true
+	}
+	startStr, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
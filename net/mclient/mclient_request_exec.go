@@ -25,38 +25,53 @@ func (r *Request) Send(url string) (*Response, error) {
 // It orchestrates calls to attemptRequest and handles the delay between retries.
 func (r *Request) doRequest(ctx context.Context, method string, urlPath string) (*Response, error) {
 	var (
-		err      error
-		resp     *Response
-		attempts = 0
+		err  error
+		resp *Response
 	)
 
+	// Each Send starts with no endpoint tried.
+	r.triedEndpoints = &endpointSet{}
+	r.attempts = nil
+	if budget := r.client.retryBudget; budget != nil {
+		budget.deposit()
+	}
+
 	// Start with at least one attempt (0 retries)
 	maxAttempts := r.retryCount + 1
 	if maxAttempts <= 0 {
 		maxAttempts = 1
 	}
 
-	for attempts < maxAttempts {
-		attempts++
-
+	for attempt := 1; ; attempt++ {
 		// Create a new request for each attempt
-		resp, err = r.attemptRequest(ctx, method, urlPath)
+		attemptCtx, cancel := r.attemptContext(ctx, attempt, maxAttempts)
+		start := time.Now()
+		resp, err = r.hedgedAttempt(attemptCtx, method, urlPath)
 		if err == nil && (resp == nil || resp.Response == nil) {
 			err = merror.New("mclient: middleware returned a nil response without an error")
 		}
 
 		// If an error occurred (like a timeout from a panic), resp might be nil.
 		// We must handle the error case first before accessing resp.
-		if err != nil {
-			// If we shouldn't retry based on the error, or we've exhausted attempts, break.
-			if !r.shouldRetry(nil, err) || attempts >= maxAttempts {
-				break
-			}
-		} else {
-			// If there's no error, check the response to see if we should retry.
-			if !r.shouldRetry(resp.Response, nil) || attempts >= maxAttempts {
-				break
+		record := Attempt{Number: attempt, Err: err, Duration: time.Since(start)}
+		var httpResp *http.Response
+		if err == nil {
+			httpResp = resp.Response
+			record.StatusCode = resp.StatusCode
+		}
+		record.Outcome, record.Delay = r.retryDecision(ctx, attempt, maxAttempts, httpResp, err)
+		r.attempts = append(r.attempts, record)
+
+		if record.Outcome != AttemptRetried {
+			if cancel != nil {
+				// The attempt context lives until the response body is closed.
+				if err == nil && resp.Body != nil {
+					resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+				} else {
+					cancel()
+				}
 			}
+			break
 		}
 
 		// Close the response before retry if it exists
@@ -64,17 +79,19 @@ func (r *Request) doRequest(ctx context.Context, method string, urlPath string)
 			resp.Close()
 			resp = nil
 		}
+		if cancel != nil {
+			cancel()
+		}
 
 		// Log retry attempt
 		if r.Request != nil && r.Request.Context() != nil {
 			intlog.Printf(r.Request.Context(), "Retrying request (attempt %d/%d) after error: %v",
-				attempts, maxAttempts, err)
+				attempt, maxAttempts, err)
 		}
 
 		// Wait before retry if interval is set
-		delay := r.calculateRetryDelay(attempts)
-		if delay > 0 {
-			timer := time.NewTimer(delay)
+		if record.Delay > 0 {
+			timer := time.NewTimer(record.Delay)
 			select {
 			case <-timer.C:
 				// Continue after waiting
@@ -97,6 +114,12 @@ func (r *Request) doRequest(ctx context.Context, method string, urlPath string)
 	if resp == nil || resp.Response == nil {
 		return nil, merror.New("mclient: middleware returned a nil response without an error")
 	}
+	resp.attempts = r.attempts
+
+	// Streamed responses are left unread for the caller.
+	if r.stream {
+		return resp, nil
+	}
 
 	// Parse response if needed
 	if err := resp.parseResponse(); err != nil {
@@ -109,25 +132,32 @@ func (r *Request) doRequest(ctx context.Context, method string, urlPath string)
 
 // attemptRequest makes a single attempt to execute the request.
 // It builds the http.Request, chains and executes middlewares, and returns the response.
-func (r *Request) attemptRequest(ctx context.Context, method string, urlPath string) (*Response, error) {
-	var (
-		req *http.Request
-		err error
-	)
+func (r *Request) attemptRequest(ctx context.Context, method string, urlPath string) (response *Response, err error) {
+	var req *http.Request
 
 	// Prepare the request URL
 	fullURL := urlPath
-	if r.client.config.BaseURL != "" && !strings.HasPrefix(urlPath, "http://") && !strings.HasPrefix(urlPath, "https://") {
+	if !strings.HasPrefix(urlPath, "http://") && !strings.HasPrefix(urlPath, "https://") {
 		baseURL := r.client.config.BaseURL
-
-		// Ensure there's a single slash between baseURL and urlPath
-		if !strings.HasSuffix(baseURL, "/") && !strings.HasPrefix(urlPath, "/") {
-			baseURL = baseURL + "/"
-		} else if strings.HasSuffix(baseURL, "/") && strings.HasPrefix(urlPath, "/") {
-			urlPath = urlPath[1:]
+		if balancer := r.client.balancer; balancer != nil {
+			if r.triedEndpoints == nil {
+				r.triedEndpoints = &endpointSet{}
+			}
+			endpoint, pickErr := balancer.pick(ctx, r.triedEndpoints.snapshot())
+			if pickErr != nil {
+				return nil, pickErr
+			}
+			r.triedEndpoints.add(endpoint.URL)
+			defer func() {
+				if exception := recover(); exception != nil {
+					balancer.done(ctx, endpoint, true)
+					panic(exception)
+				}
+				balancer.done(ctx, endpoint, balancer.config.IsFailure(response, err))
+			}()
+			baseURL = endpoint.URL
 		}
-
-		fullURL = baseURL + urlPath
+		fullURL = joinURL(baseURL, urlPath)
 	}
 
 	// Process query parameters
@@ -141,7 +171,18 @@ func (r *Request) attemptRequest(ctx context.Context, method string, urlPath str
 
 	var body io.Reader
 	// It's essential to create a new body reader for every attempt.
-	if len(r.formParams) > 0 {
+	if len(r.files) > 0 {
+		// Multipart bodies are streamed, files are reopened for every attempt.
+		r.ContentType(r.multipartContentType())
+		multipartBody, bodyErr := r.multipartBody()
+		if bodyErr != nil {
+			return nil, bodyErr
+		}
+		// Stop the producer if the body was not fully sent, e.g. when a middleware
+		// answered without calling the server.
+		defer multipartBody.Close()
+		body = multipartBody
+	} else if len(r.formParams) > 0 {
 		// Form data is safe to be re-encoded every time.
 		body = strings.NewReader(r.formParams.Encode())
 		if r.Request == nil {
@@ -181,13 +222,12 @@ func (r *Request) attemptRequest(ctx context.Context, method string, urlPath str
 	// CRITICAL: Update the main request object so that middlewares can see
 	// the fully formed request (with URL, context, etc.).
 	r.Request = req
-	if originalGetBody != nil {
+	if len(r.files) > 0 {
+		r.Request.GetBody = r.multipartBody
+	} else if originalGetBody != nil {
 		r.Request.GetBody = originalGetBody
 	}
 
-	// Create a Response placeholder that will be filled by middleware
-	var response *Response
-
 	// Prepare the middleware chain
 	middlewares := make([]MiddlewareFunc, 0, len(r.client.middlewares)+len(r.middlewares))
 	middlewares = append(middlewares, r.client.middlewares...)
@@ -228,6 +268,19 @@ func (r *Request) attemptRequest(ctx context.Context, method string, urlPath str
 	return response, nil
 }
 
+// joinURL joins baseURL and urlPath with a single slash between them.
+func joinURL(baseURL, urlPath string) string {
+	if baseURL == "" {
+		return urlPath
+	}
+	if !strings.HasSuffix(baseURL, "/") && !strings.HasPrefix(urlPath, "/") {
+		baseURL = baseURL + "/"
+	} else if strings.HasSuffix(baseURL, "/") && strings.HasPrefix(urlPath, "/") {
+		urlPath = urlPath[1:]
+	}
+	return baseURL + urlPath
+}
+
 // Do executes the request.
 //
 // Deprecated: This method is deprecated and will be removed in a future version.
@@ -0,0 +1,344 @@
+package mclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graingo/maltose/net/mtrace"
+	"github.com/graingo/maltose/os/mmetric"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HedgeConfig is the configuration of hedged requests.
+type HedgeConfig struct {
+	// Delay is how long an attempt runs before a hedge is sent. When zero, the
+	// delay is the Percentile of the latencies observed by the hedger.
+	Delay time.Duration
+	// Percentile of the observed latencies used as delay, between 0 and 1. Default is 0.95.
+	Percentile float64
+	// MinDelay is the lower bound of the percentile-based delay. Default is 10ms.
+	MinDelay time.Duration
+	// MaxDelay is the upper bound of the percentile-based delay, also used until
+	// MinSamples latencies are observed. Default is 1s.
+	MaxDelay time.Duration
+	// MaxHedges is the number of hedges sent in addition to the original attempt. Default is 1.
+	MaxHedges int
+	// Methods are the methods hedged. Default is the idempotent methods GET, HEAD,
+	// OPTIONS, TRACE, PUT and DELETE.
+	Methods []string
+	// Window is the number of latest latencies the percentile is computed over. Default is 1000.
+	Window int
+	// MinSamples is the number of latencies observed before the percentile is used. Default is 20.
+	MinSamples int
+}
+
+// DefaultHedgeConfig returns a default hedge configuration.
+func DefaultHedgeConfig() HedgeConfig {
+	return HedgeConfig{
+		Percentile: 0.95,
+		MinDelay:   10 * time.Millisecond,
+		MaxDelay:   time.Second,
+		MaxHedges:  1,
+		Methods: []string{
+			http.MethodGet, http.MethodHead, http.MethodOptions,
+			http.MethodTrace, http.MethodPut, http.MethodDelete,
+		},
+		Window:     1000,
+		MinSamples: 20,
+	}
+}
+
+func normalizeHedgeConfig(config HedgeConfig) HedgeConfig {
+	defaults := DefaultHedgeConfig()
+	if config.Percentile <= 0 || config.Percentile > 1 {
+		config.Percentile = defaults.Percentile
+	}
+	if config.MinDelay <= 0 {
+		config.MinDelay = defaults.MinDelay
+	}
+	if config.MaxDelay < config.MinDelay {
+		config.MaxDelay = max(defaults.MaxDelay, config.MinDelay)
+	}
+	if config.MaxHedges <= 0 {
+		config.MaxHedges = defaults.MaxHedges
+	}
+	if len(config.Methods) == 0 {
+		config.Methods = defaults.Methods
+	} else {
+		methods := make([]string, len(config.Methods))
+		for i, method := range config.Methods {
+			methods[i] = strings.ToUpper(method)
+		}
+		config.Methods = methods
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MinSamples <= 0 {
+		config.MinSamples = defaults.MinSamples
+	}
+	return config
+}
+
+// Hedger sends backup attempts of slow requests and keeps the first response.
+// It learns the latencies of the requests it hedges, so share one hedger
+// between the requests of a service.
+type Hedger struct {
+	config HedgeConfig
+
+	mu        sync.Mutex
+	latencies []time.Duration // ring of the latest latencies
+	next      int
+}
+
+// NewHedger creates a hedger. Use it with Client.SetHedger or Request.SetHedger.
+func NewHedger(config HedgeConfig) *Hedger {
+	config = normalizeHedgeConfig(config)
+	return &Hedger{
+		config:    config,
+		latencies: make([]time.Duration, 0, config.Window),
+	}
+}
+
+// SetHedger hedges the idempotent requests of the client with hedger.
+// Clones of the client share the hedger.
+func (c *Client) SetHedger(hedger *Hedger) *Client {
+	c.hedger = hedger
+	return c
+}
+
+// WithHedging hedges the idempotent requests of the client with a new hedger.
+func (c *Client) WithHedging(config HedgeConfig) *Client {
+	return c.SetHedger(NewHedger(config))
+}
+
+// SetHedger hedges this request with hedger instead of the client one.
+// A nil hedger disables hedging for this request.
+func (r *Request) SetHedger(hedger *Hedger) *Request {
+	r.hedger = hedger
+	r.hedgerSet = true
+	return r
+}
+
+// Delay returns the current delay before a hedge is sent.
+func (h *Hedger) Delay() time.Duration {
+	if h.config.Delay > 0 {
+		return h.config.Delay
+	}
+	h.mu.Lock()
+	if len(h.latencies) < h.config.MinSamples {
+		h.mu.Unlock()
+		return h.config.MaxDelay
+	}
+	latencies := slices.Clone(h.latencies)
+	h.mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	delay := latencies[int(h.config.Percentile*float64(len(latencies)-1))]
+	return min(max(delay, h.config.MinDelay), h.config.MaxDelay)
+}
+
+// observe records the latency of a successful attempt.
+func (h *Hedger) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < h.config.Window {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % len(h.latencies)
+}
+
+// activeHedger returns the hedger of the request, or nil when the request
+// cannot be hedged.
+func (r *Request) activeHedger(method string) *Hedger {
+	hedger := r.client.hedger
+	if r.hedgerSet {
+		hedger = r.hedger
+	}
+	if hedger == nil || !slices.Contains(hedger.config.Methods, method) {
+		return nil
+	}
+	// Concurrent attempts need a body that can be produced again.
+	for _, f := range r.files {
+		if f.path == "" {
+			return nil
+		}
+	}
+	if len(r.files) == 0 && len(r.formParams) == 0 &&
+		r.Request != nil && r.Request.Body != nil && r.Request.Body != http.NoBody && r.Request.GetBody == nil {
+		return nil
+	}
+	return hedger
+}
+
+// hedgeResult is the outcome of an attempt of a hedged request.
+type hedgeResult struct {
+	index   int
+	attempt *Request
+	resp    *Response
+	err     error
+	latency time.Duration
+	span    trace.Span
+	cancel  context.CancelFunc
+}
+
+// release ends the attempt of a result that did not win.
+func (res *hedgeResult) release() {
+	res.span.SetAttributes(attribute.Bool(mtrace.AttributeHTTPHedgeWon, false))
+	res.span.End()
+	res.cancel()
+	if res.resp != nil {
+		_ = res.resp.Close()
+	}
+}
+
+// hedgedAttempt makes an attempt of the request, sending up to MaxHedges more
+// attempts while no response arrived. The first response that would not be
+// retried wins and the other attempts are cancelled.
+func (r *Request) hedgedAttempt(ctx context.Context, method string, urlPath string) (*Response, error) {
+	hedger := r.activeHedger(method)
+	if hedger == nil {
+		return r.attemptRequest(ctx, method, urlPath)
+	}
+	if len(r.files) > 0 {
+		// Attempts must share the boundary of the Content-Type header.
+		r.ContentType(r.multipartContentType())
+	}
+
+	var (
+		tracer = otel.GetTracerProvider().Tracer(
+			instrumentName,
+			trace.WithInstrumentationVersion(version),
+		)
+		attributes = []attribute.KeyValue{
+			attribute.String(mmetric.AttrHTTPRequestMethod, method),
+		}
+		total   = hedger.config.MaxHedges + 1
+		results = make(chan *hedgeResult, total)
+		cancels = make([]context.CancelFunc, 0, total)
+		delay   = hedger.Delay()
+		timer   = time.NewTimer(delay)
+		pending = 0
+		failed  *hedgeResult
+	)
+	defer timer.Stop()
+
+	start := func() {
+		index := len(cancels)
+		attemptCtx, cancel := context.WithCancel(ctx)
+		attemptCtx, span := tracer.Start(attemptCtx, "HTTP "+method+" attempt",
+			trace.WithSpanKind(trace.SpanKindInternal),
+			trace.WithAttributes(attribute.Int(mtrace.AttributeHTTPHedgeAttempt, index)),
+		)
+		cancels = append(cancels, cancel)
+		pending++
+		if index > 0 {
+			metricManager.HTTPClientHedgeTotal.Inc(ctx, mmetric.WithAttributes(attributes...))
+		}
+
+		// Each attempt runs on its own copy of the request.
+		attempt := *r
+		if r.Request != nil {
+			attempt.Request = r.Request.Clone(attemptCtx)
+		}
+		go func() {
+			result := &hedgeResult{index: index, attempt: &attempt, span: span, cancel: cancel}
+			begin := time.Now()
+			result.resp, result.err = attempt.attemptRequest(attemptCtx, method, urlPath)
+			result.latency = time.Since(begin)
+			results <- result
+		}()
+	}
+
+	start()
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if len(cancels) < total {
+				start()
+				timer.Reset(delay)
+			}
+
+		case result := <-results:
+			pending--
+			var httpResp *http.Response
+			if result.resp != nil {
+				httpResp = result.resp.Response
+			}
+			if httpResp == nil || r.shouldRetry(httpResp, result.err) {
+				// Wait for the other attempts, keeping the latest failure.
+				if failed != nil {
+					failed.release()
+				}
+				failed = result
+				continue
+			}
+			if failed != nil {
+				failed.release()
+			}
+
+			// Cancel the losing attempts and release them once they return.
+			for i, cancel := range cancels {
+				if i != result.index {
+					cancel()
+				}
+			}
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					(<-results).release()
+				}
+			}(pending)
+
+			hedger.observe(result.latency)
+			if result.index > 0 {
+				metricManager.HTTPClientHedgeWonTotal.Inc(ctx, mmetric.WithAttributes(attributes...))
+			}
+			return r.hedgeWon(result)
+		}
+	}
+	// Every attempt failed, the latest failure goes through the retry policy.
+	return r.hedgeWon(failed)
+}
+
+// hedgeWon adopts the attempt of result as the attempt of the request. The
+// context of the attempt is cancelled once its response body is closed.
+func (r *Request) hedgeWon(result *hedgeResult) (*Response, error) {
+	result.span.SetAttributes(attribute.Bool(mtrace.AttributeHTTPHedgeWon, true))
+	result.span.End()
+	r.Request = result.attempt.Request
+	r.SetResponse(result.attempt.response)
+	if result.resp == nil || result.resp.Response == nil || result.resp.Body == nil {
+		result.cancel()
+		return result.resp, result.err
+	}
+	result.resp.Body = &cancelReadCloser{ReadCloser: result.resp.Body, cancel: result.cancel}
+	return result.resp, result.err
+}
+
+// cancelReadCloser cancels the context of a request when its body is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and cancels the context.
+func (c *cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// Unwrap returns the wrapped body, e.g. the connection of an upgraded response.
+func (c *cancelReadCloser) Unwrap() io.ReadCloser {
+	return c.ReadCloser
+}
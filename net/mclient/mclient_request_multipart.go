@@ -0,0 +1,121 @@
+package mclient
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/graingo/maltose/errors/merror"
+)
+
+// multipartFile is a file part of a multipart/form-data body.
+type multipartFile struct {
+	field    string
+	filename string
+	path     string    // file reopened for every attempt
+	reader   io.Reader // stream consumed by the first attempt
+	sent     bool      // whether reader was consumed
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// SetFile adds the file at path to a multipart/form-data body under field.
+// The file is streamed rather than buffered, and reopened for every attempt,
+// so the request can be retried. Form parameters are sent as parts of the same body.
+func (r *Request) SetFile(field, path string) *Request {
+	r.files = append(r.files, &multipartFile{field: field, filename: filepath.Base(path), path: path})
+	return r
+}
+
+// SetFileReader adds a part named filename read from reader to a multipart/form-data
+// body under field. The reader is streamed rather than buffered, so once an
+// attempt has sent it the request cannot be retried.
+func (r *Request) SetFileReader(field, filename string, reader io.Reader) *Request {
+	r.files = append(r.files, &multipartFile{field: field, filename: filename, reader: reader})
+	return r
+}
+
+// multipartContentType returns the Content-Type of the multipart body. The
+// boundary stays the same for all attempts.
+func (r *Request) multipartContentType() string {
+	if r.boundary == "" {
+		r.boundary = multipart.NewWriter(io.Discard).Boundary()
+	}
+	return "multipart/form-data; boundary=" + r.boundary
+}
+
+// multipartBody opens the files and returns a reader producing the multipart
+// body as it is read. Closing the reader stops the producer.
+func (r *Request) multipartBody() (io.ReadCloser, error) {
+	readers := make([]io.Reader, len(r.files))
+	closeFiles := func() {
+		for _, reader := range readers {
+			if file, ok := reader.(*os.File); ok {
+				_ = file.Close()
+			}
+		}
+	}
+	for i, f := range r.files {
+		if f.path == "" {
+			if f.sent {
+				closeFiles()
+				return nil, merror.Newf("mclient: the stream of multipart field %s was already sent and cannot be replayed", f.field)
+			}
+			f.sent = true
+			readers[i] = f.reader
+			continue
+		}
+		file, err := os.Open(f.path)
+		if err != nil {
+			closeFiles()
+			return nil, merror.Wrapf(err, "failed to open multipart file %s", f.path)
+		}
+		readers[i] = file
+	}
+
+	var (
+		pr, pw   = io.Pipe()
+		boundary = r.boundary
+		form     = r.formParams
+		files    = r.files
+	)
+	go func() {
+		defer closeFiles()
+		writer := multipart.NewWriter(pw)
+		err := writer.SetBoundary(boundary)
+		for key, values := range form {
+			for _, value := range values {
+				if err == nil {
+					err = writer.WriteField(key, value)
+				}
+			}
+		}
+		for i, f := range files {
+			if err != nil {
+				break
+			}
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+				quoteEscaper.Replace(f.field), quoteEscaper.Replace(f.filename)))
+			contentType := mime.TypeByExtension(filepath.Ext(f.filename))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			header.Set("Content-Type", contentType)
+			var part io.Writer
+			if part, err = writer.CreatePart(header); err == nil {
+				_, err = io.Copy(part, readers[i])
+			}
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
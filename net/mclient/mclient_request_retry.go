@@ -1,8 +1,12 @@
 package mclient
 
 import (
+	"context"
+	"errors"
 	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,18 +18,18 @@ import (
 type RetryConfig struct {
 	// Count is the maximum number of retries.
 	// For example, if Count is 3, the request will be tried up to 4 times (initial attempt + 3 retries).
-	Count int
+	Count int `mconv:"count"`
 
 	// BaseInterval is the base interval between retries.
 	// This is the starting point for calculating the delay between retries.
 	// For example, if BaseInterval is 1 second, the first retry will wait at least 1 second.
-	BaseInterval time.Duration
+	BaseInterval time.Duration `mconv:"base_interval"`
 
 	// MaxInterval is the maximum interval between retries.
 	// This prevents the delay from growing too large due to exponential backoff.
 	// For example, if MaxInterval is 30 seconds, even if the calculated delay is 60 seconds,
 	// the actual delay will be capped at 30 seconds.
-	MaxInterval time.Duration
+	MaxInterval time.Duration `mconv:"max_interval"`
 
 	// BackoffFactor is the factor for exponential backoff.
 	// Each retry's delay is calculated by multiplying the previous delay by this factor.
@@ -34,7 +38,7 @@ type RetryConfig struct {
 	// - Second retry: 2 seconds
 	// - Third retry: 4 seconds
 	// - And so on...
-	BackoffFactor float64
+	BackoffFactor float64 `mconv:"backoff_factor"`
 
 	// JitterFactor is the factor for random jitter.
 	// This adds randomness to the delay to prevent multiple clients from retrying simultaneously.
@@ -42,7 +46,24 @@ type RetryConfig struct {
 	// For example, if the calculated delay is 1 second and JitterFactor is 0.1:
 	// - The actual delay will be between 0.9 and 1.1 seconds
 	// A value of 0 means no jitter will be added.
-	JitterFactor float64
+	JitterFactor float64 `mconv:"jitter_factor"`
+
+	// IgnoreRetryAfter computes the delay of retries of 429 and 503 responses
+	// with the backoff instead of waiting as long as their Retry-After header asks.
+	IgnoreRetryAfter bool `mconv:"ignore_retry_after"`
+
+	// MaxRetryAfter is the longest Retry-After honored. Responses asking for a
+	// longer wait are not retried. Default is MaxInterval.
+	MaxRetryAfter time.Duration `mconv:"max_retry_after"`
+
+	// AttemptTimeout is the timeout of each attempt. Zero leaves attempts bound
+	// by the request context only.
+	AttemptTimeout time.Duration `mconv:"attempt_timeout"`
+
+	// SplitDeadline divides the time left until the deadline of the request
+	// context evenly between the remaining attempts, so a slow attempt cannot
+	// use up the time of the retries.
+	SplitDeadline bool `mconv:"split_deadline"`
 }
 
 // DefaultRetryConfig returns the default retry configuration.
@@ -79,6 +100,12 @@ func (r *Request) SetRetry(config RetryConfig) *Request {
 	if config.JitterFactor < 0 {
 		config.JitterFactor = 0
 	}
+	if config.MaxRetryAfter <= 0 {
+		config.MaxRetryAfter = config.MaxInterval
+	}
+	if config.AttemptTimeout < 0 {
+		config.AttemptTimeout = 0
+	}
 	r.retryCount = config.Count
 	r.retryInterval = config.BaseInterval
 	r.retryConfig = config
@@ -110,8 +137,9 @@ func (r *Request) shouldRetry(resp *http.Response, err error) bool {
 
 	// Default retry condition
 	if err != nil {
-		// Retry on network/connection errors
-		return true
+		// Retry on network/connection errors, but not against an open circuit
+		// or a full bulkhead, which would only add load.
+		return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrBulkheadFull)
 	}
 
 	if resp != nil {
@@ -150,3 +178,117 @@ func (r *Request) calculateRetryDelay(attempt int) time.Duration {
 
 	return delay
 }
+
+// AttemptOutcome is what followed an attempt of a request.
+type AttemptOutcome string
+
+const (
+	// AttemptDone is an attempt whose outcome is returned as it is not retryable.
+	AttemptDone AttemptOutcome = "done"
+	// AttemptRetried is an attempt followed by a retry.
+	AttemptRetried AttemptOutcome = "retried"
+	// AttemptExhausted is a retryable attempt after which no retry was left.
+	AttemptExhausted AttemptOutcome = "exhausted"
+	// AttemptBudgetExhausted is a retryable attempt not retried because the retry
+	// budget of the client was exhausted.
+	AttemptBudgetExhausted AttemptOutcome = "budget_exhausted"
+	// AttemptRetryAfterTooLong is a retryable attempt not retried because the
+	// server asked to wait longer than MaxRetryAfter.
+	AttemptRetryAfterTooLong AttemptOutcome = "retry_after_too_long"
+	// AttemptDeadline is a retryable attempt not retried because the deadline
+	// of the request context would pass before the retry.
+	AttemptDeadline AttemptOutcome = "deadline"
+	// AttemptCanceled is an attempt not retried because the request context is done.
+	AttemptCanceled AttemptOutcome = "canceled"
+)
+
+// Attempt is the record of an attempt of a request.
+type Attempt struct {
+	// Number is the number of the attempt, starting from 1.
+	Number int
+	// StatusCode is the status of the response, zero when the attempt failed.
+	StatusCode int
+	// Err is the error of the attempt.
+	Err error
+	// Duration is how long the attempt took.
+	Duration time.Duration
+	// Delay is the wait before the next attempt.
+	Delay time.Duration
+	// Outcome is what followed the attempt.
+	Outcome AttemptOutcome
+}
+
+// Attempts returns the records of the attempts of the last send of the request.
+func (r *Request) Attempts() []Attempt {
+	return r.attempts
+}
+
+// retryDecision decides whether the attempt with the response resp or the
+// error err is retried, and the delay before the retry.
+func (r *Request) retryDecision(ctx context.Context, attempt, maxAttempts int, resp *http.Response, err error) (AttemptOutcome, time.Duration) {
+	if ctx.Err() != nil {
+		return AttemptCanceled, 0
+	}
+	if !r.shouldRetry(resp, err) {
+		return AttemptDone, 0
+	}
+	if attempt >= maxAttempts {
+		return AttemptExhausted, 0
+	}
+
+	delay := r.calculateRetryDelay(attempt)
+	if after, ok := retryAfter(resp, time.Now()); ok && !r.retryConfig.IgnoreRetryAfter {
+		maxRetryAfter := r.retryConfig.MaxRetryAfter
+		if maxRetryAfter <= 0 {
+			maxRetryAfter = DefaultRetryConfig().MaxInterval
+		}
+		if after > maxRetryAfter {
+			return AttemptRetryAfterTooLong, 0
+		}
+		delay = after
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+		return AttemptDeadline, 0
+	}
+	if budget := r.client.retryBudget; budget != nil && !budget.withdraw() {
+		metricManager.HTTPClientRetryBudgetExhaustedTotal.Inc(ctx)
+		return AttemptBudgetExhausted, 0
+	}
+	return AttemptRetried, delay
+}
+
+// attemptContext returns the context of an attempt, bound by AttemptTimeout
+// and by its share of the request deadline. The cancel function is nil when
+// ctx is used as it is.
+func (r *Request) attemptContext(ctx context.Context, attempt, maxAttempts int) (context.Context, context.CancelFunc) {
+	timeout := r.retryConfig.AttemptTimeout
+	if deadline, ok := ctx.Deadline(); ok && r.retryConfig.SplitDeadline && attempt < maxAttempts {
+		share := time.Until(deadline) / time.Duration(maxAttempts-attempt+1)
+		if timeout <= 0 || share < timeout {
+			timeout = share
+		}
+	}
+	if timeout <= 0 {
+		return ctx, nil
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// retryAfter returns the wait asked by the Retry-After header of a 429 or 503
+// response, given in seconds or as an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
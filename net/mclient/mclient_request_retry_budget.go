@@ -0,0 +1,114 @@
+package mclient
+
+import (
+	"sync"
+	"time"
+)
+
+// RetryBudgetConfig is the configuration of a retry budget.
+type RetryBudgetConfig struct {
+	// Ratio is the number of retries earned by each request, e.g. 0.1 lets
+	// retries add at most 10% to the traffic. Default is 0.1.
+	Ratio float64 `mconv:"ratio"`
+	// MinRetriesPerSecond is the number of retries allowed per second whatever
+	// the traffic, so clients sending few requests can still retry. Default is 1,
+	// negative disables it.
+	MinRetriesPerSecond float64 `mconv:"min_retries_per_second"`
+	// Burst is the most retries the budget accumulates. Default is 10.
+	Burst int `mconv:"burst"`
+}
+
+// DefaultRetryBudgetConfig returns a default retry budget configuration.
+func DefaultRetryBudgetConfig() RetryBudgetConfig {
+	return RetryBudgetConfig{
+		Ratio:               0.1,
+		MinRetriesPerSecond: 1,
+		Burst:               10,
+	}
+}
+
+func normalizeRetryBudgetConfig(config RetryBudgetConfig) RetryBudgetConfig {
+	defaults := DefaultRetryBudgetConfig()
+	if config.Ratio <= 0 {
+		config.Ratio = defaults.Ratio
+	}
+	if config.MinRetriesPerSecond < 0 {
+		config.MinRetriesPerSecond = 0
+	} else if config.MinRetriesPerSecond == 0 {
+		config.MinRetriesPerSecond = defaults.MinRetriesPerSecond
+	}
+	if config.Burst <= 0 {
+		config.Burst = defaults.Burst
+	}
+	return config
+}
+
+// RetryBudget is a token bucket limiting the retries of a client to a share of
+// its requests. Each request deposits Ratio tokens, tokens also accrue at
+// MinRetriesPerSecond, and each retry takes one token. Retries are given up
+// while the bucket is empty, so a failing dependency does not receive a
+// multiple of the usual traffic.
+type RetryBudget struct {
+	config RetryBudgetConfig
+
+	mu       sync.Mutex
+	tokens   float64
+	lastTime time.Time
+}
+
+// NewRetryBudget creates a retry budget. Use it with Client.SetRetryBudget.
+func NewRetryBudget(config RetryBudgetConfig) *RetryBudget {
+	config = normalizeRetryBudgetConfig(config)
+	return &RetryBudget{
+		config:   config,
+		tokens:   float64(config.Burst),
+		lastTime: time.Now(),
+	}
+}
+
+// SetRetryBudget limits the retries of all requests of the client with budget.
+// Clones of the client share the budget.
+func (c *Client) SetRetryBudget(budget *RetryBudget) *Client {
+	c.retryBudget = budget
+	return c
+}
+
+// WithRetryBudget limits the retries of all requests of the client with a new budget.
+func (c *Client) WithRetryBudget(config RetryBudgetConfig) *Client {
+	return c.SetRetryBudget(NewRetryBudget(config))
+}
+
+// Tokens returns the number of retries currently allowed.
+func (b *RetryBudget) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.tokens
+}
+
+// deposit credits the budget for a request.
+func (b *RetryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens = min(b.tokens+b.config.Ratio, float64(b.config.Burst))
+}
+
+// withdraw takes a token for a retry. It returns false when the budget is exhausted.
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill adds the tokens accrued since the last refill.
+func (b *RetryBudget) refill(now time.Time) {
+	elapsed := now.Sub(b.lastTime).Seconds()
+	b.lastTime = now
+	b.tokens = min(b.tokens+elapsed*b.config.MinRetriesPerSecond, float64(b.config.Burst))
+}
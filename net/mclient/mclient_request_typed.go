@@ -0,0 +1,218 @@
+package mclient
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+)
+
+// maxErrorBodySize is the largest part of an error body quoted in an error message.
+const maxErrorBodySize = 512
+
+// ErrorDecoder converts an unsuccessful response of the typed helpers into an
+// error. body is the content of the response. Returning nil falls back to the
+// default error, which carries the status code and the body.
+type ErrorDecoder func(resp *Response, body []byte) error
+
+// envelope is the standard response of Maltose services, see mhttp.DefaultResponse.
+type envelope struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// SetErrorDecoder decodes the error responses of the typed helpers of this
+// client with decoder, e.g. JSONErrorDecoder.
+func (c *Client) SetErrorDecoder(decoder ErrorDecoder) *Client {
+	c.errDecoder = decoder
+	return c
+}
+
+// SetUnwrapEnvelope sets whether the typed helpers of this client expect the
+// responses wrapped in the standard response of Maltose services. The data of
+// the envelope is decoded as the result, and an envelope with an error code is
+// returned as an merror with the remote code and message.
+func (c *Client) SetUnwrapEnvelope(unwrap bool) *Client {
+	c.envelope = unwrap
+	return c
+}
+
+// SetErrorDecoder decodes the error response of this request with decoder
+// instead of the client one.
+func (r *Request) SetErrorDecoder(decoder ErrorDecoder) *Request {
+	r.errDecoder = decoder
+	return r
+}
+
+// SetUnwrapEnvelope sets whether the response of this request is wrapped in the
+// standard response of Maltose services, overriding the client setting.
+func (r *Request) SetUnwrapEnvelope(unwrap bool) *Request {
+	r.envelope = &unwrap
+	return r
+}
+
+// JSONErrorDecoder returns an error decoder decoding JSON error bodies into a
+// new E, e.g. JSONErrorDecoder[APIError]() when *APIError implements error.
+// The decoded error is wrapped with the code of the status, use errors.As to
+// get it back.
+func JSONErrorDecoder[E any, PE interface {
+	*E
+	error
+}]() ErrorDecoder {
+	return func(resp *Response, body []byte) error {
+		target := PE(new(E))
+		if err := json.Unmarshal(body, target); err != nil {
+			return nil
+		}
+		return merror.WrapCodef(target, statusCode(resp.StatusCode), "request failed with status %d", resp.StatusCode)
+	}
+}
+
+// GetJSON sends a GET request and decodes the JSON response into a T.
+func GetJSON[T any](r *Request, url string) (T, error) {
+	return DoJSON[T](r, http.MethodGet, url)
+}
+
+// DeleteJSON sends a DELETE request and decodes the JSON response into a T.
+func DeleteJSON[T any](r *Request, url string) (T, error) {
+	return DoJSON[T](r, http.MethodDelete, url)
+}
+
+// PostJSON sends a POST request with body encoded in JSON and decodes the JSON
+// response into a Res.
+func PostJSON[Req, Res any](r *Request, url string, body Req) (Res, error) {
+	return sendJSON[Req, Res](r, http.MethodPost, url, body)
+}
+
+// PutJSON sends a PUT request with body encoded in JSON and decodes the JSON
+// response into a Res.
+func PutJSON[Req, Res any](r *Request, url string, body Req) (Res, error) {
+	return sendJSON[Req, Res](r, http.MethodPut, url, body)
+}
+
+// PatchJSON sends a PATCH request with body encoded in JSON and decodes the JSON
+// response into a Res.
+func PatchJSON[Req, Res any](r *Request, url string, body Req) (Res, error) {
+	return sendJSON[Req, Res](r, http.MethodPatch, url, body)
+}
+
+func sendJSON[Req, Res any](r *Request, method, url string, body Req) (Res, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		var result Res
+		return result, merror.Wrapf(err, "failed to encode request body of %s %s", method, url)
+	}
+	r.SetBody(data).ContentType("application/json")
+	return DoJSON[Res](r, method, url)
+}
+
+// DoJSON sends the request with method and decodes the JSON response into a T.
+// Unsuccessful responses are returned as errors, see ErrorDecoder and
+// Client.SetUnwrapEnvelope. The response body is closed.
+func DoJSON[T any](r *Request, method, url string) (T, error) {
+	var result T
+	if r.Request == nil || r.Request.Header.Get("Accept") == "" {
+		r.SetHeader("Accept", "application/json")
+	}
+	resp, err := r.Method(method).Send(url)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return result, merror.Wrapf(err, "failed to read response of %s %s", method, url)
+	}
+	if !resp.IsSuccess() {
+		return result, r.decodeError(resp, body)
+	}
+
+	if r.unwrapsEnvelope() {
+		var env envelope
+		if err = json.Unmarshal(body, &env); err != nil {
+			return result, merror.Wrapf(err, "failed to decode response envelope of %s %s", method, url)
+		}
+		if env.Code != mcode.CodeOK.Code() {
+			return result, env.error()
+		}
+		body = env.Data
+	}
+	if len(body) == 0 || string(body) == "null" {
+		return result, nil
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return result, merror.Wrapf(err, "failed to decode response of %s %s", method, url)
+	}
+	return result, nil
+}
+
+// unwrapsEnvelope reports whether the response is wrapped in the standard response.
+func (r *Request) unwrapsEnvelope() bool {
+	if r.envelope != nil {
+		return *r.envelope
+	}
+	return r.client.envelope
+}
+
+// decodeError returns the error of an unsuccessful response.
+func (r *Request) decodeError(resp *Response, body []byte) error {
+	if r.unwrapsEnvelope() {
+		var env envelope
+		if json.Unmarshal(body, &env) == nil && env.Code != mcode.CodeOK.Code() && env.Message != "" {
+			return env.error()
+		}
+	}
+	decoder := r.client.errDecoder
+	if r.errDecoder != nil {
+		decoder = r.errDecoder
+	}
+	if decoder != nil {
+		if err := decoder(resp, body); err != nil {
+			return err
+		}
+	}
+
+	if len(body) > maxErrorBodySize {
+		body = body[:maxErrorBodySize]
+	}
+	if resp.Request != nil {
+		return merror.NewCodef(statusCode(resp.StatusCode), "%s %s failed with status %d: %s",
+			resp.Request.Method, resp.Request.URL, resp.StatusCode, body)
+	}
+	return merror.NewCodef(statusCode(resp.StatusCode), "request failed with status %d: %s", resp.StatusCode, body)
+}
+
+// error returns the remote error of the envelope, with the predefined code of
+// the same number when there is one.
+func (e envelope) error() error {
+	code, ok := mcode.Lookup(e.Code)
+	if !ok {
+		code = mcode.New(e.Code, e.Message, nil)
+	}
+	return merror.NewCode(code, e.Message)
+}
+
+// statusCode returns the error code of an HTTP status.
+func statusCode(status int) mcode.Code {
+	switch {
+	case status == http.StatusBadRequest:
+		return mcode.CodeValidationFailed
+	case status == http.StatusUnauthorized:
+		return mcode.CodeNotAuthorized
+	case status == http.StatusForbidden:
+		return mcode.CodeForbidden
+	case status == http.StatusNotFound:
+		return mcode.CodeNotFound
+	case status == http.StatusTooManyRequests:
+		return mcode.CodeRateLimitExceeded
+	case status == http.StatusServiceUnavailable:
+		return mcode.CodeServerBusy
+	case status >= 400 && status < 500:
+		return mcode.CodeInvalidRequest
+	default:
+		return mcode.CodeInternalError
+	}
+}
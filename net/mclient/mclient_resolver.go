@@ -0,0 +1,220 @@
+package mclient
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+)
+
+// Endpoint is a replica of a service.
+type Endpoint struct {
+	// URL is the base URL of the replica, e.g. "http://10.0.0.1:8080".
+	URL string
+	// Weight is the relative share of requests for the weighted policy. Default is 1.
+	Weight int
+}
+
+// EndpointResolver discovers the endpoints of a service.
+type EndpointResolver interface {
+	// Resolve returns the current endpoints.
+	Resolve(ctx context.Context) ([]Endpoint, error)
+}
+
+// ParseEndpoint parses an endpoint of the form "http://10.0.0.1:8080 weight=3",
+// where the weight is optional.
+func ParseEndpoint(s string) (Endpoint, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Endpoint{}, merror.NewCode(mcode.CodeInvalidConfiguration, "empty endpoint")
+	}
+	endpoint := Endpoint{URL: strings.TrimSuffix(fields[0], "/"), Weight: 1}
+	if u, err := url.Parse(endpoint.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return Endpoint{}, merror.NewCodef(mcode.CodeInvalidConfiguration, "invalid endpoint URL %q", fields[0])
+	}
+	for _, field := range fields[1:] {
+		value, ok := strings.CutPrefix(field, "weight=")
+		if !ok {
+			return Endpoint{}, merror.NewCodef(mcode.CodeInvalidConfiguration, "unknown endpoint option %q", field)
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight <= 0 {
+			return Endpoint{}, merror.NewCodef(mcode.CodeInvalidConfiguration, "invalid endpoint weight %q", value)
+		}
+		endpoint.Weight = weight
+	}
+	return endpoint, nil
+}
+
+// staticResolver resolves a fixed list of endpoints.
+type staticResolver struct {
+	endpoints []Endpoint
+	err       error
+}
+
+// NewStaticResolver creates a resolver of a fixed list of endpoints in the
+// format of ParseEndpoint. An invalid endpoint is reported by Resolve.
+func NewStaticResolver(endpoints ...string) EndpointResolver {
+	r := &staticResolver{}
+	for _, s := range endpoints {
+		endpoint, err := ParseEndpoint(s)
+		if err != nil {
+			r.err = err
+			break
+		}
+		r.endpoints = append(r.endpoints, endpoint)
+	}
+	return r
+}
+
+// Resolve implements EndpointResolver.
+func (r *staticResolver) Resolve(_ context.Context) ([]Endpoint, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return slices.Clone(r.endpoints), nil
+}
+
+// fileResolver reads endpoints from a file.
+type fileResolver struct {
+	path string
+}
+
+// NewFileResolver creates a resolver that reads endpoints from the file at
+// path, one per line in the format of ParseEndpoint. Blank lines and lines
+// starting with # are ignored. The file is read again on every refresh, so
+// it can be updated by a sidecar or a config management tool.
+func NewFileResolver(path string) EndpointResolver {
+	return &fileResolver{path: path}
+}
+
+// Resolve implements EndpointResolver.
+func (r *fileResolver) Resolve(_ context.Context) ([]Endpoint, error) {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return nil, merror.Wrapf(err, "failed to open endpoint file %s", r.path)
+	}
+	defer file.Close()
+
+	var endpoints []Endpoint
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		endpoint, err := ParseEndpoint(text)
+		if err != nil {
+			return nil, merror.Wrapf(err, "%s:%d", r.path, line)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, merror.Wrapf(err, "failed to read endpoint file %s", r.path)
+	}
+	return endpoints, nil
+}
+
+// DNSResolverConfig is the configuration of a DNS endpoint resolver.
+type DNSResolverConfig struct {
+	// Host is the name to look up, e.g. "orders.internal".
+	Host string
+	// Port is the port of the endpoints for A/AAAA lookups.
+	Port int
+	// Service and Proto select an SRV lookup of "_service._proto.host" when
+	// Service is set. Ports and weights are then taken from the records, and only
+	// the records with the lowest priority are used. Proto defaults to "tcp".
+	Service string
+	Proto   string
+	// Scheme is the scheme of the endpoint URLs. Default is "http".
+	Scheme string
+	// Nameserver overrides the system resolver with a DNS server address,
+	// e.g. "127.0.0.1:53".
+	Nameserver string
+	// Resolver overrides the resolver used for lookups. Default is net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+// dnsResolver resolves endpoints from A/AAAA or SRV records.
+type dnsResolver struct {
+	config   DNSResolverConfig
+	resolver *net.Resolver
+}
+
+// NewDNSResolver creates a resolver that looks up endpoints in DNS.
+func NewDNSResolver(config DNSResolverConfig) EndpointResolver {
+	if config.Scheme == "" {
+		config.Scheme = "http"
+	}
+	if config.Proto == "" {
+		config.Proto = "tcp"
+	}
+	resolver := config.Resolver
+	if resolver == nil && config.Nameserver != "" {
+		nameserver := config.Nameserver
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, nameserver)
+			},
+		}
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &dnsResolver{config: config, resolver: resolver}
+}
+
+// Resolve implements EndpointResolver.
+func (r *dnsResolver) Resolve(ctx context.Context) ([]Endpoint, error) {
+	if r.config.Service != "" {
+		return r.resolveSRV(ctx)
+	}
+	if r.config.Port <= 0 {
+		return nil, merror.NewCodef(mcode.CodeInvalidConfiguration, "port is required to resolve %s", r.config.Host)
+	}
+	addrs, err := r.resolver.LookupHost(ctx, r.config.Host)
+	if err != nil {
+		return nil, merror.Wrapf(err, "failed to look up %s", r.config.Host)
+	}
+	endpoints := make([]Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoints = append(endpoints, r.endpoint(addr, r.config.Port, 1))
+	}
+	return endpoints, nil
+}
+
+func (r *dnsResolver) resolveSRV(ctx context.Context) ([]Endpoint, error) {
+	_, records, err := r.resolver.LookupSRV(ctx, r.config.Service, r.config.Proto, r.config.Host)
+	if err != nil {
+		return nil, merror.Wrapf(err, "failed to look up SRV records of %s", r.config.Host)
+	}
+	var endpoints []Endpoint
+	for _, record := range records {
+		// Records are sorted by priority, lower ones are only used as fallback.
+		if record.Priority != records[0].Priority {
+			break
+		}
+		weight := int(record.Weight)
+		if weight <= 0 {
+			weight = 1
+		}
+		endpoints = append(endpoints, r.endpoint(strings.TrimSuffix(record.Target, "."), int(record.Port), weight))
+	}
+	return endpoints, nil
+}
+
+func (r *dnsResolver) endpoint(host string, port int, weight int) Endpoint {
+	return Endpoint{
+		URL:    r.config.Scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)),
+		Weight: weight,
+	}
+}
@@ -19,6 +19,7 @@ type Response struct {
 	cookies        map[string]string // Response cookies, which are only parsed once.
 	result         any               // Result object for successful response.
 	errorResult    any               // Error result object for error response.
+	attempts       []Attempt         // Records of the attempts of the request.
 }
 
 // initCookie initializes the cookie map attribute of Response.
@@ -177,6 +178,12 @@ func (r *Response) GetResult() any {
 	return r.result
 }
 
+// Attempts returns the records of the attempts of the request, the last one
+// being the attempt of this response.
+func (r *Response) Attempts() []Attempt {
+	return r.attempts
+}
+
 // GetError returns the error result object.
 func (r *Response) GetError() any {
 	return r.errorResult
@@ -0,0 +1,97 @@
+package mclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/graingo/maltose/errors/merror"
+)
+
+// defaultMaxLineSize is the default length limit of the lines of a response stream.
+const defaultMaxLineSize = 4 << 20
+
+// ErrLineTooLong is matched by errors.Is when a line of a response stream
+// exceeds its length limit.
+var ErrLineTooLong = errors.New("response stream line is too long")
+
+// Stream marks the response of the request as a stream. Middlewares leave its
+// body unread and it is not parsed into the result, so it can be consumed
+// incrementally, e.g. with Response.ReadLines or ReadNDJSON. Note the client
+// timeout bounds the whole stream, use the request context for long-lived ones.
+func (r *Request) Stream() *Request {
+	r.stream = true
+	return r
+}
+
+// ReadLines calls fn with each line of the body as soon as it arrives, without
+// the line terminator. It returns at the end of the body or with the first
+// error of fn, and closes the body. The line is only valid during the call.
+// Lines longer than 4MB fail with ErrLineTooLong, see ReadLinesLimit.
+func (r *Response) ReadLines(fn func(line []byte) error) error {
+	return r.ReadLinesLimit(defaultMaxLineSize, fn)
+}
+
+// ReadLinesLimit is like ReadLines with a limit of maxSize bytes per line,
+// without the terminator. A non-positive maxSize uses the default of 4MB.
+func (r *Response) ReadLinesLimit(maxSize int, fn func(line []byte) error) error {
+	if maxSize <= 0 {
+		maxSize = defaultMaxLineSize
+	}
+	if r == nil || r.Response == nil || r.Body == nil {
+		return merror.New("mclient: response or response body is nil")
+	}
+	defer r.Body.Close()
+
+	reader := bufio.NewReader(r.Body)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Long lines are accumulated beyond the buffer size.
+			long := append([]byte(nil), line...)
+			for err == bufio.ErrBufferFull {
+				// Two more bytes leave room for the terminator.
+				if len(long) > maxSize+2 {
+					return merror.Wrapf(ErrLineTooLong, "line exceeds %d bytes", maxSize)
+				}
+				line, err = reader.ReadSlice('\n')
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if len(line) > 0 && (err == nil || err == io.EOF) {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if len(line) > maxSize {
+				return merror.Wrapf(ErrLineTooLong, "line exceeds %d bytes", maxSize)
+			}
+			if fnErr := fn(line); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return merror.Wrap(err, "failed to read response stream")
+		}
+	}
+}
+
+// ReadNDJSON decodes each non-empty line of a newline-delimited JSON body as a
+// T and calls fn with it as soon as it arrives. It returns at the end of the
+// body or with the first decoding error or error of fn, and closes the body.
+// Lines are limited like with ReadLines.
+func ReadNDJSON[T any](resp *Response, fn func(T) error) error {
+	return resp.ReadLines(func(line []byte) error {
+		if len(bytes.TrimSpace(line)) == 0 {
+			return nil
+		}
+		var value T
+		if err := json.Unmarshal(line, &value); err != nil {
+			return merror.Wrapf(err, "failed to decode NDJSON line %q", line)
+		}
+		return fn(value)
+	})
+}
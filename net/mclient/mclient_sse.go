@@ -0,0 +1,310 @@
+package mclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/internal/httplog"
+	"github.com/graingo/maltose/net/mtrace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SSEEvent is an event received from a Server-Sent Events stream.
+type SSEEvent struct {
+	// ID is the last event ID of the stream when the event was dispatched.
+	ID string
+	// Event is the event type, "message" by default.
+	Event string
+	// Data is the event data, with multiple data lines joined by "\n".
+	Data string
+	// Retry is the reconnection delay the event set, zero if it set none.
+	Retry time.Duration
+}
+
+// SSEConfig is the configuration of a Server-Sent Events subscription.
+type SSEConfig struct {
+	// LastEventID is sent as Last-Event-ID on the first connection to resume a stream.
+	LastEventID string
+	// ReconnectDelay is the delay before reconnecting, until the server sets
+	// one with a retry field. Default is 3s.
+	ReconnectDelay time.Duration
+	// MaxReconnectDelay caps the delay, which doubles with each reconnection
+	// that fails before receiving an event. Default is 30s.
+	MaxReconnectDelay time.Duration
+	// MaxReconnects is the number of consecutive reconnections without
+	// receiving an event before giving up. Zero reconnects forever.
+	MaxReconnects int
+	// BufferSize is the capacity of the channel of SSEStream. Default is 16.
+	BufferSize int
+	// MaxLineSize is the length limit of the lines of the stream. Longer
+	// lines end the subscription with ErrLineTooLong. Default is 4MB.
+	MaxLineSize int
+}
+
+// DefaultSSEConfig returns a default Server-Sent Events configuration.
+func DefaultSSEConfig() SSEConfig {
+	return SSEConfig{
+		ReconnectDelay:    3 * time.Second,
+		MaxReconnectDelay: 30 * time.Second,
+		BufferSize:        16,
+		MaxLineSize:       defaultMaxLineSize,
+	}
+}
+
+func normalizeSSEConfig(config ...SSEConfig) SSEConfig {
+	defaults := DefaultSSEConfig()
+	if len(config) == 0 {
+		return defaults
+	}
+	c := config[0]
+	if c.ReconnectDelay <= 0 {
+		c.ReconnectDelay = defaults.ReconnectDelay
+	}
+	if c.MaxReconnectDelay < c.ReconnectDelay {
+		c.MaxReconnectDelay = max(defaults.MaxReconnectDelay, c.ReconnectDelay)
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = defaults.BufferSize
+	}
+	if c.MaxLineSize <= 0 {
+		c.MaxLineSize = defaults.MaxLineSize
+	}
+	return c
+}
+
+// SSE subscribes to the Server-Sent Events stream at url and calls handler
+// with each event. When the connection drops it reconnects with Last-Event-ID
+// and backoff. It returns when the request context is done, when handler
+// returns an error, when the server answers 204 No Content or an error status
+// other than 5xx and 429, or after MaxReconnects. All connections are traced
+// as children of one subscription span.
+//
+// Note the client timeout bounds each connection, so long-lived streams are
+// best consumed with a client without timeout.
+func (r *Request) SSE(url string, handler func(SSEEvent) error, config ...SSEConfig) error {
+	c := normalizeSSEConfig(config...)
+	ctx := r.Method(http.MethodGet).Context()
+
+	tr := otel.GetTracerProvider().Tracer(instrumentName, trace.WithInstrumentationVersion(version))
+	ctx, span := tr.Start(ctx, "SSE "+http.MethodGet, trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	span.SetAttributes(attribute.String(mtrace.AttributeHTTPUrl, url))
+
+	s := &sseSubscription{request: r, url: url, handler: handler, lastEventID: c.LastEventID, delay: c.ReconnectDelay, maxLineSize: c.MaxLineSize}
+	err := s.run(ctx, span, c)
+	span.SetAttributes(
+		attribute.Int(mtrace.AttributeSSEEvents, s.events),
+		attribute.String(mtrace.AttributeSSELastEventID, s.lastEventID),
+	)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// SSEStream is a Server-Sent Events subscription consumed through a channel.
+type SSEStream struct {
+	events chan SSEEvent
+	done   chan struct{}
+	err    error
+}
+
+// SSEStream subscribes to the Server-Sent Events stream at url in the
+// background, like SSE, and delivers the events through the channel of the
+// returned stream. Cancel the request context to stop it.
+func (r *Request) SSEStream(url string, config ...SSEConfig) *SSEStream {
+	c := normalizeSSEConfig(config...)
+	ctx := r.Method(http.MethodGet).Context()
+	s := &SSEStream{
+		events: make(chan SSEEvent, c.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(s.events)
+		s.err = r.SSE(url, func(event SSEEvent) error {
+			select {
+			case s.events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, c)
+		close(s.done)
+	}()
+	return s
+}
+
+// Events returns the channel of events. It is closed when the subscription ends.
+func (s *SSEStream) Events() <-chan SSEEvent {
+	return s.events
+}
+
+// Err waits for the subscription to end and returns the reason.
+func (s *SSEStream) Err() error {
+	<-s.done
+	return s.err
+}
+
+// sseSubscription is the state of a subscription across connections.
+type sseSubscription struct {
+	request     *Request
+	url         string
+	handler     func(SSEEvent) error
+	lastEventID string
+	delay       time.Duration
+	maxLineSize int
+	events      int
+}
+
+// sseHandlerError is an error returned by the handler, which ends the subscription.
+type sseHandlerError struct {
+	err error
+}
+
+func (e *sseHandlerError) Error() string {
+	return e.err.Error()
+}
+
+func (s *sseSubscription) run(ctx context.Context, span trace.Span, c SSEConfig) error {
+	failures := 0
+	for reconnect := 0; ; reconnect++ {
+		if reconnect > 0 {
+			span.AddEvent("sse.reconnect", trace.WithAttributes(
+				attribute.Int(mtrace.AttributeSSEReconnect, reconnect),
+				attribute.String(mtrace.AttributeSSELastEventID, s.lastEventID),
+			))
+		}
+		received, retry, err := s.connect(ctx)
+		if !retry {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if received > 0 {
+			failures = 0
+		} else {
+			failures++
+		}
+		if c.MaxReconnects > 0 && failures > c.MaxReconnects {
+			if err == nil {
+				err = merror.NewCode(mcode.CodeOperationFailed, "stream ended without events")
+			}
+			return merror.Wrapf(err, "giving up on event stream %s after %d reconnects", s.url, c.MaxReconnects)
+		}
+
+		// A delay set by the server is honored even above MaxReconnectDelay.
+		delay, limit := s.delay, max(c.MaxReconnectDelay, s.delay)
+		for i := 1; i < failures && delay < limit; i++ {
+			delay *= 2
+		}
+		timer := time.NewTimer(min(delay, limit))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// connect reads one connection of the stream. It returns the number of events
+// received and whether the subscription should reconnect.
+func (s *sseSubscription) connect(ctx context.Context) (received int, retry bool, err error) {
+	r := s.request
+	r.SetContext(ctx).Stream()
+	r.SetHeader("Accept", "text/event-stream")
+	r.SetHeader("Cache-Control", "no-cache")
+	if s.lastEventID != "" {
+		r.SetHeader("Last-Event-ID", s.lastEventID)
+	} else {
+		r.Request.Header.Del("Last-Event-ID")
+	}
+
+	resp, err := r.Get(s.url)
+	if err != nil {
+		return 0, true, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		// The server asks the client to stop reconnecting.
+		resp.Close()
+		return 0, false, nil
+	case resp.StatusCode != http.StatusOK:
+		resp.Close()
+		err = merror.NewCodef(mcode.CodeOperationFailed, "event stream %s failed with status %s", s.url, resp.Status)
+		return 0, resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+	case httplog.MediaType(resp.Header.Get("Content-Type")) != "text/event-stream":
+		resp.Close()
+		return 0, false, merror.NewCodef(mcode.CodeOperationFailed,
+			"event stream %s has content type %q", s.url, resp.Header.Get("Content-Type"))
+	}
+
+	var (
+		event = SSEEvent{}
+		data  bytes.Buffer
+	)
+	err = resp.ReadLinesLimit(s.maxLineSize, func(line []byte) error {
+		if len(line) == 0 {
+			// A blank line dispatches the event.
+			if data.Len() > 0 {
+				event.ID = s.lastEventID
+				if event.Event == "" {
+					event.Event = "message"
+				}
+				event.Data = strings.TrimSuffix(data.String(), "\n")
+				s.events++
+				received++
+				if err := s.handler(event); err != nil {
+					return &sseHandlerError{err: err}
+				}
+			}
+			event = SSEEvent{}
+			data.Reset()
+			return nil
+		}
+		if line[0] == ':' {
+			return nil // comment
+		}
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "event":
+			event.Event = string(value)
+		case "data":
+			data.Write(value)
+			data.WriteByte('\n')
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				s.lastEventID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(string(value), 10, 63); err == nil {
+				event.Retry = time.Duration(ms) * time.Millisecond
+				s.delay = event.Retry
+			}
+		}
+		return nil
+	})
+	var handlerErr *sseHandlerError
+	if errors.As(err, &handlerErr) {
+		return received, false, handlerErr.err
+	}
+	if errors.Is(err, ErrLineTooLong) {
+		// The server would send the line again.
+		return received, false, err
+	}
+	// The stream ended or broke, the incomplete event is discarded.
+	return received, true, err
+}
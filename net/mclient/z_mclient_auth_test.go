@@ -0,0 +1,229 @@
+package mclient_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/graingo/maltose/os/mcache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenServer issues numbered tokens and serves an API accepting only the
+// latest one.
+type tokenServer struct {
+	issued  atomic.Int32
+	current atomic.Value
+	forms   chan url.Values
+	server  *httptest.Server
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	t.Helper()
+	s := &tokenServer{forms: make(chan url.Values, 16)}
+	s.current.Store("")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		id, secret, _ := r.BasicAuth()
+		if id != "app" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		token := fmt.Sprintf("token-%d", s.issued.Add(1))
+		s.current.Store(token)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","expires_in":%d,"refresh_token":"refresh-%s"}`,
+			token, expiresIn, strings.TrimPrefix(token, "token-"))
+		select {
+		case s.forms <- r.PostForm:
+		default:
+		}
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+s.current.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	})
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *tokenServer) clientCredentials() mclient.TokenSource {
+	return mclient.NewClientCredentialsSource(mclient.ClientCredentialsConfig{
+		TokenURL:     s.server.URL + "/token",
+		ClientID:     "app",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	})
+}
+
+func TestMiddlewareAuth(t *testing.T) {
+	t.Run("client_credentials_token_is_cached", func(t *testing.T) {
+		ts := newTokenServer(t, 3600)
+		client := mclient.New().WithAuth(mclient.AuthConfig{Source: ts.clientCredentials()})
+		for i := 0; i < 3; i++ {
+			resp, err := client.R().Get(ts.server.URL + "/api")
+			require.NoError(t, err)
+			assert.Equal(t, "Bearer token-1", resp.ReadAllString())
+			resp.Close()
+		}
+		assert.Equal(t, int32(1), ts.issued.Load())
+		form := <-ts.forms
+		assert.Equal(t, "client_credentials", form.Get("grant_type"))
+		assert.Equal(t, "read write", form.Get("scope"))
+	})
+
+	t.Run("token_is_replaced_before_expiry", func(t *testing.T) {
+		ts := newTokenServer(t, 10)
+		client := mclient.New().WithAuth(mclient.AuthConfig{
+			Source: ts.clientCredentials(),
+			Cache:  mclient.TokenCacheConfig{ExpiryLeeway: 30 * time.Second},
+		})
+		for i := 0; i < 2; i++ {
+			resp, err := client.R().Get(ts.server.URL + "/api")
+			require.NoError(t, err)
+			resp.Close()
+		}
+		assert.Equal(t, int32(2), ts.issued.Load())
+	})
+
+	t.Run("single_flight_refresh", func(t *testing.T) {
+		ts := newTokenServer(t, 3600)
+		client := mclient.New().WithAuth(mclient.AuthConfig{Source: ts.clientCredentials()})
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.R().Get(ts.server.URL + "/api")
+				if assert.NoError(t, err) {
+					assert.Equal(t, http.StatusOK, resp.StatusCode)
+					resp.Close()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), ts.issued.Load())
+	})
+
+	t.Run("unauthorized_is_retried_once_with_new_token", func(t *testing.T) {
+		ts := newTokenServer(t, 3600)
+		client := mclient.New().WithAuth(mclient.AuthConfig{Source: ts.clientCredentials()})
+		resp, err := client.R().Get(ts.server.URL + "/api")
+		require.NoError(t, err)
+		resp.Close()
+
+		// The server revokes the token.
+		ts.current.Store("revoked")
+		resp, err = client.R().SetBody(map[string]string{"a": "b"}).Post(ts.server.URL + "/api")
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "Bearer token-2", resp.ReadAllString())
+		assert.Equal(t, int32(2), ts.issued.Load())
+	})
+
+	t.Run("shared_cache", func(t *testing.T) {
+		ts := newTokenServer(t, 3600)
+		adapter := mcache.NewAdapterMemory()
+		for i := 0; i < 2; i++ {
+			client := mclient.New().WithAuth(mclient.AuthConfig{
+				Source: ts.clientCredentials(),
+				Cache:  mclient.TokenCacheConfig{Adapter: adapter, Key: "payment:token"},
+			})
+			resp, err := client.R().Get(ts.server.URL + "/api")
+			require.NoError(t, err)
+			resp.Close()
+		}
+		assert.Equal(t, int32(1), ts.issued.Load())
+	})
+
+	t.Run("shared_cache_default_key", func(t *testing.T) {
+		ts := newTokenServer(t, 3600)
+		adapter := mcache.NewAdapterMemory()
+		ctx := context.Background()
+		sources := []mclient.TokenSource{
+			ts.clientCredentials(),
+			ts.clientCredentials(),
+			mclient.NewClientCredentialsSource(mclient.ClientCredentialsConfig{
+				TokenURL:     ts.server.URL + "/token",
+				ClientID:     "app",
+				ClientSecret: "secret",
+				Scopes:       []string{"read"},
+			}),
+		}
+		var tokens []string
+		for _, source := range sources {
+			token, err := mclient.NewCachedTokenSource(source, mclient.TokenCacheConfig{Adapter: adapter}).Token(ctx)
+			require.NoError(t, err)
+			tokens = append(tokens, token.AccessToken)
+		}
+		assert.Equal(t, []string{"token-1", "token-1", "token-2"}, tokens, "sources with other scopes do not share tokens")
+
+		keys, err := adapter.Keys(ctx)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		for _, key := range keys {
+			value, err := adapter.Get(ctx, key)
+			require.NoError(t, err)
+			assert.NotContains(t, value.String(), "refresh", "refresh tokens are not shared")
+		}
+
+		assert.Panics(t, func() {
+			mclient.NewCachedTokenSource(mclient.StaticTokenSource("static"), mclient.TokenCacheConfig{Adapter: adapter})
+		})
+	})
+
+	t.Run("token_error", func(t *testing.T) {
+		ts := newTokenServer(t, 3600)
+		client := mclient.New().WithAuth(mclient.AuthConfig{
+			Source: mclient.NewClientCredentialsSource(mclient.ClientCredentialsConfig{
+				TokenURL:     ts.server.URL + "/token",
+				ClientID:     "app",
+				ClientSecret: "wrong",
+			}),
+		})
+		_, err := client.R().Get(ts.server.URL + "/api")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid_client")
+	})
+}
+
+func TestRefreshTokenSource(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	source := mclient.NewRefreshTokenSource(mclient.RefreshTokenConfig{
+		TokenURL:     ts.server.URL + "/token",
+		ClientID:     "app",
+		ClientSecret: "secret",
+		RefreshToken: "initial",
+	})
+	ctx := context.Background()
+
+	token, err := source.Token(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+	assert.True(t, token.Valid())
+	form := <-ts.forms
+	assert.Equal(t, "refresh_token", form.Get("grant_type"))
+	assert.Equal(t, "initial", form.Get("refresh_token"))
+
+	// The rotated refresh token is used for the next refresh.
+	_, err = source.Token(ctx)
+	require.NoError(t, err)
+	form = <-ts.forms
+	assert.Equal(t, "refresh-1", form.Get("refresh_token"))
+}
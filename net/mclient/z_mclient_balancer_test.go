@@ -0,0 +1,189 @@
+package mclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingServer returns a server replying with status and counting its requests.
+func countingServer(t *testing.T, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestLoadBalancer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("round_robin_from_config", func(t *testing.T) {
+		a, hitsA := countingServer(t, http.StatusOK)
+		b, hitsB := countingServer(t, http.StatusOK)
+		client := mclient.NewWithConfig(mclient.ClientConfig{Endpoints: []string{a.URL, b.URL + "/"}})
+		for i := 0; i < 6; i++ {
+			resp, err := client.R().Get("/ping")
+			require.NoError(t, err)
+			resp.Close()
+		}
+		assert.Equal(t, int32(3), hitsA.Load())
+		assert.Equal(t, int32(3), hitsB.Load())
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		a, hitsA := countingServer(t, http.StatusOK)
+		b, hitsB := countingServer(t, http.StatusOK)
+		client := mclient.New().SetEndpoints(mclient.BalanceWeighted, a.URL+" weight=3", b.URL)
+		for i := 0; i < 8; i++ {
+			resp, err := client.R().Get("/")
+			require.NoError(t, err)
+			resp.Close()
+		}
+		assert.Equal(t, int32(6), hitsA.Load())
+		assert.Equal(t, int32(2), hitsB.Load())
+	})
+
+	t.Run("least_requests", func(t *testing.T) {
+		var (
+			received = make(chan struct{})
+			release  = make(chan struct{})
+		)
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			close(received)
+			<-release
+		}))
+		defer slow.Close()
+		fast, hitsFast := countingServer(t, http.StatusOK)
+		client := mclient.New().SetEndpoints(mclient.BalanceLeastRequests, slow.URL, fast.URL)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.R().Get("/")
+			if assert.NoError(t, err) {
+				resp.Close()
+			}
+		}()
+		<-received
+		for i := 0; i < 3; i++ {
+			resp, err := client.R().Get("/")
+			require.NoError(t, err)
+			resp.Close()
+		}
+		close(release)
+		wg.Wait()
+		assert.Equal(t, int32(3), hitsFast.Load(), "the busy endpoint is avoided")
+	})
+
+	t.Run("retry_uses_another_endpoint", func(t *testing.T) {
+		failing, hitsFailing := countingServer(t, http.StatusServiceUnavailable)
+		healthy, hitsHealthy := countingServer(t, http.StatusOK)
+		client := mclient.New().SetEndpoints(mclient.BalanceRoundRobin, failing.URL, healthy.URL)
+		for i := 0; i < 4; i++ {
+			resp, err := client.R().SetRetrySimple(1, time.Millisecond).Get("/")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			resp.Close()
+		}
+		// Every first attempt that hit the failing endpoint succeeded on its only retry.
+		assert.Equal(t, int32(8), hitsFailing.Load()+hitsHealthy.Load())
+		assert.Equal(t, int32(4), hitsHealthy.Load())
+	})
+
+	t.Run("ejects_failing_endpoints", func(t *testing.T) {
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		healthy, hitsHealthy := countingServer(t, http.StatusOK)
+		balancer := mclient.NewLoadBalancer(mclient.LoadBalancerConfig{
+			Resolver:      mclient.NewStaticResolver(down.URL, healthy.URL),
+			EjectFailures: 2,
+			EjectDuration: time.Minute,
+		})
+		client := mclient.New().SetLoadBalancer(balancer)
+		for i := 0; i < 4; i++ {
+			if resp, err := client.R().Get("/"); err == nil {
+				resp.Close()
+			}
+		}
+		assert.Equal(t, []mclient.Endpoint{{URL: healthy.URL, Weight: 1}}, balancer.Endpoints())
+
+		for i := 0; i < 4; i++ {
+			resp, err := client.R().Get("/")
+			require.NoError(t, err)
+			resp.Close()
+		}
+		assert.Equal(t, int32(6), hitsHealthy.Load())
+	})
+
+	t.Run("file_resolver_refresh", func(t *testing.T) {
+		a, hitsA := countingServer(t, http.StatusOK)
+		b, hitsB := countingServer(t, http.StatusOK)
+		path := filepath.Join(t.TempDir(), "endpoints")
+		require.NoError(t, os.WriteFile(path, []byte("# replicas\n"+a.URL+"\n"), 0o644))
+
+		client := mclient.New().SetLoadBalancer(mclient.NewLoadBalancer(mclient.LoadBalancerConfig{
+			Resolver:        mclient.NewFileResolver(path),
+			RefreshInterval: 10 * time.Millisecond,
+		}))
+		resp, err := client.R().Get("/")
+		require.NoError(t, err)
+		resp.Close()
+
+		require.NoError(t, os.WriteFile(path, []byte(b.URL+" weight=2\n"), 0o644))
+		time.Sleep(20 * time.Millisecond)
+		resp, err = client.R().Get("/")
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, int32(1), hitsA.Load())
+		assert.Equal(t, int32(1), hitsB.Load())
+	})
+
+	t.Run("resolution_errors", func(t *testing.T) {
+		_, err := mclient.NewStaticResolver("http://a:80", "not-a-url").Resolve(ctx)
+		assert.Error(t, err)
+		_, err = mclient.ParseEndpoint("http://a:80 weight=0")
+		assert.Error(t, err)
+
+		client := mclient.New().SetLoadBalancer(mclient.NewLoadBalancer(mclient.LoadBalancerConfig{
+			Resolver: mclient.NewFileResolver(filepath.Join(t.TempDir(), "missing")),
+		}))
+		_, err = client.R().Get("/")
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid_policy", func(t *testing.T) {
+		err := mclient.New().SetConfigWithMap(map[string]any{
+			"endpoints":    []string{"http://a:80"},
+			"load_balance": "random",
+		})
+		assert.ErrorContains(t, err, `unsupported balance policy "random"`)
+		assert.NotPanics(t, func() {
+			mclient.NewWithConfig(mclient.ClientConfig{Endpoints: []string{"http://a:80"}, LoadBalance: "random"})
+		})
+		assert.Panics(t, func() { mclient.New().SetEndpoints("random", "http://a:80") })
+	})
+
+	t.Run("dns_resolver", func(t *testing.T) {
+		endpoints, err := mclient.NewDNSResolver(mclient.DNSResolverConfig{Host: "localhost", Port: 8080}).Resolve(ctx)
+		require.NoError(t, err)
+		assert.Contains(t, endpoints, mclient.Endpoint{URL: "http://127.0.0.1:8080", Weight: 1})
+
+		_, err = mclient.NewDNSResolver(mclient.DNSResolverConfig{Host: "localhost"}).Resolve(ctx)
+		assert.Error(t, err, "port is required for A lookups")
+	})
+}
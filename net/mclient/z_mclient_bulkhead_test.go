@@ -0,0 +1,145 @@
+package mclient_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingServer holds the requests until release is closed.
+func blockingServer(t *testing.T) (*httptest.Server, chan struct{}, chan struct{}) {
+	t.Helper()
+	arrived := make(chan struct{}, 16)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, arrived, release
+}
+
+func TestBulkhead(t *testing.T) {
+	t.Run("queue_full_is_rejected", func(t *testing.T) {
+		server, arrived, release := blockingServer(t)
+		bulkhead := mclient.NewBulkhead(mclient.BulkheadConfig{Name: "api", MaxConcurrent: 1, MaxQueue: 1})
+		client := mclient.New().Use(bulkhead.Middleware())
+
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.R().Get(server.URL)
+				if assert.NoError(t, err) {
+					assert.Equal(t, "ok", resp.ReadAllString())
+					resp.Close()
+				}
+			}()
+		}
+		<-arrived
+		require.Eventually(t, func() bool { return bulkhead.Queued() == 1 }, time.Second, 5*time.Millisecond)
+		assert.Equal(t, 1, bulkhead.InFlight())
+
+		_, err := client.R().Get(server.URL)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, mclient.ErrBulkheadFull))
+		assert.Equal(t, mcode.CodeServerBusy, merror.Code(err))
+		var rejected *mclient.BulkheadRejectedError
+		require.True(t, errors.As(err, &rejected))
+		assert.Equal(t, "api", rejected.Name)
+
+		close(release)
+		wg.Wait()
+		assert.Equal(t, 0, bulkhead.InFlight())
+		assert.Equal(t, 0, bulkhead.Queued())
+	})
+
+	t.Run("queue_timeout", func(t *testing.T) {
+		server, arrived, release := blockingServer(t)
+		defer close(release)
+		client := mclient.New().WithBulkhead(mclient.BulkheadConfig{
+			MaxConcurrent: 1,
+			MaxQueue:      1,
+			QueueTimeout:  20 * time.Millisecond,
+		})
+		go func() {
+			if resp, err := client.R().Get(server.URL); err == nil {
+				resp.Close()
+			}
+		}()
+		<-arrived
+
+		_, err := client.R().Get(server.URL)
+		require.ErrorIs(t, err, mclient.ErrBulkheadFull)
+		assert.Contains(t, err.Error(), "no slot freed within")
+	})
+
+	t.Run("rejections_are_not_retried", func(t *testing.T) {
+		server, arrived, release := blockingServer(t)
+		defer close(release)
+		client := mclient.New().WithBulkhead(mclient.BulkheadConfig{MaxConcurrent: 1})
+		go func() {
+			if resp, err := client.R().Get(server.URL); err == nil {
+				resp.Close()
+			}
+		}()
+		<-arrived
+
+		req := client.R().SetRetry(mclient.RetryConfig{Count: 3, BaseInterval: time.Millisecond})
+		_, err := req.Get(server.URL)
+		require.ErrorIs(t, err, mclient.ErrBulkheadFull)
+		require.Len(t, req.Attempts(), 1)
+		assert.Equal(t, mclient.AttemptDone, req.Attempts()[0].Outcome)
+	})
+
+	t.Run("per_host_partitions", func(t *testing.T) {
+		slow, arrived, release := blockingServer(t)
+		defer close(release)
+		fast, _ := countingServer(t, http.StatusOK)
+		bulkhead := mclient.NewBulkhead(mclient.BulkheadConfig{MaxConcurrent: 1, KeyFunc: mclient.CircuitKeyHost})
+		client := mclient.New().Use(bulkhead.Middleware())
+		go func() {
+			if resp, err := client.R().Get(slow.URL); err == nil {
+				resp.Close()
+			}
+		}()
+		<-arrived
+
+		resp, err := client.R().Get(fast.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, 1, bulkhead.InFlight(strings.TrimPrefix(slow.URL, "http://")))
+		assert.Equal(t, 0, bulkhead.InFlight(strings.TrimPrefix(fast.URL, "http://")))
+	})
+
+	t.Run("slot_is_held_until_body_is_consumed", func(t *testing.T) {
+		server, _ := countingServer(t, http.StatusOK)
+		bulkhead := mclient.NewBulkhead(mclient.BulkheadConfig{MaxConcurrent: 1})
+		client := mclient.New().Use(bulkhead.Middleware())
+		resp, err := client.R().Get(server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, 1, bulkhead.InFlight())
+		_, err = client.R().Get(server.URL)
+		assert.ErrorIs(t, err, mclient.ErrBulkheadFull)
+
+		resp.ReadAll()
+		assert.Equal(t, 0, bulkhead.InFlight())
+		resp.Close()
+		assert.Equal(t, 0, bulkhead.InFlight())
+	})
+}
@@ -0,0 +1,130 @@
+package mclient_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedging(t *testing.T) {
+	t.Run("hedge_wins_and_loser_is_cancelled", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+				close(cancelled)
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer slow.Close()
+		fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("fast"))
+		}))
+		defer fast.Close()
+
+		client := mclient.New().
+			SetEndpoints(mclient.BalanceRoundRobin, slow.URL, fast.URL).
+			WithHedging(mclient.HedgeConfig{Delay: 20 * time.Millisecond})
+		start := time.Now()
+		resp, err := client.R().Get("/")
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, "fast", resp.ReadAllString())
+		assert.Less(t, time.Since(start), time.Second)
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("losing attempt was not cancelled")
+		}
+	})
+
+	t.Run("original_wins_without_hedge", func(t *testing.T) {
+		server, hits := countingServer(t, http.StatusOK)
+		client := mclient.New().WithHedging(mclient.HedgeConfig{Delay: time.Second})
+		resp, err := client.R().Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, int32(1), hits.Load())
+	})
+
+	t.Run("max_hedges", func(t *testing.T) {
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hits.Add(1) < 3 {
+				<-r.Context().Done()
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := mclient.New().WithHedging(mclient.HedgeConfig{Delay: 10 * time.Millisecond, MaxHedges: 2})
+		resp, err := client.R().Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(3), hits.Load())
+	})
+
+	t.Run("non_idempotent_and_disabled_requests_are_not_hedged", func(t *testing.T) {
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			hits.Add(1)
+			time.Sleep(50 * time.Millisecond)
+		}))
+		defer server.Close()
+
+		client := mclient.New().WithHedging(mclient.HedgeConfig{Delay: 5 * time.Millisecond})
+		resp, err := client.R().SetBody(map[string]string{"a": "b"}).Post(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, int32(1), hits.Load())
+
+		resp, err = client.R().SetHedger(nil).Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, int32(2), hits.Load())
+	})
+
+	t.Run("failed_original_keeps_waiting_for_hedge", func(t *testing.T) {
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if hits.Add(1) == 1 {
+				time.Sleep(30 * time.Millisecond)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			time.Sleep(60 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := mclient.New().WithHedging(mclient.HedgeConfig{Delay: 10 * time.Millisecond})
+		resp, err := client.R().Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestHedgerDelay(t *testing.T) {
+	hedger := mclient.NewHedger(mclient.HedgeConfig{MaxDelay: 500 * time.Millisecond, MinSamples: 2})
+	assert.Equal(t, 500*time.Millisecond, hedger.Delay())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+	client := mclient.New().SetHedger(hedger)
+	for i := 0; i < 2; i++ {
+		resp, err := client.R().Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+	}
+	assert.Less(t, hedger.Delay(), 500*time.Millisecond)
+}
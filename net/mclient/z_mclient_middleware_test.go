@@ -2,13 +2,19 @@ package mclient_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/graingo/maltose/errors/mcode"
 	"github.com/graingo/maltose/errors/merror"
 	"github.com/graingo/maltose/net/mclient"
+	"github.com/graingo/maltose/os/mcache"
 	"github.com/graingo/maltose/os/mlog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -59,6 +65,27 @@ func TestMiddleware(t *testing.T) {
 		assert.Greater(t, duration, 900*time.Millisecond, "Expected total time to be > 900ms")
 	})
 
+	t.Run("rate_limit_from_config", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		var limited int
+		config := mclient.ClientConfig{RateLimit: mclient.RateLimitConfig{
+			RequestsPerSecond: 100,
+			Skip:              func(*mclient.Request) bool { limited++; return true },
+		}}
+		client := mclient.New().SetConfig(config).SetConfig(config)
+		clone := client.Clone().SetConfig(mclient.ClientConfig{})
+		for _, c := range []*mclient.Client{client, clone} {
+			resp, err := c.R().Get(server.URL)
+			require.NoError(t, err)
+			resp.Close()
+		}
+		assert.Equal(t, 1, limited, "applying the config again replaces its rate limiter")
+	})
+
 	t.Run("recovery_middleware", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 			w.WriteHeader(http.StatusOK)
@@ -160,6 +187,55 @@ func TestMiddleware(t *testing.T) {
 		})
 	})
 
+	t.Run("log_middleware_with_config", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := mlog.New(&mlog.Config{Writer: &buf, Level: mlog.DebugLevel, Format: "json"})
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Path == "/fail" {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+			_, _ = w.Write([]byte(`{"data":{"refresh_token":"rt-secret","id":7}}`))
+		}))
+		defer server.Close()
+
+		config := mclient.DefaultLogConfig()
+		config.Query = true
+		config.Headers = true
+		config.Body.MaxSize = -1
+		config.Redaction.JSONPaths = append(config.Redaction.JSONPaths, "card.number")
+		config.Sampling.RouteRates = map[string]float64{"/quiet": 0, "/fail": 0}
+
+		client := mclient.New()
+		client.Use(mclient.MiddlewareLogWithConfig(logger, config))
+
+		_, err := client.R().
+			SetHeader("Authorization", "Bearer top-secret").
+			SetHeader("Content-Type", "application/json").
+			SetBody(`{"card":{"number":"4111111111111111","holder":"alice"}}`).
+			Post(server.URL + "/pay?password=pw&page=2")
+		require.NoError(t, err)
+
+		logStr := buf.String()
+		assert.Contains(t, logStr, `page=2&password=***`)
+		assert.Contains(t, logStr, `"Authorization":"***"`)
+		assert.Contains(t, logStr, "alice")
+		assert.Contains(t, logStr, `\"id\":7`)
+		assert.NotContains(t, logStr, "4111111111111111")
+		assert.NotContains(t, logStr, "top-secret")
+		assert.NotContains(t, logStr, "rt-secret")
+
+		buf.Reset()
+		_, err = client.R().Get(server.URL + "/quiet")
+		require.NoError(t, err)
+		assert.Empty(t, buf.String(), "muted successful requests must not be logged")
+
+		_, err = client.R().Get(server.URL + "/fail")
+		require.NoError(t, err)
+		assert.Contains(t, buf.String(), "http client request finished with error status")
+	})
+
 	t.Run("nil_response_from_middleware", func(t *testing.T) {
 		client := mclient.New()
 		client.Use(func(_ mclient.HandlerFunc) mclient.HandlerFunc {
@@ -170,3 +246,291 @@ func TestMiddleware(t *testing.T) {
 		assert.Contains(t, err.Error(), "nil response")
 	})
 }
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("opens_half_opens_and_closes", func(t *testing.T) {
+		var (
+			hits    atomic.Int32
+			healthy atomic.Bool
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			hits.Add(1)
+			if !healthy.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		var transitions []string
+		breaker := mclient.NewCircuitBreaker(mclient.CircuitBreakerConfig{
+			Name:        "downstream",
+			MinRequests: 4,
+			OpenTimeout: 100 * time.Millisecond,
+			OnStateChange: func(name, _ string, from, to mclient.CircuitState) {
+				transitions = append(transitions, name+":"+from.String()+"->"+to.String())
+			},
+		})
+		client := mclient.New()
+		client.Use(breaker.Middleware())
+
+		for i := 0; i < 4; i++ {
+			resp, err := client.R().Get(server.URL)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		}
+		assert.Equal(t, mclient.CircuitOpen, breaker.State())
+
+		// Requests fail fast and are not retried against the open circuit.
+		_, err := client.R().SetRetrySimple(3, time.Millisecond).Get(server.URL)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, mclient.ErrCircuitOpen))
+		assert.Equal(t, mcode.CodeServerBusy, merror.Code(err))
+		var openErr *mclient.CircuitOpenError
+		require.True(t, errors.As(err, &openErr))
+		assert.Equal(t, "downstream", openErr.Name)
+		assert.Greater(t, openErr.RetryAfter, time.Duration(0))
+		assert.Equal(t, int32(4), hits.Load())
+
+		// A failed probe opens the circuit again.
+		time.Sleep(120 * time.Millisecond)
+		_, err = client.R().Get(server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, mclient.CircuitOpen, breaker.State())
+
+		// A successful probe closes it.
+		healthy.Store(true)
+		time.Sleep(120 * time.Millisecond)
+		resp, err := client.R().Get(server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, mclient.CircuitClosed, breaker.State())
+
+		assert.Equal(t, []string{
+			"downstream:closed->open",
+			"downstream:open->half-open",
+			"downstream:half-open->open",
+			"downstream:open->half-open",
+			"downstream:half-open->closed",
+		}, transitions)
+	})
+
+	t.Run("per_host_circuits", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer failing.Close()
+		working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer working.Close()
+
+		breaker := mclient.NewCircuitBreaker(mclient.CircuitBreakerConfig{
+			KeyFunc:     mclient.CircuitKeyHost,
+			MinRequests: 2,
+		})
+		client := mclient.New()
+		client.Use(breaker.Middleware())
+
+		for i := 0; i < 2; i++ {
+			_, _ = client.R().Get(failing.URL)
+		}
+		_, err := client.R().Get(failing.URL)
+		assert.ErrorIs(t, err, mclient.ErrCircuitOpen)
+
+		resp, err := client.R().Get(working.URL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, mclient.CircuitOpen, breaker.State(strings.TrimPrefix(failing.URL, "http://")))
+		assert.Equal(t, mclient.CircuitClosed, breaker.State(strings.TrimPrefix(working.URL, "http://")))
+	})
+
+	t.Run("slow_calls", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(30 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := mclient.New().WithCircuitBreaker(mclient.CircuitBreakerConfig{
+			MinRequests:           2,
+			SlowCallDuration:      10 * time.Millisecond,
+			SlowCallRateThreshold: 0.5,
+		})
+		for i := 0; i < 2; i++ {
+			_, err := client.R().Get(server.URL)
+			require.NoError(t, err)
+		}
+		_, err := client.R().Get(server.URL)
+		assert.ErrorIs(t, err, mclient.ErrCircuitOpen)
+	})
+}
+
+func TestMiddlewareCache(t *testing.T) {
+	// cacheServer replies with the handler and counts the requests reaching it.
+	cacheServer := func(t *testing.T, handler http.HandlerFunc) (*mclient.Client, string, *atomic.Int32) {
+		t.Helper()
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			handler(w, r)
+		}))
+		t.Cleanup(server.Close)
+		adapter := mcache.NewAdapterMemory()
+		t.Cleanup(func() { _ = adapter.Close(context.Background()) })
+		client := mclient.New().WithCache(mclient.CacheConfig{Adapter: adapter})
+		return client, server.URL, &hits
+	}
+	get := func(t *testing.T, req *mclient.Request, url string) (string, string) {
+		t.Helper()
+		resp, err := req.Get(url)
+		require.NoError(t, err)
+		defer resp.Close()
+		return resp.Header.Get(mclient.CacheStatusHeader), resp.ReadAllString()
+	}
+
+	t.Run("fresh_responses_are_served_from_cache", func(t *testing.T) {
+		client, url, hits := cacheServer(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write([]byte("reference"))
+		})
+		status, body := get(t, client.R(), url)
+		assert.Equal(t, mclient.CacheMiss, status)
+		assert.Equal(t, "reference", body)
+		status, body = get(t, client.R(), url)
+		assert.Equal(t, mclient.CacheHit, status)
+		assert.Equal(t, "reference", body)
+		assert.Equal(t, int32(1), hits.Load())
+
+		status, _ = get(t, client.R().BypassCache(), url)
+		assert.Equal(t, mclient.CacheBypass, status)
+		status, _ = get(t, client.R().SetHeader("Cache-Control", "max-age=0"), url)
+		assert.Equal(t, mclient.CacheMiss, status, "max-age=0 without validators refetches")
+		assert.Equal(t, int32(3), hits.Load())
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		client, url, hits := cacheServer(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Expires", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		})
+		get(t, client.R(), url)
+		status, _ := get(t, client.R(), url)
+		assert.Equal(t, mclient.CacheHit, status)
+		assert.Equal(t, int32(1), hits.Load())
+	})
+
+	t.Run("revalidates_with_etag", func(t *testing.T) {
+		client, url, hits := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = w.Write([]byte("reference"))
+		})
+		get(t, client.R(), url)
+		status, body := get(t, client.R(), url)
+		assert.Equal(t, mclient.CacheRevalidated, status)
+		assert.Equal(t, "reference", body)
+		assert.Equal(t, int32(2), hits.Load())
+	})
+
+	t.Run("vary", func(t *testing.T) {
+		client, url, _ := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+		})
+		get(t, client.R().SetHeader("Accept-Language", "en"), url)
+		status, body := get(t, client.R().SetHeader("Accept-Language", "fr"), url)
+		assert.Equal(t, mclient.CacheMiss, status)
+		assert.Equal(t, "fr", body)
+		status, body = get(t, client.R().SetHeader("Accept-Language", "fr"), url)
+		assert.Equal(t, mclient.CacheHit, status)
+		assert.Equal(t, "fr", body)
+	})
+
+	t.Run("stale_if_error", func(t *testing.T) {
+		var failing atomic.Bool
+		client, url, _ := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=0, "+r.URL.Query().Get("cc"))
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write([]byte("reference"))
+		})
+		get(t, client.R(), url+"?cc=stale-if-error=60")
+		get(t, client.R(), url+"?cc=must-revalidate")
+		failing.Store(true)
+
+		status, body := get(t, client.R(), url+"?cc=stale-if-error=60")
+		assert.Equal(t, mclient.CacheStale, status)
+		assert.Equal(t, "reference", body)
+
+		resp, err := client.R().Get(url + "?cc=must-revalidate")
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+
+	t.Run("not_stored", func(t *testing.T) {
+		client, url, hits := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/private" {
+				w.Header().Set("Cache-Control", "no-store")
+			} else {
+				w.Header().Set("Cache-Control", "max-age=60")
+			}
+		})
+		get(t, client.R(), url+"/private")
+		get(t, client.R(), url+"/private")
+		get(t, client.R().SetHeader("Cache-Control", "no-store"), url+"/public")
+		get(t, client.R(), url+"/public")
+		assert.Equal(t, int32(4), hits.Load())
+	})
+
+	t.Run("credentialed_requests", func(t *testing.T) {
+		client, url, hits := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			switch r.URL.Path {
+			case "/public":
+				w.Header().Set("Cache-Control", "public, max-age=60")
+			case "/private":
+				w.Header().Set("Cache-Control", "private, max-age=60")
+			}
+			_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+		})
+		status, body := get(t, client.R().SetHeader("Authorization", "Bearer alice"), url)
+		assert.Equal(t, mclient.CacheMiss, status)
+		assert.Equal(t, "Bearer alice", body)
+		status, body = get(t, client.R().SetHeader("Authorization", "Bearer bob"), url)
+		assert.Equal(t, mclient.CacheMiss, status)
+		assert.Equal(t, "Bearer bob", body)
+		assert.Equal(t, int32(2), hits.Load())
+
+		get(t, client.R().SetHeader("Authorization", "Bearer alice"), url+"/public")
+		status, body = get(t, client.R().SetHeader("Authorization", "Bearer bob"), url+"/public")
+		assert.Equal(t, mclient.CacheHit, status)
+		assert.Equal(t, "Bearer alice", body, "public responses are shared")
+
+		get(t, client.R().SetHeader("Authorization", "Bearer alice"), url+"/private")
+		status, _ = get(t, client.R().SetHeader("Authorization", "Bearer alice"), url+"/private")
+		assert.Equal(t, mclient.CacheMiss, status, "private responses are never stored")
+	})
+
+	t.Run("unsafe_requests_invalidate", func(t *testing.T) {
+		client, url, hits := cacheServer(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+		})
+		get(t, client.R(), url)
+		resp, err := client.R().SetBody("update").Post(url)
+		require.NoError(t, err)
+		resp.Close()
+		status, _ := get(t, client.R(), url)
+		assert.Equal(t, mclient.CacheMiss, status)
+		assert.Equal(t, int32(3), hits.Load())
+	})
+}
@@ -0,0 +1,176 @@
+package mclient_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shadowRequest is a request received by the shadow server.
+type shadowRequest struct {
+	Method string
+	URI    string
+	Header string
+	Body   string
+}
+
+func shadowServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, chan shadowRequest) {
+	t.Helper()
+	received := make(chan shadowRequest, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- shadowRequest{Method: r.Method, URI: r.RequestURI, Header: r.Header.Get("X-Trace"), Body: string(body)}
+		if handler != nil {
+			handler(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func TestMiddlewareMirror(t *testing.T) {
+	t.Run("mirrors_first_attempt_with_body", func(t *testing.T) {
+		var primaryBodies []string
+		var calls atomic.Int32
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			primaryBodies = append(primaryBodies, string(body))
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte("ok"))
+		}))
+		defer primary.Close()
+		shadow, received := shadowServer(t, nil)
+
+		client := mclient.New().WithMirror(mclient.MirrorConfig{BaseURL: shadow.URL})
+		resp, err := client.R().
+			SetRetry(mclient.RetryConfig{Count: 1, BaseInterval: time.Millisecond}).
+			SetHeader("X-Trace", "abc").
+			SetBody("payload").
+			Post(primary.URL + "/orders?id=1")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", resp.ReadAllString())
+		resp.Close()
+		assert.Equal(t, []string{"payload", "payload"}, primaryBodies)
+
+		select {
+		case got := <-received:
+			assert.Equal(t, shadowRequest{Method: http.MethodPost, URI: "/orders?id=1", Header: "abc", Body: "payload"}, got)
+		case <-time.After(time.Second):
+			t.Fatal("request was not mirrored")
+		}
+		select {
+		case <-received:
+			t.Fatal("retry was mirrored")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("shadow_failure_is_ignored", func(t *testing.T) {
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+		defer primary.Close()
+
+		client := mclient.New().WithMirror(mclient.MirrorConfig{BaseURL: "http://127.0.0.1:1", Timeout: 100 * time.Millisecond})
+		resp, err := client.R().Get(primary.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", resp.ReadAllString())
+		resp.Close()
+	})
+
+	t.Run("match_and_concurrency", func(t *testing.T) {
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer primary.Close()
+		release := make(chan struct{})
+		shadow, received := shadowServer(t, func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		})
+		defer close(release)
+
+		client := mclient.New().WithMirror(mclient.MirrorConfig{
+			BaseURL:       shadow.URL,
+			MaxConcurrent: 1,
+			Match:         func(r *mclient.Request) bool { return r.Request.Method == http.MethodGet },
+		})
+		_, err := client.R().Post(primary.URL)
+		require.NoError(t, err)
+		_, err = client.R().Get(primary.URL + "/first")
+		require.NoError(t, err)
+		select {
+		case got := <-received:
+			assert.Equal(t, "/first", got.URI)
+		case <-time.After(time.Second):
+			t.Fatal("request was not mirrored")
+		}
+
+		// The only slot is held by the blocked shadow request.
+		_, err = client.R().Get(primary.URL + "/second")
+		require.NoError(t, err)
+		select {
+		case got := <-received:
+			t.Fatalf("unexpected mirrored request %s", got.URI)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("compare_reports_diff", func(t *testing.T) {
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"total":10}`))
+		}))
+		defer primary.Close()
+		shadow, _ := shadowServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/same" {
+				_, _ = w.Write([]byte(`{"total":10}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"total":12}`))
+		})
+
+		diffs := make(chan *mclient.MirrorDiff, 4)
+		client := mclient.New().WithMirror(mclient.MirrorConfig{
+			BaseURL: shadow.URL,
+			Compare: true,
+			OnDiff:  func(ctx context.Context, diff *mclient.MirrorDiff) { diffs <- diff },
+		})
+
+		resp, err := client.R().Get(primary.URL + "/same")
+		require.NoError(t, err)
+		assert.Equal(t, `{"total":10}`, resp.ReadAllString())
+		resp.Close()
+
+		resp, err = client.R().Get(primary.URL + "/other")
+		require.NoError(t, err)
+		assert.Equal(t, `{"total":10}`, resp.ReadAllString())
+		resp.Close()
+
+		select {
+		case diff := <-diffs:
+			assert.Equal(t, shadow.URL+"/other", diff.URL)
+			assert.Equal(t, http.StatusOK, diff.ShadowStatus)
+			assert.Equal(t, `{"total":10}`, string(diff.PrimaryBody))
+			assert.Equal(t, `{"total":12}`, string(diff.ShadowBody))
+		case <-time.After(time.Second):
+			t.Fatal("diff was not reported")
+		}
+		select {
+		case diff := <-diffs:
+			t.Fatalf("unexpected diff for %s", diff.URL)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("base_url_is_required", func(t *testing.T) {
+		assert.Panics(t, func() { mclient.MiddlewareMirror(mclient.MirrorConfig{}) })
+	})
+}
@@ -0,0 +1,124 @@
+package mclient_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("X-Hit", string(rune('0'+n)))
+		if r.URL.Path == "/binary" {
+			_, _ = w.Write([]byte{0xff, 0x00, 0xfe})
+			return
+		}
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.Path + " " + r.Header.Get("X-Tenant")))
+	}))
+	defer server.Close()
+
+	for _, ext := range []string{".yaml", ".json"} {
+		t.Run("record_and_replay"+ext, func(t *testing.T) {
+			hits.Store(0)
+			path := filepath.Join(t.TempDir(), "cassettes", "api"+ext)
+			recorder, err := mclient.NewRecorder(mclient.RecorderConfig{
+				Path:        path,
+				Mode:        mclient.RecordModeRecord,
+				RedactQuery: []string{"token"},
+			})
+			require.NoError(t, err)
+			client := mclient.New().SetTransport(recorder)
+			for _, target := range []string{"/users?token=secret", "/users?token=secret", "/binary"} {
+				resp, err := client.R().SetHeader("Authorization", "Bearer secret").Get(server.URL + target)
+				require.NoError(t, err)
+				resp.Close()
+			}
+			resp, err := client.R().SetBody(map[string]string{"name": "a"}).Post(server.URL + "/users")
+			require.NoError(t, err)
+			resp.Close()
+			assert.Equal(t, int32(4), hits.Load())
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.NotContains(t, string(data), "secret")
+			assert.Contains(t, string(data), "REDACTED")
+
+			replayer, err := mclient.NewRecorder(mclient.RecorderConfig{Path: path, RedactQuery: []string{"token"}})
+			require.NoError(t, err)
+			client = mclient.New().SetTransport(replayer)
+
+			// Identical requests are replayed in order, then the last one repeats.
+			for _, hit := range []string{"1", "2", "2"} {
+				resp, err = client.R().Get(server.URL + "/users?token=other")
+				require.NoError(t, err)
+				assert.Equal(t, "GET /users ", resp.ReadAllString())
+				assert.Equal(t, hit, resp.Header.Get("X-Hit"))
+				resp.Close()
+			}
+			resp, err = client.R().Get(server.URL + "/binary")
+			require.NoError(t, err)
+			assert.Equal(t, []byte{0xff, 0x00, 0xfe}, resp.ReadAll())
+			resp.Close()
+
+			resp, err = client.R().SetBody(map[string]string{"name": "a"}).Post(server.URL + "/users")
+			require.NoError(t, err)
+			assert.Equal(t, "POST /users ", resp.ReadAllString())
+			resp.Close()
+
+			_, err = client.R().SetBody(map[string]string{"name": "b"}).Post(server.URL + "/users")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "no interaction recorded")
+			assert.Equal(t, int32(4), hits.Load())
+		})
+	}
+
+	t.Run("match_headers", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tenants.yaml")
+		recorder, err := mclient.NewRecorder(mclient.RecorderConfig{Path: path, Mode: mclient.RecordModeRecord})
+		require.NoError(t, err)
+		client := mclient.New().SetTransport(recorder)
+		for _, tenant := range []string{"a", "b"} {
+			resp, err := client.R().SetHeader("X-Tenant", tenant).Get(server.URL + "/tenant")
+			require.NoError(t, err)
+			resp.Close()
+		}
+
+		replayer, err := mclient.NewRecorder(mclient.RecorderConfig{Path: path, MatchHeaders: []string{"X-Tenant"}})
+		require.NoError(t, err)
+		client = mclient.New().SetTransport(replayer)
+		resp, err := client.R().SetHeader("X-Tenant", "b").Get(server.URL + "/tenant")
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.True(t, strings.HasSuffix(resp.ReadAllString(), " b"))
+
+		_, err = client.R().SetHeader("X-Tenant", "c").Get(server.URL + "/tenant")
+		assert.Error(t, err)
+	})
+
+	t.Run("passthrough", func(t *testing.T) {
+		hits.Store(0)
+		path := filepath.Join(t.TempDir(), "none.yaml")
+		recorder, err := mclient.NewRecorder(mclient.RecorderConfig{Path: path, Mode: mclient.RecordModePassthrough})
+		require.NoError(t, err)
+		resp, err := mclient.New().SetTransport(recorder).R().Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, int32(1), hits.Load())
+		assert.NoFileExists(t, path)
+	})
+
+	t.Run("missing_cassette", func(t *testing.T) {
+		_, err := mclient.NewRecorder(mclient.RecorderConfig{Path: filepath.Join(t.TempDir(), "missing.yaml")})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,155 @@
+package mclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryAfter(t *testing.T) {
+	newServer := func(t *testing.T, retryAfter string) (*httptest.Server, *atomic.Int32) {
+		t.Helper()
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if hits.Add(1) == 1 {
+				w.Header().Set("Retry-After", retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(server.Close)
+		return server, &hits
+	}
+	config := mclient.RetryConfig{Count: 2, BaseInterval: time.Millisecond}
+
+	t.Run("seconds_are_honored", func(t *testing.T) {
+		server, hits := newServer(t, "1")
+		start := time.Now()
+		resp, err := mclient.New().R().SetRetry(config).Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(2), hits.Load())
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+		attempts := resp.Attempts()
+		require.Len(t, attempts, 2)
+		assert.Equal(t, mclient.AttemptRetried, attempts[0].Outcome)
+		assert.Equal(t, http.StatusTooManyRequests, attempts[0].StatusCode)
+		assert.Equal(t, time.Second, attempts[0].Delay)
+		assert.Equal(t, mclient.AttemptDone, attempts[1].Outcome)
+	})
+
+	t.Run("too_long_is_not_retried", func(t *testing.T) {
+		server, hits := newServer(t, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		config := config
+		config.MaxRetryAfter = time.Minute
+		resp, err := mclient.New().R().SetRetry(config).Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, int32(1), hits.Load())
+		assert.Equal(t, mclient.AttemptRetryAfterTooLong, resp.Attempts()[0].Outcome)
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		server, _ := newServer(t, "60")
+		config := config
+		config.IgnoreRetryAfter = true
+		resp, err := mclient.New().R().SetRetry(config).Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("deadline_before_retry", func(t *testing.T) {
+		server, hits := newServer(t, "5")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := mclient.New().R().SetContext(ctx).SetRetry(config).Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		assert.Equal(t, int32(1), hits.Load())
+		assert.Equal(t, mclient.AttemptDeadline, resp.Attempts()[0].Outcome)
+	})
+}
+
+func TestRetryBudget(t *testing.T) {
+	server, hits := countingServer(t, http.StatusServiceUnavailable)
+	budget := mclient.NewRetryBudget(mclient.RetryBudgetConfig{Ratio: 0.5, MinRetriesPerSecond: -1, Burst: 2})
+	client := mclient.New().SetRetryBudget(budget)
+
+	// The burst allows two retries, then each request earns half a retry.
+	resp, err := client.R().SetRetrySimple(5, time.Millisecond).Get(server.URL)
+	require.NoError(t, err)
+	resp.Close()
+	assert.Equal(t, int32(3), hits.Load())
+	attempts := resp.Attempts()
+	require.Len(t, attempts, 3)
+	assert.Equal(t, mclient.AttemptBudgetExhausted, attempts[2].Outcome)
+
+	for i := 0; i < 2; i++ {
+		resp, err = client.R().SetRetrySimple(5, time.Millisecond).Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+	}
+	assert.Equal(t, int32(6), hits.Load())
+	assert.Less(t, budget.Tokens(), 1.0)
+}
+
+func TestAttemptDeadlines(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	t.Run("attempt_timeout", func(t *testing.T) {
+		hits.Store(0)
+		resp, err := mclient.New().R().
+			SetRetry(mclient.RetryConfig{Count: 1, AttemptTimeout: 50 * time.Millisecond}).
+			Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, "ok", resp.ReadAllString())
+		attempts := resp.Attempts()
+		require.Len(t, attempts, 2)
+		assert.ErrorIs(t, attempts[0].Err, context.DeadlineExceeded)
+		assert.Equal(t, mclient.AttemptRetried, attempts[0].Outcome)
+	})
+
+	t.Run("split_deadline", func(t *testing.T) {
+		hits.Store(0)
+		ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+		defer cancel()
+		resp, err := mclient.New().R().SetContext(ctx).
+			SetRetry(mclient.RetryConfig{Count: 1, SplitDeadline: true}).
+			Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, "ok", resp.ReadAllString())
+		assert.Less(t, resp.Attempts()[0].Duration, 300*time.Millisecond)
+	})
+
+	t.Run("exhausted_attempts_are_recorded", func(t *testing.T) {
+		server, _ := countingServer(t, http.StatusBadGateway)
+		req := mclient.New().R().SetRetrySimple(1, time.Millisecond)
+		resp, err := req.Get(server.URL)
+		require.NoError(t, err)
+		resp.Close()
+		require.Len(t, req.Attempts(), 2)
+		assert.Equal(t, mclient.AttemptExhausted, req.Attempts()[1].Outcome)
+	})
+}
@@ -0,0 +1,97 @@
+package mclient_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/graingo/maltose/net/msign"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nonceSet is an in-process msign.NonceStore for tests.
+type nonceSet struct {
+	mu     sync.Mutex
+	nonces map[string]bool
+}
+
+func (s *nonceSet) Claim(_ context.Context, nonce string, _ time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nonces[nonce] {
+		return false, nil
+	}
+	s.nonces[nonce] = true
+	return true, nil
+}
+
+func TestMiddlewareSign(t *testing.T) {
+	config := msign.HMACConfig{KeyID: "app", Secret: "secret"}
+
+	// signedServer verifies the requests, failing the first verified one with
+	// 503 Service Unavailable.
+	signedServer := func(t *testing.T, verifier msign.Verifier) (*httptest.Server, *atomic.Int32) {
+		t.Helper()
+		var verified atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			if err = verifier.Verify(r, body, time.Now()); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+			if verified.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write(body)
+		}))
+		t.Cleanup(server.Close)
+		return server, &verified
+	}
+
+	t.Run("signature_survives_retries", func(t *testing.T) {
+		config := config
+		config.NonceStore = &nonceSet{nonces: map[string]bool{}}
+		server, verified := signedServer(t, msign.NewHMACVerifier(config))
+		client := mclient.New().WithSigner(msign.NewHMACSigner(config))
+
+		resp, err := client.R().
+			SetRetrySimple(1, time.Millisecond).
+			SetBody(map[string]string{"id": "1"}).
+			Post(server.URL + "/orders?b=2&a=1")
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, resp.ReadAllString())
+		assert.JSONEq(t, `{"id":"1"}`, resp.ReadAllString())
+		assert.Equal(t, int32(2), verified.Load())
+	})
+
+	t.Run("form_body", func(t *testing.T) {
+		server, _ := signedServer(t, msign.NewHMACVerifier(config))
+		client := mclient.New().WithSigner(msign.NewHMACSigner(config))
+		resp, err := client.R().
+			SetRetrySimple(1, time.Millisecond).
+			SetFormMap(map[string]string{"name": "maltose"}).
+			Post(server.URL)
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, "name=maltose", resp.ReadAllString())
+	})
+
+	t.Run("unsigned_request_is_rejected", func(t *testing.T) {
+		server, _ := signedServer(t, msign.NewHMACVerifier(config))
+		resp, err := mclient.New().R().Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
@@ -0,0 +1,203 @@
+package mclient_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSSE(t *testing.T) {
+	config := mclient.SSEConfig{ReconnectDelay: time.Millisecond, MaxReconnectDelay: 5 * time.Millisecond}
+
+	t.Run("parses_events_and_resumes", func(t *testing.T) {
+		var (
+			connections atomic.Int32
+			lastIDs     = make(chan string, 10)
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lastIDs <- r.Header.Get("Last-Event-ID")
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			switch connections.Add(1) {
+			case 1:
+				_, _ = fmt.Fprint(w, ": welcome\nretry: 1\nid: 1\ndata: first\n\n")
+				_, _ = fmt.Fprint(w, "data: incomplete, discarded")
+			case 2:
+				_, _ = fmt.Fprint(w, "event: update\r\ndata: line 1\r\ndata:line 2\r\nid: 2\r\n\r\n")
+				_, _ = fmt.Fprint(w, "data: {}\n\n")
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}))
+		defer server.Close()
+
+		var events []mclient.SSEEvent
+		err := mclient.New().R().SSE(server.URL, func(event mclient.SSEEvent) error {
+			events = append(events, event)
+			return nil
+		}, config)
+		require.NoError(t, err)
+		assert.Equal(t, []mclient.SSEEvent{
+			{ID: "1", Event: "message", Data: "first", Retry: time.Millisecond},
+			{ID: "2", Event: "update", Data: "line 1\nline 2"},
+			{ID: "2", Event: "message", Data: "{}"},
+		}, events)
+		assert.Equal(t, "", <-lastIDs)
+		assert.Equal(t, "1", <-lastIDs)
+		assert.Equal(t, "2", <-lastIDs)
+	})
+
+	t.Run("handler_error_stops", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprint(w, "data: a\n\ndata: b\n\n")
+		}))
+		defer server.Close()
+
+		calls := 0
+		err := mclient.New().R().SSE(server.URL, func(mclient.SSEEvent) error {
+			calls++
+			return fmt.Errorf("stop")
+		}, config)
+		assert.EqualError(t, err, "stop")
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("terminal_errors", func(t *testing.T) {
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			if r.URL.Path == "/json" {
+				w.Header().Set("Content-Type", "application/json")
+				return
+			}
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		handler := func(mclient.SSEEvent) error { return nil }
+		assert.Error(t, mclient.New().R().SSE(server.URL+"/json", handler, config))
+		assert.Error(t, mclient.New().R().SSE(server.URL+"/forbidden", handler, config))
+		assert.Equal(t, int32(2), hits.Load())
+	})
+
+	t.Run("line_too_long", func(t *testing.T) {
+		var connections atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			connections.Add(1)
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprint(w, "data: short\n\ndata: "+strings.Repeat("x", 10000)+"\n\n")
+		}))
+		defer server.Close()
+
+		limited := config
+		limited.MaxLineSize = 1024
+		var events []mclient.SSEEvent
+		err := mclient.New().R().SSE(server.URL, func(event mclient.SSEEvent) error {
+			events = append(events, event)
+			return nil
+		}, limited)
+		assert.ErrorIs(t, err, mclient.ErrLineTooLong)
+		assert.Len(t, events, 1)
+		assert.Equal(t, int32(1), connections.Load(), "the line is not requested again")
+	})
+
+	t.Run("max_reconnects", func(t *testing.T) {
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			hits.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		c := config
+		c.MaxReconnects = 2
+		err := mclient.New().R().SSE(server.URL, func(mclient.SSEEvent) error { return nil }, c)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "after 2 reconnects")
+		assert.Equal(t, int32(3), hits.Load())
+	})
+
+	t.Run("stream_until_canceled", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 1; ; i++ {
+				if _, err := fmt.Fprintf(w, "id: %d\ndata: tick\n\n", i); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(time.Millisecond):
+				}
+			}
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stream := mclient.New().R().SetContext(ctx).SSEStream(server.URL, config)
+		var ids []string
+		for event := range stream.Events() {
+			ids = append(ids, event.ID)
+			if len(ids) == 3 {
+				cancel()
+			}
+		}
+		assert.Equal(t, []string{"1", "2", "3"}, ids[:3])
+		assert.ErrorIs(t, stream.Err(), context.Canceled)
+	})
+
+	t.Run("connections_share_trace", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		previous := otel.GetTracerProvider()
+		otel.SetTracerProvider(provider)
+		defer otel.SetTracerProvider(previous)
+
+		var connections atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if connections.Add(1) > 2 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprint(w, "data: x\n\n")
+		}))
+		defer server.Close()
+
+		err := mclient.New().R().SSE(server.URL, func(mclient.SSEEvent) error { return nil }, config)
+		require.NoError(t, err)
+
+		var (
+			subscription sdktrace.ReadOnlySpan
+			requests     []sdktrace.ReadOnlySpan
+		)
+		for _, span := range recorder.Ended() {
+			switch span.Name() {
+			case "SSE GET":
+				subscription = span
+			case "HTTP GET":
+				requests = append(requests, span)
+			}
+		}
+		require.NotNil(t, subscription)
+		require.Len(t, requests, 3)
+		for _, span := range requests {
+			assert.Equal(t, subscription.SpanContext().SpanID(), span.Parent().SpanID())
+		}
+		assert.Len(t, subscription.Events(), 2)
+	})
+}
@@ -0,0 +1,291 @@
+package mclient_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/graingo/maltose/os/mlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"id":1}`), 0o644))
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		file, header, err := r.FormFile("file")
+		require.NoError(t, err)
+		defer file.Close()
+		content, _ := io.ReadAll(file)
+		if hits.Add(1) == 1 && r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s|%s|%s|%s", r.FormValue("kind"), header.Filename, header.Header.Get("Content-Type"), content)
+	}))
+	defer server.Close()
+
+	t.Run("file_is_reopened_for_retries", func(t *testing.T) {
+		hits.Store(0)
+		resp, err := mclient.New().R().
+			SetRetrySimple(1, time.Millisecond).
+			SetForm("kind", "daily").
+			SetFile("file", path).
+			Post(server.URL + "?fail=1")
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, `daily|report.json|application/json|{"id":1}`, resp.ReadAllString())
+		assert.Equal(t, int32(2), hits.Load())
+	})
+
+	t.Run("stream_is_sent_once", func(t *testing.T) {
+		hits.Store(0)
+		resp, err := mclient.New().R().
+			SetFileReader("file", "data.bin", strings.NewReader("payload")).
+			Post(server.URL)
+		require.NoError(t, err)
+		defer resp.Close()
+		assert.Equal(t, "|data.bin|application/octet-stream|payload", resp.ReadAllString())
+
+		hits.Store(0)
+		_, err = mclient.New().R().
+			SetRetrySimple(1, time.Millisecond).
+			SetFileReader("file", "data.bin", strings.NewReader("payload")).
+			Post(server.URL + "?fail=1")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cannot be replayed")
+	})
+
+	t.Run("missing_file", func(t *testing.T) {
+		_, err := mclient.New().R().SetFile("file", filepath.Join(t.TempDir(), "missing")).Post(server.URL)
+		assert.Error(t, err)
+	})
+}
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	changedContent := bytes.Repeat([]byte("abcdefghij"), 10000)
+	var (
+		interrupt atomic.Bool
+		change    atomic.Bool
+		changed   atomic.Bool
+		ranges    = make(chan string, 10)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges <- r.Header.Get("Range")
+		body, etag := content, `"v1"`
+		if changed.Load() {
+			body, etag = changedContent, `"v2"`
+		}
+		w.Header().Set("ETag", etag)
+		if interrupt.CompareAndSwap(true, false) {
+			// Announce the whole body but stop halfway.
+			w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+			_, _ = w.Write(body[:len(body)/2])
+			changed.Store(change.Load())
+			return
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(body))
+	}))
+	defer server.Close()
+	drain := func() []string {
+		var seen []string
+		for len(ranges) > 0 {
+			seen = append(seen, <-ranges)
+		}
+		return seen
+	}
+
+	t.Run("to_file_with_progress", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.bin")
+		var last, total int64
+		_, err := mclient.New().R().SetDownloadProgress(func(written, size int64) {
+			last, total = written, size
+		}).Download(server.URL, path)
+		require.NoError(t, err)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, content, data)
+		assert.Equal(t, int64(len(content)), last)
+		assert.Equal(t, int64(len(content)), total)
+		assert.NoFileExists(t, path+".part")
+		drain()
+	})
+
+	t.Run("resumes_partial_file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.bin")
+		require.NoError(t, os.WriteFile(path+".part", content[:1234], 0o644))
+		require.NoError(t, os.WriteFile(path+".part.validator", []byte(`"v1"`), 0o644))
+		_, err := mclient.New().R().Download(server.URL, path)
+		require.NoError(t, err)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, content, data)
+		assert.Equal(t, []string{"bytes=1234-"}, drain())
+		assert.NoFileExists(t, path+".part.validator")
+	})
+
+	t.Run("restarts_changed_partial_file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.bin")
+		require.NoError(t, os.WriteFile(path+".part", content[:1234], 0o644))
+		require.NoError(t, os.WriteFile(path+".part.validator", []byte(`"v0"`), 0o644))
+		_, err := mclient.New().R().Download(server.URL, path)
+		require.NoError(t, err)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, content, data)
+		assert.Equal(t, []string{"bytes=1234-"}, drain(), "If-Range does not match, so the whole body is sent")
+	})
+
+	t.Run("restarts_partial_file_without_validator", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.bin")
+		require.NoError(t, os.WriteFile(path+".part", changedContent[:1234], 0o644))
+		_, err := mclient.New().R().Download(server.URL, path)
+		require.NoError(t, err)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, content, data)
+		assert.Equal(t, []string{""}, drain())
+	})
+
+	t.Run("restarts_when_content_changes_between_attempts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.bin")
+		interrupt.Store(true)
+		change.Store(true)
+		defer func() {
+			change.Store(false)
+			changed.Store(false)
+		}()
+		_, err := mclient.New().R().SetRetrySimple(1, time.Millisecond).Download(server.URL, path)
+		require.NoError(t, err)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, changedContent, data)
+		assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(content)/2)}, drain())
+		assert.NoFileExists(t, path+".part.validator")
+
+		// A writer that cannot be reset fails instead of mixing both versions.
+		changed.Store(false)
+		interrupt.Store(true)
+		var buf bytes.Buffer
+		_, err = mclient.New().R().SetRetrySimple(1, time.Millisecond).DownloadTo(server.URL, &buf)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cannot be reset")
+		drain()
+	})
+
+	t.Run("complete_partial_file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.bin")
+		require.NoError(t, os.WriteFile(path+".part", content, 0o644))
+		require.NoError(t, os.WriteFile(path+".part.validator", []byte(`"v1"`), 0o644))
+		_, err := mclient.New().R().Download(server.URL, path)
+		require.NoError(t, err)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, content, data)
+		assert.Equal(t, []string{fmt.Sprintf("bytes=%d-", len(content))}, drain())
+	})
+
+	t.Run("resumes_interrupted_transfer", func(t *testing.T) {
+		interrupt.Store(true)
+		var buf bytes.Buffer
+		_, err := mclient.New().R().SetRetrySimple(1, time.Millisecond).DownloadTo(server.URL, &buf)
+		require.NoError(t, err)
+		assert.Equal(t, content, buf.Bytes())
+		assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(content)/2)}, drain())
+
+		interrupt.Store(true)
+		buf.Reset()
+		_, err = mclient.New().R().DownloadTo(server.URL, &buf)
+		assert.Error(t, err, "no retries left")
+		drain()
+	})
+
+	t.Run("error_status", func(t *testing.T) {
+		notFound := httptest.NewServer(http.NotFoundHandler())
+		defer notFound.Close()
+		_, err := mclient.New().R().DownloadTo(notFound.URL, io.Discard)
+		assert.Error(t, err)
+	})
+}
+
+func TestResponseStream(t *testing.T) {
+	next := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = w.Write([]byte(`{"id":1,"name":"a"}` + "\n"))
+		w.(http.Flusher).Flush()
+		// The second event is only sent once the client has seen the first one.
+		<-next
+		_, _ = w.Write([]byte("\r\n" + `{"id":2,"name":"b"}` + "\r\n"))
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	logger := mlog.New(&mlog.Config{Writer: &logs, Level: mlog.DebugLevel, Format: "json"})
+	config := mclient.DefaultLogConfig()
+	config.Body.MaxSize = -1
+	client := mclient.New().Use(mclient.MiddlewareLogWithConfig(logger, config))
+
+	resp, err := client.R().Stream().Get(server.URL)
+	require.NoError(t, err)
+
+	type event struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	var events []event
+	err = mclient.ReadNDJSON(resp, func(e event) error {
+		events = append(events, e)
+		if e.ID == 1 {
+			close(next)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []event{{1, "a"}, {2, "b"}}, events)
+	assert.NotContains(t, logs.String(), "response_body")
+}
+
+func TestResponseStreamLineLimit(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("short\n" + long + "\r\n"))
+	}))
+	defer server.Close()
+
+	read := func(maxSize int) ([]string, error) {
+		resp, err := mclient.New().R().Stream().Get(server.URL)
+		require.NoError(t, err)
+		var lines []string
+		err = resp.ReadLinesLimit(maxSize, func(line []byte) error {
+			lines = append(lines, string(line))
+			return nil
+		})
+		return lines, err
+	}
+
+	lines, err := read(0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"short", long}, lines)
+
+	lines, err = read(len(long))
+	require.NoError(t, err, "the terminator does not count")
+	assert.Equal(t, []string{"short", long}, lines)
+
+	lines, err = read(len(long) - 1)
+	assert.ErrorIs(t, err, mclient.ErrLineTooLong)
+	assert.Equal(t, []string{"short"}, lines)
+
+	lines, err = read(3)
+	assert.ErrorIs(t, err, mclient.ErrLineTooLong)
+	assert.Empty(t, lines)
+}
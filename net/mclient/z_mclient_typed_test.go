@@ -0,0 +1,115 @@
+package mclient_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type typedUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type typedAPIError struct {
+	Reason string `json:"reason"`
+}
+
+func (e *typedAPIError) Error() string { return e.Reason }
+
+func TestTypedHelpers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users/1":
+			_, _ = w.Write([]byte(`{"id":1,"name":"alice"}`))
+		case "/users":
+			var user typedUser
+			_ = json.NewDecoder(r.Body).Decode(&user)
+			user.ID = 2
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(user)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"reason":"no such user"}`))
+		case "/envelope/users/1":
+			_, _ = w.Write([]byte(`{"code":0,"message":"OK","data":{"id":1,"name":"alice"}}`))
+		case "/envelope/missing":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code":1004,"message":"user 3 not found","data":null}`))
+		case "/envelope/business":
+			_, _ = w.Write([]byte(`{"code":7001,"message":"insufficient balance","data":null}`))
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, "boom")
+		}
+	}))
+	defer server.Close()
+
+	client := mclient.New().SetConfig(mclient.ClientConfig{BaseURL: server.URL})
+
+	t.Run("get_and_post", func(t *testing.T) {
+		user, err := mclient.GetJSON[typedUser](client.R(), "/users/1")
+		require.NoError(t, err)
+		assert.Equal(t, typedUser{ID: 1, Name: "alice"}, user)
+
+		created, err := mclient.PostJSON[typedUser, *typedUser](client.R(), "/users", typedUser{Name: "bob"})
+		require.NoError(t, err)
+		assert.Equal(t, &typedUser{ID: 2, Name: "bob"}, created)
+
+		_, err = mclient.DeleteJSON[struct{}](client.R(), "/empty")
+		assert.NoError(t, err)
+	})
+
+	t.Run("default_error", func(t *testing.T) {
+		_, err := mclient.GetJSON[typedUser](client.R(), "/missing")
+		require.Error(t, err)
+		assert.Equal(t, mcode.CodeNotFound, merror.Code(err))
+		assert.Contains(t, err.Error(), "no such user")
+
+		_, err = mclient.GetJSON[typedUser](client.R(), "/other")
+		assert.Equal(t, mcode.CodeInternalError, merror.Code(err))
+	})
+
+	t.Run("error_decoder", func(t *testing.T) {
+		client := client.Clone().SetErrorDecoder(mclient.JSONErrorDecoder[typedAPIError]())
+		_, err := mclient.GetJSON[typedUser](client.R(), "/missing")
+		var apiErr *typedAPIError
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, "no such user", apiErr.Reason)
+		assert.Equal(t, mcode.CodeNotFound, merror.Code(err))
+	})
+
+	t.Run("envelope", func(t *testing.T) {
+		client := client.Clone().SetUnwrapEnvelope(true)
+		user, err := mclient.GetJSON[typedUser](client.R(), "/envelope/users/1")
+		require.NoError(t, err)
+		assert.Equal(t, "alice", user.Name)
+
+		_, err = mclient.GetJSON[typedUser](client.R(), "/envelope/missing")
+		require.Error(t, err)
+		assert.Equal(t, mcode.CodeNotFound, merror.Code(err))
+		assert.Equal(t, "user 3 not found", err.Error())
+
+		_, err = mclient.GetJSON[typedUser](client.R(), "/envelope/business")
+		require.Error(t, err)
+		assert.Equal(t, 7001, merror.Code(err).Code())
+		assert.Equal(t, "insufficient balance", err.Error())
+
+		// The envelope can be disabled per request.
+		raw, err := mclient.GetJSON[map[string]any](client.R().SetUnwrapEnvelope(false), "/envelope/users/1")
+		require.NoError(t, err)
+		assert.Equal(t, "OK", raw["message"])
+	})
+}
@@ -2,13 +2,18 @@ package mhttp
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/graingo/maltose/util/mmeta"
 )
 
+var metaType = reflect.TypeOf(mmeta.Meta{})
+
 // schemaBuilder is a helper for building OpenAPI schemas at runtime.
 type schemaBuilder struct {
 	spec *openapi3.T
@@ -126,12 +131,27 @@ func (s *Server) initOpenAPI(_ context.Context) {
 
 func (b *schemaBuilder) createParameters(reqType reflect.Type) openapi3.Parameters {
 	params := openapi3.NewParameters()
+	if reqType.Kind() == reflect.Pointer {
+		reqType = reqType.Elem()
+	}
 	if reqType.Kind() != reflect.Struct {
 		return params
 	}
+	return b.appendParameters(params, reqType)
+}
+
+func (b *schemaBuilder) appendParameters(params openapi3.Parameters, reqType reflect.Type) openapi3.Parameters {
 	for i := 0; i < reqType.NumField(); i++ {
 		field := reqType.Field(i)
-		if field.Anonymous { // Skip embedded structs like m.Meta
+		if documenter, ok := reflect.New(field.Type).Interface().(listParameterDocumenter); ok {
+			params = append(params, &openapi3.ParameterRef{Value: documenter.listParameter(field)})
+			continue
+		}
+		if field.Anonymous {
+			// Skip m.Meta, but document embedded list components like PageReq.
+			if field.Type != metaType && field.Type.Kind() == reflect.Struct {
+				params = b.appendParameters(params, field.Type)
+			}
 			continue
 		}
 
@@ -139,13 +159,9 @@ func (b *schemaBuilder) createParameters(reqType reflect.Type) openapi3.Paramete
 		if schema.Value == nil {
 			continue
 		}
+		applyBindingLimits(schema.Value, field.Tag.Get("binding"))
 
-		paramName := field.Tag.Get("form")
-		if paramName == "" {
-			paramName = field.Name
-		}
-
-		param := openapi3.NewQueryParameter(paramName).
+		param := openapi3.NewQueryParameter(formName(field)).
 			WithSchema(schema.Value).
 			WithDescription(field.Tag.Get("dc"))
 
@@ -158,6 +174,66 @@ func (b *schemaBuilder) createParameters(reqType reflect.Type) openapi3.Paramete
 	return params
 }
 
+// listParameterDocumenter is implemented by list request components whose
+// query parameter depends on the tags of the field holding them.
+type listParameterDocumenter interface {
+	listParameter(field reflect.StructField) *openapi3.Parameter
+}
+
+func (f *Filter[T]) listParameter(field reflect.StructField) *openapi3.Parameter {
+	var zero T
+	valueType := reflect.TypeOf(zero).Kind().String()
+	if _, ok := any(zero).(time.Time); ok {
+		valueType = "time (RFC 3339 or 2006-01-02)"
+	}
+	ops := allowedFilterOps[T](field.Tag.Get("filter"))
+	names := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = string(op)
+	}
+	description := fmt.Sprintf("Filter in the form [op:]value, repeatable. Operators: %s. Value type: %s.",
+		strings.Join(names, ", "), valueType)
+	if dc := field.Tag.Get("dc"); dc != "" {
+		description = dc + ". " + description
+	}
+	schema := openapi3.NewArraySchema().WithItems(openapi3.NewStringSchema())
+	return openapi3.NewQueryParameter(formName(field)).WithSchema(schema).WithDescription(description)
+}
+
+func (s *SortReq) listParameter(field reflect.StructField) *openapi3.Parameter {
+	sortField, _ := reflect.TypeOf(s).Elem().FieldByName("Sort")
+	description := sortField.Tag.Get("dc") + ". Allowed fields: " +
+		strings.Join(sortFieldNames(parseSortFields(field.Tag.Get("sort"))), ", ") + "."
+	schema := openapi3.NewStringSchema()
+	if def := field.Tag.Get("default"); def != "" {
+		schema.Default = def
+	}
+	return openapi3.NewQueryParameter(formName(sortField)).WithSchema(schema).WithDescription(description)
+}
+
+// applyBindingLimits documents the min and max rules of a numeric field.
+func applyBindingLimits(schema *openapi3.Schema, binding string) {
+	if !schema.Type.Is(openapi3.TypeInteger) && !schema.Type.Is(openapi3.TypeNumber) {
+		return
+	}
+	for _, rule := range strings.Split(binding, ",") {
+		name, value, found := strings.Cut(rule, "=")
+		if !found {
+			continue
+		}
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch name {
+		case "min", "gte":
+			schema.Min = &limit
+		case "max", "lte":
+			schema.Max = &limit
+		}
+	}
+}
+
 func (b *schemaBuilder) typeToSchema(p reflect.Type) *openapi3.SchemaRef {
 	if p == nil {
 		return &openapi3.SchemaRef{Value: openapi3.NewObjectSchema()}
@@ -194,28 +270,11 @@ func (b *schemaBuilder) typeToSchema(p reflect.Type) *openapi3.SchemaRef {
 	}
 	if p.Kind() == reflect.Struct {
 		// Handle custom struct types by creating a component schema
-		cleanTypeName := p.Name()
+		cleanTypeName := schemaName(p)
 		if cleanTypeName == "" {
 			// For anonymous structs, create inline schema
 			schema := openapi3.NewObjectSchema()
-			for i := 0; i < p.NumField(); i++ {
-				field := p.Field(i)
-				if field.Anonymous { // Skip embedded structs like m.Meta
-					continue
-				}
-
-				jsonTag := field.Tag.Get("json")
-				if jsonTag == "" || jsonTag == "-" {
-					continue
-				}
-				jsonName := strings.Split(jsonTag, ",")[0]
-
-				fieldSchemaRef := b.typeToSchema(field.Type)
-				if field.Tag.Get("dc") != "" && fieldSchemaRef.Value != nil {
-					fieldSchemaRef.Value.Description = field.Tag.Get("dc")
-				}
-				schema.Properties[jsonName] = fieldSchemaRef
-			}
+			b.addProperties(schema, p)
 			return &openapi3.SchemaRef{Value: schema}
 		}
 
@@ -225,24 +284,7 @@ func (b *schemaBuilder) typeToSchema(p reflect.Type) *openapi3.SchemaRef {
 			b.spec.Components.Schemas[cleanTypeName] = &openapi3.SchemaRef{Value: openapi3.NewObjectSchema()}
 			// Build the full schema for the struct.
 			schema := openapi3.NewObjectSchema()
-			for i := 0; i < p.NumField(); i++ {
-				field := p.Field(i)
-				if field.Anonymous { // Skip embedded structs like m.Meta
-					continue
-				}
-
-				jsonTag := field.Tag.Get("json")
-				if jsonTag == "" || jsonTag == "-" {
-					continue
-				}
-				jsonName := strings.Split(jsonTag, ",")[0]
-
-				fieldSchemaRef := b.typeToSchema(field.Type)
-				if field.Tag.Get("dc") != "" && fieldSchemaRef.Value != nil {
-					fieldSchemaRef.Value.Description = field.Tag.Get("dc")
-				}
-				schema.Properties[jsonName] = fieldSchemaRef
-			}
+			b.addProperties(schema, p)
 
 			// Replace the placeholder with the fully constructed schema.
 			b.spec.Components.Schemas[cleanTypeName] = &openapi3.SchemaRef{Value: schema}
@@ -254,6 +296,51 @@ func (b *schemaBuilder) typeToSchema(p reflect.Type) *openapi3.SchemaRef {
 	return &openapi3.SchemaRef{Value: openapi3.NewObjectSchema()}
 }
 
+// addProperties adds the JSON fields of struct type p to schema. Embedded
+// structs other than m.Meta, such as PageRes, are flattened like encoding/json does.
+func (b *schemaBuilder) addProperties(schema *openapi3.Schema, p reflect.Type) {
+	for i := 0; i < p.NumField(); i++ {
+		field := p.Field(i)
+		if field.Anonymous {
+			if field.Type != metaType && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+				b.addProperties(schema, field.Type)
+			}
+			continue
+		}
+
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "" || jsonTag == "-" {
+			continue
+		}
+		jsonName := strings.Split(jsonTag, ",")[0]
+
+		fieldSchemaRef := b.typeToSchema(field.Type)
+		if field.Tag.Get("dc") != "" && fieldSchemaRef.Value != nil {
+			fieldSchemaRef.Value.Description = field.Tag.Get("dc")
+		}
+		schema.Properties[jsonName] = fieldSchemaRef
+	}
+}
+
+// schemaName returns the component name of a struct type. Instantiated
+// generic types like PageRes[pkg.User] are named PageResUser.
+func schemaName(p reflect.Type) string {
+	name := p.Name()
+	open := strings.IndexByte(name, '[')
+	if open < 0 {
+		return name
+	}
+	var builder strings.Builder
+	builder.WriteString(name[:open])
+	for _, arg := range strings.Split(strings.TrimSuffix(name[open+1:], "]"), ",") {
+		if dot := strings.LastIndexByte(arg, '.'); dot >= 0 {
+			arg = arg[dot+1:]
+		}
+		builder.WriteString(strings.Trim(arg, "*[] "))
+	}
+	return builder.String()
+}
+
 // openapiHandler handles OpenAPI requests.
 func (s *Server) openapiHandler(r *Request) {
 	if s.openapi == nil {
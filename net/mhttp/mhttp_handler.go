@@ -30,6 +30,14 @@ func handleValidationErrors(r *Request, err error) error {
 
 // handleRequest handles the request and returns the result.
 func handleRequest(r *Request, method reflect.Method, val reflect.Value, req interface{}) error {
+	if err := bindRequest(r, req); err != nil {
+		return err
+	}
+	return callHandler(r, method, val, req)
+}
+
+// bindRequest binds and validates the request parameters.
+func bindRequest(r *Request, req interface{}) error {
 	// Parameter binding from URI. We can ignore the error here because
 	// not all requests have URI parameters. The main validation for body,
 	// query, etc., is handled by ShouldBind below.
@@ -40,6 +48,12 @@ func handleRequest(r *Request, method reflect.Method, val reflect.Value, req int
 		return handleValidationErrors(r, err)
 	}
 
+	// resolve pagination, sort and filter components of list requests.
+	return BindList(r.Request.URL.Query(), req)
+}
+
+// callHandler calls the controller method and stores its response.
+func callHandler(r *Request, method reflect.Method, val reflect.Value, req interface{}) error {
 	// call method
 	results := method.Func.Call([]reflect.Value{
 		val,
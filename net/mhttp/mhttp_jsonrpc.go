@@ -0,0 +1,331 @@
+package mhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/mtrace"
+	"github.com/graingo/maltose/util/mmeta"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+)
+
+const (
+	jsonrpcVersion = "2.0"
+	// jsonrpcMaxBatch limits the number of calls in a batch.
+	jsonrpcMaxBatch = 100
+)
+
+// JSONRPCError is the error object of a JSON-RPC response.
+type JSONRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// jsonrpcRequest is a JSON-RPC request or notification.
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	// ID is nil for notifications, which must not be answered.
+	ID json.RawMessage `json:"id"`
+}
+
+// jsonrpcResponse is a JSON-RPC response.
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// jsonrpcMethod is a controller method exposed over JSON-RPC.
+type jsonrpcMethod struct {
+	method  reflect.Method
+	val     reflect.Value
+	reqElem reflect.Type
+}
+
+// jsonrpcEndpoint serves the JSON-RPC methods registered at one path.
+type jsonrpcEndpoint struct {
+	methods map[string]jsonrpcMethod
+}
+
+// JSONRPC registers a JSON-RPC 2.0 endpoint at path that serves the methods of
+// the given controllers, which are the same controllers accepted by Bind.
+// A method is called by the rpc tag of its request metadata, or by
+// "Controller.Method" when the tag is omitted:
+//
+//	type GetUserReq struct {
+//		m.Meta `path:"/user" method:"get" rpc:"user.get"`
+//		ID     int `json:"id" binding:"required"`
+//	}
+//
+// Params must be an object and are bound and validated like a JSON request
+// body. Batch calls and notifications are supported, and merror codes are
+// mapped to JSON-RPC error objects.
+func (rg *RouterGroup) JSONRPC(path string, object ...any) *RouterGroup {
+	endpoint := &jsonrpcEndpoint{methods: make(map[string]jsonrpcMethod)}
+	for _, o := range object {
+		endpoint.register(rg.server, o)
+	}
+	rg.addRouteWithMiddlewares(http.MethodPost, path, endpoint.handle)
+	return rg
+}
+
+// register adds the controller methods of object.
+func (e *jsonrpcEndpoint) register(s *Server, object any) {
+	typ := reflect.TypeOf(object)
+	val := reflect.ValueOf(object)
+
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+
+		// check method signature
+		if err := checkMethodSignature(method.Type); err != nil {
+			s.logger().Warnf(context.Background(),
+				"method [%s.%s] ignored, %s",
+				typ.String(), method.Name, err.Error(),
+			)
+			continue
+		}
+
+		reqElem := method.Type.In(2).Elem()
+		name := mmeta.Get(reflect.New(reqElem).Interface(), "rpc").String()
+		if name == "" {
+			name = reflect.Indirect(val).Type().Name() + "." + method.Name
+		}
+		if _, ok := e.methods[name]; ok {
+			s.logger().Warnf(context.Background(),
+				"method [%s.%s] ignored, json-rpc method %s is already registered",
+				typ.String(), method.Name, name,
+			)
+			continue
+		}
+		e.methods[name] = jsonrpcMethod{method: method, val: val, reqElem: reqElem}
+	}
+}
+
+// handle serves a single call or a batch of calls.
+func (e *jsonrpcEndpoint) handle(r *Request) {
+	body, err := io.ReadAll(r.Request.Body)
+	if err != nil {
+		r.JSON(http.StatusOK, newJSONRPCErrorResponse(nil, JSONRPCParseError, err.Error()))
+		return
+	}
+	body = bytes.TrimSpace(body)
+
+	// single call
+	if len(body) == 0 || body[0] != '[' {
+		if !json.Valid(body) {
+			r.JSON(http.StatusOK, newJSONRPCErrorResponse(nil, JSONRPCParseError, "Parse error"))
+			return
+		}
+		if res := e.call(r, body); res != nil {
+			r.JSON(http.StatusOK, res)
+			return
+		}
+		r.noContent()
+		return
+	}
+
+	// batch call
+	var calls []json.RawMessage
+	if err := json.Unmarshal(body, &calls); err != nil {
+		r.JSON(http.StatusOK, newJSONRPCErrorResponse(nil, JSONRPCParseError, "Parse error"))
+		return
+	}
+	switch {
+	case len(calls) == 0:
+		r.JSON(http.StatusOK, newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request: empty batch"))
+		return
+	case len(calls) > jsonrpcMaxBatch:
+		r.JSON(http.StatusOK, newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest,
+			fmt.Sprintf("Invalid Request: batch exceeds %d calls", jsonrpcMaxBatch)))
+		return
+	}
+	responses := make([]*jsonrpcResponse, 0, len(calls))
+	for _, raw := range calls {
+		if res := e.call(r, raw); res != nil {
+			responses = append(responses, res)
+		}
+	}
+	if len(responses) == 0 {
+		r.noContent()
+		return
+	}
+	r.JSON(http.StatusOK, responses)
+}
+
+// call executes one call in its own span and returns its response,
+// or nil for a notification.
+func (e *jsonrpcEndpoint) call(r *Request, raw json.RawMessage) (res *jsonrpcResponse) {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || !validJSONRPCID(req.ID) {
+		return newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request")
+	}
+	if req.JSONRPC != jsonrpcVersion || req.Method == "" {
+		return e.respond(req, newJSONRPCErrorResponse(req.ID, JSONRPCInvalidRequest, "Invalid Request"))
+	}
+
+	tr := otel.GetTracerProvider().Tracer(
+		instrumentName,
+		trace.WithInstrumentationVersion(version),
+	)
+	ctx, span := tr.Start(r.Request.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String(mtrace.AttributeRPCSystem, "jsonrpc"),
+			attribute.String(mtrace.AttributeRPCMethod, req.Method),
+			attribute.String(mtrace.AttributeRPCJSONRPCVersion, jsonrpcVersion),
+		),
+	)
+	if req.ID != nil {
+		span.SetAttributes(attribute.String(mtrace.AttributeRPCJSONRPCRequestID, strings.Trim(string(req.ID), `"`)))
+	}
+	defer span.End()
+
+	method, ok := e.methods[req.Method]
+	if ok {
+		res = e.invoke(r, ctx, method, req)
+	} else {
+		res = newJSONRPCErrorResponse(req.ID, JSONRPCMethodNotFound, "Method not found")
+	}
+	if res.Error != nil {
+		span.SetAttributes(
+			attribute.Int(mtrace.AttributeRPCJSONRPCErrorCode, res.Error.Code),
+			attribute.String(mtrace.AttributeRPCJSONRPCErrorMessage, res.Error.Message),
+		)
+		span.SetStatus(codes.Error, res.Error.Message)
+	}
+	return e.respond(req, res)
+}
+
+// invoke binds the params and calls the controller method.
+func (e *jsonrpcEndpoint) invoke(r *Request, ctx context.Context, method jsonrpcMethod, req jsonrpcRequest) (res *jsonrpcResponse) {
+	params := []byte(req.Params)
+	switch {
+	case len(params) == 0 || string(params) == "null":
+		params = []byte("{}")
+	case params[0] != '{':
+		return newJSONRPCErrorResponse(req.ID, JSONRPCInvalidParams, "Invalid params: params must be an object")
+	}
+
+	defer func() {
+		if exception := recover(); exception != nil {
+			err := merror.NewCodef(mcode.CodeInternalPanic, "Panic recovered: %v", exception)
+			r.Logger().Errorf(ctx, err, "Panic recovered in json-rpc method %s", req.Method)
+			res = newJSONRPCErrorResponse(req.ID, JSONRPCInternalError, err.Error())
+		}
+	}()
+
+	callReq := newJSONRPCCallRequest(r, ctx, params)
+	input := reflect.New(method.reqElem).Interface()
+	if err := bindRequest(callReq, input); err != nil {
+		if merror.Code(err) == mcode.CodeNil {
+			err = merror.WrapCode(err, mcode.CodeValidationFailed)
+		}
+		return &jsonrpcResponse{JSONRPC: jsonrpcVersion, Error: jsonrpcErrorFromError(err), ID: req.ID}
+	}
+	if err := callHandler(callReq, method.method, method.val, input); err != nil {
+		return &jsonrpcResponse{JSONRPC: jsonrpcVersion, Error: jsonrpcErrorFromError(err), ID: req.ID}
+	}
+
+	result, err := json.Marshal(callReq.GetHandlerResponse())
+	if err != nil {
+		return newJSONRPCErrorResponse(req.ID, JSONRPCInternalError, err.Error())
+	}
+	return &jsonrpcResponse{JSONRPC: jsonrpcVersion, Result: result, ID: req.ID}
+}
+
+// respond drops the response of a notification.
+func (e *jsonrpcEndpoint) respond(req jsonrpcRequest, res *jsonrpcResponse) *jsonrpcResponse {
+	if req.ID == nil {
+		return nil
+	}
+	return res
+}
+
+// noContent answers a request that only carried notifications.
+func (r *Request) noContent() {
+	r.Status(http.StatusNoContent)
+	r.Writer.WriteHeaderNow()
+}
+
+// newJSONRPCCallRequest creates the Request of a single call, which carries
+// the params as a JSON body so the regular request binding applies.
+func newJSONRPCCallRequest(r *Request, ctx context.Context, params []byte) *Request {
+	callReq := &Request{Context: r.Context.Copy(), server: r.server}
+	httpReq := r.Request.Clone(context.WithValue(ctx, requestKey, callReq))
+	httpReq.Method = http.MethodPost
+	httpReq.URL.RawQuery = ""
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Body = io.NopCloser(bytes.NewReader(params))
+	httpReq.ContentLength = int64(len(params))
+	callReq.Request = httpReq
+	return callReq
+}
+
+// jsonrpcErrorFromError maps an merror code to a JSON-RPC error object.
+// Codes without a JSON-RPC equivalent are passed through as application errors.
+func jsonrpcErrorFromError(err error) *JSONRPCError {
+	code := merror.Code(err)
+	jsonErr := &JSONRPCError{Code: code.Code(), Message: err.Error(), Data: code.Detail()}
+	switch code.Code() {
+	case mcode.CodeNil.Code(), mcode.CodeInternalError.Code(), mcode.CodeInternalPanic.Code():
+		jsonErr.Code = JSONRPCInternalError
+	case mcode.CodeValidationFailed.Code(), mcode.CodeInvalidParameter.Code(), mcode.CodeMissingParameter.Code():
+		jsonErr.Code = JSONRPCInvalidParams
+	case mcode.CodeInvalidRequest.Code():
+		jsonErr.Code = JSONRPCInvalidRequest
+	}
+	return jsonErr
+}
+
+func newJSONRPCErrorResponse(id json.RawMessage, code int, message string) *jsonrpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &jsonrpcResponse{
+		JSONRPC: jsonrpcVersion,
+		Error:   &JSONRPCError{Code: code, Message: message},
+		ID:      id,
+	}
+}
+
+// validJSONRPCID reports whether id is absent, null, a string or a number.
+func validJSONRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	var value any
+	if err := json.Unmarshal(id, &value); err != nil {
+		return false
+	}
+	switch value.(type) {
+	case nil, string, float64:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,530 @@
+package mhttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+)
+
+const (
+	// DefaultPageSize is the page size used when a list request does not specify one.
+	DefaultPageSize = 20
+	// MaxPageSize is the largest page size accepted by PageReq and CursorReq.
+	MaxPageSize = 100
+	// maxFilterValues limits the number of values of an "in" or "nin" filter.
+	maxFilterValues = 100
+	// defaultCursorKey is the cursor key column used when CursorReq has no cursor tag.
+	defaultCursorKey = "id"
+)
+
+// PageReq is embedded in list requests for offset pagination with the
+// "page" and "size" query parameters.
+type PageReq struct {
+	Page int `form:"page" json:"page" binding:"omitempty,min=1" dc:"Page number, starting from 1"`
+	Size int `form:"size" json:"size" binding:"omitempty,min=1,max=100" dc:"Number of items per page"`
+}
+
+// Offset returns the number of items to skip.
+func (p PageReq) Offset() int {
+	return (p.page() - 1) * p.Limit()
+}
+
+// Limit returns the page size, falling back to DefaultPageSize.
+func (p PageReq) Limit() int {
+	return pageSize(p.Size)
+}
+
+func (p *PageReq) bindList(reflect.StructField, url.Values) error {
+	return nil
+}
+
+func (p PageReq) page() int {
+	if p.Page < 1 {
+		return 1
+	}
+	return p.Page
+}
+
+// CursorReq is embedded in list requests for keyset pagination with the
+// "cursor" and "limit" query parameters. Items are ordered by a unique key
+// column, "id" by default; set it with a cursor tag on the embedded field,
+// prefixing the column with "-" for descending order:
+//
+//	mhttp.CursorReq `cursor:"-id"`
+//
+// Cursor pagination orders by the key only and is not combined with SortReq.
+type CursorReq struct {
+	Cursor string `form:"cursor" json:"cursor" dc:"Cursor returned as next_cursor by the previous page"`
+	Limit  int    `form:"limit" json:"limit" binding:"omitempty,min=1,max=100" dc:"Number of items per page"`
+
+	key   string
+	desc  bool
+	after any
+}
+
+// Size returns the page size, falling back to DefaultPageSize.
+func (c CursorReq) Size() int {
+	return pageSize(c.Limit)
+}
+
+// Key returns the key column and whether it is ordered descending.
+func (c CursorReq) Key() (column string, desc bool) {
+	if c.key == "" {
+		return defaultCursorKey, false
+	}
+	return c.key, c.desc
+}
+
+func (c *CursorReq) bindList(field reflect.StructField, _ url.Values) error {
+	c.key, c.desc = defaultCursorKey, false
+	if tag := strings.TrimSpace(field.Tag.Get("cursor")); tag != "" {
+		c.key, c.desc = strings.TrimPrefix(tag, "-"), strings.HasPrefix(tag, "-")
+	}
+	c.after = nil
+	if c.Cursor == "" {
+		return nil
+	}
+	after, err := DecodeCursor(c.Cursor)
+	if err != nil {
+		return merror.NewCode(mcode.CodeValidationFailed, "invalid cursor")
+	}
+	c.after = after
+	return nil
+}
+
+// SortReq is embedded in list requests to accept the "sort" query parameter,
+// a comma separated list of fields where a "-" prefix sorts descending, e.g.
+// "sort=-created_at,name". Only fields listed in the sort tag of the embedded
+// field are accepted; a field may map to a different column with "field:column".
+// The default tag applies when the parameter is omitted:
+//
+//	mhttp.SortReq `sort:"created_at,name,score:users.score" default:"-created_at"`
+type SortReq struct {
+	Sort string `form:"sort" json:"sort" dc:"Comma separated sort fields, prefix a field with - to sort descending"`
+
+	orders []SortOrder
+}
+
+// SortOrder is a validated sort field.
+type SortOrder struct {
+	// Field is the field name used in the query.
+	Field string
+	// Column is the database column the field maps to.
+	Column string
+	// Desc reports whether the order is descending.
+	Desc bool
+}
+
+// Orders returns the validated sort orders.
+func (s SortReq) Orders() []SortOrder {
+	return s.orders
+}
+
+func (s *SortReq) bindList(field reflect.StructField, _ url.Values) error {
+	s.orders = nil
+	expr := s.Sort
+	if expr == "" {
+		expr = field.Tag.Get("default")
+	}
+	if expr == "" {
+		return nil
+	}
+	allowed := parseSortFields(field.Tag.Get("sort"))
+	for _, item := range strings.Split(expr, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		order := SortOrder{Field: strings.TrimPrefix(item, "-"), Desc: strings.HasPrefix(item, "-")}
+		column, ok := allowed[order.Field]
+		if !ok {
+			return merror.NewCodef(mcode.CodeValidationFailed,
+				`sort field "%s" is not allowed, allowed fields: %s`, order.Field, strings.Join(sortFieldNames(allowed), ", "))
+		}
+		if slices.ContainsFunc(s.orders, func(o SortOrder) bool { return o.Field == order.Field }) {
+			return merror.NewCodef(mcode.CodeValidationFailed, `sort field "%s" is repeated`, order.Field)
+		}
+		order.Column = column
+		s.orders = append(s.orders, order)
+	}
+	return nil
+}
+
+// parseSortFields parses a sort tag into a map of field names to columns.
+func parseSortFields(tag string) map[string]string {
+	fields := make(map[string]string)
+	for _, item := range strings.Split(tag, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, column, found := strings.Cut(item, ":")
+		if !found {
+			column = name
+		}
+		fields[name] = column
+	}
+	return fields
+}
+
+func sortFieldNames(fields map[string]string) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// FilterOp is the operator of a filter expression.
+type FilterOp string
+
+const (
+	FilterEq    FilterOp = "eq"   // equal, also used when an expression has no operator
+	FilterNe    FilterOp = "ne"   // not equal
+	FilterGt    FilterOp = "gt"   // greater than
+	FilterGte   FilterOp = "gte"  // greater than or equal
+	FilterLt    FilterOp = "lt"   // less than
+	FilterLte   FilterOp = "lte"  // less than or equal
+	FilterIn    FilterOp = "in"   // one of comma separated values
+	FilterNotIn FilterOp = "nin"  // none of comma separated values
+	FilterLike  FilterOp = "like" // contains, only for strings
+)
+
+var filterOps = []FilterOp{FilterEq, FilterNe, FilterGt, FilterGte, FilterLt, FilterLte, FilterIn, FilterNotIn, FilterLike}
+
+// FilterValue is the set of types a Filter can hold.
+type FilterValue interface {
+	~string | ~bool |
+		~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64 |
+		time.Time
+}
+
+// Filter is a typed filter expression bound from a query parameter of the form
+// "op:value", e.g. "status=in:active,pending" or "created_at=gte:2024-01-01".
+// An expression without a known operator is an equality match, and the
+// parameter may be repeated to combine conditions. The filter tag restricts the
+// accepted operators and the column tag overrides the column, which defaults to
+// the query parameter name:
+//
+//	Status    mhttp.Filter[string]    `form:"status" filter:"eq,in"`
+//	CreatedAt mhttp.Filter[time.Time] `form:"created_at" filter:"gte,lte"`
+//
+// Time values are parsed as RFC 3339 or as a date in the form 2006-01-02.
+type Filter[T FilterValue] struct {
+	// Conditions holds the parsed conditions, combined with AND.
+	Conditions []FilterCondition[T]
+
+	column string
+}
+
+// FilterCondition is a single filter condition.
+type FilterCondition[T FilterValue] struct {
+	Op     FilterOp
+	Values []T
+}
+
+// IsSet reports whether the filter has any condition.
+func (f Filter[T]) IsSet() bool {
+	return len(f.Conditions) > 0
+}
+
+// Column returns the database column the filter applies to.
+func (f Filter[T]) Column() string {
+	return f.column
+}
+
+// UnmarshalParam implements gin's binding.BindUnmarshaler. Operator
+// restrictions and repeated parameters are applied by BindList.
+func (f *Filter[T]) UnmarshalParam(param string) error {
+	condition, err := parseFilterCondition[T](param, allowedFilterOps[T](""))
+	if err != nil {
+		return merror.NewCodef(mcode.CodeValidationFailed, "invalid filter: %v", err)
+	}
+	f.Conditions = []FilterCondition[T]{condition}
+	return nil
+}
+
+func (f *Filter[T]) bindList(field reflect.StructField, values url.Values) error {
+	name := formName(field)
+	f.column = field.Tag.Get("column")
+	if f.column == "" {
+		f.column = name
+	}
+	f.Conditions = nil
+	allowed := allowedFilterOps[T](field.Tag.Get("filter"))
+	for _, expr := range values[name] {
+		condition, err := parseFilterCondition[T](expr, allowed)
+		if err != nil {
+			return merror.NewCodef(mcode.CodeValidationFailed, `invalid filter "%s": %v`, name, err)
+		}
+		f.Conditions = append(f.Conditions, condition)
+	}
+	return nil
+}
+
+// allowedFilterOps returns the operators accepted for T, restricted by tag.
+func allowedFilterOps[T FilterValue](tag string) []FilterOp {
+	var zero T
+	var defaults []FilterOp
+	switch reflect.TypeOf(zero).Kind() {
+	case reflect.String:
+		defaults = []FilterOp{FilterEq, FilterNe, FilterIn, FilterNotIn, FilterLike}
+	case reflect.Bool:
+		defaults = []FilterOp{FilterEq, FilterNe}
+	default:
+		defaults = []FilterOp{FilterEq, FilterNe, FilterGt, FilterGte, FilterLt, FilterLte, FilterIn, FilterNotIn}
+	}
+	if tag == "" {
+		return defaults
+	}
+	var ops []FilterOp
+	for _, item := range strings.Split(tag, ",") {
+		if op := FilterOp(strings.TrimSpace(item)); slices.Contains(defaults, op) {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+func parseFilterCondition[T FilterValue](expr string, allowed []FilterOp) (FilterCondition[T], error) {
+	condition := FilterCondition[T]{Op: FilterEq}
+	raw := expr
+	if prefix, rest, found := strings.Cut(expr, ":"); found && slices.Contains(filterOps, FilterOp(prefix)) {
+		condition.Op, raw = FilterOp(prefix), rest
+	}
+	if !slices.Contains(allowed, condition.Op) {
+		return condition, fmt.Errorf(`operator "%s" is not allowed`, condition.Op)
+	}
+
+	items := []string{raw}
+	if condition.Op == FilterIn || condition.Op == FilterNotIn {
+		items = strings.Split(raw, ",")
+		if len(items) > maxFilterValues {
+			return condition, fmt.Errorf("too many values, at most %d are allowed", maxFilterValues)
+		}
+	}
+	for _, item := range items {
+		value, err := parseFilterValue[T](item)
+		if err != nil {
+			return condition, err
+		}
+		condition.Values = append(condition.Values, value)
+	}
+	return condition, nil
+}
+
+func parseFilterValue[T FilterValue](raw string) (T, error) {
+	var value T
+	target := reflect.ValueOf(&value).Elem()
+	if _, ok := any(value).(time.Time); ok {
+		for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+			if t, err := time.Parse(layout, raw); err == nil {
+				target.Set(reflect.ValueOf(t))
+				return value, nil
+			}
+		}
+		return value, fmt.Errorf(`"%s" is not a valid time`, raw)
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(raw)
+		return value, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err == nil {
+			target.SetBool(b)
+			return value, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, target.Type().Bits())
+		if err == nil {
+			target.SetInt(i)
+			return value, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, target.Type().Bits())
+		if err == nil {
+			target.SetUint(u)
+			return value, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, target.Type().Bits())
+		if err == nil {
+			target.SetFloat(f)
+			return value, nil
+		}
+	}
+	return value, fmt.Errorf(`"%s" is not a valid %s`, raw, target.Kind())
+}
+
+// PageRes is embedded in list responses for offset pagination.
+type PageRes[T any] struct {
+	List  []T   `json:"list" dc:"Items of the current page"`
+	Total int64 `json:"total" dc:"Total number of items"`
+	Page  int   `json:"page" dc:"Current page number"`
+	Size  int   `json:"size" dc:"Number of items per page"`
+}
+
+// NewPageRes creates a page response for req.
+func NewPageRes[T any](req PageReq, list []T, total int64) PageRes[T] {
+	if list == nil {
+		list = []T{}
+	}
+	return PageRes[T]{List: list, Total: total, Page: req.page(), Size: req.Limit()}
+}
+
+// CursorRes is embedded in list responses for cursor pagination.
+type CursorRes[T any] struct {
+	List       []T    `json:"list" dc:"Items of the current page"`
+	NextCursor string `json:"next_cursor,omitempty" dc:"Cursor of the next page, empty on the last page"`
+	HasMore    bool   `json:"has_more" dc:"Whether more items follow"`
+}
+
+// NewCursorRes creates a cursor page response for req from the rows fetched
+// with ListScopes, which reads one row beyond the page size to detect whether
+// more rows follow. The key function returns the cursor key value of an item.
+func NewCursorRes[T any](req CursorReq, list []T, key func(T) any) CursorRes[T] {
+	res := CursorRes[T]{List: list}
+	if size := req.Size(); len(list) > size {
+		res.List, res.HasMore = list[:size], true
+	}
+	if res.List == nil {
+		res.List = []T{}
+	}
+	if res.HasMore && key != nil {
+		res.NextCursor = EncodeCursor(key(res.List[len(res.List)-1]))
+	}
+	return res
+}
+
+// EncodeCursor encodes a key value into an opaque cursor.
+func EncodeCursor(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor decodes a cursor created by EncodeCursor. Integers decode as
+// int64, other numbers as float64 and times as RFC 3339 strings.
+func DecodeCursor(cursor string) (any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case string, bool:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unsupported cursor value %T", value)
+	}
+}
+
+func pageSize(size int) int {
+	switch {
+	case size < 1:
+		return DefaultPageSize
+	case size > MaxPageSize:
+		return MaxPageSize
+	default:
+		return size
+	}
+}
+
+// formName returns the query parameter name of a field.
+func formName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("form"), ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+// listBinder is implemented by the list request components that are resolved
+// from the query after binding.
+type listBinder interface {
+	bindList(field reflect.StructField, values url.Values) error
+}
+
+var (
+	listBinderType = reflect.TypeOf((*listBinder)(nil)).Elem()
+	// listFieldCache caches the list component field paths per request type.
+	listFieldCache sync.Map
+)
+
+type listFieldPath struct {
+	index []int
+	field reflect.StructField
+}
+
+// BindList resolves the pagination, sort and filter components of a bound
+// request from the query values: it applies the sort whitelist, parses
+// repeated filter parameters, checks filter operators and decodes the cursor.
+// Controller requests are bound automatically.
+func BindList(values url.Values, req any) error {
+	value := reflect.ValueOf(req)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	value = value.Elem()
+	for _, path := range listFields(value.Type()) {
+		binder := value.FieldByIndex(path.index).Addr().Interface().(listBinder)
+		if err := binder.bindList(path.field, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listFields returns the list components of a request type, including those
+// of embedded structs.
+func listFields(typ reflect.Type) []listFieldPath {
+	if cached, ok := listFieldCache.Load(typ); ok {
+		return cached.([]listFieldPath)
+	}
+	var paths []listFieldPath
+	var walk func(t reflect.Type, prefix []int)
+	walk = func(t reflect.Type, prefix []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			index := append(prefix[:len(prefix):len(prefix)], i)
+			if reflect.PointerTo(field.Type).Implements(listBinderType) {
+				paths = append(paths, listFieldPath{index: index, field: field})
+				continue
+			}
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				walk(field.Type, index)
+			}
+		}
+	}
+	walk(typ, nil)
+	listFieldCache.Store(typ, paths)
+	return paths
+}
@@ -0,0 +1,145 @@
+package mhttp
+
+import (
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ListScope is a GORM scope, usable with mdb.DB.Scopes.
+type ListScope = func(db *gorm.DB) *gorm.DB
+
+// listScoper is implemented by the list request components that translate
+// into query conditions. Filters narrow the result set, the remaining scopes
+// order and paginate it.
+type listScoper interface {
+	listScopes() (filters, orders []ListScope)
+}
+
+// ListScopes returns the GORM scopes of a bound list request: its filters,
+// sort orders and pagination, in that order. With cursor pagination one row
+// beyond the page size is fetched, see NewCursorRes.
+//
+//	var users []User
+//	err := db.WithContext(ctx).Scopes(mhttp.ListScopes(req)...).Find(&users).Error
+func ListScopes(req any) []ListScope {
+	filters, orders := collectListScopes(req)
+	return append(filters, orders...)
+}
+
+// FilterScopes returns only the filter scopes of a bound list request,
+// to count the total number of items for PageRes.
+func FilterScopes(req any) []ListScope {
+	filters, _ := collectListScopes(req)
+	return filters
+}
+
+func collectListScopes(req any) (filters, orders []ListScope) {
+	value := reflect.ValueOf(req)
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil, nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	// Addressable copy, as the components implement listBinder on pointers.
+	copied := reflect.New(value.Type()).Elem()
+	copied.Set(value)
+	for _, path := range listFields(value.Type()) {
+		scoper, ok := copied.FieldByIndex(path.index).Addr().Interface().(listScoper)
+		if !ok {
+			continue
+		}
+		f, o := scoper.listScopes()
+		filters = append(filters, f...)
+		orders = append(orders, o...)
+	}
+	return filters, orders
+}
+
+func (f *Filter[T]) listScopes() (filters, orders []ListScope) {
+	if !f.IsSet() || f.column == "" {
+		return nil, nil
+	}
+	column := clause.Column{Name: f.column}
+	for _, condition := range f.Conditions {
+		expression := condition.expression(column)
+		filters = append(filters, func(db *gorm.DB) *gorm.DB {
+			return db.Where(expression)
+		})
+	}
+	return filters, nil
+}
+
+func (c FilterCondition[T]) expression(column clause.Column) clause.Expression {
+	values := make([]any, len(c.Values))
+	for i, value := range c.Values {
+		values[i] = value
+	}
+	switch c.Op {
+	case FilterNe:
+		return clause.Neq{Column: column, Value: values[0]}
+	case FilterGt:
+		return clause.Gt{Column: column, Value: values[0]}
+	case FilterGte:
+		return clause.Gte{Column: column, Value: values[0]}
+	case FilterLt:
+		return clause.Lt{Column: column, Value: values[0]}
+	case FilterLte:
+		return clause.Lte{Column: column, Value: values[0]}
+	case FilterIn:
+		return clause.IN{Column: column, Values: values}
+	case FilterNotIn:
+		return clause.Not(clause.IN{Column: column, Values: values})
+	case FilterLike:
+		// Wildcards in the value match literally.
+		pattern := "%" + likeEscaper.Replace(reflect.ValueOf(values[0]).String()) + "%"
+		return clause.Expr{SQL: "? LIKE ? ESCAPE ?", Vars: []any{column, pattern, `\`}}
+	default:
+		return clause.Eq{Column: column, Value: values[0]}
+	}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *SortReq) listScopes() (filters, orders []ListScope) {
+	for _, order := range s.orders {
+		orderBy := clause.OrderByColumn{Column: clause.Column{Name: order.Column}, Desc: order.Desc}
+		orders = append(orders, func(db *gorm.DB) *gorm.DB {
+			return db.Order(orderBy)
+		})
+	}
+	return nil, orders
+}
+
+func (p *PageReq) listScopes() (filters, orders []ListScope) {
+	offset, limit := p.Offset(), p.Limit()
+	return nil, []ListScope{func(db *gorm.DB) *gorm.DB {
+		return db.Offset(offset).Limit(limit)
+	}}
+}
+
+func (c *CursorReq) listScopes() (filters, orders []ListScope) {
+	key, desc := c.Key()
+	column := clause.Column{Name: key}
+	if c.after != nil {
+		var expression clause.Expression = clause.Gt{Column: column, Value: c.after}
+		if desc {
+			expression = clause.Lt{Column: column, Value: c.after}
+		}
+		// The keyset condition belongs to pagination, so FilterScopes leaves it out.
+		orders = append(orders, func(db *gorm.DB) *gorm.DB {
+			return db.Where(expression)
+		})
+	}
+	limit := c.Size() + 1
+	orders = append(orders, func(db *gorm.DB) *gorm.DB {
+		return db.Order(clause.OrderByColumn{Column: column, Desc: desc}).Limit(limit)
+	})
+	return filters, orders
+}
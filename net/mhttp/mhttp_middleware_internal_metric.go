@@ -19,8 +19,14 @@ type localMetricManager struct {
 	HTTPServerRequestDurationTotal mmetric.Counter
 	HTTPServerRequestBodySize      mmetric.Counter
 	HTTPServerResponseBodySize     mmetric.Counter
+	HTTPServerProxyRequestTotal    mmetric.Counter
+	HTTPServerProxyRequestDuration mmetric.Histogram
+	HTTPServerProxyErrorTotal      mmetric.Counter
 }
 
+// metricAttrProxyUpstream is the upstream attribute of the proxy metrics.
+const metricAttrProxyUpstream = "proxy.upstream"
+
 // global metric manager
 var metricManager = newMetricManager()
 
@@ -73,6 +79,27 @@ func newMetricManager() *localMetricManager {
 				Unit: "bytes",
 			},
 		),
+		HTTPServerProxyRequestTotal: meter.MustCounter(
+			"http.server.proxy.request.total",
+			mmetric.MetricOption{
+				Help: "requests proxied to upstreams",
+				Unit: "",
+			},
+		),
+		HTTPServerProxyRequestDuration: meter.MustHistogram(
+			"http.server.proxy.request.duration",
+			mmetric.MetricOption{
+				Help: "duration of proxied requests",
+				Unit: "ms",
+			},
+		),
+		HTTPServerProxyErrorTotal: meter.MustCounter(
+			"http.server.proxy.error.total",
+			mmetric.MetricOption{
+				Help: "proxied requests failed",
+				Unit: "",
+			},
+		),
 	}
 	return mm
 }
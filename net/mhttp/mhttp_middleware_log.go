@@ -2,12 +2,17 @@ package mhttp
 
 import (
 	"bytes"
-	"io"
+	"encoding/json"
+	"fmt"
+	"math"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/graingo/maltose/internal/httplog"
 	"github.com/graingo/maltose/os/mlog"
+	"go.uber.org/zap/zapcore"
 )
 
 // LogMaxBodySize controls request/response body logging. Zero disables body logging.
@@ -17,11 +22,19 @@ var LogMaxBodySize = 0
 // It embeds gin.ResponseWriter to ensure full compatibility.
 type responseWriter struct {
 	gin.ResponseWriter
-	body  *bytes.Buffer
-	limit int
+	body     *bytes.Buffer
+	limits   httplog.BodyLimits
+	limit    int
+	resolved bool
 }
 
 func (w *responseWriter) capture(data []byte) {
+	// The capture limit depends on the response content type,
+	// which is only known once the handler starts writing.
+	if !w.resolved {
+		w.limit = w.limits.Limit(w.Header().Get("Content-Type"))
+		w.resolved = true
+	}
 	if w.limit == 0 {
 		return
 	}
@@ -63,30 +76,60 @@ func (w *responseWriter) WriteString(s string) (int, error) {
 // 1. Before the handler is executed ("started").
 // 2. After the handler is completed ("finished").
 // This allows for better observability, especially for hanging or panicking requests.
+// It uses DefaultLogConfig; see MiddlewareLogWithConfig for formats, sampling and redaction.
 func MiddlewareLog() MiddlewareFunc {
-	bodyLimit := LogMaxBodySize
+	return MiddlewareLogWithConfig(DefaultLogConfig())
+}
+
+// MiddlewareLogWithConfig creates a logging middleware with the given configuration.
+// Failed and slow requests are always logged, successful requests are sampled,
+// and sensitive headers, query parameters and body fields are masked.
+func MiddlewareLogWithConfig(config LogConfig) MiddlewareFunc {
+	var (
+		redactor  = config.Redaction.Redactor()
+		sampler   = config.Sampling.Sampler()
+		limits    = config.Body.Limits()
+		skipPaths = make(map[string]struct{}, len(config.SkipPaths))
+	)
+	for _, path := range config.SkipPaths {
+		skipPaths[path] = struct{}{}
+	}
+
 	return func(r *Request) {
-		// Skip health check
+		// Skip health check and configured paths
 		if r.Request.URL.Path == r.server.config.HealthCheck {
 			r.Next()
 			return
 		}
+		if _, ok := skipPaths[r.Request.URL.Path]; ok {
+			r.Next()
+			return
+		}
 
 		// --- Step 1: Log request start ---
 
 		start := time.Now()
+		route := r.FullPath()
+		if route == "" {
+			route = r.Request.URL.Path
+		}
 
 		// Safely read and capture the request body for logging, then restore it.
-		var reqBodyBytes []byte
-		if bodyLimit != 0 && r.Request.Body != nil {
-			reqBodyBytes, r.Request.Body = readBodyForLog(r.Request.Body, bodyLimit)
+		var (
+			reqBodyBytes    []byte
+			reqContentType  = r.Request.Header.Get("Content-Type")
+			reqBodyLimit    = limits.Limit(reqContentType)
+			logBeforeFinish = config.Format == LogFormatDefault && sampler.Rate(route) >= 1
+		)
+		if reqBodyLimit != 0 && r.Request.Body != nil {
+			reqBodyBytes, r.Request.Body = httplog.ReadBody(r.Request.Body, reqBodyLimit)
 		}
 
 		// Create a custom response writer to capture the response body and status.
 		writer := &responseWriter{
 			ResponseWriter: r.Writer,
 			body:           &bytes.Buffer{},
-			limit:          bodyLimit,
+			limits:         limits,
 		}
 		r.Writer = writer
 
@@ -96,18 +139,30 @@ func MiddlewareLog() MiddlewareFunc {
 			mlog.String("path", r.Request.URL.Path),
 		}
 		if query := r.Request.URL.Query(); len(query) > 0 {
-			keys := make([]string, 0, len(query))
-			for key := range query {
-				keys = append(keys, key)
+			if config.Query {
+				requestFields = append(requestFields, mlog.String("query", redactor.Query(query)))
+			} else {
+				keys := make([]string, 0, len(query))
+				for key := range query {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				requestFields = append(requestFields, mlog.Any("query_keys", keys))
 			}
-			sort.Strings(keys)
-			requestFields = append(requestFields, mlog.Any("query_keys", keys))
+		}
+		if config.Headers {
+			requestFields = append(requestFields, mlog.Any("request_headers", redactor.Header(r.Request.Header)))
 		}
 		if len(reqBodyBytes) > 0 {
-			requestFields = append(requestFields, mlog.String("request_body", getBodyString(reqBodyBytes, bodyLimit)))
+			body := redactor.Body(reqContentType, reqBodyBytes)
+			requestFields = append(requestFields, mlog.String("request_body", httplog.BodyString(body, reqBodyLimit)))
 		}
 
-		r.Logger().Infow(r.Request.Context(), "http server request started", requestFields...)
+		// Sampling is decided on completion, so the start entry is only written
+		// for routes whose successful requests are always logged.
+		if logBeforeFinish {
+			r.Logger().Infow(r.Request.Context(), "http server request started", requestFields...)
+		}
 
 		// --- Step 2: Execute handler and log completion ---
 
@@ -115,27 +170,52 @@ func MiddlewareLog() MiddlewareFunc {
 
 		duration := time.Since(start)
 		status := writer.Status()
-		resBodyBytes := writer.body.Bytes()
-
-		msg := "http server request finished"
+		failed := len(r.Errors) > 0 || status >= 400
+		if !sampler.Sample(route, failed, duration) {
+			return
+		}
 
-		// The final log should contain all information for context.
-		// Start with the initial request fields and add response details.
-		finalFields := append(requestFields,
-			mlog.Int("status", status),
-			mlog.Float64("latency_ms", float64(duration.Nanoseconds())/1e6),
+		var (
+			msg         string
+			finalFields mlog.Fields
+			// entryLine is set when the message is the whole entry.
+			entryLine = config.Format == LogFormatCommon || config.Format == LogFormatCombined
 		)
-		if bodyLimit != 0 && len(resBodyBytes) > 0 {
-			finalFields = append(finalFields, mlog.String("response_body", getBodyString(resBodyBytes, bodyLimit)))
+		if entryLine {
+			msg = formatAccessLine(r, writer, redactor, start, config.Format == LogFormatCombined)
+		} else {
+			msg = "http server request finished"
+
+			// The final log should contain all information for context.
+			// Start with the initial request fields and add response details.
+			finalFields = append(requestFields,
+				mlog.Int("status", status),
+				mlog.Float64("latency_ms", float64(duration.Nanoseconds())/1e6),
+			)
+			if resBodyBytes := writer.body.Bytes(); writer.limit != 0 && len(resBodyBytes) > 0 {
+				body := redactor.Body(writer.Header().Get("Content-Type"), resBodyBytes)
+				finalFields = append(finalFields, mlog.String("response_body", httplog.BodyString(body, writer.limit)))
+			}
+			if config.Format == LogFormatJSON {
+				// The message is the entry, so that it is JSON whatever the logger format.
+				if len(r.Errors) > 0 {
+					finalFields = append(finalFields, mlog.String("error", r.Errors.Last().Error()))
+				}
+				msg, finalFields, entryLine = formatJSONEntry(finalFields), nil, true
+			}
 		}
 
 		// Decide log level based on errors or status code
 		if len(r.Errors) > 0 {
-			msg += " with errors"
+			if !entryLine {
+				msg += " with errors"
+			}
 			// Log with the actual error from the context
 			r.Logger().Errorw(r.Request.Context(), r.Errors.Last().Err, msg, finalFields...)
 		} else if status >= 400 {
-			msg += " with warning status"
+			if !entryLine {
+				msg += " with warning status"
+			}
 			r.Logger().Warnw(r.Request.Context(), msg, finalFields...)
 		} else {
 			r.Logger().Infow(r.Request.Context(), msg, finalFields...)
@@ -143,40 +223,59 @@ func MiddlewareLog() MiddlewareFunc {
 	}
 }
 
-type replayReadCloser struct {
-	io.Reader
-	io.Closer
+// formatAccessLine renders the request in the Apache common or combined log format.
+func formatAccessLine(r *Request, writer *responseWriter, redactor *httplog.Redactor, start time.Time, combined bool) string {
+	user := "-"
+	if username, _, ok := r.Request.BasicAuth(); ok && username != "" {
+		user = username
+	} else if r.Request.URL.User != nil && r.Request.URL.User.Username() != "" {
+		user = r.Request.URL.User.Username()
+	}
+	size := "-"
+	if writer.Size() > 0 {
+		size = strconv.Itoa(writer.Size())
+	}
+	line := fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s`,
+		r.ClientIP(),
+		user,
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Request.Method,
+		redactor.RequestURI(r.Request.URL),
+		r.Request.Proto,
+		writer.Status(),
+		size,
+	)
+	if combined {
+		line += fmt.Sprintf(` %q %q`, headerOrDash(r.Request.Referer()), headerOrDash(r.Request.UserAgent()))
+	}
+	return line
 }
 
-func readBodyForLog(body io.ReadCloser, limit int) ([]byte, io.ReadCloser) {
-	if limit < 0 {
-		data, err := io.ReadAll(body)
-		if err != nil {
-			return nil, body
+// formatJSONEntry renders the fields of a request as a JSON object.
+func formatJSONEntry(fields mlog.Fields) string {
+	entry := make(map[string]any, len(fields))
+	for _, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			entry[field.Key] = field.String
+		case zapcore.Int64Type:
+			entry[field.Key] = field.Integer
+		case zapcore.Float64Type:
+			entry[field.Key] = math.Float64frombits(uint64(field.Integer))
+		default:
+			entry[field.Key] = field.Interface
 		}
-		_ = body.Close()
-		return data, io.NopCloser(bytes.NewReader(data))
 	}
-	data, err := io.ReadAll(io.LimitReader(body, int64(limit+1)))
+	data, err := json.Marshal(entry)
 	if err != nil {
-		return nil, body
-	}
-	return data, &replayReadCloser{
-		Reader: io.MultiReader(bytes.NewReader(data), body),
-		Closer: body,
+		return fmt.Sprintf(`{"error":%q}`, "failed to encode access log entry: "+err.Error())
 	}
+	return string(data)
 }
 
-// getBodyString safely converts a byte slice to a string for logging, with a size limit.
-func getBodyString(body []byte, limit int) string {
-	if len(body) == 0 {
-		return ""
-	}
-	if limit < 0 { // no limit
-		return string(body)
-	}
-	if len(body) > limit {
-		return string(body[:limit]) + "..."
+func headerOrDash(value string) string {
+	if value == "" {
+		return "-"
 	}
-	return string(body)
+	return value
 }
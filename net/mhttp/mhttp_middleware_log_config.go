@@ -0,0 +1,62 @@
+package mhttp
+
+import "github.com/graingo/maltose/internal/httplog"
+
+// LogFormat defines how MiddlewareLogWithConfig renders access log entries.
+type LogFormat string
+
+const (
+	// LogFormatDefault logs a structured "started" entry before the handler and a
+	// "finished" entry after it.
+	LogFormatDefault LogFormat = ""
+	// LogFormatJSON logs a single line per request after completion, a JSON
+	// object with the fields of the default format, whatever the logger format.
+	LogFormatJSON LogFormat = "json"
+	// LogFormatCommon logs a single line in the Apache common log format.
+	LogFormatCommon LogFormat = "common"
+	// LogFormatCombined logs a single line in the Apache combined log format.
+	LogFormatCombined LogFormat = "combined"
+)
+
+// LogConfig defines the configuration for MiddlewareLogWithConfig.
+type LogConfig struct {
+	// Format is the access log format.
+	Format LogFormat
+	// SkipPaths lists request paths that are never logged. The health check path is always skipped.
+	SkipPaths []string
+	// Query logs the full query string with redaction applied instead of only the query keys.
+	Query bool
+	// Headers logs the request headers with redaction applied.
+	Headers bool
+	// Sampling controls which completed requests are logged.
+	Sampling LogSamplingConfig
+	// Body controls request and response body capture.
+	Body LogBodyConfig
+	// Redaction controls masking of sensitive values.
+	Redaction LogRedactionConfig
+}
+
+// LogSamplingConfig defines access log sampling.
+// Requests that fail (status >= 400 or handler errors) and slow requests are always logged.
+type LogSamplingConfig = httplog.SamplingConfig
+
+// LogBodyConfig defines request and response body capture.
+type LogBodyConfig = httplog.BodyConfig
+
+// LogRedactionConfig defines which values are masked in access logs.
+type LogRedactionConfig = httplog.RedactionConfig
+
+// DefaultLogRedactionConfig returns redaction rules for commonly sensitive values.
+func DefaultLogRedactionConfig() LogRedactionConfig {
+	return httplog.DefaultRedactionConfig()
+}
+
+// DefaultLogConfig returns the configuration used by MiddlewareLog.
+// Body capture follows LogMaxBodySize.
+func DefaultLogConfig() LogConfig {
+	return LogConfig{
+		Format:    LogFormatDefault,
+		Body:      LogBodyConfig{MaxSize: LogMaxBodySize},
+		Redaction: DefaultLogRedactionConfig(),
+	}
+}
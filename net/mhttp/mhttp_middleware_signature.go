@@ -0,0 +1,65 @@
+package mhttp
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/msign"
+)
+
+// SignatureConfig defines the verification of signed requests.
+type SignatureConfig struct {
+	// Verifier checks the signatures, e.g. msign.NewHMACVerifier or msign.NewSigV4Verifier.
+	Verifier msign.Verifier
+	// MaxBodySize limits the body read for verification, 1MB by default.
+	MaxBodySize int64
+	// ErrorHandler is an optional function to handle rejected requests. By default
+	// the error is added to the request and the chain is aborted.
+	ErrorHandler func(*Request, error)
+}
+
+// MiddlewareSignature creates a middleware that verifies the signature of
+// requests signed with the msign package, e.g. by mclient.MiddlewareSign. The
+// raw body is available through Request.RawBody and is restored for
+// subsequent binding.
+func MiddlewareSignature(config SignatureConfig) MiddlewareFunc {
+	if config.Verifier == nil {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "signature verifier is required"))
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+
+	return func(r *Request) {
+		if err := verifySignature(r, config); err != nil {
+			if config.ErrorHandler != nil {
+				config.ErrorHandler(r, err)
+			} else {
+				r.Error(err)
+			}
+			r.Abort()
+			return
+		}
+		r.Next()
+	}
+}
+
+func verifySignature(r *Request, config SignatureConfig) error {
+	var body []byte
+	if r.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Request.Body, config.MaxBodySize+1))
+		if err != nil {
+			return merror.WrapCode(err, mcode.CodeValidationFailed, "failed to read signed body")
+		}
+		if int64(len(body)) > config.MaxBodySize {
+			return merror.NewCodef(mcode.CodeValidationFailed, "signed body exceeds %d bytes", config.MaxBodySize)
+		}
+		r.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	r.Set(rawBodyKey, body)
+	return config.Verifier.Verify(r.Request, body, time.Now())
+}
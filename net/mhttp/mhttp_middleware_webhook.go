@@ -0,0 +1,295 @@
+package mhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graingo/maltose/database/mredis"
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+)
+
+const (
+	// rawBodyKey is the key of the raw request body captured by MiddlewareWebhook
+	// and MiddlewareSignature.
+	rawBodyKey = "MaltoseRawBody"
+	// defaultWebhookNoncePrefix is the key prefix of nonces stored in Redis.
+	defaultWebhookNoncePrefix = "webhook:nonce:"
+)
+
+// WebhookConfig defines the signing scheme of a webhook sender.
+type WebhookConfig struct {
+	// SignatureHeader is the header carrying the signature, e.g. "X-Hub-Signature-256".
+	SignatureHeader string
+	// SignaturePrefix is stripped from each signature, e.g. "sha256=" or "v0=".
+	SignaturePrefix string
+	// Algorithm is the HMAC hash: "sha1", "sha256" (default) or "sha512".
+	Algorithm string
+	// Encoding is the signature encoding: "hex" (default) or "base64".
+	Encoding string
+	// Template is the signed payload, where {body}, {timestamp}, {nonce},
+	// {method} and {path} are replaced by the request values, e.g.
+	// "v0:{timestamp}:{body}". It defaults to "{body}". It must contain
+	// {timestamp} when TimestampHeader is set, so the timestamp is signed.
+	Template string
+	// Secrets are the active secrets. A signature made with any of them is
+	// accepted, so a new secret can be added before the old one is removed.
+	Secrets []string
+	// TimestampHeader is the header carrying the Unix time the request was
+	// signed at. When set, requests outside Tolerance are rejected.
+	TimestampHeader string
+	// Tolerance is the accepted clock difference for timestamps, 5 minutes by default.
+	Tolerance time.Duration
+	// NonceHeader is the header carrying the unique delivery ID, e.g. "X-GitHub-Delivery".
+	// Unless Template signs {nonce}, the signature is claimed as well, since an
+	// unsigned delivery ID can be replaced when a request is replayed.
+	NonceHeader string
+	// NonceStore enables replay protection: a nonce is accepted only once
+	// within NonceTTL. See NewWebhookRedisNonceStore.
+	NonceStore WebhookNonceStore
+	// NonceTTL is how long nonces are remembered, twice the Tolerance by default.
+	NonceTTL time.Duration
+	// MaxBodySize limits the body read for verification, 1MB by default.
+	MaxBodySize int64
+	// ErrorHandler is an optional function to handle rejected requests. By default
+	// the error is added to the request and the chain is aborted.
+	ErrorHandler func(*Request, error)
+}
+
+// WebhookNonceStore records the nonces of accepted webhook requests.
+type WebhookNonceStore interface {
+	// Claim records nonce for ttl. It returns false if nonce was already recorded.
+	Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// webhookRedisNonceStore stores nonces in Redis.
+type webhookRedisNonceStore struct {
+	redis  *mredis.Redis
+	prefix string
+}
+
+// NewWebhookRedisNonceStore creates a nonce store backed by Redis, so replays
+// are detected across all instances of a service. The prefix defaults to "webhook:nonce:".
+func NewWebhookRedisNonceStore(redis *mredis.Redis, prefix ...string) WebhookNonceStore {
+	store := &webhookRedisNonceStore{redis: redis, prefix: defaultWebhookNoncePrefix}
+	if len(prefix) > 0 && prefix[0] != "" {
+		store.prefix = prefix[0]
+	}
+	return store
+}
+
+// Claim implements WebhookNonceStore.
+func (s *webhookRedisNonceStore) Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return s.redis.SetNX(ctx, s.prefix+nonce, 1, ttl)
+}
+
+func normalizeWebhookConfig(config WebhookConfig) WebhookConfig {
+	if config.Algorithm == "" {
+		config.Algorithm = "sha256"
+	}
+	if config.Encoding == "" {
+		config.Encoding = "hex"
+	}
+	if config.Template == "" {
+		config.Template = "{body}"
+	}
+	if config.Tolerance <= 0 {
+		config.Tolerance = 5 * time.Minute
+	}
+	if config.NonceTTL <= 0 {
+		config.NonceTTL = 2 * config.Tolerance
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+	return config
+}
+
+// MiddlewareWebhook creates a middleware that verifies the HMAC signature of
+// webhook requests, rejects stale timestamps and replayed nonces, and keeps
+// the raw body available through Request.RawBody. The body is restored for
+// subsequent binding.
+func MiddlewareWebhook(config WebhookConfig) MiddlewareFunc {
+	config = normalizeWebhookConfig(config)
+	newHash := webhookHashes[config.Algorithm]
+	if newHash == nil {
+		panic(merror.NewCodef(mcode.CodeInvalidConfiguration, "unsupported webhook algorithm %q", config.Algorithm))
+	}
+	if config.SignatureHeader == "" || len(config.Secrets) == 0 {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "webhook signature header and secrets are required"))
+	}
+	if config.TimestampHeader != "" && !strings.Contains(config.Template, "{timestamp}") {
+		panic(merror.NewCodef(mcode.CodeInvalidConfiguration, "webhook template %q does not sign the timestamp of %s", config.Template, config.TimestampHeader))
+	}
+
+	return func(r *Request) {
+		if err := verifyWebhook(r, config, newHash); err != nil {
+			if config.ErrorHandler != nil {
+				config.ErrorHandler(r, err)
+			} else {
+				r.Error(err)
+			}
+			r.Abort()
+			return
+		}
+		r.Next()
+	}
+}
+
+// RawBody returns the raw request body captured by MiddlewareWebhook or MiddlewareSignature.
+func (r *Request) RawBody() []byte {
+	body, _ := r.Get(rawBodyKey)
+	data, _ := body.([]byte)
+	return data
+}
+
+var webhookHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func verifyWebhook(r *Request, config WebhookConfig, newHash func() hash.Hash) error {
+	body, err := io.ReadAll(io.LimitReader(r.Request.Body, config.MaxBodySize+1))
+	if err != nil {
+		return merror.WrapCode(err, mcode.CodeValidationFailed, "failed to read webhook body")
+	}
+	if int64(len(body)) > config.MaxBodySize {
+		return merror.NewCodef(mcode.CodeValidationFailed, "webhook body exceeds %d bytes", config.MaxBodySize)
+	}
+	r.Request.Body = io.NopCloser(bytes.NewReader(body))
+	r.Set(rawBodyKey, body)
+
+	header := r.GetHeader(config.SignatureHeader)
+	if header == "" {
+		return merror.NewCodef(mcode.CodeNotAuthorized, "missing webhook signature header %s", config.SignatureHeader)
+	}
+
+	timestamp := ""
+	if config.TimestampHeader != "" {
+		timestamp = r.GetHeader(config.TimestampHeader)
+		signedAt, err := parseWebhookTimestamp(timestamp)
+		if err != nil {
+			return merror.NewCodef(mcode.CodeNotAuthorized, "invalid webhook timestamp %q", timestamp)
+		}
+		if diff := time.Since(signedAt); diff > config.Tolerance || diff < -config.Tolerance {
+			return merror.NewCode(mcode.CodeNotAuthorized, "webhook timestamp is outside the tolerance window")
+		}
+	}
+	nonce := ""
+	if config.NonceHeader != "" {
+		if nonce = r.GetHeader(config.NonceHeader); nonce == "" {
+			return merror.NewCodef(mcode.CodeNotAuthorized, "missing webhook nonce header %s", config.NonceHeader)
+		}
+	}
+
+	payload := webhookPayload(config.Template, body, map[string]string{
+		"{timestamp}": timestamp,
+		"{nonce}":     nonce,
+		"{method}":    r.Request.Method,
+		"{path}":      r.Request.URL.Path,
+	})
+	signature, ok := matchWebhookSignature(header, payload, config, newHash)
+	if !ok {
+		return merror.NewCode(mcode.CodeNotAuthorized, "invalid webhook signature")
+	}
+
+	if config.NonceStore != nil {
+		nonces := make([]string, 0, 2)
+		if nonce != "" {
+			nonces = append(nonces, nonce)
+		}
+		if nonce == "" || !strings.Contains(config.Template, "{nonce}") {
+			nonces = append(nonces, hex.EncodeToString(signature))
+		}
+		for _, nonce := range nonces {
+			claimed, err := config.NonceStore.Claim(r.Request.Context(), nonce, config.NonceTTL)
+			if err != nil {
+				return merror.WrapCode(err, mcode.CodeInternalError, "failed to record webhook nonce")
+			}
+			if !claimed {
+				return merror.NewCode(mcode.CodeNotAuthorized, "webhook request has already been received")
+			}
+		}
+	}
+	return nil
+}
+
+// matchWebhookSignature checks the signatures of header, which may list
+// several separated by commas or spaces, against every active secret.
+// It returns the matching signature.
+func matchWebhookSignature(header string, payload []byte, config WebhookConfig, newHash func() hash.Hash) ([]byte, bool) {
+	expected := make([][]byte, len(config.Secrets))
+	for i, secret := range config.Secrets {
+		mac := hmac.New(newHash, []byte(secret))
+		mac.Write(payload)
+		expected[i] = mac.Sum(nil)
+	}
+
+	for _, item := range strings.FieldsFunc(header, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if config.SignaturePrefix != "" {
+			var found bool
+			if item, found = strings.CutPrefix(item, config.SignaturePrefix); !found {
+				continue
+			}
+		}
+		signature, err := decodeWebhookSignature(item, config.Encoding)
+		if err != nil {
+			continue
+		}
+		for _, mac := range expected {
+			if hmac.Equal(signature, mac) {
+				return signature, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func decodeWebhookSignature(signature, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(signature)
+	}
+	return hex.DecodeString(signature)
+}
+
+// webhookPayload expands template. Placeholders inside the body are left untouched.
+func webhookPayload(template string, body []byte, values map[string]string) []byte {
+	pairs := make([]string, 0, len(values)*2)
+	for placeholder, value := range values {
+		pairs = append(pairs, placeholder, value)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	var payload bytes.Buffer
+	for i, part := range strings.Split(template, "{body}") {
+		if i > 0 {
+			payload.Write(body)
+		}
+		payload.WriteString(replacer.Replace(part))
+	}
+	return payload.Bytes()
+}
+
+// parseWebhookTimestamp parses Unix seconds or milliseconds.
+func parseWebhookTimestamp(value string) (time.Time, error) {
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ts > 1e12 {
+		return time.UnixMilli(ts), nil
+	}
+	return time.Unix(ts, 0), nil
+}
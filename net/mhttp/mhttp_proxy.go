@@ -0,0 +1,372 @@
+package mhttp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/graingo/maltose/os/mmetric"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// proxyPathParam is the catch-all parameter of proxy routes.
+const proxyPathParam = "proxyPath"
+
+// hopHeaders are the hop-by-hop headers, which are not forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ProxyConfig defines how a proxy route forwards requests to its upstream.
+type ProxyConfig struct {
+	// Client sends the requests to the upstream. Its load balancer, retries and
+	// middlewares apply. It should neither follow redirects nor set a timeout
+	// bounding long-lived streams. Default is a client without timeout that does
+	// not follow redirects.
+	Client *mclient.Client
+	// Upstream names the upstream in metrics. Default is the host of the target,
+	// or the route prefix when the target has no host.
+	Upstream string
+	// StripPrefix removes the route prefix from the forwarded path.
+	StripPrefix bool
+	// Rewrite rewrites the escaped path forwarded, after the prefix is stripped.
+	Rewrite func(path string) string
+	// Headers are set on the forwarded requests. An empty value removes the header.
+	Headers map[string]string
+	// ModifyRequest edits the forwarded request before it is sent.
+	ModifyRequest func(r *Request, req *mclient.Request)
+	// ModifyResponse edits the upstream response before it is copied. Returning
+	// an error discards the response and calls ErrorHandler.
+	ModifyResponse func(r *Request, resp *mclient.Response) error
+	// MaxBufferedBodySize is the largest request body buffered so the client can
+	// retry it. Larger bodies and bodies of unknown size are streamed and sent
+	// once. Default is 64KB, negative streams all bodies.
+	MaxBufferedBodySize int64
+	// ErrorHandler is an optional function to handle failed requests. By default
+	// the error is added to the request and the chain is aborted.
+	ErrorHandler func(*Request, error)
+}
+
+func normalizeProxyConfig(config ProxyConfig, prefix, target string) ProxyConfig {
+	if config.Client == nil {
+		config.Client = mclient.New().SetTimeout(0).SetRedirectLimit(0)
+	}
+	if config.Upstream == "" {
+		if u, err := url.Parse(target); err == nil && u.Host != "" {
+			config.Upstream = u.Host
+		} else {
+			config.Upstream = prefix
+		}
+	}
+	if config.MaxBufferedBodySize == 0 {
+		config.MaxBufferedBodySize = 64 << 10
+	}
+	return config
+}
+
+// proxy forwards the requests of a proxy route.
+type proxy struct {
+	config ProxyConfig
+	prefix string // absolute path of the route prefix
+	target string
+}
+
+// Proxy forwards all requests under prefix to target, e.g.
+//
+//	s.Group("/api").Middleware(auth).Proxy("/orders", "http://orders:8080", mhttp.ProxyConfig{StripPrefix: true})
+//
+// The path and query of the requests are appended to target. A target without
+// host, e.g. "" or "/v1", is resolved by the client against the endpoints of its
+// load balancer or its base URL. The middlewares of the group apply, bodies are
+// streamed both ways, WebSocket and other upgrades are tunneled, the trace
+// context is propagated and proxy metrics are recorded per upstream.
+func (rg *RouterGroup) Proxy(prefix, target string, config ...ProxyConfig) *RouterGroup {
+	var c ProxyConfig
+	if len(config) > 0 {
+		c = config[0]
+	}
+	p := &proxy{
+		prefix: strings.TrimSuffix(joinPaths(rg.path, prefix), "/"),
+		target: strings.TrimSuffix(target, "/"),
+	}
+	p.config = normalizeProxyConfig(c, p.prefix, target)
+
+	if strings.Trim(prefix, "/") != "" {
+		rg.Any(prefix, p.handle)
+	}
+	rg.Any(joinPaths(prefix, "/*"+proxyPathParam), p.handle)
+	return rg
+}
+
+// handle forwards a request and copies the upstream response.
+func (p *proxy) handle(r *Request) {
+	var (
+		ctx        = r.Request.Context()
+		start      = time.Now()
+		attributes = []attribute.KeyValue{
+			attribute.String(metricAttrProxyUpstream, p.config.Upstream),
+			attribute.String(mmetric.AttrHTTPRequestMethod, r.Request.Method),
+		}
+	)
+	resp, err := p.send(r)
+	if err == nil && p.config.ModifyResponse != nil {
+		if err = p.config.ModifyResponse(r, resp); err != nil {
+			resp.Close()
+			err = merror.Wrapf(err, "proxy response of %s rejected", p.config.Upstream)
+		}
+	}
+	if err != nil {
+		metricManager.HTTPServerProxyErrorTotal.Inc(ctx, mmetric.WithAttributes(attributes...))
+		if p.config.ErrorHandler != nil {
+			p.config.ErrorHandler(r, err)
+		} else {
+			r.Error(err)
+		}
+		r.Abort()
+		return
+	}
+	defer resp.Close()
+
+	attributes = append(attributes, attribute.Int(mmetric.AttrHTTPResponseStatusCode, resp.StatusCode))
+	metricManager.HTTPServerProxyRequestTotal.Inc(ctx, mmetric.WithAttributes(attributes...))
+	defer func() {
+		mmetric.RecordHistogram(ctx, metricManager.HTTPServerProxyRequestDuration,
+			float64(time.Since(start).Milliseconds()), mmetric.WithAttributes(attributes...))
+	}()
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		err = p.tunnel(r, resp)
+	} else {
+		err = p.copyResponse(r, resp)
+	}
+	if err != nil {
+		metricManager.HTTPServerProxyErrorTotal.Inc(ctx, mmetric.WithAttributes(attributes...))
+		r.Logger().Warnf(ctx, "proxy to %s: %v", p.config.Upstream, err)
+		r.Abort()
+	}
+}
+
+// send forwards the request to the upstream.
+func (p *proxy) send(r *Request) (*mclient.Response, error) {
+	in := r.Request
+	req := p.config.Client.R().SetContext(in.Context()).Method(in.Method).Stream()
+	req.Request.Header = forwardHeader(in)
+	for key, value := range p.config.Headers {
+		if value == "" {
+			req.Request.Header.Del(key)
+		} else {
+			req.Request.Header.Set(key, value)
+		}
+	}
+
+	if in.Body != nil && in.Body != http.NoBody && in.ContentLength != 0 {
+		if in.ContentLength > 0 && in.ContentLength <= p.config.MaxBufferedBodySize {
+			body, err := io.ReadAll(in.Body)
+			if err != nil {
+				return nil, merror.WrapCode(err, mcode.CodeInvalidRequest, "failed to read proxied request body")
+			}
+			req.SetBody(body)
+		} else {
+			// Streamed bodies cannot be replayed.
+			req.Request.Body = in.Body
+			req.SetRetry(mclient.RetryConfig{})
+		}
+	}
+	if upgradeType(in.Header) != "" {
+		req.SetRetry(mclient.RetryConfig{}).SetHedger(nil)
+	}
+	if p.config.ModifyRequest != nil {
+		p.config.ModifyRequest(r, req)
+	}
+
+	resp, err := req.Send(p.targetURL(in))
+	if err != nil {
+		return nil, merror.WrapCodef(err, mcode.CodeServerBusy, "proxy to %s failed", p.config.Upstream)
+	}
+	return resp, nil
+}
+
+// targetURL returns the upstream URL of the request.
+func (p *proxy) targetURL(in *http.Request) string {
+	path := in.URL.EscapedPath()
+	if p.config.StripPrefix {
+		path = strings.TrimPrefix(path, p.prefix)
+	}
+	if p.config.Rewrite != nil {
+		path = p.config.Rewrite(path)
+	}
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	target := p.target + path
+	if target == "" {
+		target = "/"
+	}
+	if in.URL.RawQuery != "" {
+		target += "?" + in.URL.RawQuery
+	}
+	return target
+}
+
+// copyResponse writes the upstream response, flushing streamed bodies as they arrive.
+func (p *proxy) copyResponse(r *Request, resp *mclient.Response) error {
+	header := r.Writer.Header()
+	for key, values := range removeHopHeaders(resp.Header) {
+		header[key] = values
+	}
+	r.Writer.WriteHeader(resp.StatusCode)
+	if resp.Body == nil {
+		return nil
+	}
+
+	flush := resp.ContentLength < 0 ||
+		strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, writeErr := r.Writer.Write(buf[:n]); writeErr != nil {
+				return merror.Wrap(writeErr, "failed to write proxied response")
+			}
+			if flush {
+				r.Writer.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return merror.Wrap(err, "failed to read upstream response")
+		}
+	}
+}
+
+// tunnel copies the data of an upgraded connection both ways until one side closes it.
+func (p *proxy) tunnel(r *Request, resp *mclient.Response) error {
+	upstream, ok := upgradedConn(resp.Body)
+	if !ok {
+		return merror.NewCode(mcode.CodeNotSupported, "upstream connection of the upgraded response is not writable")
+	}
+	hijacker, ok := r.Writer.(http.Hijacker)
+	if !ok {
+		return merror.NewCode(mcode.CodeNotSupported, "response writer does not support upgrades")
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return merror.Wrap(err, "failed to hijack upgraded connection")
+	}
+	defer conn.Close()
+
+	header := removeHopHeaders(resp.Header)
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", upgradeType(resp.Header))
+	_, _ = buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = header.Write(buffered)
+	_, _ = buffered.WriteString("\r\n")
+	if err = buffered.Flush(); err != nil {
+		return merror.Wrap(err, "failed to write upgrade response")
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(upstream, buffered)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, resp.Body)
+		errCh <- err
+	}()
+	if err = <-errCh; err != nil && !errors.Is(err, net.ErrClosed) {
+		return merror.Wrap(err, "upgraded connection failed")
+	}
+	return nil
+}
+
+// upgradedConn returns the writable connection of an upgraded response body,
+// unwrapping the bodies of the client middlewares. Reads and Close still go
+// through the body so that the middlewares release their resources.
+func upgradedConn(body io.ReadCloser) (io.Writer, bool) {
+	for body != nil {
+		if conn, ok := body.(io.ReadWriteCloser); ok {
+			return conn, true
+		}
+		wrapper, ok := body.(interface{ Unwrap() io.ReadCloser })
+		if !ok {
+			return nil, false
+		}
+		body = wrapper.Unwrap()
+	}
+	return nil, false
+}
+
+// forwardHeader returns the headers forwarded for in, with the X-Forwarded headers set.
+func forwardHeader(in *http.Request) http.Header {
+	header := removeHopHeaders(in.Header)
+	if upgrade := upgradeType(in.Header); upgrade != "" {
+		header.Set("Connection", "Upgrade")
+		header.Set("Upgrade", upgrade)
+	}
+	if ip, _, err := net.SplitHostPort(in.RemoteAddr); err == nil {
+		if prior := header.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		header.Set("X-Forwarded-For", ip)
+	}
+	if header.Get("X-Forwarded-Host") == "" {
+		header.Set("X-Forwarded-Host", in.Host)
+	}
+	if header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if in.TLS != nil {
+			proto = "https"
+		}
+		header.Set("X-Forwarded-Proto", proto)
+	}
+	return header
+}
+
+// removeHopHeaders returns a copy of header without the hop-by-hop headers.
+func removeHopHeaders(header http.Header) http.Header {
+	header = header.Clone()
+	if header == nil {
+		return make(http.Header)
+	}
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+	return header
+}
+
+// upgradeType returns the protocol a request or response upgrades to, empty if none.
+func upgradeType(header http.Header) string {
+	for _, value := range header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return header.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+package mhttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/mhttp"
+	"github.com/graingo/maltose/net/mtrace"
+	"github.com/graingo/maltose/util/mmeta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// --- Test Controller for JSON-RPC ---
+
+type TestRPCController struct {
+	notified chan string
+}
+
+type RPCGreetReq struct {
+	mmeta.Meta `path:"/rpc/greet" method:"get" rpc:"greeter.greet"`
+	Name       string `json:"name" form:"name" binding:"required"`
+}
+type RPCGreetRes struct {
+	Message string `json:"message"`
+}
+
+func (c *TestRPCController) Greet(_ context.Context, req *RPCGreetReq) (*RPCGreetRes, error) {
+	if req.Name == "ghost" {
+		return nil, merror.NewCode(mcode.CodeNotFound, "user ghost not found")
+	}
+	if req.Name == "panic" {
+		panic("boom")
+	}
+	return &RPCGreetRes{Message: "Hello, " + req.Name}, nil
+}
+
+type RPCNotifyReq struct {
+	Event string `json:"event"`
+}
+type RPCNotifyRes struct{}
+
+func (c *TestRPCController) Notify(_ context.Context, req *RPCNotifyReq) (*RPCNotifyRes, error) {
+	c.notified <- req.Event
+	return &RPCNotifyRes{}, nil
+}
+
+func postRPC(t *testing.T, body string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(baseURL+"/rpc", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(data)
+}
+
+// --- Tests ---
+
+func TestJSONRPC(t *testing.T) {
+	controller := &TestRPCController{notified: make(chan string, 10)}
+	teardown := setupServer(t, func(s *mhttp.Server) {
+		s.Use(mhttp.MiddlewareResponse())
+		s.JSONRPC("/rpc", controller)
+	})
+	defer teardown()
+
+	t.Run("single_call", func(t *testing.T) {
+		code, body := postRPC(t, `{"jsonrpc":"2.0","method":"greeter.greet","params":{"name":"maltose"},"id":1}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.JSONEq(t, `{"jsonrpc":"2.0","result":{"message":"Hello, maltose"},"id":1}`, body)
+	})
+
+	t.Run("default_method_name", func(t *testing.T) {
+		code, body := postRPC(t, `{"jsonrpc":"2.0","method":"TestRPCController.Notify","params":{"event":"a"},"id":"n1"}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.JSONEq(t, `{"jsonrpc":"2.0","result":{},"id":"n1"}`, body)
+		assert.Equal(t, "a", <-controller.notified)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			body string
+			code int
+		}{
+			{"parse_error", `{"jsonrpc":"2.0",`, mhttp.JSONRPCParseError},
+			{"invalid_request", `{"jsonrpc":"1.0","method":"greeter.greet","id":1}`, mhttp.JSONRPCInvalidRequest},
+			{"method_not_found", `{"jsonrpc":"2.0","method":"greeter.missing","id":1}`, mhttp.JSONRPCMethodNotFound},
+			{"validation_failed", `{"jsonrpc":"2.0","method":"greeter.greet","params":{},"id":1}`, mhttp.JSONRPCInvalidParams},
+			{"positional_params", `{"jsonrpc":"2.0","method":"greeter.greet","params":["x"],"id":1}`, mhttp.JSONRPCInvalidParams},
+			{"application_error", `{"jsonrpc":"2.0","method":"greeter.greet","params":{"name":"ghost"},"id":1}`, mcode.CodeNotFound.Code()},
+			{"panic", `{"jsonrpc":"2.0","method":"greeter.greet","params":{"name":"panic"},"id":1}`, mhttp.JSONRPCInternalError},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				_, body := postRPC(t, tc.body)
+				var res struct {
+					Error *mhttp.JSONRPCError `json:"error"`
+				}
+				require.NoError(t, json.Unmarshal([]byte(body), &res), body)
+				require.NotNil(t, res.Error, body)
+				assert.Equal(t, tc.code, res.Error.Code, body)
+			})
+		}
+	})
+
+	t.Run("batch_with_notification", func(t *testing.T) {
+		code, body := postRPC(t, `[
+			{"jsonrpc":"2.0","method":"greeter.greet","params":{"name":"a"},"id":1},
+			{"jsonrpc":"2.0","method":"TestRPCController.Notify","params":{"event":"b"}},
+			{"jsonrpc":"2.0","method":"greeter.missing","id":2},
+			1
+		]`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.JSONEq(t, `[
+			{"jsonrpc":"2.0","result":{"message":"Hello, a"},"id":1},
+			{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":2},
+			{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}
+		]`, body)
+		assert.Equal(t, "b", <-controller.notified)
+	})
+
+	t.Run("notifications_only", func(t *testing.T) {
+		code, body := postRPC(t, `[{"jsonrpc":"2.0","method":"TestRPCController.Notify","params":{"event":"c"}}]`)
+		assert.Equal(t, http.StatusNoContent, code)
+		assert.Empty(t, body)
+		assert.Equal(t, "c", <-controller.notified)
+	})
+
+	t.Run("empty_batch", func(t *testing.T) {
+		_, body := postRPC(t, `[]`)
+		assert.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request: empty batch"},"id":null}`, body)
+	})
+}
+
+func TestJSONRPCTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	teardown := setupServer(t, func(s *mhttp.Server) {
+		s.JSONRPC("/rpc", &TestRPCController{notified: make(chan string, 1)})
+	})
+	defer teardown()
+
+	_, _ = postRPC(t, `[
+		{"jsonrpc":"2.0","method":"greeter.greet","params":{"name":"a"},"id":1},
+		{"jsonrpc":"2.0","method":"greeter.greet","params":{"name":"ghost"},"id":2}
+	]`)
+
+	spans := recorder.Ended()
+	var server sdktrace.ReadOnlySpan
+	var calls []sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Name() == "greeter.greet" {
+			calls = append(calls, span)
+		} else if span.Name() == "/rpc" {
+			server = span
+		}
+	}
+	require.NotNil(t, server)
+	require.Len(t, calls, 2)
+	for _, call := range calls {
+		assert.Equal(t, server.SpanContext().SpanID(), call.Parent().SpanID(), "calls are children of the request span")
+	}
+	attrs := map[string]string{}
+	for _, kv := range calls[1].Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	assert.Equal(t, "jsonrpc", attrs[mtrace.AttributeRPCSystem])
+	assert.Equal(t, "2", attrs[mtrace.AttributeRPCJSONRPCRequestID])
+	assert.Equal(t, "1004", attrs[mtrace.AttributeRPCJSONRPCErrorCode])
+}
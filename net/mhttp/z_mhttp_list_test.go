@@ -0,0 +1,309 @@
+package mhttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/database/mdb"
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/mhttp"
+	"github.com/graingo/maltose/util/mmeta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// --- Test Controller for List Endpoints ---
+
+type listItem struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Score  int    `json:"score"`
+}
+
+type TestListController struct {
+	db *mdb.DB
+}
+
+type ListItemsReq struct {
+	mmeta.Meta `path:"/items" method:"get" summary:"List items"`
+	mhttp.PageReq
+	mhttp.SortReq `sort:"id,name,score" default:"id"`
+	Name          mhttp.Filter[string] `form:"name" filter:"eq,like"`
+	Status        mhttp.Filter[string] `form:"status" filter:"eq,in,nin" dc:"Item status"`
+	Score         mhttp.Filter[int]    `form:"score"`
+}
+type ListItemsRes struct {
+	mhttp.PageRes[listItem]
+}
+
+func (c *TestListController) ListItems(ctx context.Context, req *ListItemsReq) (*ListItemsRes, error) {
+	var (
+		items []listItem
+		total int64
+	)
+	db := c.db.WithContext(ctx).Model(&listItem{})
+	if err := db.Scopes(mhttp.FilterScopes(req)...).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	if err := c.db.WithContext(ctx).Scopes(mhttp.ListScopes(req)...).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return &ListItemsRes{PageRes: mhttp.NewPageRes(req.PageReq, items, total)}, nil
+}
+
+type ScrollItemsReq struct {
+	mmeta.Meta      `path:"/items/scroll" method:"get"`
+	mhttp.CursorReq `cursor:"-id"`
+	Status          mhttp.Filter[string] `form:"status"`
+}
+type ScrollItemsRes struct {
+	mhttp.CursorRes[listItem]
+}
+
+func (c *TestListController) ScrollItems(ctx context.Context, req *ScrollItemsReq) (*ScrollItemsRes, error) {
+	var items []listItem
+	if err := c.db.WithContext(ctx).Scopes(mhttp.ListScopes(req)...).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return &ScrollItemsRes{CursorRes: mhttp.NewCursorRes(req.CursorReq, items, func(item listItem) any {
+		return item.ID
+	})}, nil
+}
+
+func setupListDB(t *testing.T) *mdb.DB {
+	t.Helper()
+	db, err := mdb.New(&mdb.Config{
+		Type: "sqlite",
+		DSN:  fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()),
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	require.NoError(t, db.AutoMigrate(&listItem{}))
+	items := []listItem{
+		{ID: 1, Name: "alpha", Status: "active", Score: 10},
+		{ID: 2, Name: "beta", Status: "pending", Score: 30},
+		{ID: 3, Name: "gamma_1", Status: "active", Score: 20},
+		{ID: 4, Name: "delta", Status: "closed", Score: 40},
+		{ID: 5, Name: "gammax1", Status: "active", Score: 50},
+	}
+	require.NoError(t, db.Create(&items).Error)
+	return db
+}
+
+func getListJSON(t *testing.T, path string, out any) int {
+	t.Helper()
+	resp, err := http.Get(baseURL + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	if out != nil && resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.Unmarshal(body, out), string(body))
+	}
+	return resp.StatusCode
+}
+
+func itemIDs(items []listItem) []int64 {
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+// --- Tests ---
+
+func TestListEndpoints(t *testing.T) {
+	db := setupListDB(t)
+	teardown := setupServer(t, func(s *mhttp.Server) {
+		s.SetConfigWithMap(map[string]any{"openapi_path": "/api/v1/openapi.json"})
+		s.Use(mhttp.MiddlewareResponse())
+		s.Bind(&TestListController{db: db})
+	})
+	defer teardown()
+
+	type pageBody struct {
+		Data struct {
+			List  []listItem `json:"list"`
+			Total int64      `json:"total"`
+			Page  int        `json:"page"`
+			Size  int        `json:"size"`
+		} `json:"data"`
+	}
+
+	t.Run("offset_pagination_with_filters", func(t *testing.T) {
+		var body pageBody
+		code := getListJSON(t, "/items?status=in:active,pending&score=gte:20&sort=-score&page=1&size=2", &body)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, int64(3), body.Data.Total)
+		assert.Equal(t, 1, body.Data.Page)
+		assert.Equal(t, 2, body.Data.Size)
+		assert.Equal(t, []int64{5, 2}, itemIDs(body.Data.List))
+
+		code = getListJSON(t, "/items?status=in:active,pending&score=gte:20&sort=-score&page=2&size=2", &body)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []int64{3}, itemIDs(body.Data.List))
+	})
+
+	t.Run("defaults_and_repeated_filters", func(t *testing.T) {
+		var body pageBody
+		code := getListJSON(t, "/items?score=gt:10&score=lt:50", &body)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, mhttp.DefaultPageSize, body.Data.Size)
+		assert.Equal(t, []int64{2, 3, 4}, itemIDs(body.Data.List), "default sort applies")
+	})
+
+	t.Run("like_matches_wildcards_literally", func(t *testing.T) {
+		var body pageBody
+		code := getListJSON(t, "/items?name="+url.QueryEscape("like:a_1"), &body)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []int64{3}, itemIDs(body.Data.List))
+	})
+
+	t.Run("cursor_pagination", func(t *testing.T) {
+		type cursorBody struct {
+			Data struct {
+				List       []listItem `json:"list"`
+				NextCursor string     `json:"next_cursor"`
+				HasMore    bool       `json:"has_more"`
+			} `json:"data"`
+		}
+		var body cursorBody
+		require.Equal(t, http.StatusOK, getListJSON(t, "/items/scroll?limit=2", &body))
+		assert.Equal(t, []int64{5, 4}, itemIDs(body.Data.List))
+		assert.True(t, body.Data.HasMore)
+
+		var seen []int64
+		seen = append(seen, itemIDs(body.Data.List)...)
+		for body.Data.HasMore {
+			cursor := body.Data.NextCursor
+			body = cursorBody{}
+			require.Equal(t, http.StatusOK, getListJSON(t, "/items/scroll?limit=2&cursor="+cursor, &body))
+			seen = append(seen, itemIDs(body.Data.List)...)
+		}
+		assert.Equal(t, []int64{5, 4, 3, 2, 1}, seen)
+		assert.Empty(t, body.Data.NextCursor)
+	})
+
+	t.Run("validation_errors", func(t *testing.T) {
+		for _, query := range []string{
+			"/items?sort=status",             // not whitelisted
+			"/items?sort=id,-id",             // repeated
+			"/items?status=like:act",         // operator not allowed by tag
+			"/items?score=like:1",            // operator not allowed for type
+			"/items?score=abc",               // invalid value
+			"/items?size=1000",               // above max page size
+			"/items/scroll?cursor=not-valid", // undecodable cursor
+		} {
+			assert.Equal(t, http.StatusBadRequest, getListJSON(t, query, nil), query)
+		}
+	})
+
+	t.Run("openapi_parameters", func(t *testing.T) {
+		var spec struct {
+			Paths map[string]struct {
+				Get struct {
+					Parameters []struct {
+						Name        string         `json:"name"`
+						Description string         `json:"description"`
+						Schema      map[string]any `json:"schema"`
+					} `json:"parameters"`
+				} `json:"get"`
+			} `json:"paths"`
+			Components struct {
+				Schemas map[string]struct {
+					Properties map[string]any `json:"properties"`
+				} `json:"schemas"`
+			} `json:"components"`
+		}
+		require.Equal(t, http.StatusOK, getListJSON(t, "/api/v1/openapi.json", &spec))
+
+		params := map[string]int{}
+		operation := spec.Paths["/items"].Get
+		for i, param := range operation.Parameters {
+			params[param.Name] = i
+		}
+		for _, name := range []string{"page", "size", "sort", "name", "status", "score"} {
+			assert.Contains(t, params, name)
+		}
+		size := operation.Parameters[params["size"]]
+		assert.Equal(t, float64(mhttp.MaxPageSize), size.Schema["maximum"])
+		sort := operation.Parameters[params["sort"]]
+		assert.Contains(t, sort.Description, "Allowed fields: id, name, score")
+		assert.Equal(t, "id", sort.Schema["default"])
+		status := operation.Parameters[params["status"]]
+		assert.Contains(t, status.Description, "Item status")
+		assert.Contains(t, status.Description, "Operators: eq, in, nin")
+
+		res, ok := spec.Components.Schemas["ListItemsRes"]
+		require.True(t, ok)
+		for _, name := range []string{"list", "total", "page", "size"} {
+			assert.Contains(t, res.Properties, name)
+		}
+	})
+}
+
+func TestBindList(t *testing.T) {
+	type request struct {
+		mhttp.SortReq `sort:"name,created:created_at"`
+		Name          mhttp.Filter[string]    `form:"name"`
+		Since         mhttp.Filter[time.Time] `form:"since" column:"created_at"`
+		Active        mhttp.Filter[bool]      `form:"active"`
+	}
+
+	t.Run("parses_expressions", func(t *testing.T) {
+		req := &request{SortReq: mhttp.SortReq{Sort: "-created,name"}}
+		values := url.Values{
+			"name":   {"a:b"},
+			"since":  {"gte:2024-01-02", "lt:2024-02-01T08:00:00Z"},
+			"active": {"true"},
+		}
+		require.NoError(t, mhttp.BindList(values, req))
+
+		assert.Equal(t, []mhttp.SortOrder{
+			{Field: "created", Column: "created_at", Desc: true},
+			{Field: "name", Column: "name"},
+		}, req.Orders())
+		require.Len(t, req.Name.Conditions, 1)
+		assert.Equal(t, mhttp.FilterEq, req.Name.Conditions[0].Op)
+		assert.Equal(t, []string{"a:b"}, req.Name.Conditions[0].Values, "unknown prefixes are part of the value")
+		assert.Equal(t, "created_at", req.Since.Column())
+		require.Len(t, req.Since.Conditions, 2)
+		assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), req.Since.Conditions[0].Values[0])
+		assert.Equal(t, mhttp.FilterLt, req.Since.Conditions[1].Op)
+		assert.True(t, req.Active.Conditions[0].Values[0])
+	})
+
+	t.Run("rejects_invalid_values", func(t *testing.T) {
+		for _, values := range []url.Values{
+			{"since": {"gte:yesterday"}},
+			{"active": {"gt:true"}},
+		} {
+			err := mhttp.BindList(values, &request{})
+			require.Error(t, err)
+			assert.Equal(t, mcode.CodeValidationFailed, merror.Code(err))
+		}
+	})
+
+	t.Run("cursor_round_trip", func(t *testing.T) {
+		value, err := mhttp.DecodeCursor(mhttp.EncodeCursor(int64(42)))
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), value)
+
+		value, err = mhttp.DecodeCursor(mhttp.EncodeCursor("2024-01-02"))
+		require.NoError(t, err)
+		assert.Equal(t, "2024-01-02", value)
+	})
+}
@@ -1,16 +1,27 @@
 package mhttp_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/graingo/maltose/database/mredis"
 	"github.com/graingo/maltose/errors/merror"
+	"github.com/graingo/maltose/net/mclient"
 	"github.com/graingo/maltose/net/mhttp"
+	"github.com/graingo/maltose/net/msign"
+	"github.com/graingo/maltose/os/mlog"
 	"github.com/graingo/maltose/util/mmeta"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -320,4 +331,397 @@ func TestMiddleware(t *testing.T) {
 		assert.Equal(t, "custom panic handler", respMap["error"])
 		assert.Contains(t, respMap["message"], "custom panic test")
 	})
+
+	t.Run("log_with_config", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := mlog.New(&mlog.Config{Writer: &buf, Level: mlog.DebugLevel, Format: "json"})
+
+		config := mhttp.DefaultLogConfig()
+		config.Query = true
+		config.Headers = true
+		config.SkipPaths = []string{"/skipped"}
+		config.Body = mhttp.LogBodyConfig{MaxSize: 0, ContentTypes: map[string]int{"application/json": -1}}
+		config.Sampling = mhttp.LogSamplingConfig{RouteRates: map[string]float64{"/users/:id": 0}}
+
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.SetLogger(logger)
+			s.Use(mhttp.MiddlewareLogWithConfig(config))
+			s.POST("/login", func(r *mhttp.Request) {
+				body, _ := io.ReadAll(r.Request.Body)
+				assert.Contains(t, string(body), "hunter2", "handler must still see the original body")
+				r.JSON(http.StatusOK, map[string]any{"token": "issued-token", "user": map[string]any{"name": "alice"}})
+			})
+			s.GET("/users/:id", func(r *mhttp.Request) {
+				if r.Param("id") == "0" {
+					r.String(http.StatusNotFound, "missing")
+					return
+				}
+				r.String(http.StatusOK, "ok")
+			})
+			s.GET("/skipped", func(r *mhttp.Request) {
+				r.String(http.StatusOK, "ok")
+			})
+			s.GET("/plain", func(r *mhttp.Request) {
+				r.String(http.StatusOK, "plain-body")
+			})
+		})
+		defer teardown()
+
+		req, err := http.NewRequest(http.MethodPost, baseURL+"/login?access_token=abc&view=full",
+			strings.NewReader(`{"username":"alice","password":"hunter2"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret-credential")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		logStr := buf.String()
+		assert.Contains(t, logStr, "http server request started")
+		assert.Contains(t, logStr, `access_token=***&view=full`)
+		assert.Contains(t, logStr, `"Authorization":"***"`)
+		assert.NotContains(t, logStr, "hunter2")
+		assert.NotContains(t, logStr, "secret-credential")
+		assert.NotContains(t, logStr, "issued-token")
+		assert.Contains(t, logStr, "alice")
+
+		buf.Reset()
+		resp, err = http.Get(baseURL + "/plain")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.NotContains(t, buf.String(), "plain-body", "text bodies are not captured when only JSON is enabled")
+
+		buf.Reset()
+		for _, path := range []string{"/users/1", "/skipped"} {
+			resp, err = http.Get(baseURL + path)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		assert.Empty(t, buf.String(), "muted routes and skipped paths must not be logged")
+
+		resp, err = http.Get(baseURL + "/users/0")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Contains(t, buf.String(), "http server request finished with warning status")
+	})
+
+	t.Run("log_apache_formats", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := mlog.New(&mlog.Config{Writer: &buf, Level: mlog.DebugLevel, Format: "json"})
+
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.SetLogger(logger)
+			common := s.Group("/common")
+			common.Middleware(mhttp.MiddlewareLogWithConfig(mhttp.LogConfig{
+				Format:    mhttp.LogFormatCommon,
+				Redaction: mhttp.DefaultLogRedactionConfig(),
+			}))
+			common.GET("/ping", func(r *mhttp.Request) {
+				r.String(http.StatusOK, "pong")
+			})
+			combined := s.Group("/combined")
+			combined.Middleware(mhttp.MiddlewareLogWithConfig(mhttp.LogConfig{Format: mhttp.LogFormatCombined}))
+			combined.GET("/ping", func(r *mhttp.Request) {
+				r.String(http.StatusOK, "pong")
+			})
+		})
+		defer teardown()
+
+		resp, err := http.Get(baseURL + "/common/ping?token=abc")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Regexp(t, `127\.0\.0\.1 - - \[[^\]]+\] \\"GET /common/ping\?token=\*\*\* HTTP/1\.1\\" 200 4`, buf.String())
+		assert.NotContains(t, buf.String(), "http server request started")
+
+		buf.Reset()
+		req, err := http.NewRequest(http.MethodGet, baseURL+"/combined/ping", nil)
+		require.NoError(t, err)
+		req.Header.Set("User-Agent", "maltose-test")
+		req.SetBasicAuth("bob", "pw")
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Contains(t, buf.String(), `- bob [`)
+		assert.Contains(t, buf.String(), `200 4 \"-\" \"maltose-test\"`)
+	})
+
+	t.Run("log_json_format", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := mlog.New(&mlog.Config{Writer: &buf, Level: mlog.DebugLevel, Format: "text"})
+
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.SetLogger(logger)
+			s.Use(mhttp.MiddlewareLogWithConfig(mhttp.LogConfig{
+				Format:    mhttp.LogFormatJSON,
+				Query:     true,
+				Redaction: mhttp.DefaultLogRedactionConfig(),
+			}))
+			s.GET("/ping", func(r *mhttp.Request) {
+				r.String(http.StatusOK, "pong")
+			})
+		})
+		defer teardown()
+
+		resp, err := http.Get(baseURL + "/ping?token=abc")
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		logStr := buf.String()
+		assert.NotContains(t, logStr, "http server request started")
+		start := strings.Index(logStr, `{"ip":`)
+		require.NotEqual(t, -1, start, logStr)
+		var entry map[string]any
+		require.NoError(t, json.NewDecoder(strings.NewReader(logStr[start:])).Decode(&entry), logStr)
+		assert.Equal(t, "GET", entry["method"])
+		assert.Equal(t, "/ping", entry["path"])
+		assert.Equal(t, "token=***", entry["query"])
+		assert.EqualValues(t, 200, entry["status"])
+		assert.Contains(t, entry, "latency_ms")
+	})
+
+}
+
+// --- Test Controller for Webhook ---
+
+type TestWebhookController struct{}
+
+type WebhookEventReq struct {
+	mmeta.Meta `path:"/webhook" method:"post"`
+	Event      string `json:"event" binding:"required"`
+}
+type WebhookEventRes struct {
+	Event   string `json:"event"`
+	RawSize int    `json:"raw_size"`
+}
+
+func (c *TestWebhookController) Receive(ctx context.Context, req *WebhookEventReq) (*WebhookEventRes, error) {
+	return &WebhookEventRes{Event: req.Event, RawSize: len(mhttp.RequestFromCtx(ctx).RawBody())}, nil
+}
+
+// memoryNonceStore is an in-process WebhookNonceStore for tests.
+type memoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]bool
+}
+
+func (s *memoryNonceStore) Claim(_ context.Context, nonce string, _ time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nonces[nonce] {
+		return false, nil
+	}
+	s.nonces[nonce] = true
+	return true, nil
+}
+
+func signWebhook(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestMiddlewareWebhook(t *testing.T) {
+	const body = `{"event":"push"}`
+
+	send := func(t *testing.T, headers map[string]string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, baseURL+"/webhook", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(data)
+	}
+
+	t.Run("body_signature_with_rotation", func(t *testing.T) {
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.Use(mhttp.MiddlewareResponse())
+			s.Group("/", func(group *mhttp.RouterGroup) {
+				group.Middleware(mhttp.MiddlewareWebhook(mhttp.WebhookConfig{
+					SignatureHeader: "X-Hub-Signature-256",
+					SignaturePrefix: "sha256=",
+					Secrets:         []string{"new-secret", "old-secret"},
+				}))
+				group.Bind(&TestWebhookController{})
+			})
+		})
+		defer teardown()
+
+		for _, secret := range []string{"new-secret", "old-secret"} {
+			code, data := send(t, map[string]string{"X-Hub-Signature-256": "sha256=" + signWebhook(secret, body)})
+			assert.Equal(t, http.StatusOK, code, data)
+			assert.JSONEq(t, `{"code":0,"message":"OK","data":{"event":"push","raw_size":16}}`, data)
+		}
+
+		code, _ := send(t, map[string]string{"X-Hub-Signature-256": "sha256=" + signWebhook("retired", body)})
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = send(t, nil)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("timestamp_template_and_replay", func(t *testing.T) {
+		store := &memoryNonceStore{nonces: map[string]bool{}}
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.Use(mhttp.MiddlewareResponse())
+			s.Group("/", func(group *mhttp.RouterGroup) {
+				group.Middleware(mhttp.MiddlewareWebhook(mhttp.WebhookConfig{
+					SignatureHeader: "X-Signature",
+					SignaturePrefix: "v0=",
+					Template:        "v0:{timestamp}:{body}",
+					Secrets:         []string{"secret"},
+					TimestampHeader: "X-Timestamp",
+					Tolerance:       time.Minute,
+					NonceStore:      store,
+				}))
+				group.Bind(&TestWebhookController{})
+			})
+		})
+		defer teardown()
+
+		signed := func(at time.Time) map[string]string {
+			ts := strconv.FormatInt(at.Unix(), 10)
+			return map[string]string{
+				"X-Timestamp": ts,
+				"X-Signature": "v0=" + signWebhook("secret", "v0:"+ts+":"+body),
+			}
+		}
+
+		headers := signed(time.Now())
+		code, data := send(t, headers)
+		assert.Equal(t, http.StatusOK, code, data)
+
+		code, data = send(t, headers)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Contains(t, data, "already been received")
+
+		code, data = send(t, signed(time.Now().Add(-2*time.Minute)))
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Contains(t, data, "tolerance")
+	})
+
+	t.Run("redis_nonce_store", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		redis, err := mredis.New(&mredis.Config{Address: server.Addr()})
+		require.NoError(t, err)
+		defer redis.Close()
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.Use(mhttp.MiddlewareResponse())
+			s.Group("/", func(group *mhttp.RouterGroup) {
+				group.Middleware(mhttp.MiddlewareWebhook(mhttp.WebhookConfig{
+					SignatureHeader: "X-Hub-Signature-256",
+					SignaturePrefix: "sha256=",
+					Secrets:         []string{"secret"},
+					NonceHeader:     "X-GitHub-Delivery",
+					NonceStore:      mhttp.NewWebhookRedisNonceStore(redis, "hooks:"),
+					NonceTTL:        time.Hour,
+				}))
+				group.Bind(&TestWebhookController{})
+			})
+		})
+		defer teardown()
+
+		delivery := func(id string) map[string]string {
+			return map[string]string{
+				"X-Hub-Signature-256": "sha256=" + signWebhook("secret", body),
+				"X-GitHub-Delivery":   id,
+			}
+		}
+		code, data := send(t, delivery("d-1"))
+		assert.Equal(t, http.StatusOK, code, data)
+		assert.True(t, server.Exists("hooks:d-1"))
+		assert.Equal(t, time.Hour, server.TTL("hooks:d-1"))
+
+		code, data = send(t, delivery("d-1"))
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Contains(t, data, "already been received")
+		// The delivery ID is not signed, so the same signature is rejected under a new one.
+		code, data = send(t, delivery("d-2"))
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Contains(t, data, "already been received")
+	})
+
+	t.Run("signed_nonce", func(t *testing.T) {
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.Use(mhttp.MiddlewareResponse())
+			s.Group("/", func(group *mhttp.RouterGroup) {
+				group.Middleware(mhttp.MiddlewareWebhook(mhttp.WebhookConfig{
+					SignatureHeader: "X-Signature",
+					Template:        "{nonce}.{body}",
+					Secrets:         []string{"secret"},
+					NonceHeader:     "X-Delivery",
+					NonceStore:      &memoryNonceStore{nonces: map[string]bool{}},
+				}))
+				group.Bind(&TestWebhookController{})
+			})
+		})
+		defer teardown()
+
+		delivery := func(id string) map[string]string {
+			return map[string]string{
+				"X-Signature": signWebhook("secret", id+"."+body),
+				"X-Delivery":  id,
+			}
+		}
+		code, data := send(t, delivery("d-1"))
+		assert.Equal(t, http.StatusOK, code, data)
+		code, data = send(t, delivery("d-2"))
+		assert.Equal(t, http.StatusOK, code, data)
+		code, _ = send(t, delivery("d-1"))
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("unsigned_timestamp_is_rejected", func(t *testing.T) {
+		assert.Panics(t, func() {
+			mhttp.MiddlewareWebhook(mhttp.WebhookConfig{
+				SignatureHeader: "X-Signature",
+				Secrets:         []string{"secret"},
+				TimestampHeader: "X-Timestamp",
+			})
+		})
+	})
+}
+
+func TestMiddlewareSignature(t *testing.T) {
+	config := msign.HMACConfig{KeyID: "app", Secret: "secret", NonceStore: &memoryNonceStore{nonces: map[string]bool{}}}
+	teardown := setupServer(t, func(s *mhttp.Server) {
+		s.Use(mhttp.MiddlewareResponse())
+		s.Group("/", func(group *mhttp.RouterGroup) {
+			group.Middleware(mhttp.MiddlewareSignature(mhttp.SignatureConfig{Verifier: msign.NewHMACVerifier(config)}))
+			group.Bind(&TestWebhookController{})
+		})
+	})
+	defer teardown()
+
+	resp, err := mclient.New().
+		WithSigner(msign.NewHMACSigner(config)).
+		R().
+		SetBody(map[string]string{"event": "push"}).
+		Post(baseURL + "/webhook")
+	require.NoError(t, err)
+	defer resp.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"code":0,"message":"OK","data":{"event":"push","raw_size":16}}`, resp.ReadAllString())
+
+	// The same signed request is replayed.
+	replay, err := http.NewRequest(http.MethodPost, baseURL+"/webhook", strings.NewReader(`{"event":"push"}`))
+	require.NoError(t, err)
+	replay.Header = resp.Request.Header.Clone()
+	replayed, err := http.DefaultClient.Do(replay)
+	require.NoError(t, err)
+	defer replayed.Body.Close()
+	data, err := io.ReadAll(replayed.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, replayed.StatusCode)
+	assert.Contains(t, string(data), "already been received")
+
+	unsigned, err := mclient.New().R().SetBody(map[string]string{"event": "push"}).Post(baseURL + "/webhook")
+	require.NoError(t, err)
+	defer unsigned.Close()
+	assert.Equal(t, http.StatusUnauthorized, unsigned.StatusCode)
 }
@@ -0,0 +1,200 @@
+package mhttp_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/mclient"
+	"github.com/graingo/maltose/net/mhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy(t *testing.T) {
+	t.Run("forwards_requests", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Upstream", "orders")
+			w.Header().Set("Connection", "X-Hop")
+			w.Header().Set("X-Hop", "dropped")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = fmt.Fprintf(w, "%s %s body=%s token=%s version=%s forwarded=%t hop=%s traced=%t",
+				r.Method, r.URL.RequestURI(), body, r.Header.Get("Authorization"), r.Header.Get("X-Version"),
+				r.Header.Get("X-Forwarded-For") != "", r.Header.Get("X-Hop"),
+				strings.Contains(r.Header.Get("Traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736"))
+		}))
+		defer upstream.Close()
+
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			api := s.Group("/api")
+			api.Middleware(func(r *mhttp.Request) {
+				if r.GetHeader("Authorization") == "" {
+					r.String(http.StatusUnauthorized, "denied")
+					r.Abort()
+					return
+				}
+				r.Next()
+			})
+			api.Proxy("/orders", upstream.URL+"/v1", mhttp.ProxyConfig{
+				StripPrefix: true,
+				Headers:     map[string]string{"X-Version": "2"},
+			})
+		})
+		defer teardown()
+
+		req, err := http.NewRequest(http.MethodPost, baseURL+"/api/orders/42/items?limit=5", strings.NewReader("payload"))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("Connection", "X-Hop")
+		req.Header.Set("X-Hop", "dropped")
+		req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "orders", resp.Header.Get("X-Upstream"))
+		assert.Empty(t, resp.Header.Get("X-Hop"))
+		assert.Equal(t, "POST /v1/42/items?limit=5 body=payload token=Bearer token version=2 forwarded=true hop= traced=true", string(body))
+
+		resp, err = http.Get(baseURL + "/api/orders")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("balances_and_retries", func(t *testing.T) {
+		var failed atomic.Int32
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			failed.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer failing.Close()
+		healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok " + r.URL.Path))
+		}))
+		defer healthy.Close()
+
+		client := mclient.NewWithConfig(mclient.ClientConfig{
+			Endpoints:   []string{failing.URL, healthy.URL},
+			LoadBalance: mclient.BalanceRoundRobin,
+			Retry:       mclient.RetryConfig{Count: 1, BaseInterval: time.Millisecond},
+		}).SetRedirectLimit(0)
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.Proxy("/users", "", mhttp.ProxyConfig{Client: client})
+		})
+		defer teardown()
+
+		for i := 0; i < 4; i++ {
+			resp, err := http.Get(baseURL + "/users/1")
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "ok /users/1", string(body))
+		}
+		assert.Positive(t, failed.Load())
+	})
+
+	t.Run("upstream_failure", func(t *testing.T) {
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.Proxy("/down", "http://127.0.0.1:1", mhttp.ProxyConfig{
+				ErrorHandler: func(r *mhttp.Request, err error) {
+					r.String(http.StatusBadGateway, "bad gateway")
+				},
+			})
+		})
+		defer teardown()
+
+		resp, err := http.Get(baseURL + "/down/x")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	})
+
+	t.Run("streams_response", func(t *testing.T) {
+		next := make(chan struct{})
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+			<-next
+			_, _ = w.Write([]byte("data: 2\n\n"))
+		}))
+		defer upstream.Close()
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.Proxy("/events", upstream.URL)
+		})
+		defer teardown()
+
+		resp, err := http.Get(baseURL + "/events")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		reader := bufio.NewReader(resp.Body)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "data: 1\n", line)
+		close(next)
+		rest, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "\ndata: 2\n\n", string(rest))
+	})
+
+	t.Run("tunnels_upgrades", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") != "echo" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			conn, buffered, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+			_ = buffered.Flush()
+			line, _ := buffered.ReadString('\n')
+			_, _ = conn.Write([]byte("echo: " + line))
+		}))
+		defer upstream.Close()
+		// The bulkhead wraps the body of the upgraded response.
+		bulkhead := mclient.NewBulkhead(mclient.BulkheadConfig{MaxConcurrent: 1})
+		wrapping := mclient.New().SetTimeout(0).SetRedirectLimit(0).Use(bulkhead.Middleware())
+		teardown := setupServer(t, func(s *mhttp.Server) {
+			s.Proxy("/ws", upstream.URL)
+			s.Proxy("/wrapped", upstream.URL, mhttp.ProxyConfig{Client: wrapping})
+		})
+		defer teardown()
+
+		for _, path := range []string{"/ws", "/wrapped", "/wrapped"} {
+			conn, err := net.Dial("tcp", strings.TrimPrefix(baseURL, "http://"))
+			require.NoError(t, err)
+			_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+			require.NoError(t, err)
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, nil)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode, path)
+			assert.Equal(t, "echo", resp.Header.Get("Upgrade"))
+
+			_, err = conn.Write([]byte("ping\n"))
+			require.NoError(t, err)
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, "echo: ping\n", line)
+			_ = conn.Close()
+		}
+		// The second tunnel through the bulkhead shows the slot was released.
+		assert.Eventually(t, func() bool { return bulkhead.InFlight() == 0 }, time.Second, 10*time.Millisecond)
+	})
+}
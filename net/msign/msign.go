@@ -0,0 +1,131 @@
+// Package msign signs HTTP requests and verifies their signatures, so that
+// services can authenticate each other and call partner APIs requiring signed
+// requests. Signers are used by mclient.MiddlewareSign and verifiers by
+// mhttp.MiddlewareSignature.
+package msign
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signer adds the signature of a request to its headers.
+type Signer interface {
+	// Sign signs r, whose body is body, at time now.
+	Sign(r *http.Request, body []byte, now time.Time) error
+}
+
+// Verifier checks the signature of a request.
+type Verifier interface {
+	// Verify returns an error if the signature of r, whose body is body, is
+	// missing or invalid at time now.
+	Verify(r *http.Request, body []byte, now time.Time) error
+}
+
+// Canonicalizer builds the canonical form of a request that is signed.
+type Canonicalizer interface {
+	// Canonicalize returns the canonical request of r including the headers signedHeaders.
+	Canonicalize(r *http.Request, body []byte, signedHeaders []string) string
+}
+
+// CanonicalizerFunc is an adapter to use a function as a Canonicalizer.
+type CanonicalizerFunc func(r *http.Request, body []byte, signedHeaders []string) string
+
+// Canonicalize calls f(r, body, signedHeaders).
+func (f CanonicalizerFunc) Canonicalize(r *http.Request, body []byte, signedHeaders []string) string {
+	return f(r, body, signedHeaders)
+}
+
+// NonceStore records the nonces of accepted requests to reject replays.
+// mhttp.NewWebhookRedisNonceStore returns a Redis-backed implementation.
+type NonceStore interface {
+	// Claim records nonce for ttl. It returns false if nonce was already recorded.
+	Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// DefaultCanonicalizer builds the canonical request as the lines of the
+// method, the escaped path, the sorted query, the signed headers as
+// "name:value", the signed header names joined by ";" and the hex SHA-256 of
+// the body.
+var DefaultCanonicalizer Canonicalizer = CanonicalizerFunc(defaultCanonicalize)
+
+func defaultCanonicalize(r *http.Request, body []byte, signedHeaders []string) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	var canonical strings.Builder
+	canonical.WriteString(r.Method + "\n")
+	canonical.WriteString(path + "\n")
+	canonical.WriteString(canonicalQuery(r.URL.Query(), url.QueryEscape) + "\n")
+	canonical.WriteString(canonicalHeaders(r, signedHeaders))
+	canonical.WriteString(strings.Join(signedHeaders, ";") + "\n")
+	canonical.WriteString(hashHex(body))
+	return canonical.String()
+}
+
+// canonicalQuery returns the query sorted by name and value, escaped by escape.
+func canonicalQuery(query url.Values, escape func(string) string) string {
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, escape(key)+"="+escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// canonicalHeaders returns a "name:value\n" line of each signed header, with
+// the values of repeated headers joined by commas and spaces folded.
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+	var lines strings.Builder
+	for _, name := range signedHeaders {
+		var values []string
+		if name == "host" {
+			values = []string{requestHost(r)}
+		} else {
+			values = append([]string(nil), r.Header.Values(name)...)
+		}
+		for i, value := range values {
+			values[i] = strings.Join(strings.Fields(value), " ")
+		}
+		lines.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	return lines.String()
+}
+
+// normalizeSignedHeaders returns the lower-cased, sorted and deduplicated header names.
+func normalizeSignedHeaders(names []string) []string {
+	seen := make(map[string]struct{}, len(names))
+	headers := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := seen[name]; ok || name == "" {
+			continue
+		}
+		seen[name] = struct{}{}
+		headers = append(headers, name)
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+// requestHost returns the host of a client or server request.
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.URL.Host
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,190 @@
+package msign
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+)
+
+// HMACConfig defines a signing scheme where the HMAC of the canonical request
+// is sent in a header, together with the key ID, a timestamp and a nonce.
+// The signer and the verifier of a request must use the same scheme.
+type HMACConfig struct {
+	// KeyID identifies the secret of the signer. It is sent in KeyIDHeader.
+	KeyID string
+	// Secret is the secret of the signer.
+	Secret string
+	// Secrets are the secrets accepted by the verifier by key ID. Several
+	// secrets of a key ID can be active during a rotation. By default it is
+	// Secret under KeyID.
+	Secrets map[string][]string
+	// Algorithm is the HMAC hash: "sha1", "sha256" (default) or "sha512".
+	Algorithm string
+	// Canonicalizer builds the signed request. Default is DefaultCanonicalizer.
+	Canonicalizer Canonicalizer
+	// SignedHeaders are signed in addition to the host, timestamp and nonce
+	// headers. Default is Content-Type.
+	SignedHeaders []string
+	// SignatureHeader is the header carrying the hex signature. Default is "X-Signature".
+	SignatureHeader string
+	// KeyIDHeader is the header carrying KeyID. Default is "X-Key-Id".
+	KeyIDHeader string
+	// TimestampHeader is the header carrying the Unix time of signing. Default is "X-Timestamp".
+	TimestampHeader string
+	// NonceHeader is the header carrying a random nonce. Default is "X-Nonce".
+	NonceHeader string
+	// Tolerance is the accepted clock difference for timestamps. Default is 5m.
+	Tolerance time.Duration
+	// NonceStore enables replay protection on the verifier: a nonce is accepted
+	// only once within NonceTTL.
+	NonceStore NonceStore
+	// NonceTTL is how long nonces are remembered, twice the Tolerance by default.
+	NonceTTL time.Duration
+}
+
+var hmacHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func normalizeHMACConfig(config HMACConfig) HMACConfig {
+	if config.Algorithm == "" {
+		config.Algorithm = "sha256"
+	}
+	if hmacHashes[config.Algorithm] == nil {
+		panic(merror.NewCodef(mcode.CodeInvalidConfiguration, "unsupported HMAC algorithm %q", config.Algorithm))
+	}
+	if config.Canonicalizer == nil {
+		config.Canonicalizer = DefaultCanonicalizer
+	}
+	if config.SignedHeaders == nil {
+		config.SignedHeaders = []string{"Content-Type"}
+	}
+	if config.SignatureHeader == "" {
+		config.SignatureHeader = "X-Signature"
+	}
+	if config.KeyIDHeader == "" {
+		config.KeyIDHeader = "X-Key-Id"
+	}
+	if config.TimestampHeader == "" {
+		config.TimestampHeader = "X-Timestamp"
+	}
+	if config.NonceHeader == "" {
+		config.NonceHeader = "X-Nonce"
+	}
+	if config.Tolerance <= 0 {
+		config.Tolerance = 5 * time.Minute
+	}
+	if config.NonceTTL <= 0 {
+		config.NonceTTL = 2 * config.Tolerance
+	}
+	if config.Secrets == nil && config.Secret != "" {
+		config.Secrets = map[string][]string{config.KeyID: {config.Secret}}
+	}
+	config.SignedHeaders = normalizeSignedHeaders(append([]string{
+		"host", config.TimestampHeader, config.NonceHeader,
+	}, config.SignedHeaders...))
+	return config
+}
+
+// hmacScheme signs and verifies requests with an HMACConfig.
+type hmacScheme struct {
+	config HMACConfig
+}
+
+// NewHMACSigner creates a signer of the scheme config.
+func NewHMACSigner(config HMACConfig) Signer {
+	if config.Secret == "" {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "HMAC signer secret is required"))
+	}
+	return &hmacScheme{config: normalizeHMACConfig(config)}
+}
+
+// NewHMACVerifier creates a verifier of the scheme config.
+func NewHMACVerifier(config HMACConfig) Verifier {
+	config = normalizeHMACConfig(config)
+	if len(config.Secrets) == 0 {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "HMAC verifier secrets are required"))
+	}
+	return &hmacScheme{config: config}
+}
+
+// Sign implements Signer.
+func (s *hmacScheme) Sign(r *http.Request, body []byte, now time.Time) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return merror.Wrap(err, "failed to generate signature nonce")
+	}
+	if s.config.KeyID != "" {
+		r.Header.Set(s.config.KeyIDHeader, s.config.KeyID)
+	}
+	r.Header.Set(s.config.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	r.Header.Set(s.config.NonceHeader, hex.EncodeToString(nonce))
+	r.Header.Set(s.config.SignatureHeader, hex.EncodeToString(s.mac(s.config.Secret, r, body)))
+	return nil
+}
+
+// Verify implements Verifier.
+func (s *hmacScheme) Verify(r *http.Request, body []byte, now time.Time) error {
+	signature, err := hex.DecodeString(r.Header.Get(s.config.SignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return merror.NewCodef(mcode.CodeNotAuthorized, "missing or malformed signature header %s", s.config.SignatureHeader)
+	}
+	secrets, ok := s.config.Secrets[r.Header.Get(s.config.KeyIDHeader)]
+	if !ok {
+		return merror.NewCodef(mcode.CodeNotAuthorized, "unknown signature key %q", r.Header.Get(s.config.KeyIDHeader))
+	}
+
+	timestamp := r.Header.Get(s.config.TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return merror.NewCodef(mcode.CodeNotAuthorized, "invalid signature timestamp %q", timestamp)
+	}
+	if diff := now.Sub(time.Unix(seconds, 0)); diff > s.config.Tolerance || diff < -s.config.Tolerance {
+		return merror.NewCode(mcode.CodeNotAuthorized, "signature timestamp is outside the tolerance window")
+	}
+	nonce := r.Header.Get(s.config.NonceHeader)
+	if nonce == "" {
+		return merror.NewCodef(mcode.CodeNotAuthorized, "missing signature nonce header %s", s.config.NonceHeader)
+	}
+
+	matched := false
+	for _, secret := range secrets {
+		if hmac.Equal(signature, s.mac(secret, r, body)) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return merror.NewCode(mcode.CodeNotAuthorized, "invalid request signature")
+	}
+
+	if s.config.NonceStore != nil {
+		claimed, err := s.config.NonceStore.Claim(r.Context(), nonce, s.config.NonceTTL)
+		if err != nil {
+			return merror.WrapCode(err, mcode.CodeInternalError, "failed to record signature nonce")
+		}
+		if !claimed {
+			return merror.NewCode(mcode.CodeNotAuthorized, "signed request has already been received")
+		}
+	}
+	return nil
+}
+
+// mac returns the HMAC of the canonical request of r with secret.
+func (s *hmacScheme) mac(secret string, r *http.Request, body []byte) []byte {
+	mac := hmac.New(hmacHashes[s.config.Algorithm], []byte(secret))
+	mac.Write([]byte(s.config.Canonicalizer.Canonicalize(r, body, s.config.SignedHeaders)))
+	return mac.Sum(nil)
+}
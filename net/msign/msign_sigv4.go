@@ -0,0 +1,233 @@
+package msign
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graingo/maltose/errors/mcode"
+	"github.com/graingo/maltose/errors/merror"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+
+	headerAmzDate          = "X-Amz-Date"
+	headerAmzContentSHA256 = "X-Amz-Content-Sha256"
+	headerAmzSecurityToken = "X-Amz-Security-Token"
+)
+
+// SigV4Config defines the AWS Signature Version 4 scheme, where the signature
+// is sent in the Authorization header.
+type SigV4Config struct {
+	// AccessKeyID identifies the credentials of the signer.
+	AccessKeyID string
+	// SecretAccessKey is the secret of the signer.
+	SecretAccessKey string
+	// SessionToken is sent in the X-Amz-Security-Token header of temporary credentials.
+	SessionToken string
+	// Region is the region of the service, e.g. "us-east-1".
+	Region string
+	// Service is the name of the service, e.g. "s3". The path is encoded once,
+	// as S3 and most non-AWS implementations expect.
+	Service string
+	// SignedHeaders are signed in addition to the host and X-Amz-* headers, e.g. Content-Type.
+	SignedHeaders []string
+	// ContentSHA256 sends the X-Amz-Content-Sha256 header, which S3 requires.
+	ContentSHA256 bool
+	// Credentials returns the secret of an access key on the verifier.
+	// By default only AccessKeyID is accepted.
+	Credentials func(ctx context.Context, accessKeyID string) (secret string, err error)
+	// Tolerance is the accepted clock difference for the request time. Default is 15m.
+	Tolerance time.Duration
+}
+
+// sigV4Scheme signs and verifies requests with a SigV4Config.
+type sigV4Scheme struct {
+	config SigV4Config
+}
+
+func normalizeSigV4Config(config SigV4Config) SigV4Config {
+	if config.Region == "" || config.Service == "" {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "SigV4 region and service are required"))
+	}
+	if config.Tolerance <= 0 {
+		config.Tolerance = 15 * time.Minute
+	}
+	if config.Credentials == nil && config.AccessKeyID != "" {
+		accessKeyID, secret := config.AccessKeyID, config.SecretAccessKey
+		config.Credentials = func(_ context.Context, id string) (string, error) {
+			if id != accessKeyID {
+				return "", merror.NewCodef(mcode.CodeNotAuthorized, "unknown access key %q", id)
+			}
+			return secret, nil
+		}
+	}
+	return config
+}
+
+// NewSigV4Signer creates a signer of the scheme config.
+func NewSigV4Signer(config SigV4Config) Signer {
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "SigV4 signer credentials are required"))
+	}
+	return &sigV4Scheme{config: normalizeSigV4Config(config)}
+}
+
+// NewSigV4Verifier creates a verifier of the scheme config.
+func NewSigV4Verifier(config SigV4Config) Verifier {
+	config = normalizeSigV4Config(config)
+	if config.Credentials == nil {
+		panic(merror.NewCode(mcode.CodeInvalidConfiguration, "SigV4 verifier credentials are required"))
+	}
+	return &sigV4Scheme{config: config}
+}
+
+// Sign implements Signer.
+func (s *sigV4Scheme) Sign(r *http.Request, body []byte, now time.Time) error {
+	now = now.UTC()
+	payloadHash := hashHex(body)
+	r.Header.Set(headerAmzDate, now.Format(sigV4TimeFormat))
+	signedHeaders := append([]string{"host", headerAmzDate}, s.config.SignedHeaders...)
+	if s.config.ContentSHA256 {
+		r.Header.Set(headerAmzContentSHA256, payloadHash)
+		signedHeaders = append(signedHeaders, headerAmzContentSHA256)
+	}
+	if s.config.SessionToken != "" {
+		r.Header.Set(headerAmzSecurityToken, s.config.SessionToken)
+		signedHeaders = append(signedHeaders, headerAmzSecurityToken)
+	}
+	signedHeaders = normalizeSignedHeaders(signedHeaders)
+
+	scope := s.scope(now)
+	signature := s.signature(s.config.SecretAccessKey, now, scope, r, signedHeaders, payloadHash)
+	r.Header.Set("Authorization", sigV4Algorithm+
+		" Credential="+s.config.AccessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+
+		", Signature="+signature)
+	return nil
+}
+
+// Verify implements Verifier.
+func (s *sigV4Scheme) Verify(r *http.Request, body []byte, now time.Time) error {
+	authorization, found := strings.CutPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" ")
+	if !found {
+		return merror.NewCode(mcode.CodeNotAuthorized, "missing SigV4 authorization header")
+	}
+	fields := make(map[string]string, 3)
+	for _, field := range strings.Split(authorization, ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(field), "="); ok {
+			fields[key] = value
+		}
+	}
+	credential := strings.SplitN(fields["Credential"], "/", 2)
+	if len(credential) != 2 || fields["SignedHeaders"] == "" || fields["Signature"] == "" {
+		return merror.NewCode(mcode.CodeNotAuthorized, "malformed SigV4 authorization header")
+	}
+
+	signedAt, err := time.Parse(sigV4TimeFormat, r.Header.Get(headerAmzDate))
+	if err != nil {
+		return merror.NewCodef(mcode.CodeNotAuthorized, "invalid %s header", headerAmzDate)
+	}
+	if diff := now.Sub(signedAt); diff > s.config.Tolerance || diff < -s.config.Tolerance {
+		return merror.NewCode(mcode.CodeNotAuthorized, "signature time is outside the tolerance window")
+	}
+	scope := s.scope(signedAt)
+	if credential[1] != scope {
+		return merror.NewCodef(mcode.CodeNotAuthorized, "invalid SigV4 credential scope %q", credential[1])
+	}
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-date"} {
+		if !containsString(signedHeaders, required) {
+			return merror.NewCodef(mcode.CodeNotAuthorized, "SigV4 signed headers must include %s", required)
+		}
+	}
+
+	payloadHash := hashHex(body)
+	if header := r.Header.Get(headerAmzContentSHA256); header != "" && header != payloadHash {
+		return merror.NewCodef(mcode.CodeNotAuthorized, "%s does not match the body", headerAmzContentSHA256)
+	}
+	secret, err := s.config.Credentials(r.Context(), credential[0])
+	if err != nil {
+		return merror.WrapCode(err, mcode.CodeNotAuthorized, "failed to get SigV4 credentials")
+	}
+	expected := s.signature(secret, signedAt, scope, r, signedHeaders, payloadHash)
+	if !hmac.Equal([]byte(fields["Signature"]), []byte(expected)) {
+		return merror.NewCode(mcode.CodeNotAuthorized, "invalid request signature")
+	}
+	return nil
+}
+
+// scope returns the credential scope of a request signed at t.
+func (s *sigV4Scheme) scope(t time.Time) string {
+	return t.UTC().Format(sigV4DateFormat) + "/" + s.config.Region + "/" + s.config.Service + "/aws4_request"
+}
+
+// signature returns the hex SigV4 signature of r.
+func (s *sigV4Scheme) signature(secret string, t time.Time, scope string, r *http.Request, signedHeaders []string, payloadHash string) string {
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		sigV4Escape(path, false),
+		canonicalQuery(r.URL.Query(), func(value string) string { return sigV4Escape(value, true) }),
+		canonicalHeaders(r, signedHeaders),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		t.UTC().Format(sigV4TimeFormat),
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secret), t.UTC().Format(sigV4DateFormat))
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, s.config.Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sigV4Escape percent-encodes every byte but the unreserved characters, and
+// the slashes unless encodeSlash is set.
+func sigV4Escape(value string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			escaped.WriteByte(c)
+		default:
+			escaped.WriteByte('%')
+			escaped.WriteByte(hexDigits[c>>4])
+			escaped.WriteByte(hexDigits[c&15])
+		}
+	}
+	return escaped.String()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
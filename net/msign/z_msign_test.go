@@ -0,0 +1,146 @@
+package msign_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/graingo/maltose/net/msign"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]struct{}
+}
+
+func (s *memoryNonceStore) Claim(_ context.Context, nonce string, _ time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.nonces[nonce]; ok {
+		return false, nil
+	}
+	s.nonces[nonce] = struct{}{}
+	return true, nil
+}
+
+func newSignedRequest(t *testing.T, signer msign.Signer, body string, now time.Time) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "http://api.example.com/orders?b=2&a=1", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	require.NoError(t, signer.Sign(r, []byte(body), now))
+	return r
+}
+
+func TestHMAC(t *testing.T) {
+	now := time.Now()
+	config := msign.HMACConfig{KeyID: "app", Secret: "secret"}
+	signer := msign.NewHMACSigner(config)
+
+	t.Run("valid_signature", func(t *testing.T) {
+		r := newSignedRequest(t, signer, `{"id":1}`, now)
+		assert.Equal(t, "app", r.Header.Get("X-Key-Id"))
+		assert.NotEmpty(t, r.Header.Get("X-Nonce"))
+		assert.NoError(t, msign.NewHMACVerifier(config).Verify(r, []byte(`{"id":1}`), now))
+	})
+
+	t.Run("tampered_request", func(t *testing.T) {
+		verifier := msign.NewHMACVerifier(config)
+		r := newSignedRequest(t, signer, `{"id":1}`, now)
+		assert.Error(t, verifier.Verify(r, []byte(`{"id":2}`), now))
+
+		r = newSignedRequest(t, signer, `{"id":1}`, now)
+		r.URL.RawQuery = "a=1&b=3"
+		assert.Error(t, verifier.Verify(r, []byte(`{"id":1}`), now))
+
+		r = newSignedRequest(t, signer, `{"id":1}`, now)
+		r.Header.Set("Content-Type", "text/plain")
+		assert.Error(t, verifier.Verify(r, []byte(`{"id":1}`), now))
+	})
+
+	t.Run("query_order_is_ignored", func(t *testing.T) {
+		r := newSignedRequest(t, signer, "", now)
+		r.URL.RawQuery = "a=1&b=2"
+		assert.NoError(t, msign.NewHMACVerifier(config).Verify(r, nil, now))
+	})
+
+	t.Run("stale_timestamp", func(t *testing.T) {
+		r := newSignedRequest(t, signer, "", now.Add(-10*time.Minute))
+		assert.Error(t, msign.NewHMACVerifier(config).Verify(r, nil, now))
+	})
+
+	t.Run("rotated_secrets", func(t *testing.T) {
+		verifier := msign.NewHMACVerifier(msign.HMACConfig{
+			Secrets: map[string][]string{"app": {"new", "secret"}},
+		})
+		assert.NoError(t, verifier.Verify(newSignedRequest(t, signer, "", now), nil, now))
+
+		other := msign.NewHMACSigner(msign.HMACConfig{KeyID: "other", Secret: "secret"})
+		assert.Error(t, verifier.Verify(newSignedRequest(t, other, "", now), nil, now))
+	})
+
+	t.Run("replayed_nonce", func(t *testing.T) {
+		verifier := msign.NewHMACVerifier(msign.HMACConfig{
+			KeyID:      "app",
+			Secret:     "secret",
+			NonceStore: &memoryNonceStore{nonces: map[string]struct{}{}},
+		})
+		r := newSignedRequest(t, signer, "", now)
+		assert.NoError(t, verifier.Verify(r, nil, now))
+		assert.Error(t, verifier.Verify(r, nil, now))
+	})
+
+	t.Run("custom_canonicalizer", func(t *testing.T) {
+		config := msign.HMACConfig{
+			Secret: "secret",
+			Canonicalizer: msign.CanonicalizerFunc(func(r *http.Request, body []byte, _ []string) string {
+				return r.Header.Get("X-Timestamp") + "." + string(body)
+			}),
+		}
+		r := newSignedRequest(t, msign.NewHMACSigner(config), "payload", now)
+		assert.NoError(t, msign.NewHMACVerifier(config).Verify(r, []byte("payload"), now))
+	})
+}
+
+func TestSigV4(t *testing.T) {
+	config := msign.SigV4Config{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	t.Run("aws_test_suite_get_vanilla", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "http://example.amazonaws.com/", nil)
+		require.NoError(t, msign.NewSigV4Signer(config).Sign(r, nil, now))
+		assert.Equal(t, "20150830T123600Z", r.Header.Get("X-Amz-Date"))
+		assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, "+
+			"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+			r.Header.Get("Authorization"))
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		config := config
+		config.ContentSHA256 = true
+		config.SessionToken = "session"
+		body := []byte(`{"name":"maltose"}`)
+		r := httptest.NewRequest(http.MethodPut, "http://example.amazonaws.com/bucket/a%20b.txt?x=1&a=b+c", nil)
+		require.NoError(t, msign.NewSigV4Signer(config).Sign(r, body, now))
+		assert.Contains(t, r.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,")
+
+		verifier := msign.NewSigV4Verifier(config)
+		assert.NoError(t, verifier.Verify(r, body, now.Add(time.Minute)))
+		assert.Error(t, verifier.Verify(r, []byte("tampered"), now))
+		assert.Error(t, verifier.Verify(r, body, now.Add(time.Hour)))
+
+		r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "AKIDEXAMPLE", "UNKNOWN", 1))
+		assert.Error(t, verifier.Verify(r, body, now))
+	})
+}
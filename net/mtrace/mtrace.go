@@ -30,6 +30,20 @@ const (
 	AttributeHTTPResponseSize = "http.response_content_length"
 	AttributeHTTPRoute        = "http.route"
 	AttributeHTTPClientIP     = "http.client_ip"
+
+	AttributeRPCSystem              = "rpc.system"
+	AttributeRPCMethod              = "rpc.method"
+	AttributeRPCJSONRPCVersion      = "rpc.jsonrpc.version"
+	AttributeRPCJSONRPCRequestID    = "rpc.jsonrpc.request_id"
+	AttributeRPCJSONRPCErrorCode    = "rpc.jsonrpc.error_code"
+	AttributeRPCJSONRPCErrorMessage = "rpc.jsonrpc.error_message"
+
+	AttributeSSELastEventID = "sse.last_event_id"
+	AttributeSSEReconnect   = "sse.reconnect"
+	AttributeSSEEvents      = "sse.events"
+
+	AttributeHTTPHedgeAttempt = "http.hedge.attempt"
+	AttributeHTTPHedgeWon     = "http.hedge.won"
 )
 
 var (
@@ -75,6 +75,9 @@ type MetricOption struct {
 	// set of buckets will be used by the provider.
 	// This is only applicable to Histogram metrics.
 	Buckets []float64
+	// Callback reports the value of an observable instrument when metrics are collected.
+	// This is only applicable to ObservableCounter and ObservableGauge metrics.
+	Callback MetricCallback
 }
 
 // Option is the option for a single metric operation, like Add or Inc.
@@ -109,6 +112,24 @@ type Meter interface {
 	Histogram(name string, option MetricOption) (Histogram, error)
 	// MustHistogram is like Histogram but panics on error.
 	MustHistogram(name string, option MetricOption) Histogram
+	// Gauge creates a new synchronous gauge. A gauge records the current value,
+	// such as a queue depth, replacing the previously recorded value.
+	Gauge(name string, option MetricOption) (Gauge, error)
+	// MustGauge is like Gauge but panics on error.
+	MustGauge(name string, option MetricOption) Gauge
+	// ObservableCounter creates a new asynchronous counter whose cumulative value is
+	// reported by option.Callback or by a callback added with RegisterCallback.
+	ObservableCounter(name string, option MetricOption) (ObservableCounter, error)
+	// MustObservableCounter is like ObservableCounter but panics on error.
+	MustObservableCounter(name string, option MetricOption) ObservableCounter
+	// ObservableGauge creates a new asynchronous gauge whose current value is
+	// reported by option.Callback or by a callback added with RegisterCallback.
+	ObservableGauge(name string, option MetricOption) (ObservableGauge, error)
+	// MustObservableGauge is like ObservableGauge but panics on error.
+	MustObservableGauge(name string, option MetricOption) ObservableGauge
+	// RegisterCallback registers a callback that observes several observable
+	// instruments at once. The instruments must have been created by this meter.
+	RegisterCallback(callback Callback, metrics ...ObservableMetric) (Registration, error)
 }
 
 // Counter is an interface for a counter metric.
@@ -135,6 +156,54 @@ type Histogram interface {
 	Record(value float64, opts ...Option)
 }
 
+// Gauge is an interface for a synchronous gauge metric.
+type Gauge interface {
+	// Record records the current value of the gauge.
+	Record(value float64, opts ...Option)
+}
+
+// ObservableMetric is an asynchronous instrument whose value is reported by a
+// callback when metrics are collected. It is implemented by ObservableCounter
+// and ObservableGauge.
+type ObservableMetric interface {
+	observable() (metric.Float64Observable, Attributes)
+}
+
+// ObservableCounter is an asynchronous counter. Callbacks observe its cumulative value.
+type ObservableCounter interface {
+	ObservableMetric
+}
+
+// ObservableGauge is an asynchronous gauge. Callbacks observe its current value.
+type ObservableGauge interface {
+	ObservableMetric
+}
+
+// MetricCallback reports the value of a single observable instrument.
+type MetricCallback func(ctx context.Context, obs MetricObserver) error
+
+// MetricObserver records values for the observable instrument a MetricCallback belongs to.
+type MetricObserver interface {
+	// Observe records the value with the given attributes.
+	Observe(value float64, opts ...Option)
+}
+
+// Callback reports the values of several observable instruments at once,
+// for example when they are read from one expensive snapshot.
+type Callback func(ctx context.Context, obs Observer) error
+
+// Observer records values for the observable instruments a Callback is registered with.
+type Observer interface {
+	// Observe records the value of the instrument with the given attributes.
+	Observe(metric ObservableMetric, value float64, opts ...Option)
+}
+
+// Registration is a callback registration that can be removed.
+type Registration interface {
+	// Unregister removes the callback.
+	Unregister() error
+}
+
 type contextHistogram interface {
 	RecordContext(ctx context.Context, value float64, opts ...Option)
 }
@@ -149,6 +218,20 @@ func RecordHistogram(ctx context.Context, histogram Histogram, value float64, op
 	histogram.Record(value, opts...)
 }
 
+type contextGauge interface {
+	RecordContext(ctx context.Context, value float64, opts ...Option)
+}
+
+// RecordGauge records a gauge value with the caller's context when the
+// implementation supports it, while remaining compatible with custom Gauge implementations.
+func RecordGauge(ctx context.Context, gauge Gauge, value float64, opts ...Option) {
+	if recorder, ok := gauge.(contextGauge); ok {
+		recorder.RecordContext(ctx, value, opts...)
+		return
+	}
+	gauge.Record(value, opts...)
+}
+
 // GetProvider returns the global metric provider, which is a wrapper around
 // the default OpenTelemetry MeterProvider.
 func GetProvider() Provider {
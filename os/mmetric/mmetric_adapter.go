@@ -3,6 +3,7 @@ package mmetric
 import (
 	"context"
 
+	"github.com/graingo/maltose/errors/merror"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 )
@@ -121,6 +122,114 @@ func (m *meterWrapper) MustHistogram(name string, option MetricOption) Histogram
 	return histogram
 }
 
+// Gauge creates a new synchronous Gauge metric instrument.
+func (m *meterWrapper) Gauge(name string, option MetricOption) (Gauge, error) {
+	gauge, err := m.meter.Float64Gauge(
+		name,
+		metric.WithDescription(option.Help),
+		metric.WithUnit(option.Unit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &gaugeWrapper{
+		gauge:      gauge,
+		attributes: combineAttributes(m.attributes, option.Attributes),
+	}, nil
+}
+
+// MustGauge creates a new Gauge, panicking on error.
+func (m *meterWrapper) MustGauge(name string, option MetricOption) Gauge {
+	gauge, err := m.Gauge(name, option)
+	if err != nil {
+		panic(err)
+	}
+	return gauge
+}
+
+// ObservableCounter creates a new asynchronous counter metric instrument.
+func (m *meterWrapper) ObservableCounter(name string, option MetricOption) (ObservableCounter, error) {
+	attributes := combineAttributes(m.attributes, option.Attributes)
+	opts := []metric.Float64ObservableCounterOption{
+		metric.WithDescription(option.Help),
+		metric.WithUnit(option.Unit),
+	}
+	if option.Callback != nil {
+		opts = append(opts, float64Callback(option.Callback, attributes))
+	}
+	counter, err := m.meter.Float64ObservableCounter(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &observableWrapper{
+		instrument: counter,
+		attributes: attributes,
+	}, nil
+}
+
+// MustObservableCounter creates a new ObservableCounter, panicking on error.
+func (m *meterWrapper) MustObservableCounter(name string, option MetricOption) ObservableCounter {
+	counter, err := m.ObservableCounter(name, option)
+	if err != nil {
+		panic(err)
+	}
+	return counter
+}
+
+// ObservableGauge creates a new asynchronous gauge metric instrument.
+func (m *meterWrapper) ObservableGauge(name string, option MetricOption) (ObservableGauge, error) {
+	attributes := combineAttributes(m.attributes, option.Attributes)
+	opts := []metric.Float64ObservableGaugeOption{
+		metric.WithDescription(option.Help),
+		metric.WithUnit(option.Unit),
+	}
+	if option.Callback != nil {
+		opts = append(opts, float64Callback(option.Callback, attributes))
+	}
+	gauge, err := m.meter.Float64ObservableGauge(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &observableWrapper{
+		instrument: gauge,
+		attributes: attributes,
+	}, nil
+}
+
+// MustObservableGauge creates a new ObservableGauge, panicking on error.
+func (m *meterWrapper) MustObservableGauge(name string, option MetricOption) ObservableGauge {
+	gauge, err := m.ObservableGauge(name, option)
+	if err != nil {
+		panic(err)
+	}
+	return gauge
+}
+
+// RegisterCallback registers a callback observing several observable instruments.
+func (m *meterWrapper) RegisterCallback(callback Callback, metrics ...ObservableMetric) (Registration, error) {
+	if callback == nil {
+		return nil, merror.New("metric callback is required")
+	}
+	instruments := make([]metric.Observable, 0, len(metrics))
+	for _, observable := range metrics {
+		if observable == nil {
+			continue
+		}
+		instrument, _ := observable.observable()
+		instruments = append(instruments, instrument)
+	}
+	return m.meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		return callback(ctx, &observerWrapper{observer: o})
+	}, instruments...)
+}
+
+// float64Callback adapts a MetricCallback to an OpenTelemetry instrument option.
+func float64Callback(callback MetricCallback, attributes Attributes) metric.Float64ObservableOption {
+	return metric.WithFloat64Callback(func(ctx context.Context, o metric.Float64Observer) error {
+		return callback(ctx, &metricObserverWrapper{observer: o, attributes: attributes})
+	})
+}
+
 // counterWrapper is a wrapper for an OpenTelemetry Counter.
 type counterWrapper struct {
 	counter    metric.Float64Counter
@@ -185,6 +294,62 @@ func (h *histogramWrapper) RecordContext(ctx context.Context, value float64, opt
 	))
 }
 
+// gaugeWrapper is a wrapper for an OpenTelemetry Gauge.
+type gaugeWrapper struct {
+	gauge      metric.Float64Gauge
+	attributes Attributes
+}
+
+// Record records the current value of the gauge.
+func (g *gaugeWrapper) Record(value float64, opts ...Option) {
+	g.RecordContext(context.Background(), value, opts...)
+}
+
+func (g *gaugeWrapper) RecordContext(ctx context.Context, value float64, opts ...Option) {
+	g.gauge.Record(ctx, value, metric.WithAttributes(
+		combineAttributes(g.attributes, optionsToAttributes(opts))...,
+	))
+}
+
+// observableWrapper is a wrapper for an OpenTelemetry observable instrument.
+type observableWrapper struct {
+	instrument metric.Float64Observable
+	attributes Attributes
+}
+
+func (o *observableWrapper) observable() (metric.Float64Observable, Attributes) {
+	return o.instrument, o.attributes
+}
+
+// metricObserverWrapper observes the instrument a MetricCallback belongs to.
+type metricObserverWrapper struct {
+	observer   metric.Float64Observer
+	attributes Attributes
+}
+
+// Observe records the value with the given attributes.
+func (o *metricObserverWrapper) Observe(value float64, opts ...Option) {
+	o.observer.Observe(value, metric.WithAttributes(
+		combineAttributes(o.attributes, optionsToAttributes(opts))...,
+	))
+}
+
+// observerWrapper observes the instruments a Callback is registered with.
+type observerWrapper struct {
+	observer metric.Observer
+}
+
+// Observe records the value of the instrument with the given attributes.
+func (o *observerWrapper) Observe(observable ObservableMetric, value float64, opts ...Option) {
+	if observable == nil {
+		return
+	}
+	instrument, attributes := observable.observable()
+	o.observer.ObserveFloat64(instrument, value, metric.WithAttributes(
+		combineAttributes(attributes, optionsToAttributes(opts))...,
+	))
+}
+
 func combineAttributes(base, extra Attributes) Attributes {
 	attributes := make(Attributes, 0, len(base)+len(extra))
 	attributes = append(attributes, base...)
@@ -0,0 +1,323 @@
+package mmetric
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/graingo/maltose/errors/merror"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// collectorInstrument is the instrumentation name of the runtime collector meter.
+	collectorInstrument = "github.com/graingo/maltose/os/mmetric/collector"
+)
+
+// Attribute keys used by the runtime collector.
+const (
+	AttrCPUMode                 = "cpu.mode"
+	AttrDBSystem                = "db.system"
+	AttrDBClientConnectionPool  = "db.client.connection.pool.name"
+	AttrDBClientConnectionState = "db.client.connection.state"
+)
+
+const (
+	cpuModeUser                 = "user"
+	cpuModeSystem               = "system"
+	dbClientConnectionStateIdle = "idle"
+	dbClientConnectionStateUsed = "used"
+)
+
+// Names of the runtime/metrics samples read by the runtime collector.
+const (
+	runtimeMetricGoroutines   = "/sched/goroutines:goroutines"
+	runtimeMetricGoMaxProcs   = "/sched/gomaxprocs:threads"
+	runtimeMetricHeapObjects  = "/memory/classes/heap/objects:bytes"
+	runtimeMetricMemoryTotal  = "/memory/classes/total:bytes"
+	runtimeMetricHeapReleased = "/memory/classes/heap/released:bytes"
+	runtimeMetricHeapGoal     = "/gc/heap/goal:bytes"
+	runtimeMetricMemoryLimit  = "/gc/gomemlimit:bytes"
+	runtimeMetricAllocBytes   = "/gc/heap/allocs:bytes"
+	runtimeMetricAllocObjects = "/gc/heap/allocs:objects"
+	runtimeMetricGCCycles     = "/gc/cycles/total:gc-cycles"
+)
+
+// processStart approximates the process start time with package initialization.
+var processStart = time.Now()
+
+// CollectorConfig defines which metric groups a Collector reports.
+type CollectorConfig struct {
+	// Runtime reports Go runtime metrics: goroutines, heap, GC cycles and pauses.
+	Runtime bool `mconv:"runtime"`
+	// Process reports process CPU time, resident memory, open file descriptors and uptime.
+	// CPU, memory and file descriptor metrics are only available on Linux.
+	Process bool `mconv:"process"`
+	// Pools reports statistics of connection pools added with RegisterPool,
+	// such as named mdb and mredis instances.
+	Pools bool `mconv:"pools"`
+	// Provider is the meter provider the instruments are created with.
+	// It defaults to the global provider.
+	Provider metric.MeterProvider
+}
+
+// DefaultCollectorConfig returns a configuration with every metric group enabled.
+func DefaultCollectorConfig() CollectorConfig {
+	return CollectorConfig{
+		Runtime: true,
+		Process: true,
+		Pools:   true,
+	}
+}
+
+// Collector reports runtime, process and connection pool metrics through
+// observable instruments. Values are read when the provider collects, so an
+// idle collector costs nothing between exports.
+type Collector struct {
+	registrations []metric.Registration
+	stopOnce      sync.Once
+	stopErr       error
+}
+
+// StartCollector registers the observable instruments enabled by config.
+// Call Stop to unregister them.
+func StartCollector(config CollectorConfig) (*Collector, error) {
+	provider := config.Provider
+	if provider == nil {
+		provider = otel.GetMeterProvider()
+	}
+	meter := provider.Meter(collectorInstrument)
+
+	c := &Collector{}
+	register := []struct {
+		enabled bool
+		fn      func(metric.Meter) (metric.Registration, error)
+	}{
+		{config.Runtime, registerRuntimeMetrics},
+		{config.Process, registerProcessMetrics},
+		{config.Pools, registerPoolMetrics},
+	}
+	for _, item := range register {
+		if !item.enabled {
+			continue
+		}
+		registration, err := item.fn(meter)
+		if err != nil {
+			_ = c.Stop()
+			return nil, err
+		}
+		c.registrations = append(c.registrations, registration)
+	}
+	return c, nil
+}
+
+// Stop unregisters the collector callbacks. It is safe to call more than once.
+func (c *Collector) Stop() error {
+	if c == nil {
+		return nil
+	}
+	c.stopOnce.Do(func() {
+		for _, registration := range c.registrations {
+			c.stopErr = errors.Join(c.stopErr, registration.Unregister())
+		}
+	})
+	return c.stopErr
+}
+
+// Close implements io.Closer for use with m.WithCloser.
+func (c *Collector) Close() error {
+	return c.Stop()
+}
+
+func registerRuntimeMetrics(meter metric.Meter) (metric.Registration, error) {
+	b := &instrumentBuilder{meter: meter}
+	var (
+		goroutines  = b.int64UpDown("go.goroutine.count", "{goroutine}", "Count of live goroutines.")
+		processors  = b.int64UpDown("go.processor.limit", "{thread}", "The number of OS threads that can execute user-level Go code simultaneously.")
+		memoryUsed  = b.int64UpDown("go.memory.used", "By", "Memory used by the Go runtime.")
+		heapUsed    = b.int64UpDown("go.memory.heap.used", "By", "Memory occupied by live and not-yet-swept heap objects.")
+		gcGoal      = b.int64UpDown("go.memory.gc.goal", "By", "Heap size target for the end of the GC cycle.")
+		memoryLimit = b.int64UpDown("go.memory.limit", "By", "Go runtime memory limit configured by the user, if a limit exists.")
+		allocated   = b.int64Counter("go.memory.allocated", "By", "Memory allocated to the heap by the application.")
+		allocations = b.int64Counter("go.memory.allocations", "{allocation}", "Count of allocations to the heap by the application.")
+		gcCycles    = b.int64Counter("go.gc.cycles", "{cycle}", "Count of completed GC cycles.")
+		gcPauseSum  = b.float64Counter("go.gc.pause.total", "s", "Cumulative stop-the-world GC pause time.")
+		gcPauseLast = b.float64Gauge("go.gc.pause.last", "s", "Duration of the most recent stop-the-world GC pause.")
+	)
+	samples := []metrics.Sample{
+		{Name: runtimeMetricGoroutines},
+		{Name: runtimeMetricGoMaxProcs},
+		{Name: runtimeMetricMemoryTotal},
+		{Name: runtimeMetricHeapReleased},
+		{Name: runtimeMetricHeapObjects},
+		{Name: runtimeMetricHeapGoal},
+		{Name: runtimeMetricMemoryLimit},
+		{Name: runtimeMetricAllocBytes},
+		{Name: runtimeMetricAllocObjects},
+		{Name: runtimeMetricGCCycles},
+	}
+	var mu sync.Mutex
+
+	if b.err != nil {
+		return nil, merror.Wrap(b.err, "failed to create runtime instruments")
+	}
+
+	registration, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		// The samples slice is reused between collections.
+		mu.Lock()
+		defer mu.Unlock()
+		metrics.Read(samples)
+		values := make(map[string]int64, len(samples))
+		for _, sample := range samples {
+			if sample.Value.Kind() == metrics.KindUint64 {
+				values[sample.Name] = int64(sample.Value.Uint64())
+			}
+		}
+		o.ObserveInt64(goroutines, values[runtimeMetricGoroutines])
+		o.ObserveInt64(processors, values[runtimeMetricGoMaxProcs])
+		o.ObserveInt64(memoryUsed, values[runtimeMetricMemoryTotal]-values[runtimeMetricHeapReleased])
+		o.ObserveInt64(heapUsed, values[runtimeMetricHeapObjects])
+		o.ObserveInt64(gcGoal, values[runtimeMetricHeapGoal])
+		o.ObserveInt64(memoryLimit, values[runtimeMetricMemoryLimit])
+		o.ObserveInt64(allocated, values[runtimeMetricAllocBytes])
+		o.ObserveInt64(allocations, values[runtimeMetricAllocObjects])
+		o.ObserveInt64(gcCycles, values[runtimeMetricGCCycles])
+
+		var gcStats debug.GCStats
+		debug.ReadGCStats(&gcStats)
+		o.ObserveFloat64(gcPauseSum, gcStats.PauseTotal.Seconds())
+		if len(gcStats.Pause) > 0 {
+			o.ObserveFloat64(gcPauseLast, gcStats.Pause[0].Seconds())
+		}
+		return nil
+	}, goroutines, processors, memoryUsed, heapUsed, gcGoal, memoryLimit, allocated, allocations, gcCycles, gcPauseSum, gcPauseLast)
+	if err != nil {
+		return nil, merror.Wrap(err, "failed to register runtime metrics")
+	}
+	return registration, nil
+}
+
+func registerProcessMetrics(meter metric.Meter) (metric.Registration, error) {
+	b := &instrumentBuilder{meter: meter}
+	var (
+		cpuTime     = b.float64Counter("process.cpu.time", "s", "Total CPU seconds broken down by mode.")
+		memory      = b.int64UpDown("process.memory.usage", "By", "The amount of physical memory in use.")
+		virtual     = b.int64UpDown("process.memory.virtual", "By", "The amount of committed virtual memory.")
+		fds         = b.int64UpDown("process.open_file_descriptor.count", "{file_descriptor}", "Number of file descriptors in use by the process.")
+		uptime      = b.float64Gauge("process.uptime", "s", "The time the process has been running.")
+		userMode    = metric.WithAttributes(attribute.String(AttrCPUMode, cpuModeUser))
+		systemMode  = metric.WithAttributes(attribute.String(AttrCPUMode, cpuModeSystem))
+		instruments = []metric.Observable{cpuTime, memory, virtual, fds, uptime}
+	)
+	if b.err != nil {
+		return nil, merror.Wrap(b.err, "failed to create process instruments")
+	}
+
+	registration, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveFloat64(uptime, time.Since(processStart).Seconds())
+		stats, ok := readProcessStats()
+		if !ok {
+			return nil
+		}
+		o.ObserveFloat64(cpuTime, stats.cpuUser.Seconds(), userMode)
+		o.ObserveFloat64(cpuTime, stats.cpuSystem.Seconds(), systemMode)
+		o.ObserveInt64(memory, stats.rss)
+		o.ObserveInt64(virtual, stats.virtual)
+		if stats.fds >= 0 {
+			o.ObserveInt64(fds, stats.fds)
+		}
+		return nil
+	}, instruments...)
+	if err != nil {
+		return nil, merror.Wrap(err, "failed to register process metrics")
+	}
+	return registration, nil
+}
+
+func registerPoolMetrics(meter metric.Meter) (metric.Registration, error) {
+	b := &instrumentBuilder{meter: meter}
+	var (
+		count        = b.int64UpDown("db.client.connection.count", "{connection}", "The number of connections that are currently in the state described by the state attribute.")
+		maxOpen      = b.int64UpDown("db.client.connection.max", "{connection}", "The maximum number of open connections allowed.")
+		waitCount    = b.int64Counter("db.client.connection.wait_count", "{wait}", "The total number of times a caller waited for a connection.")
+		waitDuration = b.float64Counter("db.client.connection.wait_time", "s", "The total time callers spent waiting for a connection.")
+		timeouts     = b.int64Counter("db.client.connection.timeouts", "{timeout}", "The number of connection timeouts that have occurred trying to obtain a connection from the pool.")
+		closed       = b.int64Counter("db.client.connection.closed", "{connection}", "The total number of connections closed because they were idle or expired.")
+		instruments  = []metric.Observable{count, maxOpen, waitCount, waitDuration, timeouts, closed}
+	)
+	if b.err != nil {
+		return nil, merror.Wrap(b.err, "failed to create connection pool instruments")
+	}
+
+	registration, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, source := range registeredPools() {
+			var (
+				stats = source.stats()
+				pool  = []attribute.KeyValue{
+					attribute.String(AttrDBSystem, source.system),
+					attribute.String(AttrDBClientConnectionPool, source.name),
+				}
+				poolAttrs = metric.WithAttributes(pool...)
+			)
+			o.ObserveInt64(count, stats.Idle, metric.WithAttributes(
+				append(pool[:len(pool):len(pool)], attribute.String(AttrDBClientConnectionState, dbClientConnectionStateIdle))...,
+			))
+			o.ObserveInt64(count, stats.InUse, metric.WithAttributes(
+				append(pool[:len(pool):len(pool)], attribute.String(AttrDBClientConnectionState, dbClientConnectionStateUsed))...,
+			))
+			if stats.MaxOpen > 0 {
+				o.ObserveInt64(maxOpen, stats.MaxOpen, poolAttrs)
+			}
+			o.ObserveInt64(waitCount, stats.WaitCount, poolAttrs)
+			o.ObserveFloat64(waitDuration, stats.WaitDuration.Seconds(), poolAttrs)
+			o.ObserveInt64(timeouts, stats.Timeouts, poolAttrs)
+			o.ObserveInt64(closed, stats.Closed, poolAttrs)
+		}
+		return nil
+	}, instruments...)
+	if err != nil {
+		return nil, merror.Wrap(err, "failed to register connection pool metrics")
+	}
+	return registration, nil
+}
+
+// instrumentBuilder creates observable instruments and keeps the first error.
+type instrumentBuilder struct {
+	meter metric.Meter
+	err   error
+}
+
+func (b *instrumentBuilder) int64UpDown(name, unit, description string) metric.Int64ObservableUpDownCounter {
+	instrument, err := b.meter.Int64ObservableUpDownCounter(name, metric.WithUnit(unit), metric.WithDescription(description))
+	b.keep(err)
+	return instrument
+}
+
+func (b *instrumentBuilder) int64Counter(name, unit, description string) metric.Int64ObservableCounter {
+	instrument, err := b.meter.Int64ObservableCounter(name, metric.WithUnit(unit), metric.WithDescription(description))
+	b.keep(err)
+	return instrument
+}
+
+func (b *instrumentBuilder) float64Counter(name, unit, description string) metric.Float64ObservableCounter {
+	instrument, err := b.meter.Float64ObservableCounter(name, metric.WithUnit(unit), metric.WithDescription(description))
+	b.keep(err)
+	return instrument
+}
+
+func (b *instrumentBuilder) float64Gauge(name, unit, description string) metric.Float64ObservableGauge {
+	instrument, err := b.meter.Float64ObservableGauge(name, metric.WithUnit(unit), metric.WithDescription(description))
+	b.keep(err)
+	return instrument
+}
+
+func (b *instrumentBuilder) keep(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
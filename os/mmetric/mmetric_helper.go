@@ -58,6 +58,12 @@ func (o MetricOption) WithBuckets(buckets []float64) MetricOption {
 	return o
 }
 
+// WithCallback sets the callback of an observable instrument
+func (o MetricOption) WithCallback(callback MetricCallback) MetricOption {
+	o.Callback = callback
+	return o
+}
+
 // WithAttributes creates an Option with the given attributes.
 // This is a convenience function for creating attributes for a single metric observation.
 func WithAttributes(attrs ...attribute.KeyValue) Option {
@@ -66,6 +72,10 @@ func WithAttributes(attrs ...attribute.KeyValue) Option {
 	}
 }
 
+// helperInstrumentName is the meter of the observable instruments created by
+// the helpers, shared so that RegisterCallback can observe them together.
+const helperInstrumentName = "github.com/graingo/maltose/os/mmetric"
+
 // GetMeter creates a Meter with the specified instrument name.
 // It uses the global default provider.
 func GetMeter(name string) Meter {
@@ -110,6 +120,51 @@ func NewMustHistogram(name string, option MetricOption) Histogram {
 	return meter.MustHistogram(name, option)
 }
 
+// NewGauge creates a new synchronous Gauge metric.
+func NewGauge(name string, option MetricOption) (Gauge, error) {
+	meter := GetMeter(name)
+	return meter.Gauge(name, option)
+}
+
+// NewMustGauge creates a new Gauge metric and panics if an error occurs.
+func NewMustGauge(name string, option MetricOption) Gauge {
+	meter := GetMeter(name)
+	return meter.MustGauge(name, option)
+}
+
+// NewObservableCounter creates a new asynchronous counter reported by
+// option.Callback or by a callback added with RegisterCallback.
+func NewObservableCounter(name string, option MetricOption) (ObservableCounter, error) {
+	meter := GetMeter(helperInstrumentName)
+	return meter.ObservableCounter(name, option)
+}
+
+// NewMustObservableCounter creates a new ObservableCounter metric and panics if an error occurs.
+func NewMustObservableCounter(name string, option MetricOption) ObservableCounter {
+	meter := GetMeter(helperInstrumentName)
+	return meter.MustObservableCounter(name, option)
+}
+
+// NewObservableGauge creates a new asynchronous gauge reported by
+// option.Callback or by a callback added with RegisterCallback.
+func NewObservableGauge(name string, option MetricOption) (ObservableGauge, error) {
+	meter := GetMeter(helperInstrumentName)
+	return meter.ObservableGauge(name, option)
+}
+
+// NewMustObservableGauge creates a new ObservableGauge metric and panics if an error occurs.
+func NewMustObservableGauge(name string, option MetricOption) ObservableGauge {
+	meter := GetMeter(helperInstrumentName)
+	return meter.MustObservableGauge(name, option)
+}
+
+// RegisterCallback registers a callback observing several observable
+// instruments created by NewObservableCounter and NewObservableGauge.
+func RegisterCallback(callback Callback, metrics ...ObservableMetric) (Registration, error) {
+	meter := GetMeter(helperInstrumentName)
+	return meter.RegisterCallback(callback, metrics...)
+}
+
 // Shutdown gracefully shuts down the global metric provider.
 func Shutdown(ctx context.Context) error {
 	p := GetProvider()
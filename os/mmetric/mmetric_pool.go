@@ -0,0 +1,87 @@
+package mmetric
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// PoolStats is a snapshot of a client connection pool, such as a database/sql
+// pool or a Redis client pool. Counters are cumulative since the pool was created.
+type PoolStats struct {
+	// MaxOpen is the maximum number of open connections. Zero means unlimited.
+	MaxOpen int64
+	// Idle is the number of idle connections.
+	Idle int64
+	// InUse is the number of connections currently in use.
+	InUse int64
+	// WaitCount is the total number of times a caller waited for a connection.
+	WaitCount int64
+	// WaitDuration is the total time callers spent waiting for a connection.
+	WaitDuration time.Duration
+	// Timeouts is the total number of times waiting for a connection timed out.
+	Timeouts int64
+	// Closed is the total number of connections closed because they were idle or expired.
+	Closed int64
+}
+
+// PoolStatsFunc returns the current statistics of a connection pool.
+type PoolStatsFunc func() PoolStats
+
+type poolSource struct {
+	id     uint64
+	system string
+	name   string
+	stats  PoolStatsFunc
+}
+
+var (
+	poolMu      sync.RWMutex
+	poolSeq     uint64
+	poolSources = map[string]*poolSource{}
+)
+
+// RegisterPool registers a connection pool whose statistics are reported by a
+// Collector with pool metrics enabled. The system identifies the client, e.g.
+// "mysql" or "redis", and name is the configured instance name.
+// Registering the same system and name again replaces the previous source.
+// The returned function removes the registration.
+func RegisterPool(system, name string, stats PoolStatsFunc) (unregister func()) {
+	if stats == nil {
+		return func() {}
+	}
+	key := system + "\x00" + name
+
+	poolMu.Lock()
+	poolSeq++
+	source := &poolSource{id: poolSeq, system: system, name: name, stats: stats}
+	poolSources[key] = source
+	poolMu.Unlock()
+
+	return func() {
+		poolMu.Lock()
+		defer poolMu.Unlock()
+		// A newer registration under the same key must survive the old one being removed.
+		if current, ok := poolSources[key]; ok && current.id == source.id {
+			delete(poolSources, key)
+		}
+	}
+}
+
+// registeredPools returns the registered pools ordered by system and name.
+func registeredPools() []*poolSource {
+	poolMu.RLock()
+	sources := make([]*poolSource, 0, len(poolSources))
+	for _, source := range poolSources {
+		sources = append(sources, source)
+	}
+	poolMu.RUnlock()
+
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].system != sources[j].system {
+			return sources[i].system < sources[j].system
+		}
+		return sources[i].name < sources[j].name
+	})
+	return sources
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package mmetric
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processStats is a snapshot of the current process resource usage.
+type processStats struct {
+	cpuUser   time.Duration
+	cpuSystem time.Duration
+	rss       int64
+	virtual   int64
+	fds       int64 // -1 when unknown
+}
+
+// readProcessStats reads resource usage from getrusage and procfs.
+func readProcessStats() (processStats, bool) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return processStats{}, false
+	}
+	stats := processStats{
+		cpuUser:   time.Duration(usage.Utime.Nano()),
+		cpuSystem: time.Duration(usage.Stime.Nano()),
+		fds:       -1,
+	}
+
+	// statm reports sizes in pages: total program size, then resident set size.
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		fields := strings.Fields(string(data))
+		pageSize := int64(os.Getpagesize())
+		if len(fields) >= 2 {
+			if pages, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				stats.virtual = pages * pageSize
+			}
+			if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				stats.rss = pages * pageSize
+			}
+		}
+	}
+
+	if dir, err := os.Open("/proc/self/fd"); err == nil {
+		names, err := dir.Readdirnames(-1)
+		_ = dir.Close()
+		if err == nil {
+			// Exclude the descriptor used to read the directory itself.
+			stats.fds = int64(len(names)) - 1
+		}
+	}
+	return stats, true
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package mmetric
+
+import "time"
+
+// processStats is a snapshot of the current process resource usage.
+type processStats struct {
+	cpuUser   time.Duration
+	cpuSystem time.Duration
+	rss       int64
+	virtual   int64
+	fds       int64 // -1 when unknown
+}
+
+// readProcessStats is not supported on this platform; only uptime is reported.
+func readProcessStats() (processStats, bool) {
+	return processStats{}, false
+}
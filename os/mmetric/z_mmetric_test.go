@@ -3,6 +3,7 @@ package mmetric_test
 import (
 	"context"
 	"errors"
+	"runtime"
 	"slices"
 	"sync"
 	"testing"
@@ -79,6 +80,24 @@ func findHistogramMetric[N int64 | float64](t *testing.T, data metricdata.Resour
 	return nil
 }
 
+// findGaugeMetric searches for a Gauge metric and returns its data points.
+func findGaugeMetric[N int64 | float64](t *testing.T, data metricdata.ResourceMetrics, name string) []metricdata.DataPoint[N] {
+	t.Helper()
+	for _, sm := range data.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				agg, ok := m.Data.(metricdata.Gauge[N])
+				if !ok {
+					t.Fatalf("metric '%s' has unexpected aggregation type %T, expected Gauge", name, m.Data)
+				}
+				return agg.DataPoints
+			}
+		}
+	}
+	t.Fatalf("metric '%s' not found in collected data", name)
+	return nil
+}
+
 // assertAttributes asserts that the given attribute set contains all the expected attributes.
 func assertAttributes(t *testing.T, set attribute.Set, expected ...attribute.KeyValue) {
 	t.Helper()
@@ -264,6 +283,83 @@ func TestInstruments(t *testing.T) {
 		}
 		require.True(t, found, "data point with operation attributes not found")
 	})
+
+	t.Run("gauge_keeps_last_value", func(t *testing.T) {
+		provider, reader := setupTestProvider(t)
+		metricAttrs := mmetric.Attributes{attribute.String("queue", "emails")}
+
+		meter := provider.Meter(mmetric.MeterOption{Instrument: "test_instrument"})
+		gauge, err := meter.Gauge("test.gauge", mmetric.MetricOption{Attributes: metricAttrs})
+		require.NoError(t, err)
+
+		gauge.Record(12)
+		mmetric.RecordGauge(context.Background(), gauge, 7)
+
+		data := metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+
+		points := findGaugeMetric[float64](t, data, "test.gauge")
+		require.Len(t, points, 1)
+		assert.Equal(t, float64(7), points[0].Value)
+		assertAttributes(t, points[0].Attributes, metricAttrs[0])
+	})
+
+	t.Run("observable_counter_with_callback", func(t *testing.T) {
+		provider, reader := setupTestProvider(t)
+		meterAttrs := mmetric.Attributes{attribute.String("meter_key", "meter_val")}
+		opAttrs := mmetric.Attributes{attribute.String("op_key", "op_val")}
+
+		var total float64
+		meter := provider.Meter(mmetric.MeterOption{Instrument: "test_instrument", Attributes: meterAttrs})
+		_, err := meter.ObservableCounter("test.observable_counter", mmetric.NewMetricOption().
+			WithCallback(func(ctx context.Context, obs mmetric.MetricObserver) error {
+				total += 5
+				obs.Observe(total, mmetric.WithAttributes(opAttrs...))
+				return nil
+			}))
+		require.NoError(t, err)
+
+		data := metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		require.NoError(t, reader.Collect(context.Background(), &data))
+
+		points := findSumMetric[float64](t, data, "test.observable_counter")
+		require.Len(t, points, 1)
+		assert.Equal(t, float64(10), points[0].Value)
+		assertAttributes(t, points[0].Attributes, meterAttrs[0], opAttrs[0])
+	})
+
+	t.Run("batch_observation", func(t *testing.T) {
+		provider, reader := setupTestProvider(t)
+		meter := provider.Meter(mmetric.MeterOption{Instrument: "test_instrument"})
+		size := meter.MustObservableGauge("test.cache.size", mmetric.MetricOption{Unit: "{item}"})
+		evictions := meter.MustObservableCounter("test.cache.evictions", mmetric.MetricOption{
+			Attributes: mmetric.Attributes{attribute.String("cache", "users")},
+		})
+
+		registration, err := meter.RegisterCallback(func(ctx context.Context, obs mmetric.Observer) error {
+			obs.Observe(size, 42)
+			obs.Observe(evictions, 3)
+			return nil
+		}, size, evictions)
+		require.NoError(t, err)
+
+		data := metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		assert.Equal(t, float64(42), findGaugeMetric[float64](t, data, "test.cache.size")[0].Value)
+		evictionPoints := findSumMetric[float64](t, data, "test.cache.evictions")
+		require.Len(t, evictionPoints, 1)
+		assert.Equal(t, float64(3), evictionPoints[0].Value)
+		assertAttributes(t, evictionPoints[0].Attributes, attribute.String("cache", "users"))
+
+		require.NoError(t, registration.Unregister())
+		data = metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		assert.False(t, hasMetric(data, "test.cache.size"))
+
+		_, err = meter.RegisterCallback(nil, size)
+		assert.Error(t, err)
+	})
 }
 
 // errorMeter is a mock meter that always returns an error, for testing Must... functions.
@@ -300,6 +396,17 @@ func (e *errorMeter) MustHistogram(name string, option mmetric.MetricOption) mme
 	return h
 }
 
+func (e *errorMeter) Gauge(string, mmetric.MetricOption) (mmetric.Gauge, error) {
+	return nil, errors.New("mock error")
+}
+func (e *errorMeter) MustGauge(name string, option mmetric.MetricOption) mmetric.Gauge {
+	g, err := e.Gauge(name, option)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
 func TestMustPanics(t *testing.T) {
 	meter := &errorMeter{}
 
@@ -320,6 +427,12 @@ func TestMustPanics(t *testing.T) {
 			meter.MustHistogram("any", mmetric.MetricOption{})
 		})
 	})
+
+	t.Run("must_gauge_panics", func(t *testing.T) {
+		assert.Panics(t, func() {
+			meter.MustGauge("any", mmetric.MetricOption{})
+		})
+	})
 }
 
 func TestHelpers(t *testing.T) {
@@ -338,6 +451,48 @@ func TestHelpers(t *testing.T) {
 		assert.Equal(t, float64(1), points[0].Value)
 	})
 
+	t.Run("package_level_gauge_helpers", func(t *testing.T) {
+		_, reader := setupTestProvider(t)
+
+		mmetric.NewMustGauge("pkg.gauge", mmetric.MetricOption{}).Record(3)
+		mmetric.NewMustObservableGauge("pkg.observable_gauge", mmetric.NewMetricOption().
+			WithCallback(func(ctx context.Context, obs mmetric.MetricObserver) error {
+				obs.Observe(9)
+				return nil
+			}))
+		mmetric.NewMustObservableCounter("pkg.observable_counter", mmetric.NewMetricOption().
+			WithCallback(func(ctx context.Context, obs mmetric.MetricObserver) error {
+				obs.Observe(4)
+				return nil
+			}))
+
+		data := metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		assert.Equal(t, float64(3), findGaugeMetric[float64](t, data, "pkg.gauge")[0].Value)
+		assert.Equal(t, float64(9), findGaugeMetric[float64](t, data, "pkg.observable_gauge")[0].Value)
+		assert.Equal(t, float64(4), findSumMetric[float64](t, data, "pkg.observable_counter")[0].Value)
+	})
+
+	t.Run("package_level_batch_observation", func(t *testing.T) {
+		_, reader := setupTestProvider(t)
+		size := mmetric.NewMustObservableGauge("pkg.cache.size", mmetric.MetricOption{})
+		evictions, err := mmetric.NewObservableCounter("pkg.cache.evictions", mmetric.MetricOption{})
+		require.NoError(t, err)
+
+		registration, err := mmetric.RegisterCallback(func(ctx context.Context, obs mmetric.Observer) error {
+			obs.Observe(size, 42)
+			obs.Observe(evictions, 3)
+			return nil
+		}, size, evictions)
+		require.NoError(t, err)
+		defer registration.Unregister()
+
+		data := metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		assert.Equal(t, float64(42), findGaugeMetric[float64](t, data, "pkg.cache.size")[0].Value)
+		assert.Equal(t, float64(3), findSumMetric[float64](t, data, "pkg.cache.evictions")[0].Value)
+	})
+
 	t.Run("option_helpers", func(t *testing.T) {
 		meterOpt := mmetric.NewMeterOption().
 			WithInstrument("inst").
@@ -398,3 +553,102 @@ func TestHelpers(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+// hasMetric reports whether a metric with the given name was collected.
+func hasMetric(data metricdata.ResourceMetrics, name string) bool {
+	for _, sm := range data.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestCollector(t *testing.T) {
+	newCollector := func(t *testing.T, config mmetric.CollectorConfig) (*mmetric.Collector, *metric.ManualReader) {
+		t.Helper()
+		reader := metric.NewManualReader()
+		provider := mmetric.NewProvider(metric.WithReader(reader))
+		t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+		config.Provider = provider
+		collector, err := mmetric.StartCollector(config)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = collector.Stop() })
+		return collector, reader
+	}
+
+	t.Run("runtime_and_process", func(t *testing.T) {
+		_, reader := newCollector(t, mmetric.DefaultCollectorConfig())
+		data := metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+
+		goroutines := findSumMetric[int64](t, data, "go.goroutine.count")
+		require.Len(t, goroutines, 1)
+		assert.Positive(t, goroutines[0].Value)
+		assert.Positive(t, findSumMetric[int64](t, data, "go.memory.used")[0].Value)
+		assert.True(t, hasMetric(data, "go.gc.pause.total"))
+		assert.True(t, hasMetric(data, "process.uptime"))
+		if runtime.GOOS == "linux" {
+			assert.Positive(t, findSumMetric[int64](t, data, "process.memory.usage")[0].Value)
+			assert.Positive(t, findSumMetric[int64](t, data, "process.open_file_descriptor.count")[0].Value)
+			assert.Len(t, findSumMetric[float64](t, data, "process.cpu.time"), 2)
+		}
+	})
+
+	t.Run("pools", func(t *testing.T) {
+		_, reader := newCollector(t, mmetric.CollectorConfig{Pools: true})
+		unregister := mmetric.RegisterPool("redis", "cache", func() mmetric.PoolStats {
+			return mmetric.PoolStats{MaxOpen: 10, Idle: 2, InUse: 3, Timeouts: 1}
+		})
+
+		data := metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		assert.False(t, hasMetric(data, "go.goroutine.count"))
+
+		counts := map[string]int64{}
+		for _, point := range findSumMetric[int64](t, data, "db.client.connection.count") {
+			assertAttributes(t, point.Attributes,
+				attribute.String(mmetric.AttrDBSystem, "redis"),
+				attribute.String(mmetric.AttrDBClientConnectionPool, "cache"),
+			)
+			state, _ := point.Attributes.Value(mmetric.AttrDBClientConnectionState)
+			counts[state.AsString()] = point.Value
+		}
+		assert.Equal(t, map[string]int64{"idle": 2, "used": 3}, counts)
+		assert.Equal(t, int64(10), findSumMetric[int64](t, data, "db.client.connection.max")[0].Value)
+		assert.Equal(t, int64(1), findSumMetric[int64](t, data, "db.client.connection.timeouts")[0].Value)
+
+		unregister()
+		data = metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		assert.False(t, hasMetric(data, "db.client.connection.count"))
+	})
+
+	t.Run("replaced_pool_survives_old_unregister", func(t *testing.T) {
+		_, reader := newCollector(t, mmetric.CollectorConfig{Pools: true})
+		unregisterOld := mmetric.RegisterPool("mysql", "orders", func() mmetric.PoolStats { return mmetric.PoolStats{Idle: 1} })
+		unregisterNew := mmetric.RegisterPool("mysql", "orders", func() mmetric.PoolStats { return mmetric.PoolStats{Idle: 5} })
+		defer unregisterNew()
+		unregisterOld()
+
+		data := metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		for _, point := range findSumMetric[int64](t, data, "db.client.connection.count") {
+			if state, _ := point.Attributes.Value(mmetric.AttrDBClientConnectionState); state.AsString() == "idle" {
+				assert.Equal(t, int64(5), point.Value)
+			}
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		collector, reader := newCollector(t, mmetric.DefaultCollectorConfig())
+		require.NoError(t, collector.Stop())
+		require.NoError(t, collector.Stop())
+
+		data := metricdata.ResourceMetrics{}
+		require.NoError(t, reader.Collect(context.Background(), &data))
+		assert.False(t, hasMetric(data, "go.goroutine.count"))
+	})
+}
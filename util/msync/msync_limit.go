@@ -1,6 +1,9 @@
 package msync
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 var (
 	// ErrLimitReturn is returned when Return is called without a corresponding Borrow.
@@ -27,6 +30,17 @@ func (l *Limit) Borrow() {
 	l.pool <- struct{}{}
 }
 
+// BorrowContext acquires a slot from the limit pool, blocking until one is
+// free or ctx is done. It returns the error of ctx when no slot was acquired.
+func (l *Limit) BorrowContext(ctx context.Context) error {
+	select {
+	case l.pool <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // TryBorrow attempts to acquire a slot from the limit pool without blocking.
 // It returns true if successful, false if the pool is full.
 func (l *Limit) TryBorrow() bool {
@@ -48,3 +62,8 @@ func (l *Limit) Return() error {
 		return ErrLimitReturn
 	}
 }
+
+// InUse returns the number of slots currently borrowed.
+func (l *Limit) InUse() int {
+	return len(l.pool)
+}
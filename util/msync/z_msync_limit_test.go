@@ -1,6 +1,7 @@
 package msync_test
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -99,6 +100,20 @@ func TestLimit_Borrow(t *testing.T) {
 	})
 }
 
+func TestLimit_BorrowContext(t *testing.T) {
+	limit := msync.NewLimit(1)
+	assert.NoError(t, limit.BorrowContext(context.Background()))
+	assert.Equal(t, 1, limit.InUse())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limit.BorrowContext(ctx), context.DeadlineExceeded)
+	assert.Equal(t, 1, limit.InUse())
+
+	assert.NoError(t, limit.Return())
+	assert.Equal(t, 0, limit.InUse())
+}
+
 func TestLimit_TryBorrow(t *testing.T) {
 	t.Run("succeeds_when_available", func(t *testing.T) {
 		limit := msync.NewLimit(5)